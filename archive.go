@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ────────── handing files off ──────────────────────────────
+// after_upload frees local_dir on machines that produce data all the
+// time, such as instrument PCs: once a file is on the target, "delete"
+// removes it here, and "move:<dir>" moves it into dir, with its path
+// below local_dir, where it replaces a file of the same name. A file is
+// only let go when it is verified: the target's copy has the file's size,
+// reading it back gives the same SHA-256, and the file still has the
+// size and mtime it was uploaded with, so one an instrument is still
+// writing stays. A copy that does not match fails the file (and is sent
+// again on the retry); the file stays. This applies to files uploaded in
+// this run and to those found up to date on the target. Folders are left
+// in place, empty or not, and the file's record leaves the state file.
+type afterUpload struct {
+	move string // "" deletes
+}
+
+// newAfterUpload is nil unless after_upload is set.
+func newAfterUpload(c *Conf) (*afterUpload, error) {
+	a := &afterUpload{}
+	switch v := c.AfterUpload; {
+	case v == "":
+		return nil, nil
+	case v == "delete":
+	case strings.HasPrefix(v, "move:"):
+		dir, err := filepath.Abs(strings.TrimPrefix(v, "move:"))
+		if err != nil || dir == "" { return nil, fmt.Errorf("after_upload: %q names no folder", v) }
+		root, _ := filepath.Abs(c.LocalDir)
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("after_upload: %s is inside local_dir, so moved files would be uploaded again", dir)
+		}
+		a.move = dir
+	default:
+		return nil, fmt.Errorf("after_upload: %q is not \"delete\" or \"move:<dir>\"", v)
+	}
+	switch {
+	case c.Direction != "" && c.Direction != "push":
+		return nil, fmt.Errorf("after_upload is for a push")
+	case c.Mirror:
+		return nil, fmt.Errorf("after_upload cannot be used with mirror, which would delete on the target what was handed off here")
+	}
+	return a, nil
+}
+
+// handOff lets local go once the copy of it at rel on t is verified.
+func (r *run) handOff(ctx context.Context, t target, rel string, local *FileInfo) error {
+	a := r.handoff
+	if a == nil { return nil }
+	if r.opts.dryRun { debugf(catTransfer, "%s: would be handed off", rel); return nil }
+	remote, err := t.stat(ctx, rel)
+	if err != nil { return fmt.Errorf("after_upload: %w", err) }
+	if remote.Size != local.Size { return fmt.Errorf("after_upload: the target has %d bytes of %s, not %d; the local file stays", remote.Size, rel, local.Size) }
+	h := sha256.New()
+	if err = t.fetch(ctx, rel, h); err != nil { return fmt.Errorf("after_upload: reading %s back: %w", rel, err) }
+	there := hex.EncodeToString(h.Sum(nil))
+	here, err := hashFile(local.Path)
+	if err != nil { return classifyOS(err) }
+	if there != here { return fmt.Errorf("after_upload: the target's copy of %s does not match (SHA-256 %s, here %s); the local file stays", rel, there, here) }
+	fi, err := os.Stat(local.Path)
+	if err != nil { return classifyOS(err) }
+	if fi.Size() != local.Size || !fi.ModTime().Equal(local.MTime) {
+		debugf(catTransfer, "%s: changed since the upload, stays", rel)
+		return nil
+	}
+	if a.move == "" {
+		err = os.Remove(local.Path)
+	} else {
+		err = moveFile(local.Path, filepath.Join(a.move, filepath.FromSlash(r.localRel(local.Path))))
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) { return fmt.Errorf("after_upload: %w", classifyOS(err)) }
+	debugf(catTransfer, "%s: verified on the target, handed off", rel)
+	r.st.forget(rel)
+	r.archived.Add(1)
+	return nil
+}
+
+// moveFile renames from to to, or copies it where a rename cannot, as
+// between volumes.
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil { return err }
+	if os.Rename(from, to) == nil { return nil }
+	src, err := os.Open(from)
+	if err != nil { return err }
+	fi, err := src.Stat()
+	if err == nil { err = copyFile(context.Background(), src, to) }
+	src.Close()
+	if err == nil { err = os.Chtimes(to, fi.ModTime(), fi.ModTime()) }
+	if err != nil { os.Remove(to); return err }
+	return os.Remove(from)
+}
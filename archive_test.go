@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewAfterUpload(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(dir, "data")
+	for _, c := range []struct {
+		conf Conf
+		ok   bool
+		move string
+	}{
+		{Conf{}, true, ""},
+		{Conf{AfterUpload: "delete"}, true, ""},
+		{Conf{AfterUpload: "delete", Direction: "push"}, true, ""},
+		{Conf{AfterUpload: "move:" + filepath.Join(dir, "done")}, true, filepath.Join(dir, "done")},
+		{Conf{AfterUpload: "move:" + filepath.Join(local, "done")}, false, ""},
+		{Conf{AfterUpload: "move:" + local}, false, ""},
+		{Conf{AfterUpload: "move:" + local + "-old"}, true, local + "-old"},
+		{Conf{AfterUpload: "remove"}, false, ""},
+		{Conf{AfterUpload: "delete", Direction: "both"}, false, ""},
+		{Conf{AfterUpload: "delete", Mirror: true}, false, ""},
+	} {
+		c.conf.LocalDir = local
+		a, err := newAfterUpload(&c.conf)
+		if (err == nil) != c.ok { t.Errorf("%q: %v, want ok %v", c.conf.AfterUpload, err, c.ok); continue }
+		if a != nil && a.move != c.move { t.Errorf("%q: moves to %q, want %q", c.conf.AfterUpload, a.move, c.move) }
+	}
+}
+
+// newHandOff is a push with after_upload set to how.
+func newHandOff(t *testing.T, how string) *twoWay {
+	w := newTwoWay(t)
+	w.conf.Direction, w.conf.AfterUpload = "", how
+	return w
+}
+
+func TestAfterUploadDelete(t *testing.T) {
+	w := newHandOff(t, "delete")
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "run1/a.dat", "aaa", then)
+	put(t, w.here, "b.dat", "bb", then)
+	if s := w.sync(t); s.Uploaded != 2 || s.Archived != 2 { t.Errorf("%d up, %d handed off; want 2, 2", s.Uploaded, s.Archived) }
+	for _, rel := range []string{"run1/a.dat", "b.dat"} {
+		if body(w.here, rel) != "" { t.Errorf("%s still in local_dir", rel) }
+	}
+	if body(w.there, "run1/a.dat") != "aaa" || body(w.there, "b.dat") != "bb" { t.Error("the target lacks a handed-off file") }
+	if fi, err := os.Stat(filepath.Join(w.here, "run1")); err != nil || !fi.IsDir() { t.Error("folder run1 removed from local_dir") }
+	st, err := loadState(w.conf.StateFile)
+	if err != nil { t.Fatal(err) }
+	if len(st.Files) != 0 { t.Errorf("state file still holds %d record(s)", len(st.Files)) }
+}
+
+func TestAfterUploadMove(t *testing.T) {
+	w := newHandOff(t, "")
+	done := filepath.Join(filepath.Dir(w.here), "done")
+	w.conf.AfterUpload = "move:" + done
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "run1/a.dat", "aaa", then)
+	put(t, done, "run1/a.dat", "older", then) // replaced by the move
+	if s := w.sync(t); s.Archived != 1 { t.Errorf("%d handed off, want 1", s.Archived) }
+	if body(w.here, "run1/a.dat") != "" || body(done, "run1/a.dat") != "aaa" { t.Errorf("here %q, in done %q; want it moved", body(w.here, "run1/a.dat"), body(done, "run1/a.dat")) }
+	if fi, err := os.Stat(filepath.Join(done, "run1", "a.dat")); err != nil || !fi.ModTime().Equal(then) { t.Error("the moved file lost its mtime") }
+}
+
+func TestAfterUploadUpToDate(t *testing.T) {
+	w := newHandOff(t, "")
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "same.dat", "same", then)
+	put(t, w.here, "other.dat", "mine", then)
+	w.sync(t)
+
+	// already on the target: handed off when the copy matches, not when it
+	// only has the same size
+	w.conf.AfterUpload = "delete"
+	put(t, w.there, "other.dat", "THEM", time.Now())
+	s := w.sync(t)
+	if s.Uploaded != 0 || s.Archived != 1 || s.Failed != 1 { t.Errorf("%d up, %d handed off, %d failed; want 0, 1, 1", s.Uploaded, s.Archived, s.Failed) }
+	if body(w.here, "same.dat") != "" { t.Error("same.dat, verified on the target, still in local_dir") }
+	if body(w.here, "other.dat") != "mine" { t.Error("other.dat deleted although the target's copy differs") }
+}
+
+func TestAfterUploadDryRun(t *testing.T) {
+	w := newHandOff(t, "delete")
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "a.dat", "a", then)
+	w.conf.AfterUpload = ""
+	w.sync(t)
+	w.conf.AfterUpload = "delete"
+	p := &summed{}
+	runSync(context.Background(), w.conf, runOpts{progress: p, dryRun: true})
+	if body(w.here, "a.dat") != "a" { t.Error("a dry run deleted a.dat") }
+}
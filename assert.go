@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// ────────── asserting the remote copy ──────────────────────
+// With assert set, a file on the target is only replaced when it is
+// still the copy this site last wrote, as the state file recorded it:
+// "size+mtime" checks both as the target reports them, "hash" also reads
+// the remote file back and compares its hash (where one was recorded,
+// which compare: hash and the never list do). Anything else is a
+// conflict and stays untouched. Without assert, only a remote mtime later
+// than the recorded one counts, which misses a writer that keeps mtimes.
+// HTTP and S3 targets also send the recorded ETag as If-Match, so a write
+// that lands between the check and the upload fails with 412 instead of
+// being overwritten. Targets whose listings round mtimes (FTP servers
+// without MLST) report a different mtime for unchanged files; use assert
+// with them only where the listing is exact.
+
+// checkAssert rejects settings assert cannot work with.
+func checkAssert(c *Conf) error {
+	switch c.Assert {
+	case "":
+		return nil
+	case "size+mtime", "hash":
+	default:
+		return fmt.Errorf("assert: %q is not \"size+mtime\" or \"hash\"", c.Assert)
+	}
+	if c.StateFile == "" { return fmt.Errorf("assert needs state_file to know what was last written") }
+	return nil
+}
+
+// assertRemote returns ErrConflict unless remote is still what the state
+// file recorded for rel. A file without a record has nothing to check.
+func (r *run) assertRemote(ctx context.Context, t target, rel string, remote FileInfo) error {
+	if r.conf.Assert == "" || r.st == nil || !remote.Exists { return nil }
+	last, ok := r.st.last(rel)
+	if !ok || last.Partial { return nil } // what our own broken-off upload left
+	switch {
+	case remote.Size != last.Size:
+		debugf(catCompare, "%s: assert: target has %d bytes, %d were written", rel, remote.Size, last.Size)
+		return ErrConflict
+	case !remote.MTime.Equal(last.RemoteMTime):
+		debugf(catCompare, "%s: assert: target mtime %s, recorded %s", rel, remote.MTime, last.RemoteMTime)
+		return ErrConflict
+	case last.ETag != "" && remote.ETag != "" && remote.ETag != last.ETag:
+		debugf(catCompare, "%s: assert: target ETag %s, recorded %s", rel, remote.ETag, last.ETag)
+		return ErrConflict
+	}
+	algo, want := last.content()
+	if r.conf.Assert != "hash" || want == "" { return nil }
+	h := newHash(algo)
+	if err := t.fetch(ctx, rel, h); err != nil { return fmt.Errorf("assert: reading %s: %w", rel, err) }
+	if sum := sumString(algo, h); sum != want {
+		debugf(catCompare, "%s: assert: target content %s, recorded %s", rel, sum, want)
+		return ErrConflict
+	}
+	return nil
+}
+
+type ifMatchKey struct{}
+
+// withIfMatch makes the upload under ctx conditional on the target still
+// holding the version with etag.
+func withIfMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, etag)
+}
+
+// ifMatchHeader is the If-Match header for an upload under ctx, nil for
+// an unconditional one.
+func ifMatchHeader(ctx context.Context) map[string]string {
+	etag, _ := ctx.Value(ifMatchKey{}).(string)
+	if etag == "" { return nil }
+	return map[string]string{"If-Match": etag}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ────────── backups on the target ──────────────────────────
+// With backup_suffix or backup_dir set, a file on the target that an
+// upload is about to replace is first renamed out of the way, which
+// keeps a simple version history where the files are:
+//
+//	report.xlsx → report.xlsx.2026-10-14~                  (backup_suffix ".{date}~", the default)
+//	report.xlsx → .versions/report.xlsx.2026-10-14~        (backup_dir ".versions")
+//
+// {date} and {time} in the suffix are the replaced copy's mtime, in the
+// machine's local time; "{date}_{time}~" keeps every version, the default
+// one per day. A backup name that is already taken holds an earlier
+// version of that day, which is kept, and the copy is replaced without
+// another backup. backup_dir is a folder under remote_path (and site)
+// that gets the file's path below it. A backup that fails leaves the copy
+// in place and fails the file, so nothing is replaced without one. The
+// copy our own upload left when it broke off is no version and is not
+// kept. Mirror keeps backups, direction both leaves them alone, and the
+// target must be able to rename (FTP, SMB, local, SCP). Old backups are
+// never deleted.
+type backups struct {
+	dir    string // under the site, "" for next to the file
+	suffix string
+	name   *regexp.Regexp // what the suffix makes of a name
+}
+
+const backupSuffix = ".{date}~"
+
+// newBackups is nil when nothing is to be backed up.
+func newBackups(c *Conf) (*backups, error) {
+	if c.BackupDir == "" && c.BackupSuffix == "" { return nil, nil }
+	switch {
+	case c.WriteOnce:
+		return nil, fmt.Errorf("backup: write_once never replaces a file, so there is nothing to back up")
+	case c.Direction == "pull":
+		return nil, fmt.Errorf("backup: direction pull does not replace files on the target")
+	}
+	b := &backups{suffix: c.BackupSuffix}
+	if b.suffix == "" { b.suffix = backupSuffix }
+	if strings.ContainsAny(b.suffix, `/\`) { return nil, fmt.Errorf("backup_suffix: %q is not a name suffix", b.suffix) }
+	if c.BackupDir != "" {
+		b.dir = path.Clean(strings.Trim(strings.ReplaceAll(c.BackupDir, `\`, "/"), "/"))
+		if b.dir == "." || b.dir == ".." || strings.HasPrefix(b.dir, "../") { return nil, fmt.Errorf("backup_dir: %q is not a folder under remote_path", c.BackupDir) }
+	}
+	pat := regexp.QuoteMeta(b.suffix)
+	pat = strings.NewReplacer(`\{date\}`, `\d{4}-\d{2}-\d{2}`, `\{time\}`, `\d{6}`).Replace(pat) // QuoteMeta escaped the braces
+	b.name = regexp.MustCompile(pat + `$`)
+	return b, nil
+}
+
+// of is where the copy at under, a path below the site, goes when its
+// mtime is at.
+func (b *backups) of(under string, at time.Time) string {
+	at = at.Local()
+	name := under + strings.NewReplacer("{date}", at.Format("2006-01-02"), "{time}", at.Format("150405")).Replace(b.suffix)
+	if b.dir != "" { name = b.dir + "/" + name }
+	return name
+}
+
+// covers reports whether under, a path below the site, is a backup.
+func (b *backups) covers(under string) bool {
+	if b == nil { return false }
+	if b.dir != "" { return strings.HasPrefix(under, b.dir+"/") }
+	return b.name.MatchString(under)
+}
+
+// unshared is t without the wrapper an SMB target is shared in.
+func unshared(t target) target {
+	if sc, ok := t.(sharedConn); ok { return sc.target }
+	return t
+}
+
+// backup renames the copy of rel on the target, last changed at mtime,
+// to its backup name before an upload replaces it.
+func (r *run) backup(ctx context.Context, t target, rel string, mtime time.Time) error {
+	if r.backups == nil { return nil }
+	t = unshared(t)
+	rn, ok := t.(renamer)
+	if !ok { return fmt.Errorf("backup: %s targets cannot rename files", r.conf.Type) }
+	if mtime.IsZero() { mtime = time.Now() }
+	to := r.backups.of(r.under(rel), mtime)
+	if r.conf.Site != "" { to = r.conf.Site + "/" + to }
+	_, err := t.stat(ctx, to)
+	switch {
+	case err == nil:
+		debugf(catTransfer, "%s: %s already holds an earlier version, kept", rel, to)
+		return nil
+	case !errors.Is(err, ErrNotFound):
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err = rn.rename(ctx, rel, to); err != nil { return fmt.Errorf("backup: renaming %s to %s: %w", rel, to, err) }
+	debugf(catTransfer, "%s: old copy kept as %s", rel, to)
+	r.backedUp.Add(1)
+	return nil
+}
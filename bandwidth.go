@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ────────── shared bandwidth ───────────────────────────────
+// Jobs side by side on one machine (see job.go) are separate processes,
+// and without this the first to start a large transfer takes the line
+// while the others crawl. With bandwidth.kbps set, the same in every
+// job's config, the jobs moving data at a time split that many KB/s
+// between them by bandwidth.weight, the job's priority (default 1):
+// weights 3 and 1 give 75% and 25%, and a job alone gets all of it. A job
+// that moves less than its part, held up by a slow target say, keeps what
+// it uses and the rest goes to the others (weighted max-min). They meet
+// in bandwidth.dir, by default datasync\bandwidth under ProgramData
+// (datasync-bandwidth in the temp folder elsewhere), which every job's
+// account must be able to write: while it moves data, each job keeps a
+// file there with its weight and rate, written every 2 seconds, and takes
+// its share from the others'. A job that has not written for 10 seconds,
+// scanning or between runs of schedule.interval, counts for nothing, so
+// the others' shares grow back; its file goes when the run ends. All
+// transfers of a run, uploads and downloads, share its rate, on top of
+// power.busy_kbps.
+type BandwidthConf struct {
+	KBps   int64  `json:"kbps"`   // the limit for all jobs on the machine together (0 = unlimited)
+	Weight int    `json:"weight"` // this job's priority in the split (default 1)
+	Dir    string `json:"dir"`    // where the jobs meet, the same for all of them
+}
+
+const (
+	bwRefresh = 2 * time.Second  // how often a job tells the others and looks at theirs
+	bwStale   = 10 * time.Second // a job not heard from for this long moves nothing
+	bwFloor   = 64 << 10         // least rate a job that wants less is given, bytes per second
+)
+
+// bwJob is what a job moving data tells the others, in its file.
+type bwJob struct {
+	Job    string    `json:"job"`
+	Weight int       `json:"weight"`
+	Share  int64     `json:"share"` // the rate it had since it last told, bytes per second; 0: just started
+	Used   int64     `json:"used"`  // the rate it moved at meanwhile
+	At     time.Time `json:"at"`
+}
+
+type bandwidth struct {
+	total int64 // bandwidth.kbps in bytes per second
+	dir   string
+	file  string // this job's
+	mu    sync.Mutex
+	me    bwJob
+	rate  int64     // this job's share
+	moved int64     // bytes since at
+	at    time.Time // when this job last told the others
+	next  time.Time // when the bytes moved so far are paid for
+}
+
+// newBandwidth is nil unless bandwidth.kbps is set.
+func newBandwidth(c *Conf) (*bandwidth, error) {
+	bc := c.Bandwidth
+	switch {
+	case bc.KBps < 0:
+		return nil, fmt.Errorf("bandwidth.kbps: %d is negative", bc.KBps)
+	case bc.Weight < 0:
+		return nil, fmt.Errorf("bandwidth.weight: %d is negative", bc.Weight)
+	case bc.KBps == 0:
+		if bc.Weight != 0 || bc.Dir != "" { return nil, fmt.Errorf("bandwidth.weight and bandwidth.dir are for bandwidth.kbps") }
+		return nil, nil
+	}
+	b := &bandwidth{total: bc.KBps << 10, dir: bc.Dir, rate: bc.KBps << 10, me: bwJob{Job: c.Job, Weight: max(bc.Weight, 1)}}
+	if b.me.Job == "" { b.me.Job = c.instance() }
+	if b.dir == "" { b.dir = bwDir() }
+	if _, err := os.Stat(b.dir); errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(b.dir, 0755); err != nil { return nil, fmt.Errorf("bandwidth.dir: %w", classifyOS(err)) }
+		os.Chmod(b.dir, 0777|os.ModeSticky) // for the other jobs' accounts
+	}
+	b.file = filepath.Join(b.dir, fmt.Sprintf("%d.json", os.Getpid()))
+	return b, nil
+}
+
+// bwDir is where jobs meet by default.
+func bwDir() string {
+	if pd := os.Getenv("ProgramData"); pd != "" { return filepath.Join(pd, "datasync", "bandwidth") }
+	return filepath.Join(os.TempDir(), "datasync-bandwidth")
+}
+
+// pace holds back a transfer under ctx that just moved n bytes for as
+// long as this job's share asks. All transfers of the run share it.
+func (b *bandwidth) pace(ctx context.Context, n int64) {
+	if b == nil { return }
+	b.mu.Lock()
+	now := time.Now()
+	b.moved += n
+	if now.Sub(b.at) >= bwRefresh { b.refresh(now) }
+	if b.next.Before(now) { b.next = now }
+	b.next = b.next.Add(time.Duration(n) * time.Second / time.Duration(b.rate))
+	wait := b.next.Sub(now)
+	b.mu.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
+
+// refresh tells the others what this job moved since it last did, and
+// takes its share of the limit from what they told.
+func (b *bandwidth) refresh(now time.Time) {
+	if b.at.IsZero() || now.Sub(b.at) > bwStale {
+		b.me.Share, b.me.Used = 0, 0 // starting, or again after a pause: it wants what it can get
+	} else {
+		b.me.Share, b.me.Used = b.rate, int64(float64(b.moved)/now.Sub(b.at).Seconds())
+	}
+	b.moved, b.at, b.me.At = 0, now, now.UTC()
+	raw, _ := json.Marshal(b.me)
+	if err := os.WriteFile(b.file+".tmp", raw, 0644); err == nil { os.Rename(b.file+".tmp", b.file) }
+	var others []bwJob
+	ents, _ := os.ReadDir(b.dir)
+	for _, e := range ents {
+		p := filepath.Join(b.dir, e.Name())
+		if p == b.file || !strings.HasSuffix(p, ".json") { continue }
+		var j bwJob
+		if raw, err := os.ReadFile(p); err != nil || json.Unmarshal(raw, &j) != nil { continue }
+		if age := now.Sub(j.At); age > bwStale {
+			if age > time.Hour { os.Remove(p) } // left by a job that did not end cleanly
+			continue
+		}
+		j.Weight = max(j.Weight, 1)
+		others = append(others, j)
+	}
+	rate := fairShare(b.total, b.me, others)
+	if rate != b.rate { debugf(catTransfer, "bandwidth: %d of %d KB/s, %d other job(s) moving data", rate>>10, b.total>>10, len(others)) }
+	b.rate = rate
+}
+
+// demand is the rate job j would take: what it moved, with room to grow,
+// when that was well under its share, else all it can get.
+func (j bwJob) demand() int64 {
+	if j.Share == 0 || j.Used >= j.Share*9/10 { return math.MaxInt64 }
+	return max(j.Used+j.Used/4, bwFloor)
+}
+
+// fairShare is me's rate when total is split between me and others by
+// weighted max-min fairness: the jobs that want less than their part by
+// weight get what they want, and the others split the rest by weight.
+// Every job works it out alike from the same files, so the shares add
+// up to total.
+func fairShare(total int64, me bwJob, others []bwJob) int64 {
+	jobs := append([]bwJob{me}, others...)
+	done := make([]bool, len(jobs))
+	left, weights := float64(total), 0
+	for _, j := range jobs { weights += j.Weight }
+	for more := true; more; {
+		more = false
+		for i, j := range jobs {
+			if done[i] { continue }
+			if d := j.demand(); float64(d) < left*float64(j.Weight)/float64(weights) {
+				if i == 0 { return d }
+				done[i], more = true, true
+				left -= float64(d)
+				weights -= j.Weight
+			}
+		}
+	}
+	return max(int64(left*float64(me.Weight)/float64(weights)), 1<<10)
+}
+
+// close takes this job out of the split.
+func (b *bandwidth) close() {
+	if b == nil { return }
+	os.Remove(b.file)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ────────── blackout windows ───────────────────────────────
+// blackout lists times no transfers may run, in the machine's local time:
+//
+//	"blackout": [
+//	  {"days": ["mon", "tue", "wed", "thu", "fri"], "from": "08:00", "to": "18:00"},
+//	  {"month_days": [-3, -2, -1], "allow_low": true}
+//	]
+//
+// A window covers from..to on each day it names, by weekday (days) and
+// date (month_days, where -1 is the last day of the month); a window with
+// neither applies every day, one without from and to the whole day. A to
+// before from runs past midnight, the night belonging to the day it
+// starts. allow_low lets runs with priority "low" go on through the
+// window. A run that starts in a window does nothing and says until when
+// it is deferred, exit 0 and a run_deferred event; one still going when a
+// window begins is stopped as kind blackout, with what it finished kept
+// and a resume token for the rest. A dry run (and estimate) may run any
+// time.
+type BlackoutConf struct {
+	Days      []string `json:"days"`       // "mon" … "sun"; none: every day
+	MonthDays []int    `json:"month_days"` // 1…31, or -1 for the last day of the month, -2 the one before…
+	From      string   `json:"from"`       // "HH:MM"; none: midnight
+	To        string   `json:"to"`         // "HH:MM", before from for a night; none: midnight
+	AllowLow  bool     `json:"allow_low"`  // runs with priority "low" are not held back
+}
+
+type window struct {
+	days     [7]bool // by time.Weekday; all false: any
+	mdays    map[int]bool
+	from, to time.Duration // into the day
+	name     string        // for messages, as configured
+}
+
+type blackout struct{ windows []window }
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// newBlackout parses the windows that apply to a run at priority; nil
+// when there are none.
+func newBlackout(cs []BlackoutConf, priority string) (*blackout, error) {
+	var b blackout
+	for i, c := range cs {
+		w := window{mdays: map[int]bool{}}
+		for _, d := range c.Days {
+			wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+			if !ok { return nil, fmt.Errorf("blackout[%d].days: %q is not a weekday like \"mon\"", i, d) }
+			w.days[wd] = true
+		}
+		for _, d := range c.MonthDays {
+			if d == 0 || d < -31 || d > 31 { return nil, fmt.Errorf("blackout[%d].month_days: %d is not 1…31 or -1…-31", i, d) }
+			w.mdays[d] = true
+		}
+		var err error
+		if w.from, err = clockTime(c.From); err != nil { return nil, fmt.Errorf("blackout[%d].from: %w", i, err) }
+		if w.to, err = clockTime(c.To); err != nil { return nil, fmt.Errorf("blackout[%d].to: %w", i, err) }
+		from, to := c.From, c.To
+		if from == "" { from = "00:00" }
+		if to == "" { to = "24:00" }
+		w.name = from + "-" + to
+		if c.From == "" && c.To == "" { w.name = "all day" }
+		if c.AllowLow && strings.EqualFold(priority, "low") { continue }
+		b.windows = append(b.windows, w)
+	}
+	if len(b.windows) == 0 { return nil, nil }
+	return &b, nil
+}
+
+func clockTime(s string) (time.Duration, error) {
+	if s == "" { return 0, nil }
+	t, err := time.Parse("15:04", s)
+	if err != nil { return 0, fmt.Errorf("%q is not a time like \"08:00\"", s) }
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// on reports whether the window's day rule takes in the day of t.
+func (w window) on(t time.Time) bool {
+	if w.days != ([7]bool{}) && !w.days[t.Weekday()] { return false }
+	if len(w.mdays) == 0 { return true }
+	last := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	return w.mdays[t.Day()] || w.mdays[t.Day()-last-1]
+}
+
+func (w window) covers(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	into := t.Sub(midnight)
+	switch {
+	case w.from == w.to:
+		return w.on(t)
+	case w.from < w.to:
+		return into >= w.from && into < w.to && w.on(t)
+	case into >= w.from:
+		return w.on(t)
+	}
+	return into < w.to && w.on(midnight.AddDate(0, 0, -1)) // the night before
+}
+
+// in is the window covering t, if any.
+func (b *blackout) in(t time.Time) (window, bool) {
+	for _, w := range b.windows {
+		if w.covers(t) { return w, true }
+	}
+	return window{}, false
+}
+
+// blackoutScan bounds the minute-by-minute search for a change, which
+// the longest sensible combination of windows (a month end over a
+// weekend, say) stays well within.
+const blackoutScan = 62 * 24 * time.Hour
+
+// until is the first minute after t in no window; zero when none is found.
+func (b *blackout) until(t time.Time) time.Time {
+	for m := t.Truncate(time.Minute).Add(time.Minute); m.Sub(t) < blackoutScan; m = m.Add(time.Minute) {
+		if _, in := b.in(m); !in { return m }
+	}
+	return time.Time{}
+}
+
+// next is when the next window after t begins, and which; ok is false if
+// none does within the search.
+func (b *blackout) next(t time.Time) (at time.Time, w window, ok bool) {
+	for m := t.Truncate(time.Minute).Add(time.Minute); m.Sub(t) < blackoutScan; m = m.Add(time.Minute) {
+		if w, in := b.in(m); in { return m, w, true }
+	}
+	return time.Time{}, window{}, false
+}
+
+// watch stops the run with stop when the next window begins; calling
+// what it returns lets the run end on its own.
+func (b *blackout) watch(stop context.CancelCauseFunc) func() {
+	at, w, ok := b.next(time.Now())
+	if !ok { return func() {} }
+	debugf(catTransfer, "blackout: %s begins at %s", w.name, at.Format("Mon 15:04"))
+	t := time.AfterFunc(time.Until(at), func() {
+		stop(withClass(ErrBlackout, fmt.Errorf("blackout %s began at %s, run stopped; the rest waits until %s", w.name, at.Format("15:04"), untilText(b.until(at)))))
+	})
+	return func() { t.Stop() }
+}
+
+// untilText is when a blackout ends, for messages.
+func untilText(t time.Time) string {
+	switch {
+	case t.IsZero():
+		return "further notice"
+	case t.YearDay() == time.Now().YearDay() && t.Year() == time.Now().Year():
+		return t.Format("15:04")
+	}
+	return t.Format("Mon 2 Jan 15:04")
+}
+
+// deferRun reports a run that started in a window, on the console and to
+// the event sinks, and is its exit code.
+func (r *run) deferRun(w window, until time.Time) int {
+	say("!", "%s", tr("blackout_deferred", w.name, untilText(until)))
+	if bus, err := eventBus(r.conf, r.opts.bus); err == nil && bus != nil {
+		e := Event{Type: EventRunDeferred}
+		if !until.IsZero() { e.Until = &until }
+		bus.publish(e)
+		bus.close()
+	}
+	return 0
+}
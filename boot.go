@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ────────── catch-up at boot ───────────────────────────────
+// -at-boot is for a task that starts with the machine, next to the one
+// that runs on the usual schedule, so a machine that was off over the
+// weekend catches up on Monday morning instead of at the next scheduled
+// time. It first waits boot.settle (default 2m) for the network and the
+// share to come up, then runs the sync as usual, unless boot.catch_up is
+// set and the last good run (from the state file) is more recent than
+// that: a reboot in the middle of the day then does not make an extra run.
+// The schedule is left to the other task.
+type BootConf struct {
+	Settle  string `json:"settle"`   // wait this long after start-up first, default "2m"
+	CatchUp string `json:"catch_up"` // only run when the last good run is older than this; none: always
+}
+
+const bootSettle = 2 * time.Minute
+
+// atBoot waits and decides whether a catch-up run is due. A false run
+// comes with the exit code to leave with.
+func atBoot(ctx context.Context, conf *Conf) (run bool, code int, err error) {
+	settle := bootSettle
+	if s := conf.Boot.Settle; s != "" {
+		if settle, err = time.ParseDuration(s); err != nil || settle < 0 { return false, 1, fmt.Errorf("boot.settle: %q is not a duration like 2m", s) }
+	}
+	var catchUp time.Duration
+	if s := conf.Boot.CatchUp; s != "" {
+		if catchUp, err = time.ParseDuration(s); err != nil || catchUp <= 0 { return false, 1, fmt.Errorf("boot.catch_up: %q is not a duration like 24h", s) }
+		if conf.StateFile == "" { return false, 1, fmt.Errorf("boot.catch_up needs state_file to know when the last good run was") }
+	}
+	if settle > 0 {
+		say("…", "%s", tr("boot_settle", settle))
+		select {
+		case <-ctx.Done():
+			return false, 1, nil
+		case <-time.After(settle):
+		}
+	}
+	if catchUp == 0 { return true, 0, nil }
+	st, err := loadState(conf.StateFile)
+	if err != nil { return false, 1, err }
+	if st.Failing || st.FreshSince.IsZero() { return true, 0, nil }
+	if ago := time.Since(st.FreshSince); ago < catchUp {
+		say("✓", "%s", tr("boot_current", roundDuration(ago), catchUp))
+		return false, 0, nil
+	}
+	return true, 0, nil
+}
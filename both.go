@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── two-way sync ───────────────────────────────────
+// With direction "both" a run brings local_dir and the target together,
+// one file at a time, from what the state file says about the last time
+// the two were in sync (both mtimes and the size):
+//
+//	local       target      in sync before   done
+//	changed     unchanged   yes              upload
+//	unchanged   changed     yes              download
+//	changed     changed     yes              conflict, see on_conflict
+//	unchanged   gone        yes              local copy deleted
+//	gone        unchanged   yes              copy on the target deleted
+//	changed     gone        yes              conflict, see on_conflict (either way round)
+//	present     present     no               the newer replaces the older
+//	present     gone        no               copied to the other side
+//	gone        gone        yes              record dropped
+//
+// On the target, changed is a later mtime or another size, as a one-way
+// sync sees conflicts, since FTP listings round mtimes down. Files met on
+// both sides for the first time with the same size count as in sync. A
+// side that lists none of the files last in sync is taken for a wrong
+// path or a missing share, and the run stops before deleting anything.
+// Uploads go through the checks of a push (never_transfer, hold, guard),
+// and a held file is not deleted on the target either. Names a push could
+// not send back under the same name fail as name-invalid. FTP, SMB and
+// local targets can do this.
+
+// remover is implemented by targets files can be deleted from.
+type remover interface {
+	remove(ctx context.Context, rel string) error
+	removeDir(ctx context.Context, dir string) error // an empty folder
+}
+
+// twoWayConflict is a file direction both leaves alone; key is the
+// operator message for it.
+type twoWayConflict struct{ key, msg string }
+
+func (c *twoWayConflict) Error() string { return c.msg }
+func (c *twoWayConflict) Unwrap() error { return ErrConflict }
+
+var (
+	errBothChanged = &twoWayConflict{"conflict_both", "changed on both sides since the last sync"}
+	errGoneChanged = &twoWayConflict{"conflict_gone", "changed on one side and deleted on the other"}
+)
+
+func (r *run) both(ctx context.Context, first target, dial func() (target, error)) error {
+	defer first.close()
+	l, ok := listerOf(first)
+	if !ok { return fmt.Errorf("direction both: %s targets cannot be listed", r.conf.Type) }
+	t := first
+	if sc, shared := first.(sharedConn); shared { t = sc.target }
+	rm, ok := t.(remover)
+	if !ok { return fmt.Errorf("direction both: %s targets cannot delete files", r.conf.Type) }
+	r.cmp = alwaysComparer{} // decided here, from the state file
+
+	locals, err := r.scanLocal(ctx)
+	if err != nil { return err }
+	remotes := map[string]FileInfo{}
+	err = listTree(ctx, l, r.conf.Site, func(f FileInfo) error {
+		if !r.backups.covers(r.under(f.Rel)) { remotes[f.Rel] = f }
+		return nil
+	})
+	if err != nil { return err }
+	rels, err := r.reconcilable(locals, remotes)
+	if err != nil { return err }
+
+	jobs := make(chan job)
+	done := make(chan struct{})
+	go func() { r.pool(ctx, nil, dial, jobs); close(done) }()
+	for _, rel := range rels {
+		if ctx.Err() != nil { break }
+		j, err := r.reconcile(ctx, first, rm, rel, locals, remotes)
+		switch {
+		case err != nil:
+			r.skip(rel, err)
+		case j != nil:
+			select {
+			case jobs <- *j:
+			case <-ctx.Done():
+			}
+		}
+	}
+	close(jobs)
+	<-done
+	return r.releaseHeld(ctx, dial)
+}
+
+// scanLocal lists local_dir the way a push would, by path on the target.
+func (r *run) scanLocal(ctx context.Context) (map[string]job, error) {
+	jobs := make(chan job)
+	found := map[string]job{}
+	done := make(chan struct{})
+	go func() { for j := range jobs { found[j.rel] = j }; close(done) }()
+	sc := &scanner{ctx: ctx, root: r.conf.LocalDir, site: r.conf.Site, form: r.conf.Normalize, threads: r.conf.scanThreads(), ordered: r.conf.Ordered, jobs: jobs}
+	err := sc.run()
+	close(jobs)
+	<-done
+	return found, err
+}
+
+// reconcilable is every path to decide on, in order: what either side has
+// and what the state file remembers under the site. It refuses when one
+// side holds none of the files last in sync.
+func (r *run) reconcilable(locals map[string]job, remotes map[string]FileInfo) ([]string, error) {
+	all := map[string]bool{}
+	var synced, here, there int
+	r.st.mu.Lock()
+	for rel := range r.st.Files {
+		if r.conf.Site != "" && !strings.HasPrefix(rel, r.conf.Site+"/") { continue }
+		all[rel] = true
+		synced++
+		if _, ok := locals[rel]; ok { here++ }
+		if _, ok := remotes[rel]; ok { there++ }
+	}
+	r.st.mu.Unlock()
+	switch {
+	case synced > 0 && here == 0:
+		return nil, fmt.Errorf("direction both: local_dir holds none of the %d file(s) last in sync; nothing was deleted (is local_dir right?)", synced)
+	case synced > 0 && there == 0:
+		return nil, fmt.Errorf("direction both: the target holds none of the %d file(s) last in sync; nothing was deleted (is remote_path right?)", synced)
+	}
+	for rel := range locals { all[rel] = true }
+	for rel := range remotes { all[rel] = true }
+	rels := make([]string, 0, len(all))
+	for rel := range all { rels = append(rels, rel) }
+	sort.Strings(rels)
+	return rels, nil
+}
+
+// reconcile decides rel. Deletions are done on t (or locally) right away;
+// a transfer comes back as the job for the pool.
+func (r *run) reconcile(ctx context.Context, t target, rm remover, rel string, locals map[string]job, remotes map[string]FileInfo) (*job, error) {
+	j, here := locals[rel]
+	f, there := remotes[rel]
+	last, known := r.st.last(rel)
+	if here && !j.known {
+		fi, err := os.Stat(j.path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			here = false // deleted since the scan
+		case err != nil:
+			return nil, classifyOS(err)
+		default:
+			j.size, j.mtime, j.known = fi.Size(), fi.ModTime(), true
+		}
+	}
+	localChanged := here && known && (j.size != last.Size || !j.mtime.Equal(last.LocalMTime))
+	if here && known && last.LocalMTime.IsZero() {
+		// recorded by a push, which keeps only the target's side
+		localChanged = j.size != last.Size || j.mtime.After(last.RemoteMTime)
+	}
+	remoteChanged := there && known && (f.Size != last.Size || f.MTime.After(last.RemoteMTime))
+	upload := func() (*job, error) { return &j, nil }
+	download := func() (*job, error) {
+		for _, p := range strings.Split(rel, "/") {
+			if !rpath.ValidName(p) { return nil, withClass(ErrInvalidName, fmt.Errorf("%q cannot be created in local_dir under its name", p)) }
+		}
+		local, err := r.pullPath(rel)
+		if err != nil { return nil, withClass(ErrInvalidName, err) }
+		return &job{path: local, rel: rel, size: f.Size, mtime: f.MTime, known: true, pull: true}, nil
+	}
+	switch {
+	case here && there && !known:
+		switch {
+		case j.size == f.Size:
+			debugf(catCompare, "%s: same size on both sides, taken as in sync", rel)
+			r.st.record(rel, f.MTime, f.ETag, FileInfo{Size: j.size, MTime: j.mtime})
+			return nil, nil
+		case newer(j.mtime, f.MTime):
+			return upload()
+		case f.MTime.After(j.mtime):
+			return download()
+		}
+		return r.settle(rel, j, f, upload, download)
+	case here && there:
+		switch {
+		case localChanged && remoteChanged:
+			return r.settle(rel, j, f, upload, download)
+		case localChanged:
+			return upload()
+		case remoteChanged:
+			return download()
+		}
+		debugf(catCompare, "%s: up to date", rel)
+		return nil, nil
+	case here:
+		switch {
+		case !known:
+			return upload()
+		case localChanged:
+			switch r.winner(j.mtime, time.Time{}) {
+			case "local":
+				r.settled(rel, "local", "")
+				return upload()
+			case "remote":
+				r.settled(rel, "remote", "")
+			default:
+				return nil, errGoneChanged
+			}
+		}
+		debugf(catCompare, "%s: deleted on the target since the last sync", rel)
+		if r.opts.dryRun { r.wouldRemove(rel, true); return nil, nil }
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, fs.ErrNotExist) { return nil, classifyOS(err) }
+		r.removed(rel, true)
+		return nil, nil
+	case there:
+		switch {
+		case !known:
+			return download()
+		case remoteChanged:
+			switch r.winner(time.Time{}, f.MTime) {
+			case "remote":
+				r.settled(rel, "remote", "")
+				return download()
+			case "local":
+				r.settled(rel, "local", "")
+			default:
+				return nil, errGoneChanged
+			}
+		}
+		debugf(catCompare, "%s: deleted locally since the last sync", rel)
+		if local, err := r.pullPath(rel); err == nil && r.hold.covers(r.localRel(local)) { return nil, ErrHeld }
+		if r.opts.dryRun { r.wouldRemove(rel, false); return nil, nil }
+		if err := r.snapshot.before(ctx, t); err != nil { return nil, err }
+		if err := rm.remove(ctx, rel); err != nil && !errors.Is(err, ErrNotFound) { return nil, err }
+		r.removed(rel, false)
+		return nil, nil
+	}
+	r.st.forget(rel) // gone from both
+	return nil, nil
+}
+
+// settle applies on_conflict to rel, changed on both sides since the
+// last sync; upload and download make the job for either way.
+func (r *run) settle(rel string, j job, f FileInfo, upload, download func() (*job, error)) (*job, error) {
+	switch r.winner(j.mtime, f.MTime) {
+	case "local":
+		r.settled(rel, "local", "")
+		u, err := upload()
+		if u != nil { u.settled = true }
+		return u, err
+	case "remote":
+		r.settled(rel, "remote", "")
+		return download()
+	case "both":
+		d, err := download()
+		if err != nil { return nil, err }
+		if err = r.keepBoth(rel, j.path); err != nil { return nil, err }
+		return d, nil
+	}
+	return nil, errBothChanged
+}
+
+// removed reports a deletion, here (local) or on the target.
+func (r *run) removed(rel string, local bool) {
+	r.st.forget(rel)
+	r.deleted.Add(1)
+	if logOn(catTransfer, lvlInfo) {
+		key := "deleted_remote"
+		if local { key = "deleted_local" }
+		say("-", "%s", tr(key, rel))
+	}
+	reportRemoval(r.prog, rel, local)
+}
+
+// wouldRemove is removed for a dry run, which keeps the record.
+func (r *run) wouldRemove(rel string, local bool) {
+	r.deleted.Add(1)
+	key := "would_delete"
+	if local { key = "would_delete_here" }
+	r.would("-", key, rel)
+}
+
+// skip counts a file reconcile left alone or could not handle.
+func (r *run) skip(rel string, err error) {
+	switch {
+	case errors.Is(err, ErrConflict):
+		r.conflicts.Add(1)
+	case errors.Is(err, ErrHeld):
+		r.held.Add(1)
+	case errors.Is(err, ErrDeferred):
+		r.deferred.Add(1)
+	default:
+		r.fail(err)
+	}
+	r.prog.OnError(rel, err)
+}
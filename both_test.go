@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// twoWay is a local_dir, a local target and a state file for direction both.
+type twoWay struct {
+	conf       *Conf
+	here, there string
+}
+
+func newTwoWay(t *testing.T) *twoWay {
+	dir := t.TempDir()
+	w := &twoWay{here: filepath.Join(dir, "here"), there: filepath.Join(dir, "there")}
+	for _, d := range []string{w.here, w.there} {
+		if err := os.Mkdir(d, 0755); err != nil { t.Fatal(err) }
+	}
+	w.conf = &Conf{LocalDir: w.here, Type: "local", Local: LocalConf{Path: w.there}, StateFile: filepath.Join(dir, "state.json"), Direction: "both"}
+	return w
+}
+
+// summed is the printer, keeping the run's summary.
+type summed struct {
+	printer
+	sum Summary
+}
+
+func (p *summed) OnSummary(s Summary) { p.sum = s; p.printer.OnSummary(s) }
+
+func (w *twoWay) sync(t *testing.T) Summary {
+	t.Helper()
+	p := &summed{}
+	runSync(context.Background(), w.conf, runOpts{progress: p})
+	if p.sum.Err != nil { t.Fatalf("run: %v", p.sum.Err) }
+	return p.sum
+}
+
+// put writes body to rel under root with mtime at.
+func put(t *testing.T, root, rel, body string, at time.Time) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil { t.Fatal(err) }
+	if err := os.WriteFile(p, []byte(body), 0644); err != nil { t.Fatal(err) }
+	if err := os.Chtimes(p, at, at); err != nil { t.Fatal(err) }
+}
+
+// body is rel's content under root, "" when it is not there.
+func body(root, rel string) string {
+	b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil { return "" }
+	return string(b)
+}
+
+func TestTwoWayMatrix(t *testing.T) {
+	w := newTwoWay(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	later := then.Add(30 * time.Minute)
+	for _, rel := range []string{"up.txt", "down.txt", "both.txt", "gone-there.txt", "gone-here.txt", "changed-gone.txt"} {
+		put(t, w.here, rel, "v1", then)
+	}
+	if s := w.sync(t); s.Uploaded != 6 { t.Fatalf("first run uploaded %d, want 6", s.Uploaded) }
+
+	put(t, w.here, "up.txt", "local v2", later)
+	put(t, w.there, "down.txt", "remote v2", later)
+	put(t, w.here, "both.txt", "local v2", later)
+	put(t, w.there, "both.txt", "remote v2", later)
+	os.Remove(filepath.Join(w.there, "gone-there.txt"))
+	os.Remove(filepath.Join(w.here, "gone-here.txt"))
+	put(t, w.here, "changed-gone.txt", "local v2", later)
+	os.Remove(filepath.Join(w.there, "changed-gone.txt"))
+	put(t, w.here, "new-here.txt", "n", later)
+	put(t, w.there, "new-there.txt", "n", later)
+
+	s := w.sync(t)
+	for _, c := range []struct{ root, rel, want string }{
+		{w.there, "up.txt", "local v2"},
+		{w.here, "down.txt", "remote v2"},
+		{w.here, "both.txt", "local v2"},
+		{w.there, "both.txt", "remote v2"},
+		{w.here, "gone-there.txt", ""},
+		{w.there, "gone-here.txt", ""},
+		{w.here, "changed-gone.txt", "local v2"},
+		{w.there, "changed-gone.txt", ""},
+		{w.there, "new-here.txt", "n"},
+		{w.here, "new-there.txt", "n"},
+	} {
+		if got := body(c.root, c.rel); got != c.want { t.Errorf("%s in %s = %q, want %q", c.rel, filepath.Base(c.root), got, c.want) }
+	}
+	if s.Uploaded != 2 || s.Pulled != 2 || s.Deleted != 2 || s.Conflicts != 2 {
+		t.Errorf("second run: %d up, %d down, %d deleted, %d conflicts; want 2, 2, 2, 2", s.Uploaded, s.Pulled, s.Deleted, s.Conflicts)
+	}
+
+	// nothing changed: nothing to do, and the conflicts are still there
+	if s = w.sync(t); s.Uploaded+s.Pulled+s.Deleted != 0 || s.Conflicts != 2 {
+		t.Errorf("third run: %d up, %d down, %d deleted, %d conflicts; want 0, 0, 0, 2", s.Uploaded, s.Pulled, s.Deleted, s.Conflicts)
+	}
+}
+
+func TestTwoWayNotInSync(t *testing.T) {
+	w := newTwoWay(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "a.txt", "older", then)
+	put(t, w.there, "a.txt", "newer!", then.Add(time.Minute))
+	put(t, w.here, "b.txt", "newer!", then.Add(time.Minute))
+	put(t, w.there, "b.txt", "older", then)
+	put(t, w.here, "c.txt", "same", then)
+	put(t, w.there, "c.txt", "SAME", then.Add(time.Minute))
+	w.sync(t)
+	for _, c := range []struct{ rel, want string }{{"a.txt", "newer!"}, {"b.txt", "newer!"}, {"c.txt", "same"}} {
+		if h, th := body(w.here, c.rel), body(w.there, c.rel); h != c.want || th != c.want && c.rel != "c.txt" {
+			t.Errorf("%s: here %q, there %q; want %q", c.rel, h, th, c.want)
+		}
+	}
+	// same size, met for the first time: taken as in sync, neither copied
+	if got := body(w.there, "c.txt"); got != "SAME" { t.Errorf("c.txt on the target = %q, want it left alone", got) }
+}
+
+func TestTwoWayMissingSide(t *testing.T) {
+	w := newTwoWay(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "a.txt", "a", then)
+	put(t, w.here, "b.txt", "b", then)
+	w.sync(t)
+
+	// the target looks empty, as a missing share or a wrong path would
+	for _, rel := range []string{"a.txt", "b.txt"} { os.Remove(filepath.Join(w.there, rel)) }
+	p := &summed{}
+	if code := runSync(context.Background(), w.conf, runOpts{progress: p}); code == 0 || p.sum.Err == nil { t.Fatalf("run over an empty target succeeded (exit %d)", code) }
+	if body(w.here, "a.txt") != "a" || body(w.here, "b.txt") != "b" { t.Error("local files were deleted although the target listed none of them") }
+}
+
+// TestTwoWayOnConflict edits a file on both sides, and another on one
+// side while deleting it on the other, under every on_conflict.
+func TestTwoWayOnConflict(t *testing.T) {
+	for _, c := range []struct {
+		mode       string
+		localNewer bool
+		here       string // both.txt on either side after the run
+		there      string
+		kept       bool   // keep-both: the local edit moved aside
+		lchanged   string // changed here, deleted there: the file on either side
+		rchanged   string // changed there, deleted here
+	}{
+		{"", true, "local v2", "remote v2", false, "", ""},
+		{"", false, "local v2", "remote v2", false, "", ""},
+		{"local-wins", true, "local v2", "local v2", false, "local v2", ""},
+		{"local-wins", false, "local v2", "local v2", false, "local v2", ""},
+		{"remote-wins", true, "remote v2", "remote v2", false, "", "remote v2"},
+		{"remote-wins", false, "remote v2", "remote v2", false, "", "remote v2"},
+		{"newest-wins", true, "local v2", "local v2", false, "local v2", "remote v2"},
+		{"newest-wins", false, "remote v2", "remote v2", false, "local v2", "remote v2"},
+		{"keep-both", true, "remote v2", "remote v2", true, "local v2", "remote v2"},
+		{"keep-both", false, "remote v2", "remote v2", true, "local v2", "remote v2"},
+	} {
+		name := c.mode
+		if name == "" { name = "skip-and-report" }
+		if c.localNewer { name += "/local-newer" } else { name += "/remote-newer" }
+		t.Run(name, func(t *testing.T) {
+			w := newTwoWay(t)
+			w.conf.OnConflict = c.mode
+			then := time.Now().Add(-time.Hour).Truncate(time.Second)
+			for _, rel := range []string{"both.txt", "lchanged.txt", "rchanged.txt", "same.txt"} { put(t, w.here, rel, "v1", then) }
+			w.sync(t)
+
+			lm, rm := then.Add(2*time.Minute), then.Add(time.Minute)
+			if !c.localNewer { lm, rm = rm, lm }
+			put(t, w.here, "both.txt", "local v2", lm)
+			put(t, w.there, "both.txt", "remote v2", rm)
+			put(t, w.here, "lchanged.txt", "local v2", lm)
+			os.Remove(filepath.Join(w.there, "lchanged.txt"))
+			put(t, w.there, "rchanged.txt", "remote v2", rm)
+			os.Remove(filepath.Join(w.here, "rchanged.txt"))
+			s := w.sync(t)
+
+			if got := body(w.here, "both.txt"); got != c.here { t.Errorf("both.txt here = %q, want %q", got, c.here) }
+			if got := body(w.there, "both.txt"); got != c.there { t.Errorf("both.txt there = %q, want %q", got, c.there) }
+			for _, f := range []struct{ rel, want string }{{"lchanged.txt", c.lchanged}, {"rchanged.txt", c.rchanged}} {
+				if h, th := body(w.here, f.rel), body(w.there, f.rel); c.mode != "" && (h != f.want || th != f.want) { t.Errorf("%s: here %q, there %q; want %q on both", f.rel, h, th, f.want) }
+			}
+			if c.mode == "" {
+				if s.Conflicts != 3 || s.Resolved != 0 { t.Errorf("%d conflicts, %d resolved; want 3, 0", s.Conflicts, s.Resolved) }
+				if body(w.here, "lchanged.txt") != "local v2" || body(w.there, "rchanged.txt") != "remote v2" { t.Error("skip-and-report lost a changed copy") }
+			} else if s.Conflicts != 0 || s.Resolved != 3 {
+				t.Errorf("%d conflicts, %d resolved; want 0, 3", s.Conflicts, s.Resolved)
+			}
+			var aside string
+			ents, _ := os.ReadDir(w.here)
+			for _, e := range ents {
+				if strings.Contains(e.Name(), "(conflict ") && body(w.here, e.Name()) == "local v2" { aside = e.Name() }
+			}
+			if (aside != "") != c.kept { t.Fatalf("local edit kept aside as %q, want kept %v", aside, c.kept) }
+			if s = w.sync(t); c.mode != "" && s.Conflicts+s.Resolved != 0 { t.Errorf("next run: %d conflicts, %d resolved; want none", s.Conflicts, s.Resolved) }
+			if aside != "" && body(w.there, aside) != "local v2" { t.Errorf("keep-both copy %s not uploaded by the next run", aside) }
+		})
+	}
+}
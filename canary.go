@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ────────── canary ─────────────────────────────────────────
+// With canary set to a file name, every run ends by writing that file to
+// the target, under the site like any upload, and reading it back. A
+// target that acknowledges writes but keeps nothing (a volume remounted
+// read-only under an SMB share, a proxy answering for a dead backend)
+// looks healthy for as long as no file changes; the canary catches it on
+// the next run. The file holds the run ID, so an old copy does not pass.
+// The run fails unless the same bytes come back.
+
+// newRunID names a run by its start and a random suffix.
+func newRunID(start time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return start.UTC().Format(versionStamp) + "-" + hex.EncodeToString(b)
+}
+
+// checkCanary rejects settings the canary cannot work with.
+func checkCanary(c *Conf) error {
+	if c.Canary == "" { return nil }
+	if c.WriteOnce { return fmt.Errorf("canary replaces its file every run, which write_once forbids") }
+	if _, err := os.Lstat(filepath.Join(c.LocalDir, filepath.FromSlash(c.Canary))); err == nil {
+		return fmt.Errorf("canary: %s is also a file in local_dir", c.Canary)
+	}
+	return nil
+}
+
+// canary writes the canary and reads it back, retrying what may pass on
+// a fresh connection as file uploads do.
+func (r *run) canary(ctx context.Context, dial func() (target, error)) error {
+	rel := r.conf.Canary
+	if r.conf.Site != "" { rel = r.conf.Site + "/" + rel }
+	body := fmt.Sprintf("datasync canary\nrun %s\nsite %s\nwritten %s\n", r.id, r.conf.Site, time.Now().UTC().Format(time.RFC3339))
+	f, err := os.CreateTemp(tempDir, "datasync-canary-*")
+	if err != nil { return err }
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(body)
+	if cerr := f.Close(); err == nil { err = cerr }
+	if err != nil { return err }
+	for try := 1; try <= attempts; try++ {
+		if err = r.roundTrip(ctx, dial, f.Name(), rel, body); err == nil || !retryable(err) { break }
+		if try < attempts { debugf(catTransfer, "canary: attempt %d failed, retrying: %v", try, err) }
+	}
+	return err
+}
+
+func (r *run) roundTrip(ctx context.Context, dial func() (target, error), local, rel, body string) error {
+	t, err := dial()
+	if err != nil { return err }
+	defer t.close()
+	if err = t.upload(ctx, local, rel); err != nil { return fmt.Errorf("canary: writing %s: %w", rel, err) }
+	var got bytes.Buffer
+	if err = t.fetch(ctx, rel, &got); err != nil { return fmt.Errorf("canary: reading %s back: %w", rel, err) }
+	if got.String() != body {
+		return withClass(ErrCanary, fmt.Errorf("canary: %s came back with %d bytes that are not what run %s wrote; the target does not keep what it accepts", rel, got.Len(), r.id))
+	}
+	debugf(catTransfer, "canary: %s read back intact", rel)
+	return nil
+}
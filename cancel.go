@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+// ────────── cancellation ───────────────────────────────────
+// Neither the FTP client nor file I/O takes a context, so cancelling means
+// breaking whatever they are blocked on: FTP sockets are closed under the
+// client, and local reads start failing with the context's error.
+
+// connWatch tracks every socket one FTP session opens, control and data
+// connections alike, so abort can close them all.
+type connWatch struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+type watchedConn struct {
+	net.Conn
+	w *connWatch
+}
+
+func (c *watchedConn) Close() error {
+	c.w.mu.Lock()
+	delete(c.w.conns, c.Conn)
+	c.w.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func newConnWatch() *connWatch { return &connWatch{conns: map[net.Conn]struct{}{}} }
+
+// dialFunc dials through d and registers the result. Connections dialled
+// after ctx ends fail straight away.
+func (w *connWatch) dialFunc(ctx context.Context, d net.Dialer) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil { return nil, err }
+		w.mu.Lock()
+		w.conns[c] = struct{}{}
+		w.mu.Unlock()
+		return &watchedConn{c, w}, nil
+	}
+}
+
+func (w *connWatch) abort() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for c := range w.conns { c.Close() }
+}
+
+// during arms the watch for one operation: if ctx ends before the
+// returned stop is called, the session's sockets are closed.
+func (w *connWatch) during(ctx context.Context) (stop func() bool) {
+	return context.AfterFunc(ctx, w.abort)
+}
+
+// ctxErr reports the context's error in place of whatever a torn-down
+// operation failed with.
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil { return ctx.Err() }
+	return err
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read also reports what it read to the context's byte count.
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil { return 0, err }
+	n, err := c.r.Read(p)
+	countBytes(c.ctx, int64(n))
+	return n, err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// ────────── comparison ─────────────────────────────────────
+// A Comparer decides whether a local file must be uploaded over what the
+// target holds. "compare" in the config picks a built-in one; an
+// embedding application can pass its own in runOpts, e.g. to read version
+// numbers out of file names. Workers call it concurrently.
+type Comparer interface {
+	NeedsUpload(local *FileInfo, remote FileInfo) (bool, error)
+}
+
+// FileInfo describes one side of a comparison. The remote side only has
+// Rel, Size, MTime and Exists.
+type FileInfo struct {
+	Rel    string    // path under the target, slash separated
+	Path   string    // local file on disk
+	Size   int64
+	MTime  time.Time
+	Exists bool
+	SHA256 string    // local side: set by comparers that hash; kept in the state file after upload
+	Sum    string    // local side: compare: hash under another hash_algorithm, "xxhash:…" or "blake3:…"
+	resume *hashResume
+	ETag   string    // remote side: HTTP and S3 targets, strong ETags only
+}
+
+func newComparer(conf *Conf, st *syncState) (Comparer, error) {
+	switch strings.ToLower(conf.Compare) {
+	case "", "mtime":
+		return mtimeComparer{}, nil
+	case "size+mtime", "mtime+size":
+		return sizeMTimeComparer{}, nil
+	case "hash":
+		if st == nil { return nil, fmt.Errorf("compare: hash needs state_file to remember what was uploaded") }
+		algo, err := hashAlgorithm(conf)
+		if err != nil { return nil, err }
+		return hashComparer{st, algo}, nil
+	case "always":
+		return alwaysComparer{}, nil
+	case "never":
+		return neverComparer{}, nil
+	}
+	return nil, fmt.Errorf("compare: unknown value %q (use mtime, size+mtime, mtime+size, hash, always or never)", conf.Compare)
+}
+
+// mtimeComparer uploads when the local file is newer.
+type mtimeComparer struct{}
+
+func (mtimeComparer) NeedsUpload(l *FileInfo, r FileInfo) (bool, error) {
+	return !r.Exists || newer(l.MTime, r.MTime), nil
+}
+
+// sizeMTimeComparer also uploads when the sizes differ, which catches a
+// truncated remote copy or a local file restored with an old mtime, even
+// when the target's mtime is the later one.
+type sizeMTimeComparer struct{}
+
+func (sizeMTimeComparer) NeedsUpload(l *FileInfo, r FileInfo) (bool, error) {
+	return !r.Exists || l.Size != r.Size || newer(l.MTime, r.MTime), nil
+}
+
+// hashComparer uploads when the content differs from what this site last
+// uploaded, ignoring mtimes; neither FTP nor SMB can hash remotely, so it
+// trusts the state file for the remote side. A file without a recorded
+// hash is judged by size+mtime once and its hash remembered.
+type hashComparer struct {
+	st   *syncState
+	algo string // hash_algorithm
+}
+
+func (c hashComparer) NeedsUpload(l *FileInfo, r FileInfo) (bool, error) {
+	sum, next, err := digestAppended(l.Path, c.algo, c.st.resumable(l.Rel, c.algo))
+	if err != nil { return false, err }
+	l.resume = next
+	// hash even what is uploaded anyway, so it gets recorded
+	if c.algo == hashSHA256 { l.SHA256 = sum } else { l.Sum = sum }
+	if !r.Exists || l.Size != r.Size { return true, nil }
+	last := c.st.hash(l.Rel, c.algo)
+	if last == "" {
+		need, _ := sizeMTimeComparer{}.NeedsUpload(l, r)
+		if !need { c.st.record(l.Rel, r.MTime, r.ETag, *l) }
+		return need, nil
+	}
+	return sum != last, nil
+}
+
+// alwaysComparer uploads every file on every run.
+type alwaysComparer struct{}
+
+func (alwaysComparer) NeedsUpload(*FileInfo, FileInfo) (bool, error) { return true, nil }
+
+// neverComparer only uploads files the target does not have yet.
+type neverComparer struct{}
+
+func (neverComparer) NeedsUpload(_ *FileInfo, r FileInfo) (bool, error) { return !r.Exists, nil }
+
+// ────────── hash algorithms ────────────────────────────────
+// hash_algorithm picks what compare: hash detects changes by, and what
+// assert: hash and check-remote -content check content with. Manifests
+// (inventories, never_transfer lists, the courier's) always use SHA-256,
+// which audits ask for. "sha256", the default, runs on the CPU's SHA
+// extensions where it has them. "blake3" is also cryptographic and
+// several times faster, with AVX2/SSE4.1 where available. "xxhash"
+// (XXH64) is faster still but not cryptographic: it catches changes, not
+// tampering. The state file keeps SHA-256 sums bare and the others
+// prefixed with their algorithm, so a changed setting is noticed and each
+// file is judged by size+mtime once more.
+const (
+	hashSHA256 = "sha256"
+	hashBLAKE3 = "blake3"
+	hashXXH64  = "xxhash"
+)
+
+// hashAlgorithm is c's hash_algorithm, checked.
+func hashAlgorithm(c *Conf) (string, error) {
+	switch a := strings.ToLower(c.HashAlgo); a {
+	case "":
+		return hashSHA256, nil
+	case hashSHA256:
+		return a, nil
+	case hashBLAKE3, hashXXH64:
+		if fipsMode { return "", fmt.Errorf("hash_algorithm: %s is not FIPS-approved; use sha256 in FIPS mode", a) }
+		return a, nil
+	}
+	return "", fmt.Errorf("hash_algorithm: unknown value %q (use sha256, blake3 or xxhash)", c.HashAlgo)
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case hashBLAKE3:
+		return blake3.New()
+	case hashXXH64:
+		return xxhash.New()
+	}
+	return sha256.New()
+}
+
+// sumString is h's sum as the state file keeps it.
+func sumString(algo string, h hash.Hash) string {
+	sum := hex.EncodeToString(h.Sum(nil))
+	if algo == hashSHA256 { return sum }
+	return algo + ":" + sum
+}
+
+// digestFile hashes the file at p under algo, see sumString.
+func digestFile(p, algo string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil { return "", err }
+	defer f.Close()
+	h := newHash(algo)
+	if _, err = io.Copy(h, f); err != nil { return "", err }
+	return sumString(algo, h), nil
+}
+
+// ────────── append-only files ──────────────────────────────
+// Logs and journals only ever grow, and rehashing a multi-GB one from the
+// start on every run dominates compare: hash. For files of appendMin or
+// more the state file keeps the hash's internal state at the size last
+// recorded, with the SHA-256 of the appendCheck bytes before that point.
+// When the file has grown and those bytes are unchanged, it is taken to
+// have been appended to, and hashing goes on from the saved state over
+// the new tail only. A rewrite that leaves exactly those bytes in place
+// would go unnoticed, which is the price of not reading the rest. BLAKE3
+// cannot save its state, so under blake3 files are always hashed whole.
+const (
+	appendMin   = 64 << 20
+	appendCheck = 64 << 10
+)
+
+type hashResume struct {
+	Size  int64  `json:"size"`
+	State []byte `json:"state"` // the hash's state after Size bytes
+	Check string `json:"check"` // SHA-256 of the appendCheck bytes before Size
+}
+
+// digestAppended hashes the file at p like digestFile, going on from prev
+// when the file only grew since. next is the state to keep for the next
+// run, nil for small files and hashes that cannot save theirs.
+func digestAppended(p, algo string, prev *hashResume) (sum string, next *hashResume, err error) {
+	f, err := os.Open(p)
+	if err != nil { return "", nil, err }
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil { return "", nil, err }
+	h, from := newHash(algo), int64(0)
+	if prev != nil && prev.Size >= appendCheck && fi.Size() > prev.Size {
+		u, ok := h.(encoding.BinaryUnmarshaler)
+		if check, err := sectionSum(f, prev.Size); err == nil && check == prev.Check && ok && u.UnmarshalBinary(prev.State) == nil {
+			debugf(catCompare, "%s: appended to, hashing the last %d bytes", p, fi.Size()-prev.Size)
+			from = prev.Size
+		} else {
+			h = newHash(algo)
+		}
+	}
+	n, err := io.Copy(h, io.NewSectionReader(f, from, fi.Size()-from))
+	if err != nil { return "", nil, err }
+	sum = sumString(algo, h)
+	end := from + n
+	if m, ok := h.(encoding.BinaryMarshaler); ok && end >= appendMin {
+		state, err := m.MarshalBinary()
+		if err != nil { return sum, nil, nil }
+		check, err := sectionSum(f, end)
+		if err != nil { return sum, nil, nil }
+		next = &hashResume{Size: end, State: state, Check: check}
+	}
+	return sum, next, nil
+}
+
+// sectionSum is the SHA-256 of the appendCheck bytes of f before end.
+func sectionSum(f *os.File, end int64) (string, error) {
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, io.NewSectionReader(f, end-appendCheck, appendCheck)); err != nil { return "", err }
+	if b.Len() != appendCheck { return "", io.ErrUnexpectedEOF }
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
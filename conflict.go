@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ────────── conflict policy ────────────────────────────────
+// on_conflict settles a file changed on both sides since the last sync,
+// in direction both, and in direction pull where the state file tells a
+// local edit from the last download:
+//
+//	"skip-and-report"  both copies stay as they are, a conflict (default)
+//	"newest-wins"      the copy with the later mtime replaces the other
+//	"local-wins"       the local copy replaces the one on the target
+//	"remote-wins"      the copy on the target replaces the local one
+//	"keep-both"        the local copy is renamed "name (conflict <date>).ext"
+//	                   next to it, then the target's copy is brought down
+//
+// A file changed on one side and deleted on the other goes the same way,
+// the deletion counting as that side's copy: local-wins and remote-wins
+// repeat what the winning side did, newest-wins and keep-both keep the
+// changed copy and bring it back to the side it was deleted on, since
+// nothing is lost that way. A pull never uploads, so there local-wins
+// only leaves the local copy alone. Each settled conflict prints `!`,
+// counts in summary.resolved and sends a conflict_resolved event naming
+// the copy that won ("local", "remote" or "both"); a keep-both copy goes
+// up on the next two-way run like any new file.
+const (
+	conflictSkip     = "skip-and-report"
+	conflictNewest   = "newest-wins"
+	conflictLocal    = "local-wins"
+	conflictRemote   = "remote-wins"
+	conflictKeepBoth = "keep-both"
+)
+
+// checkConflict rejects on_conflict values, and settings it cannot work with.
+func checkConflict(c *Conf) error {
+	c.OnConflict = strings.ToLower(c.OnConflict)
+	switch c.OnConflict {
+	case "":
+		return nil
+	case conflictSkip, conflictNewest, conflictLocal, conflictRemote, conflictKeepBoth:
+	default:
+		return fmt.Errorf("on_conflict: %q is not one of %s, %s, %s, %s or %s", c.OnConflict, conflictSkip, conflictNewest, conflictLocal, conflictRemote, conflictKeepBoth)
+	}
+	switch {
+	case c.Direction != "pull" && c.Direction != "both":
+		return fmt.Errorf("on_conflict is for direction pull and both; a push keeps a copy changed on the target as a conflict")
+	case c.StateFile == "":
+		return fmt.Errorf("on_conflict needs state_file to know what changed since the last sync")
+	}
+	return nil
+}
+
+// winner is the side on_conflict settles a conflict for: "local",
+// "remote", "both", or "" to leave it. lm and rm are the mtimes of the
+// two copies, zero for a deleted one.
+func (r *run) winner(lm, rm time.Time) string {
+	switch r.conf.OnConflict {
+	case conflictLocal:
+		return "local"
+	case conflictRemote:
+		return "remote"
+	case conflictNewest, conflictKeepBoth:
+		switch {
+		case lm.IsZero():
+			return "remote"
+		case rm.IsZero():
+			return "local"
+		case r.conf.OnConflict == conflictKeepBoth:
+			return "both"
+		case lm.After(rm):
+			return "local"
+		case rm.After(lm):
+			return "remote"
+		}
+	}
+	return ""
+}
+
+// keepBoth moves the local copy at path aside for keep-both, so the
+// target's copy can take its place.
+func (r *run) keepBoth(rel, path string) error {
+	kept := conflictName(path, time.Now())
+	if r.opts.dryRun { debugf(catCompare, "%s: would keep the local copy as %s", rel, kept); return nil }
+	if _, err := os.Lstat(kept); err == nil { return fmt.Errorf("keep-both: %s is already there", kept) }
+	if err := os.Rename(path, kept); err != nil { return classifyOS(err) }
+	r.settled(rel, "both", filepath.Base(kept))
+	return nil
+}
+
+// conflictName is "report (conflict 2026-10-14 084256).docx" for report.docx.
+func conflictName(path string, at time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + " (conflict " + at.Format("2006-01-02 150405") + ")" + ext
+}
+
+// settled reports a conflict on_conflict settled for won; kept is the
+// name of the local copy keep-both moved aside.
+func (r *run) settled(rel, won, kept string) {
+	if r.opts.dryRun { return }
+	r.resolved.Add(1)
+	switch won {
+	case "both":
+		say("!", "%s", tr("resolved_both", rel, kept))
+	default:
+		say("!", "%s", tr("resolved_"+won, rel))
+	}
+	reportResolve(r.prog, rel, won)
+}
+
+// resolveReporter is implemented by progress sinks that want to hear of
+// conflicts on_conflict settled.
+type resolveReporter interface{ onResolve(rel, won string) }
+
+func reportResolve(p Progress, rel, won string) {
+	if rr, ok := p.(resolveReporter); ok { rr.onResolve(rel, won) }
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckConflict(t *testing.T) {
+	for i, c := range []struct {
+		conf Conf
+		ok   bool
+	}{
+		{Conf{}, true},
+		{Conf{OnConflict: "Newest-Wins", Direction: "both", StateFile: "s.json"}, true},
+		{Conf{OnConflict: "keep-both", Direction: "pull", StateFile: "s.json"}, true},
+		{Conf{OnConflict: "mine", Direction: "both", StateFile: "s.json"}, false},
+		{Conf{OnConflict: "local-wins", StateFile: "s.json"}, false},
+		{Conf{OnConflict: "local-wins", Direction: "pull"}, false},
+	} {
+		if err := checkConflict(&c.conf); (err == nil) != c.ok { t.Errorf("case %d: %v, want ok %v", i, err, c.ok) }
+	}
+}
+
+func TestWinner(t *testing.T) {
+	lm := time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)
+	rm := lm.Add(time.Minute)
+	var zero time.Time
+	for _, c := range []struct {
+		mode   string
+		lm, rm time.Time
+		want   string
+	}{
+		{"", lm, rm, ""},
+		{conflictSkip, lm, rm, ""},
+		{conflictLocal, lm, rm, "local"},
+		{conflictRemote, rm, lm, "remote"},
+		{conflictNewest, lm, rm, "remote"},
+		{conflictNewest, rm, lm, "local"},
+		{conflictNewest, lm, lm, ""},
+		{conflictNewest, zero, rm, "remote"}, // deleted here
+		{conflictNewest, lm, zero, "local"},  // deleted there
+		{conflictKeepBoth, lm, rm, "both"},
+		{conflictKeepBoth, zero, rm, "remote"},
+		{conflictKeepBoth, lm, zero, "local"},
+	} {
+		r := &run{conf: &Conf{OnConflict: c.mode}}
+		if got := r.winner(c.lm, c.rm); got != c.want { t.Errorf("%s(%v, %v) = %q, want %q", c.mode, c.lm.IsZero(), c.rm.IsZero(), got, c.want) }
+	}
+}
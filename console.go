@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ────────── console output ─────────────────────────────────
+// Status lines start with a mark (↑ ✓ ✗ ! ↓). With -ascii the marks become
+// words and everything printed, log lines included, is folded to plain
+// ASCII. This is for consoles, log collectors and screen readers that
+// mangle Unicode.
+var asciiOnly bool
+
+var asciiMarks = map[string]string{
+	"↑": "UPLOAD",
+	"↓": "DOWNLOAD",
+	"✓": "OK",
+	"✗": "ERROR",
+	"!": "WARNING",
+	"↻": "RESUME",
+	"-": "DELETE",
+	"≈": "ESTIMATE",
+	"…": "WAIT",
+	"→": "RENAME",
+	"+": "NEW",
+	"~": "CHANGED",
+}
+
+var asciiFold = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "Ä", "Ae", "Ö", "Oe", "Ü", "Ue", "ß", "ss",
+	"à", "a", "â", "a", "á", "a", "ç", "c", "é", "e", "è", "e", "ê", "e", "ë", "e",
+	"î", "i", "ï", "i", "í", "i", "ñ", "n", "ô", "o", "ó", "o", "ù", "u", "û", "u", "ú", "u",
+	"É", "E", "È", "E", "À", "A", "Ç", "C",
+	"–", "-", "—", "-", "’", "'", "‘", "'", "“", `"`, "”", `"`, "«", `"`, "»", `"`, "…", "...",
+	" ", " ", " ", " ",
+)
+
+func init() { log.SetOutput(consoleWriter{os.Stderr}) }
+
+// logFile, with log_file set, gets a copy of everything printed, the
+// status lines stamped as log lines are.
+var logFile io.Writer
+
+// toASCII folds s to ASCII; characters without a spelling become '?'.
+func toASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 0x7e || (r < 0x20 && r != '\n' && r != '\t') { return '?' }
+		return r
+	}, asciiFold.Replace(s))
+}
+
+// say prints one status line after its mark.
+func say(mark, format string, args ...interface{}) {
+	if asciiOnly {
+		if w, ok := asciiMarks[mark]; ok { mark = w }
+	}
+	line := mark + " " + fmt.Sprintf(format, args...)
+	if asciiOnly { line = toASCII(line) }
+	fmt.Println(line)
+	if logFile != nil { io.WriteString(logFile, time.Now().Format("2006/01/02 15:04:05 ")+line+"\n") }
+}
+
+type consoleWriter struct{ w io.Writer }
+
+func (c consoleWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if asciiOnly { p = []byte(toASCII(string(p))) }
+	if logFile != nil { logFile.Write(p) }
+	if _, err := c.w.Write(p); err != nil { return 0, err }
+	return n, nil
+}
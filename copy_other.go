@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// copyFile relies on (*os.File).ReadFrom, which on Linux uses
+// copy_file_range or sendfile so the data never passes through userspace.
+// It copies in 8 MB steps so progress is reported and a cancelled ctx
+// is noticed between them.
+func copyFile(ctx context.Context, src *os.File, dst string) error {
+	out, err := os.Create(dst)
+	if err != nil { return err }
+	for err == nil {
+		if err = ctx.Err(); err != nil { break }
+		var n int64
+		n, err = io.CopyN(out, src, 8<<20)
+		countBytes(ctx, n)
+	}
+	if err != io.EOF {
+		out.Close(); return err
+	}
+	return out.Close()
+}
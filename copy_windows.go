@@ -0,0 +1,61 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCopyFileExW = syscall.NewLazyDLL("kernel32.dll").NewProc("CopyFileExW")
+	copyProgress    = syscall.NewCallback(copyProgressRoutine)
+	copies          sync.Map // id → *copyState, for the progress routine
+	copyID          atomic.Uintptr
+)
+
+type copyState struct {
+	ctx  context.Context
+	done int64
+}
+
+const (
+	progressContinue = 0
+	progressCancel   = 1
+)
+
+// copyFile hands the copy to CopyFileExW, which moves data in large
+// kernel-side transfers and lets the SMB redirector offload it when the
+// source is on the same server. It also carries the source mtime over.
+// The progress routine reports bytes to ctx and cancels once ctx ends.
+func copyFile(ctx context.Context, src *os.File, dst string) error {
+	from, err := syscall.UTF16PtrFromString(src.Name())
+	if err != nil { return err }
+	to, err := syscall.UTF16PtrFromString(dst)
+	if err != nil { return err }
+	id := copyID.Add(1)
+	copies.Store(id, &copyState{ctx: ctx})
+	defer copies.Delete(id)
+	r, _, e := procCopyFileExW.Call(uintptr(unsafe.Pointer(from)), uintptr(unsafe.Pointer(to)), copyProgress, id, 0, 0)
+	if r == 0 {
+		if ctx.Err() != nil { return ctx.Err() }
+		return &os.LinkError{Op: "copyfile", Old: src.Name(), New: dst, Err: e}
+	}
+	return nil
+}
+
+// progressed handles one progress callback: transferred is the running
+// total for the copy identified by id.
+func progressed(id uintptr, transferred int64) uintptr {
+	v, ok := copies.Load(id)
+	if !ok { return progressContinue }
+	cs := v.(*copyState)
+	countBytes(cs.ctx, transferred-cs.done)
+	cs.done = transferred
+	if cs.ctx.Err() != nil { return progressCancel }
+	return progressContinue
+}
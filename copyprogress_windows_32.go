@@ -0,0 +1,9 @@
+//go:build windows && (386 || arm)
+
+package main
+
+// copyProgressRoutine is CopyFileExW's LPPROGRESS_ROUTINE. On 32-bit
+// Windows each LARGE_INTEGER argument arrives as two stack words.
+func copyProgressRoutine(totalLo, totalHi, doneLo, doneHi, streamLo, streamHi, streamDoneLo, streamDoneHi, stream, reason, src, dst, data uintptr) uintptr {
+	return progressed(data, int64(doneHi)<<32|int64(doneLo))
+}
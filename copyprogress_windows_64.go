@@ -0,0 +1,8 @@
+//go:build windows && !386 && !arm
+
+package main
+
+// copyProgressRoutine is CopyFileExW's LPPROGRESS_ROUTINE.
+func copyProgressRoutine(total, done, streamSize, streamDone, stream, reason, src, dst, data uintptr) uintptr {
+	return progressed(data, int64(done))
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── removable-media courier ────────────────────────
+// export copies changed files plus a manifest onto removable media; import
+// verifies every file against the manifest before placing any of them, so
+// air-gapped sites can be kept in step by carrying a disk across.
+//
+//   dirsync.exe export -conf dataxfer.conf -to E:\courier [-since E:\courier\inventory.json] [-key site.key]
+//   dirsync.exe import -from E:\courier -dir D:\exports [-key site.key]
+//
+// Media layout: files/<rel path>, manifest.json (the packaged files plus
+// any deletions) and inventory.json (the full source tree, usable as
+// -since next time).
+const (
+	courierFiles     = "files"
+	courierManifest  = "manifest.json"
+	courierInventory = "inventory.json"
+)
+
+func exportMain(args []string) {
+	fl := flag.NewFlagSet("export", flag.ExitOnError)
+	cfgPath := fl.String("conf", "dataxfer.conf", "config JSON (for local_dir)")
+	to      := fl.String("to", "", "courier directory, e.g. E:\\courier")
+	since   := fl.String("since", "", "inventory of what the destination already has")
+	keyPath := fl.String("key", "", "file holding the HMAC signing key")
+	fl.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	fl.Parse(args)
+	if *to == "" { log.Fatal("export: -to is required") }
+
+	conf, err := loadConf(*cfgPath)
+	if err != nil { log.Fatal(err) }
+	applyPriority(conf)
+	setLanguage(conf.Language)
+	var key []byte
+	if *keyPath != "" {
+		if key, err = readKey(*keyPath); err != nil { log.Fatal(err) }
+	}
+
+	manifest, inv, err := exportCourier(conf.LocalDir, *to, *since, key)
+	if err != nil { log.Fatal(err) }
+	say("✓", "%s", tr("exported", len(manifest.Files), len(inv.Files), *to))
+}
+
+// exportCourier writes the files under root that the inventory since
+// (if any) does not have to the courier directory to, with the manifest
+// and the full inventory, and returns both. The manifest deletes what
+// since has and root no longer does.
+func exportCourier(root, to, since string, key []byte) (manifest, inv *inventory, err error) {
+	if inv, err = buildInventory(root); err != nil { return nil, nil, err }
+	have := map[string]string{}
+	if since != "" {
+		old, err := loadInventory(since)
+		if err != nil { return nil, nil, err }
+		if key != nil {
+			if err = old.verify(key); err != nil { return nil, nil, fmt.Errorf("%s: %v", since, err) }
+		}
+		for _, e := range old.Files { have[e.Path] = e.SHA256 }
+	}
+
+	manifest = &inventory{Format: stamp(invFormat), Root: inv.Root, Host: inv.Host, Created: inv.Created}
+	for _, e := range inv.Files {
+		sum, had := have[e.Path]
+		delete(have, e.Path)
+		if had && sum == e.SHA256 { continue }
+		say("↑", "%s", e.Path)
+		if err = placeFile(filepath.Join(root, filepath.FromSlash(e.Path)), filepath.Join(to, courierFiles, filepath.FromSlash(e.Path)), e); err != nil {
+			return nil, nil, err
+		}
+		manifest.Files = append(manifest.Files, e)
+	}
+	for p := range have { manifest.Deleted = append(manifest.Deleted, p) } // in since, gone from root
+	sort.Strings(manifest.Deleted)
+	for _, p := range manifest.Deleted { say("-", "%s", p) }
+	if key != nil { manifest.sign(key); inv.sign(key) }
+	if err = os.MkdirAll(to, 0755); err != nil { return nil, nil, err } // nothing placed, deletions only
+	if err = manifest.write(filepath.Join(to, courierManifest)); err != nil { return nil, nil, err }
+	if err = inv.write(filepath.Join(to, courierInventory)); err != nil { return nil, nil, err }
+	return manifest, inv, nil
+}
+
+func importMain(args []string) {
+	fl := flag.NewFlagSet("import", flag.ExitOnError)
+	from    := fl.String("from", "", "courier directory written by export")
+	dir     := fl.String("dir", "", "destination directory")
+	keyPath := fl.String("key", "", "file holding the HMAC signing key")
+	fl.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	fl.Parse(args)
+	if *from == "" || *dir == "" { log.Fatal("import: -from and -dir are required") }
+	setLanguage("")
+	var key []byte
+	var err error
+	if *keyPath != "" {
+		if key, err = readKey(*keyPath); err != nil { log.Fatal(err) }
+	}
+	n, err := importCourier(*from, *dir, key)
+	if err != nil { log.Fatal(err) }
+	say("✓", "%s", tr("imported", n, *dir))
+}
+
+// importCourier checks the courier directory from against its manifest
+// and, only if every file passes, applies it to dir. It returns how many
+// files it placed.
+func importCourier(from, dir string, key []byte) (int, error) {
+	manifest, err := loadInventory(filepath.Join(from, courierManifest))
+	if err != nil { return 0, err }
+	if key != nil {
+		if err = manifest.verify(key); err != nil { return 0, fmt.Errorf("manifest: %v", err) }
+	} else if manifest.Signature != "" {
+		return 0, errors.New("import: the manifest is signed; give -key to check it")
+	}
+
+	// every path stays under from and dir, or nothing is imported
+	src, dst := make([]string, len(manifest.Files)), make([]string, len(manifest.Files))
+	for i, e := range manifest.Files {
+		if src[i], err = rpath.Local(filepath.Join(from, courierFiles), e.Path); err == nil { dst[i], err = courierTarget(dir, e.Path) }
+		if err != nil { return 0, fmt.Errorf("import: manifest: %v, nothing imported", err) }
+	}
+	gone := make([]string, len(manifest.Deleted))
+	for i, p := range manifest.Deleted {
+		if gone[i], err = courierTarget(dir, p); err != nil { return 0, fmt.Errorf("import: manifest: %v, nothing imported", err) }
+	}
+
+	// verify the whole batch first so a damaged disk changes nothing
+	bad := 0
+	for i, e := range manifest.Files {
+		sum, err := hashFile(src[i])
+		if err != nil || sum != e.SHA256 {
+			say("✗", "%s", tr("courier_mismatch", e.Path))
+			bad++
+		}
+	}
+	if bad > 0 { return 0, fmt.Errorf("import: %d file(s) failed verification, nothing imported", bad) }
+
+	for i, e := range manifest.Files {
+		say("↓", "%s", e.Path)
+		if err = placeFile(src[i], dst[i], e); err != nil { return i, err }
+	}
+	for i, p := range manifest.Deleted {
+		say("-", "%s", p)
+		if err = os.Remove(gone[i]); err != nil && !os.IsNotExist(err) { return len(manifest.Files), err }
+	}
+	return len(manifest.Files), nil
+}
+
+// courierTarget is where rel, a path from a manifest, goes under dir:
+// never outside it, nor dir itself.
+func courierTarget(dir, rel string) (string, error) {
+	p, err := rpath.Local(dir, rel)
+	if err == nil && p == filepath.Clean(dir) { err = fmt.Errorf("%q: %w", rel, rpath.ErrOutside) }
+	return p, err
+}
+
+// placeFile copies src to dst via a temp file and stamps the inventory
+// mtime on it, so later mtime comparisons see the original age.
+func placeFile(src, dst string, e invEntry) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil { return err }
+	in, err := os.Open(src)
+	if err != nil { return err }
+	defer in.Close()
+	tmp := dst + ".tmp"
+	if err = copyFile(context.Background(), in, tmp); err != nil { return err }
+	if err = os.Chtimes(tmp, e.MTime, e.MTime); err != nil { return err }
+	return os.Rename(tmp, dst)
+}
+
+// diffInventoryMain compares two inventories and can package exactly the
+// changed files, in the courier layout, as a zip that `import` can apply
+// once extracted.
+//
+//   dirsync.exe diff-inventory old.json new.json [-package out.zip] [-dir D:\exports] [-key site.key]
+//
+func diffInventoryMain(args []string) {
+	fl := flag.NewFlagSet("diff-inventory", flag.ExitOnError)
+	pkg     := fl.String("package", "", "write the changed files to this zip")
+	dir     := fl.String("dir", "", "where the new inventory's files live (default: its root)")
+	keyPath := fl.String("key", "", "file holding the HMAC key for both inventories and the package")
+	fl.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	fl.Parse(args)
+	pos := fl.Args()
+	if len(pos) > 2 { fl.Parse(pos[2:]); pos = pos[:2] } // allow flags after the file names
+	if len(pos) != 2 { log.Fatal("usage: diff-inventory old.json new.json [-package out.zip]") }
+	setLanguage("")
+
+	var key []byte
+	var err error
+	if *keyPath != "" {
+		if key, err = readKey(*keyPath); err != nil { log.Fatal(err) }
+	}
+	var invs [2]*inventory
+	for i, p := range pos {
+		if invs[i], err = loadInventory(p); err != nil { log.Fatal(err) }
+		if key != nil {
+			if err = invs[i].verify(key); err != nil { log.Fatalf("%s: %v", p, err) }
+		}
+	}
+	old, cur := invs[0], invs[1]
+
+	before := map[string]string{}
+	for _, e := range old.Files { before[e.Path] = e.SHA256 }
+	manifest := &inventory{Format: stamp(invFormat), Root: cur.Root, Host: cur.Host, Created: cur.Created}
+	for _, e := range cur.Files {
+		sum, had := before[e.Path]
+		delete(before, e.Path)
+		switch {
+		case !had:
+			say("+", "%s", e.Path)
+		case sum != e.SHA256:
+			say("~", "%s", e.Path)
+		default:
+			continue
+		}
+		manifest.Files = append(manifest.Files, e)
+	}
+	for p := range before { manifest.Deleted = append(manifest.Deleted, p) }
+	sort.Strings(manifest.Deleted)
+	for _, p := range manifest.Deleted { say("-", "%s", p) }
+
+	if *pkg == "" { return }
+	root := *dir
+	if root == "" { root = cur.Root }
+	if key != nil { manifest.sign(key) }
+	if err = writePackage(*pkg, root, manifest); err != nil {
+		os.Remove(*pkg)
+		log.Fatal(err)
+	}
+	say("✓", "%s", tr("packaged", len(manifest.Files), len(manifest.Deleted), *pkg))
+}
+
+// writePackage zips the manifest's files from root, re-hashing on the way
+// so a file that changed after the inventory was taken is not shipped.
+func writePackage(out, root string, manifest *inventory) error {
+	f, err := os.Create(out)
+	if err != nil { return err }
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, e := range manifest.Files {
+		if err = zipFile(zw, filepath.Join(root, filepath.FromSlash(e.Path)), e); err != nil { return err }
+	}
+	w, err := zw.Create(courierManifest)
+	if err != nil { return err }
+	if err = writeJSON(w, manifest); err != nil { return err }
+	if err = zw.Close(); err != nil { return err }
+	return f.Close()
+}
+
+func zipFile(zw *zip.Writer, src string, e invEntry) error {
+	in, err := os.Open(src)
+	if err != nil { return err }
+	defer in.Close()
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: courierFiles + "/" + e.Path, Method: zip.Deflate, Modified: e.MTime})
+	if err != nil { return err }
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(w, h), in); err != nil { return err }
+	if hex.EncodeToString(h.Sum(nil)) != e.SHA256 {
+		return fmt.Errorf("%s changed since the inventory was taken; take a fresh one", e.Path)
+	}
+	return nil
+}
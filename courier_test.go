@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// courierDirs is a site's files, a courier directory and the far side.
+func courierDirs(t *testing.T) (root, to, dir string) {
+	base := t.TempDir()
+	root, to, dir = filepath.Join(base, "site"), filepath.Join(base, "courier"), filepath.Join(base, "far")
+	for _, d := range []string{root, to, dir} {
+		if err := os.Mkdir(d, 0755); err != nil { t.Fatal(err) }
+	}
+	return root, to, dir
+}
+
+func TestCourierRoundTrip(t *testing.T) {
+	root, to, dir := courierDirs(t)
+	key := []byte("site key")
+	at := time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC)
+	put(t, root, "a.txt", "alpha", at)
+	put(t, root, "sub/deep/b.txt", "beta", at.Add(time.Hour))
+
+	manifest, _, err := exportCourier(root, to, "", key)
+	if err != nil { t.Fatal(err) }
+	if len(manifest.Files) != 2 || manifest.Signature == "" { t.Fatalf("manifest %+v", manifest) }
+	n, err := importCourier(to, dir, key)
+	if err != nil { t.Fatal(err) }
+	if n != 2 { t.Errorf("imported %d, want 2", n) }
+	for rel, want := range map[string]string{"a.txt": "alpha", "sub/deep/b.txt": "beta"} {
+		if got := body(dir, rel); got != want { t.Errorf("%s = %q, want %q", rel, got, want) }
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "sub", "deep", "b.txt")); err != nil || !fi.ModTime().Equal(at.Add(time.Hour)) { t.Errorf("mtime not kept: %v", fi.ModTime()) }
+
+	// the next trip, since the last inventory, carries only what changed
+	put(t, root, "a.txt", "alpha 2", at.Add(2*time.Hour))
+	next := filepath.Join(t.TempDir(), "next")
+	manifest, _, err = exportCourier(root, next, filepath.Join(to, courierInventory), key)
+	if err != nil { t.Fatal(err) }
+	if len(manifest.Files) != 1 || manifest.Files[0].Path != "a.txt" { t.Fatalf("since: manifest %+v", manifest.Files) }
+	if _, err = importCourier(next, dir, key); err != nil { t.Fatal(err) }
+	if got := body(dir, "a.txt"); got != "alpha 2" { t.Errorf("a.txt = %q after the second trip", got) }
+
+	// a file deleted at the site is deleted on the far side too
+	if err = os.Remove(filepath.Join(root, "sub", "deep", "b.txt")); err != nil { t.Fatal(err) }
+	third := filepath.Join(t.TempDir(), "third")
+	manifest, _, err = exportCourier(root, third, filepath.Join(next, courierInventory), key)
+	if err != nil { t.Fatal(err) }
+	if len(manifest.Files) != 0 || len(manifest.Deleted) != 1 || manifest.Deleted[0] != "sub/deep/b.txt" { t.Fatalf("deletion: manifest %+v, deleted %v", manifest.Files, manifest.Deleted) }
+	if _, err = importCourier(third, dir, key); err != nil { t.Fatal(err) }
+	if _, err = os.Stat(filepath.Join(dir, "sub", "deep", "b.txt")); !os.IsNotExist(err) { t.Errorf("b.txt still on the far side: %v", err) }
+	if got := body(dir, "a.txt"); got != "alpha 2" { t.Errorf("a.txt = %q after the deletion", got) }
+}
+
+func TestCourierRefusals(t *testing.T) {
+	root, to, dir := courierDirs(t)
+	key := []byte("site key")
+	put(t, root, "a.txt", "alpha", time.Now())
+	if _, _, err := exportCourier(root, to, "", key); err != nil { t.Fatal(err) }
+
+	if _, err := importCourier(to, dir, nil); err == nil || !strings.Contains(err.Error(), "-key") { t.Errorf("signed manifest without a key: %v", err) }
+	if _, err := importCourier(to, dir, []byte("other key")); err == nil { t.Error("imported with the wrong key") }
+
+	// a damaged file in the batch stops all of it
+	put(t, root, "b.txt", "beta", time.Now())
+	if _, _, err := exportCourier(root, to, "", nil); err != nil { t.Fatal(err) }
+	if err := os.WriteFile(filepath.Join(to, courierFiles, "b.txt"), []byte("BETA"), 0644); err != nil { t.Fatal(err) }
+	if _, err := importCourier(to, dir, nil); err == nil || !strings.Contains(err.Error(), "nothing imported") { t.Errorf("damaged file: %v", err) }
+	if body(dir, "a.txt") != "" { t.Error("a.txt imported from a batch that failed verification") }
+}
+
+func TestCourierEscapes(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		edit func(*inventory)
+	}{
+		{"file", func(m *inventory) { m.Files[0].Path = "../outside.txt" }},
+		{"backslash", func(m *inventory) { m.Files[0].Path = `..\outside.txt` }},
+		{"absolute", func(m *inventory) { m.Files[0].Path = "/tmp/outside.txt" }},
+		{"deletion", func(m *inventory) { m.Deleted = []string{"../keep.txt"} }},
+		{"dir itself", func(m *inventory) { m.Deleted = []string{"."} }},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			root, to, dir := courierDirs(t)
+			put(t, root, "a.txt", "alpha", time.Now())
+			put(t, filepath.Dir(dir), "keep.txt", "keep", time.Now())
+			manifest, _, err := exportCourier(root, to, "", nil)
+			if err != nil { t.Fatal(err) }
+			c.edit(manifest)
+			if err = manifest.write(filepath.Join(to, courierManifest)); err != nil { t.Fatal(err) }
+			if _, err = importCourier(to, dir, nil); err == nil || !strings.Contains(err.Error(), "nothing imported") { t.Errorf("import = %v, want refused", err) }
+			if body(dir, "a.txt") != "" { t.Error("a.txt imported from a refused manifest") }
+			if body(filepath.Dir(dir), "keep.txt") != "keep" { t.Error("file outside dir deleted") }
+			if _, err = os.Stat(dir); err != nil { t.Error("dir itself removed") }
+		})
+	}
+}
@@ -7,10 +7,15 @@
 //
 // Run on Windows:
 //   dirsync.exe -conf dataxfer.conf
+//   dirsync.exe serve -root D:\incoming      (embedded FTP server, see serve.go)
+//   dirsync.exe inventory -out inv.json      (offline file list, see inventory.go)
+//   dirsync.exe export -to E:\courier        (removable-media courier, see courier.go)
 //
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -20,168 +25,1089 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"datasync/internal/rpath"
+
 	"github.com/jlaffaye/ftp"
 )
 
 type SMBConf struct {
-	Host, User, Pass, Share, RemotePath string
+	Host, User, Pass, Share string
+	RemotePath              string `json:"remote_path"`
+	Mode                    string `json:"mode"`  // "native" (default): connect in-process, no drive letter | "netuse": map Z: with net use
+	Drive                   string `json:"drive"` // netuse: the drive letter to map (default Z:)
 }
 type FTPConf struct {
-	Host, User, Pass, RemotePath string
+	Host, User, Pass string
+	RemotePath       string `json:"remote_path"`
+	TLS              bool   `json:"tls"`      // explicit FTPS: AUTH TLS before login, data channels encrypted too
+	TLSMode          string `json:"tls_mode"` // "explicit" (default with tls) | "implicit": TLS from the first byte, port 990
+	TLSCA            string `json:"tls_ca"`   // PEM file with the CA that signed the server certificate (default: system roots)
 }
 type Conf struct {
-	LocalDir string  `json:"local_dir"`
-	Type     string  `json:"type"` // "smb" | "ftp"
-	SMB      SMBConf `json:"smb"`
-	FTP      FTPConf `json:"ftp"`
+	LocalDir      string         `json:"local_dir"`
+	Type          string         `json:"type"`           // "smb" | "ftp" | "webdav" | "s3" | "scp" | "rsyncd" | "http" | "onedrive" | "gdrive" | "dropbox" | "local"
+	Site          string         `json:"site"`           // optional: namespace uploads under RemotePath/<site>/
+	StateFile     string         `json:"state_file"`     // optional: enables conflict detection
+	WarmStart     bool           `json:"warm_start"`     // reuse the last run's tree snapshot (needs state_file)
+	ScanThreads   int            `json:"scan_threads"`   // directories listed concurrently (default 4)
+	Priority      string         `json:"priority"`       // "low": run in background I/O + CPU mode
+	CPUThreads    int            `json:"cpu_threads"`    // cap on OS threads running Go code
+	Compare       string         `json:"compare"`        // "mtime" (default) | "size+mtime" (or "mtime+size") | "hash" | "always" | "never"
+	HashAlgo      string         `json:"hash_algorithm"` // compare: hash: "sha256" (default) | "blake3" | "xxhash"
+	HashIndex     string         `json:"hash_index"`     // file of path, size and hash of every file on the target, rewritten each run (.csv or .jsonl)
+	SMB           SMBConf        `json:"smb"`
+	FTP           FTPConf        `json:"ftp"`
+	WebDAV        WebDAVConf     `json:"webdav"`
+	S3            S3Conf         `json:"s3"`
+	Local         LocalConf      `json:"local"`
+	SCP           SCPConf        `json:"scp"`
+	Rsyncd        RsyncdConf     `json:"rsyncd"`
+	HTTP          HTTPConf       `json:"http"`
+	OneDrive      OneDriveConf   `json:"onedrive"`
+	GDrive        GDriveConf     `json:"gdrive"`
+	Dropbox       DropboxConf    `json:"dropbox"`
+	Transfer      TransferConf   `json:"transfer"`
+	Events        EventsConf     `json:"events"`
+	Notify        NotifyConf     `json:"notify"`
+	SLA           string         `json:"sla"`            // freshness SLA, e.g. "4h": alert when no run succeeded for longer
+	Language      string         `json:"language"`       // "en" | "de" | "fr" | "es" for operator messages (default: OS locale)
+	Log           string         `json:"log"`            // log levels, e.g. "info" or "scan=error,protocol=trace"
+	Normalize     string         `json:"normalize"`      // "nfc" | "nfd": Unicode form of remote names (default: as found locally)
+	MaxOps        OpsConf        `json:"max_remote_ops"`
+	Tags          TagsConf       `json:"tags"`           // classification tag → file patterns, e.g. {"patient-imaging": ["*.dcm"]}
+	Never         NeverConf      `json:"never_transfer"`
+	Hold          HoldConf       `json:"hold"`
+	WriteOnce     bool           `json:"write_once"`     // never replace or delete on the target; changed files get dated versions
+	Guard         GuardConf      `json:"guard"`
+	Snapshot      SnapshotConf   `json:"snapshot"`
+	Canary        string         `json:"canary"`         // file written to the target and read back each run, e.g. ".datasync-canary"
+	Ordered       bool           `json:"ordered"`        // one file at a time, in path order, for reproducible runs
+	Assert        string         `json:"assert"`         // "size+mtime" | "hash": replace only the copy last written (needs state_file)
+	Direction     string         `json:"direction"`      // "push" (default) | "pull": download newer remote files into local_dir
+	MapNames      string         `json:"map_names"`      // pull: "replace" (default) | "percent" for names Windows cannot create
+	ReadOnly      bool           `json:"read_only"`      // never write to the target: check-remote, or direction pull, only
+	Blackout      []BlackoutConf `json:"blackout"`       // times no transfers run, see blackout.go
+	Mirror        bool           `json:"mirror"`         // delete files on the target that local_dir no longer has (see -delete)
+	MaxDelete     int            `json:"max_delete"`     // mirror: delete up to this many per run without -delete
+	Boot          BootConf       `json:"boot"`           // -at-boot: settle delay and catch-up, see boot.go
+	Schedule      ScheduleConf   `json:"schedule"`       // run again at an interval instead of exiting, see schedule.go
+	Bandwidth     BandwidthConf  `json:"bandwidth"`      // a limit shared fairly by the jobs on the machine, see bandwidth.go
+	Power         PowerConf      `json:"power"`          // defer large transfers on battery or metered networks, see power.go
+	DetectRenames bool           `json:"detect_renames"` // push: rename files moved locally on the target instead of uploading again (compare: hash)
+	OnConflict    string         `json:"on_conflict"`    // pull and both: "skip-and-report" (default), "newest-wins", "local-wins", "remote-wins", "keep-both"
+	Job           string         `json:"job"`            // name of this deployment: its own lock, temp folder and event identity, see job.go
+	LogFile       string         `json:"log_file"`       // append everything printed to this file
+	BackupDir     string         `json:"backup_dir"`     // rename a file about to be replaced into this folder on the target, see backup.go
+	BackupSuffix  string         `json:"backup_suffix"`  // ... or next to it, with this suffix (default ".{date}~")
+	FIPS          bool           `json:"fips"`           // refuse to run outside FIPS 140-3 mode, see fips.go
+	TrashDir      string         `json:"trash_dir"`      // mirror: move stale files into this folder on the target instead of deleting them, see trash.go
+	TrashDays     int            `json:"trash_days"`     // delete what was trashed more than this many days ago (0 = keep)
+	DatedFolder   string         `json:"dated_folder"`   // push each run into a new folder of this name, e.g. "{date}T{hour}-{minute}", see dated.go
+	AfterUpload   string         `json:"after_upload"`   // "delete" | "move:<dir>": let local files go once verified on the target, see archive.go
+	CentralConf   string         `json:"central_config"` // path or http(s) URL of the centrally kept version of this config, to warn of drift, see drift.go
+	StateGCDays   int            `json:"state_gc_days"`  // drop records of files gone from local_dir this many days (default 90, -1 = keep), see statetool.go
+	StateMirror   string         `json:"state_mirror"`   // file on the target keeping a copy of the records, e.g. ".datasync-state.json", see statemirror.go
+	source        []byte         // the file as loaded, for its hash
 }
 
 func loadConf(p string) (*Conf, error) {
 	f, err := os.Open(p)
 	if err != nil { return nil, err }
 	defer f.Close()
-	var c Conf
-	return &c, json.NewDecoder(f).Decode(&c)
+	b, err := io.ReadAll(f)
+	if err != nil { return nil, err }
+	c := Conf{source: b}
+	return &c, json.NewDecoder(bytes.NewReader(b)).Decode(&c)
+}
+
+// scanThreads is how many local folders are listed at once.
+func (c *Conf) scanThreads() int {
+	switch {
+	case c.Ordered:
+		return 1
+	case c.ScanThreads <= 0:
+		return 4
+	}
+	return c.ScanThreads
 }
 
 func newer(local, remote time.Time) bool { return remote.IsZero() || local.After(remote) }
 
+// ceilTime rounds t up to a whole d, for targets that keep coarser times
+// than the local disk: the stamp is then never before the file's mtime.
+func ceilTime(t time.Time, d time.Duration) time.Time {
+	if c := t.Truncate(d); !c.Equal(t) { return c.Add(d) }
+	return t
+}
+
 // ────────── FTP target ──────────────────────────────────────
 type ftpTarget struct {
-	c      *ftp.ServerConn
-	prefix string
+	c    *ftp.ServerConn
+	w    *connWatch
+	rec  *sessionRec     // -record only
+	root rpath.Root
+	cfg  FTPConf
+	tc   TransferConf
+}
+
+// dialFTP connects and logs in. ctx bounds the whole session: data
+// connections opened later are dialled under it too.
+func dialFTP(ctx context.Context, cfg FTPConf, tc TransferConf, rec *sessionRec) (*ftp.ServerConn, *connWatch, error) {
+	d, err := tc.dialer()
+	if err != nil { return nil, nil, err }
+	w := newConnWatch()
+	defer w.during(ctx)()
+	dial := w.dialFunc(ctx, d)
+	if rec != nil { dial = rec.wrap(dial) }
+	mode, err := cfg.tlsMode()
+	if err != nil { return nil, nil, err }
+	var opts []ftp.DialOption
+	if mode != "" {
+		tlsConf, err := cfg.tlsConfig()
+		if err != nil { return nil, nil, err }
+		dial = tlsDial(dial, tlsConf, mode)
+		if mode == tlsExplicit {
+			opts = append(opts, ftp.DialWithExplicitTLS(tlsConf))
+		} else {
+			opts = append(opts, ftp.DialWithTLS(tlsConf)) // only for PBSZ/PROT after login; dial does the TLS
+		}
+	}
+	opts = append(opts, ftp.DialWithDialFunc(dial))
+	if logOn(catProtocol, lvlTrace) { opts = append(opts, ftp.DialWithDebugOutput(&ftpTrace{host: fmt.Sprintf("%s#%d", cfg.Host, ftpSessions.Add(1))})) }
+	conn, err := ftp.Dial(cfg.addr(mode), opts...)
+	if err != nil { return nil, nil, classifyFTP(ctxErr(ctx, err)) }
+	if err = conn.Login(cfg.User, cfg.Pass); err != nil {
+		conn.Quit()
+		// a refusal at USER comes back as a bare message, without its code
+		if err = classifyFTP(ctxErr(ctx, err)); !classified(err) && ctx.Err() == nil { err = withClass(ErrAuth, err) }
+		return nil, nil, err
+	}
+	debugf(catProtocol, "ftp: logged in to %s as %q", cfg.Host, cfg.User)
+	if rec != nil { rec.loggedIn() }
+	return conn, w, nil
 }
 
-func connectFTP(cfg FTPConf) (*ftpTarget, error) {
-	conn, err := ftp.Dial(cfg.Host, ftp.DialWithTimeout(10*time.Second))
+func connectFTP(ctx context.Context, cfg FTPConf, tc TransferConf, form string) (*ftpTarget, error) {
+	rec := newSessionRec(cfg)
+	conn, w, err := dialFTP(ctx, cfg, tc, rec)
 	if err != nil { return nil, err }
-	if err = conn.Login(cfg.User, cfg.Pass); err != nil { return nil, err }
-	return &ftpTarget{c: conn, prefix: cfg.RemotePath}, nil
+	return &ftpTarget{c: conn, w: w, rec: rec, root: rpath.NewRoot(cfg.RemotePath, form), cfg: cfg, tc: tc}, nil
 }
 
-func (t *ftpTarget) mtime(rel string) (time.Time, error) {
-	remoteDir := filepath.ToSlash(filepath.Join(t.prefix, filepath.Dir(rel)))
-	entries, err := t.c.List(remoteDir)
-	if err != nil { return time.Time{}, err }
-	base := filepath.Base(rel)
+func (t *ftpTarget) begin(rel string) { if t.rec != nil { t.rec.begin(rel) } }
+func (t *ftpTarget) save(err error)   { if t.rec != nil { t.rec.save(err) } }
+
+func (t *ftpTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	defer t.w.during(ctx)()
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return FileInfo{}, withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	entries, err := t.c.List(rpath.Dir(remote))
+	if err != nil { return FileInfo{}, classifyFTP(ctxErr(ctx, err)) }
+	base := rpath.Base(rel)
 	for _, e := range entries {
-		if e.Name == base {
-			return e.Time, nil
+		if t.root.Same(e.Name, base) {
+			return FileInfo{Rel: rel, Size: int64(e.Size), MTime: e.Time, Exists: true}, nil
 		}
 	}
-	return time.Time{}, os.ErrNotExist
+	return FileInfo{}, withClass(ErrNotFound, os.ErrNotExist)
+}
+
+func (t *ftpTarget) upload(ctx context.Context, local, rel string) error {
+	defer t.w.during(ctx)()
+	return classifyFTP(ctxErr(ctx, t.stor(ctx, local, rel)))
 }
 
-func (t *ftpTarget) upload(local, rel string) error {
-	remote := filepath.ToSlash(filepath.Join(t.prefix, rel))
-	dir := filepath.Dir(remote)
+func (t *ftpTarget) stor(ctx context.Context, local, rel string) error {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
 	// create directory chain
-	if dir != "" && dir != "." {
-		dirs := strings.Split(dir, "/")
-		p := ""
-		for _, d := range dirs {
-			p = filepath.Join(p, d)
-			t.c.MakeDir(p)
-		}
-	}
+	for _, d := range rpath.Parents(remote) { t.c.MakeDir(d) }
 	src, err := os.Open(local)
 	if err != nil { return err }
 	defer src.Close()
-	return t.c.Stor(remote, src)
+	if fi, err := src.Stat(); err == nil {
+		if parts := t.tc.split(fi.Size()); parts != nil {
+			return t.storChunked(ctx, src, remote, fi.Size(), parts)
+		}
+		if off := resumeAt(ctx); off > 0 && off < fi.Size() { return t.storRest(ctx, src, remote, fi.Size(), off) }
+	}
+	if t.tc.BufferKB > 0 {
+		ra := t.tc.newReadAhead(ctxReader{ctx, src})
+		defer ra.Close()
+		return t.c.Stor(remote, ra)
+	}
+	return t.c.Stor(remote, ctxReader{ctx, src})
+}
+
+// storChunked sends the first range with a plain STOR (which truncates),
+// then the remaining ranges in parallel using REST+STOR on extra
+// connections. Servers that ignore REST for uploads are caught by the
+// final size check.
+func (t *ftpTarget) storChunked(ctx context.Context, src *os.File, remote string, size int64, parts []chunk) error {
+	if err := t.c.Stor(remote, ctxReader{ctx, io.NewSectionReader(src, parts[0].off, parts[0].n)}); err != nil { return err }
+	err := parallelChunks(parts[1:], func(p chunk) error {
+		c, w, err := dialFTP(ctx, t.cfg, t.tc, nil)
+		if err != nil { return err }
+		defer c.Quit()
+		defer w.during(ctx)()
+		return c.StorFrom(remote, ctxReader{ctx, io.NewSectionReader(src, p.off, p.n)}, uint64(p.off))
+	})
+	if err != nil { return err }
+	if n, err := t.c.FileSize(remote); err == nil && n != size {
+		return fmt.Errorf("%s: chunked upload left %d of %d bytes (server lacks REST STOR?)", remote, n, size)
+	}
+	return nil
+}
+func (t *ftpTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	defer t.w.during(ctx)()
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	resp, err := t.c.Retr(remote)
+	if err != nil { return classifyFTP(ctxErr(ctx, err)) }
+	_, err = io.Copy(w, ctxReader{ctx, resp})
+	if cerr := resp.Close(); err == nil { err = cerr }
+	return classifyFTP(ctxErr(ctx, err))
+}
+
+func (t *ftpTarget) list(ctx context.Context, dir string) ([]FileInfo, []string, error) {
+	defer t.w.during(ctx)()
+	remote, err := t.root.Resolve(dir)
+	if err != nil { return nil, nil, withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opListing); err != nil { return nil, nil, err }
+	entries, err := t.c.List(remote)
+	if err != nil { return nil, nil, classifyFTP(ctxErr(ctx, err)) }
+	var files []FileInfo
+	var dirs []string
+	for _, e := range entries {
+		rel, ok := rpath.Entry(dir, e.Name)
+		switch {
+		case !ok:
+		case e.Type == ftp.EntryTypeFolder:
+			dirs = append(dirs, e.Name)
+		case e.Type == ftp.EntryTypeFile:
+			files = append(files, FileInfo{Rel: rel, Size: int64(e.Size), MTime: e.Time, Exists: true})
+		}
+	}
+	return files, dirs, nil
+}
+
+func (t *ftpTarget) remove(ctx context.Context, rel string) error {
+	defer t.w.during(ctx)()
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opDelete); err != nil { return err }
+	return classifyFTP(ctxErr(ctx, t.c.Delete(remote)))
+}
+
+func (t *ftpTarget) removeDir(ctx context.Context, dir string) error {
+	defer t.w.during(ctx)()
+	remote, err := t.root.Resolve(dir)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opDelete); err != nil { return err }
+	return classifyFTP(ctxErr(ctx, t.c.RemoveDir(remote)))
 }
 func (t *ftpTarget) close() { t.c.Quit() }
 
-// ────────── SMB target (net use) ────────────────────────────
+// ────────── SMB target ─────────────────────────────────────
+// By default the share is reached by its UNC path, after logging on to it
+// in-process (smbConnect); without smb.user the process's own account is
+// used, e.g. a service account with rights on the share. smb.mode "netuse"
+// keeps the old way of mapping Z: with net use.
 type smbTarget struct {
-	drive, unc, prefix string
+	base, unc string // base: what remote paths are joined to, Z: or the UNC path
+	netuse    bool
+	root      rpath.Root
+	tc        TransferConf
+	delta     *deltaStore // nil: every upload is a full copy
 }
 
-func connectSMB(cfg SMBConf) (*smbTarget, error) {
+func connectSMB(ctx context.Context, cfg SMBConf, tc TransferConf) (*smbTarget, error) {
 	host := strings.Split(cfg.Host, ":")[0]
 	unc  := fmt.Sprintf(`\\%s\%s`, host, cfg.Share)
-	drive := "Z:"
-	if out, err := exec.Command("net", "use", drive, unc, cfg.Pass, "/user:"+cfg.User, "/persistent:no").CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("net use: %v – %s", err, out)
+	t := &smbTarget{base: unc, unc: unc, root: rpath.NewRoot(cfg.RemotePath, ""), tc: tc}
+	switch cfg.Mode {
+	case "", "native":
+		if cfg.User == "" {
+			debugf(catProtocol, "smb: %s as the current user", unc)
+			if _, err := os.Stat(unc + `\`); err != nil { return nil, smbError(err) }
+			return t, nil
+		}
+		debugf(catProtocol, "smb: connect %s as %s", unc, cfg.User)
+		if err := smbConnect(unc, cfg.User, cfg.Pass); err != nil { return nil, smbError(fmt.Errorf("smb: %s: %w", unc, err)) }
+		return t, nil
+	case "netuse":
+	default:
+		return nil, fmt.Errorf("smb.mode: unknown mode %q (use native or netuse)", cfg.Mode)
+	}
+	t.base, t.netuse = "Z:", true
+	if cfg.Drive != "" {
+		d := strings.ToUpper(strings.TrimSuffix(cfg.Drive, ":"))
+		if len(d) != 1 || d[0] < 'A' || d[0] > 'Z' { return nil, fmt.Errorf("smb.drive: %q is not a drive letter like \"Y:\"", cfg.Drive) }
+		t.base = d + ":"
+	}
+	debugf(catProtocol, "net use %s %s /user:%s", t.base, unc, cfg.User)
+	if out, err := exec.CommandContext(ctx, "net", "use", t.base, unc, cfg.Pass, "/user:"+cfg.User, "/persistent:no").CombinedOutput(); err != nil {
+		err = fmt.Errorf("net use: %v – %s", ctxErr(ctx, err), out)
+		// net use reports the Win32 error as "System error <n> has occurred."
+		var code int
+		if i := strings.Index(string(out), "System error "); i >= 0 { fmt.Sscanf(string(out[i:]), "System error %d", &code) }
+		if code == 5 { return nil, withClass(ErrPermission, err) }
+		if c := errnoClass(syscall.Errno(code)); c != nil { return nil, withClass(c, err) }
+		return nil, withClass(ErrNetwork, err)
 	}
-	return &smbTarget{drive: drive, unc: unc, prefix: cfg.RemotePath}, nil
+	return t, nil
 }
 
-func (t *smbTarget) toRemote(rel string) string {
-	if t.prefix != "" { rel = filepath.Join(t.prefix, rel) }
-	return filepath.Join(t.drive, rel)
+// smbError classifies a failed log-on, which is a network error unless
+// Windows says otherwise.
+func smbError(err error) error {
+	var code syscall.Errno
+	if !errors.As(err, &code) { return withClass(ErrNetwork, err) }
+	switch code {
+	case 5:
+		return withClass(ErrPermission, err)
+	case 1219: // ERROR_SESSION_CREDENTIAL_CONFLICT
+		return withClass(ErrAuth, fmt.Errorf("%w (this account is already connected to the server under another user)", err))
+	}
+	if c := errnoClass(code); c != nil { return withClass(c, err) }
+	return withClass(ErrNetwork, err)
+}
+
+func (t *smbTarget) toRemote(rel string) (string, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return "", withClass(ErrInvalidName, err) }
+	return filepath.Join(t.base, filepath.FromSlash(remote)), nil
+}
+// stat cannot be interrupted: a stat on a dead share waits for the
+// redirector to give up.
+func (t *smbTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	dst, err := t.toRemote(rel)
+	if err != nil { return FileInfo{}, err }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	fi, err := os.Stat(dst)
+	if err != nil { return FileInfo{}, classifyOS(err) }
+	if t.delta.patching(dst) {
+		debugf(catTransfer, "%s: a delta patch of it broke off", rel)
+		return FileInfo{Rel: rel, Size: -1, Exists: true}, nil
+	}
+	return FileInfo{Rel: rel, Size: fi.Size(), MTime: fi.ModTime(), Exists: true}, nil
 }
-func (t *smbTarget) mtime(rel string) (time.Time, error) {
-	fi, err := os.Stat(t.toRemote(rel))
-	if err != nil { return time.Time{}, err }
-	return fi.ModTime(), nil
+func (t *smbTarget) upload(ctx context.Context, local, rel string) error {
+	return classifyOS(ctxErr(ctx, t.copy(ctx, local, rel)))
 }
-func (t *smbTarget) upload(local, rel string) error {
-	dst := t.toRemote(rel)
+
+func (t *smbTarget) copy(ctx context.Context, local, rel string) error {
+	dst, err := t.toRemote(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
 	os.MkdirAll(filepath.Dir(dst), fs.FileMode(0755))
 	src, err := os.Open(local)
 	if err != nil { return err }
 	defer src.Close()
+	if t.delta == nil { return t.copyFull(ctx, src, dst) }
+	if done, err := t.delta.patch(ctx, src, dst); done || err != nil { return err }
+	if _, err = src.Seek(0, io.SeekStart); err != nil { return err }
+	if err = t.copyFull(ctx, src, dst); err != nil { return err }
+	if err = t.delta.signFull(src, dst); err != nil { debugf(catTransfer, "%s: delta signature not saved: %v", dst, err) }
+	return nil
+}
 
+// copyFull copies src to dst through a temp file renamed into place.
+func (t *smbTarget) copyFull(ctx context.Context, src *os.File, dst string) error {
 	tmp := dst + ".tmp"
-	out, err := os.Create(tmp)
+	if fi, err := src.Stat(); err == nil {
+		if parts := t.tc.split(fi.Size()); parts != nil {
+			if err := copyChunked(ctx, src, tmp, fi.Size(), parts); err != nil { return err }
+			return moveInto(ctx, tmp, dst)
+		}
+	}
+	var err error
+	if t.tc.BufferKB > 0 {
+		err = copyBuffered(ctxReader{ctx, src}, tmp, t.tc)
+	} else {
+		err = copyFile(ctx, src, tmp)
+	}
 	if err != nil { return err }
-	if _, err = io.Copy(out, src); err != nil {
-		out.Close(); return err
+	return moveInto(ctx, tmp, dst)
+}
+
+func (t *smbTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	src, err := t.toRemote(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	f, err := os.Open(src)
+	if err != nil { return classifyOS(err) }
+	defer f.Close()
+	_, err = io.Copy(w, ctxReader{ctx, f})
+	return classifyOS(ctxErr(ctx, err))
+}
+
+func (t *smbTarget) list(ctx context.Context, dir string) ([]FileInfo, []string, error) {
+	src, err := t.toRemote(dir)
+	if err != nil { return nil, nil, err }
+	if err = takeOp(ctx, opListing); err != nil { return nil, nil, err }
+	entries, err := readDir(src)
+	if err != nil { return nil, nil, classifyOS(err) }
+	var files []FileInfo
+	var dirs []string
+	for _, e := range entries {
+		if !e.hasInfo {
+			// a link: what it points to decides
+			fi, err := os.Stat(filepath.Join(src, e.name))
+			if err != nil { continue }
+			e.dir, e.size, e.mtime = fi.IsDir(), fi.Size(), fi.ModTime()
+		}
+		if e.dir { dirs = append(dirs, e.name); continue }
+		files = append(files, FileInfo{Rel: rpath.Join(dir, e.name), Size: e.size, MTime: e.mtime, Exists: true})
 	}
-	out.Close()
+	return files, dirs, nil
+}
+
+func (t *smbTarget) remove(ctx context.Context, rel string) error {
+	dst, err := t.toRemote(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opDelete); err != nil { return err }
+	return classifyOS(os.Remove(dst))
+}
+
+func (t *smbTarget) removeDir(ctx context.Context, dir string) error { return t.remove(ctx, dir) }
+
+// moveInto renames a finished temp file over its destination.
+func moveInto(ctx context.Context, tmp, dst string) error {
+	if err := takeOp(ctx, opRename); err != nil { os.Remove(tmp); return err }
 	return os.Rename(tmp, dst)
 }
-func (t *smbTarget) close() { exec.Command("net", "use", t.drive, "/delete", "/y").Run() }
+// copyChunked writes each range through its own handle so the SMB client
+// keeps several requests in flight at once.
+func copyChunked(ctx context.Context, src *os.File, tmp string, size int64, parts []chunk) error {
+	out, err := os.Create(tmp)
+	if err != nil { return err }
+	err = out.Truncate(size)
+	out.Close()
+	if err != nil { return err }
+	return parallelChunks(parts, func(p chunk) error {
+		w, err := os.OpenFile(tmp, os.O_WRONLY, 0)
+		if err != nil { return err }
+		_, err = io.Copy(io.NewOffsetWriter(w, p.off), ctxReader{ctx, io.NewSectionReader(src, p.off, p.n)})
+		if cerr := w.Close(); err == nil { err = cerr }
+		return err
+	})
+}
+func (t *smbTarget) close() {
+	if t.netuse { exec.Command("net", "use", t.base, "/delete", "/y").Run(); return }
+	if t.unc != "" { smbDisconnect(t.unc) }
+}
+
+// ────────── local target ───────────────────────────────────
+// The local target is a plain directory: a second disk, or a UNC path
+// the account can reach without logging on. It copies like the SMB
+// target, through a temp file renamed into place, with the same
+// comparison.
+type LocalConf struct {
+	Path string `json:"path"` // e.g. E:\mirror or \\nas\backup\site1
+}
+
+func connectLocal(cfg LocalConf, tc TransferConf) (*smbTarget, error) {
+	if cfg.Path == "" { return nil, fmt.Errorf("local.path is required") }
+	fi, err := os.Stat(cfg.Path)
+	if err != nil { return nil, classifyOS(err) }
+	if !fi.IsDir() { return nil, fmt.Errorf("local.path: %s is not a directory", cfg.Path) }
+	debugf(catProtocol, "local: %s", cfg.Path)
+	return &smbTarget{base: cfg.Path, root: rpath.NewRoot("", ""), tc: tc}, nil
+}
 
 // ────────── main sync logic ────────────────────────────────
+// A target aborts stat, upload and fetch when ctx ends, as far as the
+// protocol allows, and returns the context's error. stat and fetch fail
+// with ErrNotFound for a missing file.
+type target interface {
+	stat(ctx context.Context, rel string) (FileInfo, error)
+	upload(ctx context.Context, local, rel string) error
+	fetch(ctx context.Context, rel string, w io.Writer) error // a remote file's content
+	close()
+}
+
+// recorder is implemented by targets that can keep a protocol transcript
+// per file (-record).
+type recorder interface {
+	begin(rel string)
+	save(err error)
+}
+
+func connect(ctx context.Context, conf *Conf) (target, error) {
+	switch strings.ToLower(conf.Type) {
+	case "ftp":
+		ft, err := connectFTP(ctx, conf.FTP, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return ft, nil
+	case "smb":
+		st, err := connectSMB(ctx, conf.SMB, conf.Transfer); if err != nil { return nil, err }
+		if st.delta, err = newDeltaStore(conf); err != nil { return nil, err }
+		return st, nil
+	case "webdav":
+		wt, err := connectWebDAV(ctx, conf.WebDAV, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return wt, nil
+	case "s3":
+		s3, err := connectS3(ctx, conf.S3, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return s3, nil
+	case "local":
+		lt, err := connectLocal(conf.Local, conf.Transfer); if err != nil { return nil, err }
+		if lt.delta, err = newDeltaStore(conf); err != nil { return nil, err }
+		return lt, nil
+	case "scp":
+		sc, err := connectSCP(ctx, conf.SCP, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return sc, nil
+	case "rsyncd":
+		rt, err := connectRsyncd(ctx, conf.Rsyncd, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return rt, nil
+	case "http":
+		ht, err := connectHTTP(ctx, conf.HTTP, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return ht, nil
+	case "onedrive":
+		od, err := connectOneDrive(ctx, conf.OneDrive, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return od, nil
+	case "gdrive":
+		gd, err := connectGDrive(ctx, conf.GDrive, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return gd, nil
+	case "dropbox":
+		db, err := connectDropbox(ctx, conf.Dropbox, conf.Transfer, conf.Normalize); if err != nil { return nil, err }
+		return db, nil
+	}
+	return nil, fmt.Errorf("unknown type: %s (use 'ftp', 'smb', 'webdav', 's3', 'scp', 'rsyncd', 'http', 'onedrive', 'gdrive', 'dropbox' or 'local')", conf.Type)
+}
+
+// sharedConn hands one target to several workers; only its owner closes it.
+type sharedConn struct{ target }
+
+func (sharedConn) close() {}
+
+type job struct {
+	path, rel string
+	size      int64
+	mtime     time.Time
+	known     bool      // size and mtime came from the scan, no stat needed
+	snap      *fileSnap // warm start: this file's entry in the new tree snapshot
+	cleared   bool      // held back by the guard and let through at the end
+	dir       string    // folder under local_dir, for the resume token
+	pull      bool      // download rel to path, see pull.go
+	settled   bool      // on_conflict: replaces the target's copy though it changed
+}
+
+type runOpts struct {
+	full     bool     // ignore the warm-start snapshot
+	progress Progress // nil prints to stdout
+	comparer Comparer // nil uses conf.Compare
+	bus      *Bus     // optional: receives the run's events alongside any events sinks
+	accept   bool     // -accept-changes: upload what the guard would hold back
+	delete   bool     // -delete: let mirror delete any number of stale files
+	dryRun   bool     // -dry-run: say what would change, change nothing
+}
+
+type run struct {
+	conf      *Conf
+	opts      runOpts
+	st        *syncState
+	prog      Progress
+	cmp       Comparer
+	start     time.Time
+	id        string // run ID, in the canary and the summary
+	sla       time.Duration
+	uploaded  atomic.Int64
+	pulled    atomic.Int64 // direction pull: files downloaded
+	deleted   atomic.Int64 // direction both and mirror: files deleted
+	renamed   atomic.Int64 // detect_renames: moved on the target
+	backedUp  atomic.Int64 // backup_*: old copies renamed before being replaced
+	linked    atomic.Int64 // dated_folder: unchanged files linked from the last folder
+	archived  atomic.Int64 // after_upload: local files handed off
+	bytes     atomic.Int64
+	conflicts atomic.Int64
+	resolved  atomic.Int64 // on_conflict: conflicts settled
+	withheld  atomic.Int64
+	held      atomic.Int64
+	deferred  atomic.Int64 // power: large files held back
+	failed    atomic.Int64
+	tags      *tagger
+	never     *never
+	hold      *hold
+	guard     *guard
+	snapshot  *snapshotter
+	resume    *resumer
+	power     *power
+	bw        *bandwidth
+	renames   *renames
+	backups   *backups
+	trash     *trash
+	dated     *dated
+	handoff   *afterUpload
+	drifted   bool // the config differs from central_config
+	busy      busyClock // transfers in flight, for estimate
+	mu        sync.Mutex
+	kinds     map[string]int64 // failed files per error kind
+	tagged    TagTotals
+}
+
+// localRel is a file's slash-separated path under local_dir, which tags
+// and the never-transfer list match against.
+func (r *run) localRel(local string) string {
+	rel, err := filepath.Rel(r.conf.LocalDir, local)
+	if err != nil { rel = filepath.Base(local) }
+	return filepath.ToSlash(rel)
+}
+
+// tally counts an uploaded file under its tags and returns them.
+func (r *run) tally(local string, size int64) []string {
+	if r.tags == nil { return nil }
+	tags := r.tags.match(r.localRel(local))
+	r.mu.Lock()
+	if r.tagged == nil { r.tagged = TagTotals{} }
+	for _, t := range tags {
+		tt := r.tagged[t]
+		tt.Files++; tt.Bytes += size
+		r.tagged[t] = tt
+	}
+	r.mu.Unlock()
+	return tags
+}
+
+func (r *run) fail(err error) {
+	r.failed.Add(1)
+	r.mu.Lock()
+	if r.kinds == nil { r.kinds = map[string]int64{} }
+	r.kinds[errorKind(err)]++
+	r.mu.Unlock()
+}
+
+// syncFile uploads one file if the comparer says the target needs it.
+// rtt is how long the remote lookup took, for the concurrency controller.
+func (r *run) syncFile(ctx context.Context, t target, j job) (rtt time.Duration, err error) {
+	if err := ctx.Err(); err != nil { return 0, err }
+	if rc, ok := t.(recorder); ok { rc.begin(j.rel) }
+	if r.never != nil {
+		if e := r.never.byPath(r.localRel(j.path)); e != nil { return 0, r.never.withhold(j.rel, e, "") }
+	}
+	size, mtime := j.size, j.mtime
+	if !j.known {
+		localInfo, err := os.Stat(j.path)
+		if errors.Is(err, fs.ErrNotExist) { return 0, nil } // deleted since the scan
+		if err != nil { return 0, err }
+		size, mtime = localInfo.Size(), localInfo.ModTime()
+	}
+	if j.snap != nil { j.snap.Size, j.snap.MTime = size, mtime }
+	local := &FileInfo{Rel: j.rel, Path: j.path, Size: size, MTime: mtime}
+	if r.dated != nil {
+		if done, err := r.carry(ctx, t, j.rel, size, mtime); err != nil || done {
+			if done && j.snap != nil { j.snap.Synced = true }
+			return 0, err
+		}
+	}
+	start := time.Now()
+	remote, err := t.stat(ctx, j.rel)
+	rtt = time.Since(start)
+	if err != nil && !errors.Is(err, ErrNotFound) { return rtt, err }
+
+	need, err := r.cmp.NeedsUpload(local, remote)
+	if err != nil { return rtt, err }
+	r.guard.saw()
+	broken, wasBroken := r.st.brokenOff(j.rel)
+	if !need && wasBroken && remote.Exists {
+		debugf(catCompare, "%s: the copy on the target is what an upload that broke off left", j.rel)
+		need = true
+	}
+	if !need {
+		debugf(catCompare, "%s: up to date", j.rel)
+		if j.snap != nil { j.snap.Synced = true }
+		return rtt, r.handOff(ctx, t, j.rel, local)
+	}
+	if remote.Exists {
+		debugf(catCompare, "%s: upload (local %d bytes, %s; target %d bytes, %s)", j.rel, size, mtime.Format(time.RFC3339), remote.Size, remote.MTime.Format(time.RFC3339))
+	} else {
+		debugf(catCompare, "%s: upload (not on target)", j.rel)
+	}
+	if r.never != nil && r.never.hashed() {
+		if local.SHA256 == "" {
+			if local.SHA256, err = hashFile(j.path); err != nil { return rtt, err }
+		}
+		if e := r.never.byHash(local.SHA256); e != nil { return rtt, r.never.withhold(j.rel, e, local.SHA256) }
+	}
+	if !wasBroken && !j.settled && r.st.conflict(j.rel, remote.MTime) { return rtt, ErrConflict }
+	if !r.conf.WriteOnce && !wasBroken {
+		if err := r.assertRemote(ctx, t, j.rel, remote); err != nil { return rtt, err }
+	}
+	held := r.hold.covers(r.localRel(j.path))
+	if held && remote.Exists && !r.conf.WriteOnce { return rtt, ErrHeld }
+	dst := j.rel
+	if r.conf.WriteOnce && remote.Exists {
+		// a new version next to the old one, which a hold allows too
+		if dst, need, err = r.version(ctx, t, local); err != nil { return rtt, err }
+		if !need {
+			if j.snap != nil { j.snap.Synced = true }
+			return rtt, nil
+		}
+	}
+	if !remote.Exists && dst == j.rel {
+		if from, last, ok := r.claim(local); ok {
+			if moved, err := r.renameFrom(ctx, t, from, last, local); err != nil || moved {
+				if moved && j.snap != nil { j.snap.Synced = true }
+				return rtt, err
+			}
+		}
+	}
+	if r.opts.dryRun {
+		r.would("↑", "would_upload", dst)
+		r.uploaded.Add(1)
+		r.bytes.Add(size)
+		return rtt, nil
+	}
+	if err := r.power.hold(size); err != nil { return rtt, err }
+	if r.guard.suspect(j, local) { r.resume.keepOpen(j.dir); return rtt, nil } // decided once the rest is done
+	if remote.Exists && dst == j.rel {
+		if err := r.snapshot.before(ctx, t); err != nil { return rtt, err }
+		if !wasBroken {
+			if err := r.backup(ctx, t, dst, remote.MTime); err != nil { return rtt, err }
+		}
+	}
+	r.prog.OnFileStart(dst, size)
+	sent := withByteCount(ctx, func(n int64) { r.bytes.Add(n); r.prog.OnBytes(dst, n); r.power.pace(ctx, n); r.bw.pace(ctx, n) })
+	if held { sent = withHold(sent, r.hold) }
+	if r.conf.Assert != "" && remote.ETag != "" && dst == j.rel { sent = withIfMatch(sent, remote.ETag) }
+	if wasBroken && dst == j.rel {
+		if off := broken.resumeOffset(local, remote); off > 0 { sent = withResumeAt(sent, off) }
+	}
+	began := time.Now()
+	done := r.busy.start()
+	err = t.upload(sent, j.path, dst)
+	done()
+	if err != nil {
+		r.st.interrupted(dst)
+		r.st.broke(dst, *local, errors.Is(err, errResumeBroken) || wasBroken && broken.Whole)
+		return rtt, err
+	}
+	r.st.mended(dst)
+	debugf(catTransfer, "%s: %d bytes in %s", dst, size, time.Since(began).Round(time.Millisecond))
+	r.uploaded.Add(1)
+	reportUpload(r.prog, upload{rel: dst, size: size, replaced: remote.Exists && dst == j.rel, tags: r.tally(j.path, size)})
+	if r.st != nil {
+		if ri, err := t.stat(ctx, dst); err == nil { r.st.record(dst, ri.MTime, ri.ETag, *local) }
+	}
+	if j.snap != nil { j.snap.Synced = true }
+	return rtt, r.handOff(ctx, t, dst, local)
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serveMain(os.Args[2:]); return
+		case "inventory":
+			inventoryMain(os.Args[2:]); return
+		case "export":
+			exportMain(os.Args[2:]); return
+		case "import":
+			importMain(os.Args[2:]); return
+		case "diff-inventory":
+			diffInventoryMain(os.Args[2:]); return
+		case "replay":
+			replayMain(os.Args[2:]); return
+		case "never":
+			neverMain(os.Args[2:]); return
+		case "estimate":
+			estimateMain(os.Args[2:]); return
+		case "check-remote":
+			checkRemoteMain(os.Args[2:]); return
+		case "state":
+			stateMain(os.Args[2:]); return
+		}
+	}
+
 	cfgPath := flag.String("conf", "dataxfer.conf", "config JSON")
+	full := flag.Bool("full", false, "rescan every directory, ignoring the warm-start snapshot")
+	accept := flag.Bool("accept-changes", false, "upload changed files the ransomware guard would hold back")
+	del := flag.Bool("delete", false, "with mirror: delete files on the target that local_dir no longer has, however many")
+	dry := flag.Bool("dry-run", false, "compare and list what would be uploaded or deleted, without changing anything")
+	timeout := flag.Duration("timeout", 0, "give up after this long, e.g. 2h (0 = no limit)")
+	boot := flag.Bool("at-boot", false, "started with the machine: wait boot.settle, then catch up if boot.catch_up says a run is due")
+	flag.StringVar(&recordDir, "record", "", "save the FTP session of every failed file to this directory")
+	flag.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	quiet := flag.Bool("q", false, "quiet: only failures and the final line")
+	verbose := flag.Bool("v", false, "verbose: debug output for every category")
+	logSpec := flag.String("log", "", `per-category levels, e.g. "protocol=trace" (overrides the log setting)`)
 	flag.Parse()
 
 	conf, err := loadConf(*cfgPath)
 	if err != nil { log.Fatal(err) }
+	if err = setupJob(conf); err != nil { log.Fatal(err) }
+	applyPriority(conf)
+	setLanguage(conf.Language)
+	if *logSpec == "" { *logSpec = conf.Log }
+	if err = setLogLevels(*logSpec, *quiet, *verbose); err != nil { log.Fatal(err) }
 
-	var (
-		getMTime func(string) (time.Time, error)
-		putFile  func(string, string) error
-		closeFn  func()
-	)
+	// Ctrl+C stops the run cleanly; a second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func(sig context.Context) { <-sig.Done(); stop() }(ctx)
+	every, err := conf.Schedule.every()
+	if err != nil { log.Fatal(err) }
+	if *dry { every = 0 }
+	due := true
+	if *boot {
+		var code int
+		due, code, err = atBoot(ctx, conf)
+		if err != nil { log.Print(err) }
+		if !due && (err != nil || every == 0 || ctx.Err() != nil) { stop(); os.Exit(code) }
+	}
+	code := loop(ctx, every, due, func() int {
+		ctx := ctx
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		return runSync(ctx, conf, runOpts{full: *full, accept: *accept, delete: *del, dryRun: *dry})
+	})
+	stop()
+	os.Exit(code)
+}
 
-	switch strings.ToLower(conf.Type) {
-	case "ftp":
-		ft, err := connectFTP(conf.FTP); if err != nil { log.Fatal(err) }
-		getMTime, putFile, closeFn = ft.mtime, ft.upload, ft.close
-	case "smb":
-		st, err := connectSMB(conf.SMB); if err != nil { log.Fatal(err) }
-		getMTime, putFile, closeFn = st.mtime, st.upload, st.close
+func applyPriority(conf *Conf) {
+	if conf.CPUThreads > 0 { runtime.GOMAXPROCS(conf.CPUThreads) }
+	switch strings.ToLower(conf.Priority) {
+	case "", "normal":
+	case "low":
+		if err := lowerPriority(); err != nil { log.Printf("priority: %v", err) }
 	default:
-		log.Fatalf("unknown type: %s (use 'ftp' or 'smb')", conf.Type)
+		log.Printf("priority: unknown value %q (use 'low' or 'normal')", conf.Priority)
 	}
-	defer closeFn()
+}
 
-	root := conf.LocalDir
-	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
-		if walkErr != nil || d.IsDir() { return walkErr }
-		rel, _ := filepath.Rel(root, path)
-		rel = filepath.ToSlash(rel)
+// eventBus sets up the configured sinks on bus, creating one if needed.
+func eventBus(conf *Conf, bus *Bus) (*Bus, error) {
+	name := conf.instance()
+	ec := conf.Events
+	sinks := map[string]eventSink{}
+	if ec.NATS != "" {
+		if ec.Subject == "" { ec.Subject = "datasync." + name }
+		s, err := newNATSSink(ec.NATS, ec.Subject)
+		if err != nil { return nil, err }
+		sinks["nats"] = s
+	}
+	if ec.MQTT != "" {
+		if ec.Topic == "" { ec.Topic = "datasync/" + name }
+		id := "datasync-" + name
+		if conf.Job != "" && conf.Job != name { id += "-" + conf.Job }
+		s, err := newMQTTSink(ec.MQTT, ec.Topic, id)
+		if err != nil { return nil, err }
+		sinks["mqtt"] = s
+	}
+	if ec.SNMP.Target != "" {
+		s, err := newSNMPSink(ec.SNMP)
+		if err != nil { return nil, err }
+		sinks["snmp"] = s
+	}
+	if conf.Notify.HealthcheckURL != "" {
+		s, err := newHealthcheckSink(conf.Notify.HealthcheckURL)
+		if err != nil { return nil, err }
+		sinks["healthcheck"] = s
+	}
+	if conf.Notify.Digest.enabled() {
+		s, err := newDigestSink(conf.Notify.Digest, conf.StateFile, conf.Site)
+		if err != nil { return nil, err }
+		sinks["digest"] = s
+	}
+	if bus == nil && len(sinks) == 0 { return nil, nil }
+	if bus == nil { bus = &Bus{} }
+	bus.site = conf.Site
+	for n, s := range sinks { bus.addSink(n, s) }
+	return bus, nil
+}
 
-		localInfo, _ := os.Stat(path)
-		remoteTime, _ := getMTime(rel)
+func runSync(ctx context.Context, conf *Conf, opts runOpts) int {
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	ctx = withOpBudget(ctx, conf.MaxOps, stop)
+	if conf.ReadOnly || opts.dryRun { ctx = withReadOnly(ctx) }
+	r := &run{conf: conf, opts: opts, prog: opts.progress, start: time.Now()}
+	r.id = newRunID(r.start)
+	if r.prog == nil { r.prog = printer{} }
+	bo, err := newBlackout(conf.Blackout, conf.Priority)
+	if err != nil { return r.finish(Summary{Err: err}) }
+	if bo != nil && !opts.dryRun {
+		if w, in := bo.in(time.Now()); in { return r.deferRun(w, bo.until(time.Now())) }
+		defer bo.watch(stop)()
+	}
+	if !opts.dryRun {
+		unlock, err := lockJob(conf)
+		if err != nil { return r.finish(Summary{Err: err}) }
+		defer unlock()
+	}
+	if opts.dryRun {
+		// nothing to tell monitoring about a run that changes nothing
+	} else if bus, err := eventBus(conf, opts.bus); err != nil {
+		return r.finish(Summary{Err: err})
+	} else if bus != nil {
+		r.prog = progressTee{r.prog, bus}
+		defer bus.close()
+		bus.publish(Event{Type: EventRunStarted, Config: conf.digest()})
+	}
+	r.drifted = checkDrift(ctx, conf, r.prog)
+	if conf.StateFile != "" {
+		if r.st, err = loadState(conf.StateFile); err != nil { return r.finish(Summary{Err: err}) }
+	}
+	if err = checkStateMirror(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = r.seedState(ctx); err != nil { return r.finish(Summary{Err: err}) }
+	if conf.SLA != "" {
+		if r.sla, err = time.ParseDuration(conf.SLA); err != nil || r.sla <= 0 { return r.finish(Summary{Err: fmt.Errorf("sla: %q is not a duration like 4h", conf.SLA)}) }
+		if r.st == nil { log.Print("sla needs state_file to know when the last good run was; not tracked") }
+	}
+	if err = rpath.CheckForm(conf.Normalize); err != nil { return r.finish(Summary{Err: err}) }
+	if r.tags, err = newTagger(conf.Tags); err != nil { return r.finish(Summary{Err: err}) }
+	if r.hold, err = newHold(conf.Hold); err != nil { return r.finish(Summary{Err: err}) }
+	if !opts.accept && !opts.dryRun {
+		if r.guard, err = newGuard(conf.Guard, r.st); err != nil { return r.finish(Summary{Err: err}) }
+	}
+	if r.snapshot, err = newSnapshotter(conf, stop, r.start); err != nil { return r.finish(Summary{Err: err}) }
+	if r.never, err = openNever(conf.Never, conf.Site); err != nil { return r.finish(Summary{Err: err}) }
+	if r.power, err = newPower(conf.Power); err != nil { return r.finish(Summary{Err: err}) }
+	if r.bw, err = newBandwidth(conf); err != nil { return r.finish(Summary{Err: err}) }
+	defer r.bw.close()
+	if err = checkCanary(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkAssert(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkDirection(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkMirror(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkHashIndex(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkRenames(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkConflict(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkFIPS(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if err = checkDated(conf); err != nil { return r.finish(Summary{Err: err}) }
+	r.renames = newRenames(conf, r.st)
+	if r.backups, err = newBackups(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if r.trash, err = newTrash(conf); err != nil { return r.finish(Summary{Err: err}) }
+	r.dated = newDated(conf, r.st, r.start)
+	if r.handoff, err = newAfterUpload(conf); err != nil { return r.finish(Summary{Err: err}) }
+	if conf.ReadOnly && conf.Direction != "pull" && !opts.dryRun { return r.finish(Summary{Err: fmt.Errorf("read_only: this instance does not write to the target; use datasync check-remote, or direction pull")}) }
+	first, err := connect(ctx, conf)
+	if err != nil { return r.finish(Summary{Err: err}) }
+	dial := func() (target, error) { return connect(ctx, conf) }
+	if smb, ok := first.(*smbTarget); ok {
+		// every worker shares the one share connection or directory
+		defer smb.close()
+		first = sharedConn{smb}
+		dial = func() (target, error) { return first, nil }
+	}
+	if r.backups != nil && !opts.dryRun {
+		if _, ok := unshared(first).(renamer); !ok { first.close(); return r.finish(Summary{Err: fmt.Errorf("backup: %s targets cannot rename files", conf.Type)}) }
+	}
+	if r.dated != nil { _, r.dated.link = unshared(first).(linker) }
 
-		if newer(localInfo.ModTime(), remoteTime) {
-			fmt.Printf("↑ %s\n", rel)
-			if err := putFile(path, rel); err != nil {
-				return err
-			}
+	if r.cmp = opts.comparer; r.cmp == nil {
+		if r.cmp, err = newComparer(conf, r.st); err != nil { first.close(); return r.finish(Summary{Err: err}) }
+	}
+	switch conf.Direction {
+	case "pull":
+		return r.finish(r.summary(ctx, r.pull(ctx, first, dial), nil))
+	case "both":
+		return r.finish(r.summary(ctx, r.both(ctx, first, dial), nil))
+	}
+
+	jobs := make(chan job)
+	done := make(chan struct{})
+	go func() { r.pool(ctx, first, dial, jobs); close(done) }()
+
+	r.resume = newResumer(conf, r.st, opts.full)
+	sc := &scanner{ctx: ctx, root: conf.LocalDir, site: r.dated.site(conf.Site), form: conf.Normalize, threads: conf.scanThreads(), ordered: conf.Ordered, resume: r.resume, jobs: jobs}
+	if conf.WarmStart {
+		if r.st == nil {
+			log.Print("warm_start needs state_file; doing a full scan")
+		} else {
+			// replaying skips files in sync last time, which "always" must not
+			if _, always := r.cmp.(alwaysComparer); !opts.full && !always { sc.prev = r.st.Tree }
+			sc.cur = map[string]*dirSnap{}
+		}
+	}
+	err = sc.run()
+	close(jobs)
+	<-done
+	if err == nil { err = r.releaseHeld(ctx, dial) }
+	if conf.Mirror && err == nil && ctx.Err() == nil {
+		if n := r.failed.Load(); n > 0 { say("!", "%s", tr("mirror_failed", n)) } else { err = r.mirror(ctx, dial) }
+	}
+
+	var canary error
+	if conf.Canary != "" && !opts.dryRun && err == nil && ctx.Err() == nil { canary = r.canary(ctx, dial) }
+	if conf.StateMirror != "" && !opts.dryRun && err == nil && canary == nil && ctx.Err() == nil {
+		if err := r.mirrorState(ctx, dial); err != nil { log.Printf("state_mirror: %v", err) }
+	}
+
+	if r.st != nil && err == nil && sc.cur != nil { r.st.Tree = sc.cur }
+	if err == nil && ctx.Err() == nil { r.dated.sweep(r.st) }
+	return r.finish(r.summary(ctx, err, canary))
+}
+
+// releaseHeld runs the guard's verdict once the rest is done, and uploads
+// what it held back unless that looks like an attack.
+func (r *run) releaseHeld(ctx context.Context, dial func() (target, error)) error {
+	if r.guard == nil || ctx.Err() != nil { return nil }
+	held, err := r.guard.verdict()
+	if len(held) > 0 {
+		jobs := make(chan job)
+		go func() { for _, j := range held { jobs <- j }; close(jobs) }()
+		r.pool(ctx, nil, dial, jobs)
+	}
+	return err
+}
+
+// summary totals the run; err is why it stopped early, if it did.
+func (r *run) summary(ctx context.Context, err, canary error) Summary {
+	sum := Summary{DryRun: r.opts.dryRun, Uploaded: r.uploaded.Load(), Pulled: r.pulled.Load(), Deleted: r.deleted.Load(), Renamed: r.renamed.Load(), BackedUp: r.backedUp.Load(), Linked: r.linked.Load(), Archived: r.archived.Load(), Resolved: r.resolved.Load(), Bytes: r.bytes.Load(), Failed: r.failed.Load(), Errors: r.kinds, Conflicts: r.conflicts.Load(), Withheld: r.withheld.Load(), Held: r.held.Load(), Deferred: r.deferred.Load(), Tags: r.tagged, Snapshot: r.snapshot.taken()}
+	switch {
+	case ctx.Err() != nil:
+		sum.Err = context.Cause(ctx) // a hit max_remote_ops limit, else the plain ctx error
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		sum.Err = err
+	case canary != nil:
+		sum.Err = canary
+	}
+	return sum
+}
+
+// finish saves the state file with the run's outcome, reports the summary
+// and returns the exit code.
+func (r *run) finish(sum Summary) int {
+	ok := sum.Err == nil && sum.Failed == 0
+	sum.RunID, sum.Elapsed = r.id, time.Since(r.start)
+	sum.Config, sum.Drift = r.conf.digest(), r.drifted
+	sum.ErrKind = errorKind(sum.Err)
+	if r.never != nil { r.never.finish(sum.Withheld) }
+	if r.st != nil {
+		if ok { r.collectDue() }
+		sum.Recovered = ok && r.st.Failing
+		r.st.Failing = !ok
+		if ok || r.st.FreshSince.IsZero() { r.st.FreshSince = time.Now().UTC() }
+		if ok {
+			r.st.Resume = nil
+		} else if r.resume != nil {
+			r.st.Resume = r.resume.token(r.id, r.start, r.conf)
+		}
+		if !r.opts.dryRun { r.st.measured(transferRecord{At: r.start.UTC(), Files: sum.Uploaded + sum.Pulled, Bytes: sum.Bytes, Busy: r.busy.total()}) }
+		if d := time.Since(r.st.FreshSince); !ok && r.sla > 0 && d > r.sla { sum.SLA, sum.Stale = r.sla, d }
+		if r.opts.dryRun {
+			debugf(catCompare, "dry run: state file not saved")
+		} else if err := r.st.save(); err != nil {
+			log.Printf("state: %v", err)
+		} else if r.conf.HashIndex != "" {
+			if err := r.saveHashIndex(); err != nil { log.Printf("hash_index: %v", err) }
 		}
-		return nil
-	})
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		log.Fatal(err)
 	}
-	fmt.Println("✓ Sync complete")
+	r.prog.OnSummary(sum)
+	if !ok { return 1 }
+	return 0
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── dated folders ──────────────────────────────────
+// With dated_folder set, each push writes into a new folder under
+// remote_path (and site), named after the run's start in the machine's
+// local time, so the target keeps a point-in-time copy of local_dir from
+// every run instead of one mirror:
+//
+//	"{date}T{hour}-{minute}"  →  2026-10-14T02-00/sub/report.xlsx
+//
+// {date} is 2006-01-02, {time} 150405, {hour} and {minute} two digits. A
+// file unchanged since the last run, by the size and mtime the state file
+// recorded, is not sent again. SMB, local and SCP targets hard-link it
+// from the previous run's folder, so every folder is complete and costs
+// only what changed; a file that is not there to link is uploaded. Other
+// targets get only the changed files, and a point in time is then every
+// folder up to it laid over each other, oldest first, with deleted files
+// still in the older ones. Hard-linked folders can be removed from the
+// target in any order, the others not. The state file keeps the latest
+// folder's files only; a second run within the same name (minute, by
+// default) adds to that folder.
+type dated struct {
+	cur, prev string // this run's and the last run's folder, with the site
+	link      bool   // the target hard-links
+	mu        sync.Mutex
+	seen      map[string]bool // files this run met, under cur
+}
+
+const datedFolder = "{date}T{hour}-{minute}" // suggested; there is no default, setting dated_folder is what turns the mode on
+
+// datedName is the folder name tmpl gives a run started at.
+func datedName(tmpl string, at time.Time) string {
+	at = at.Local()
+	return strings.NewReplacer("{date}", at.Format("2006-01-02"), "{time}", at.Format("150405"), "{hour}", at.Format("15"), "{minute}", at.Format("04")).Replace(tmpl)
+}
+
+// checkDated rejects dated_folder values, and settings it cannot work with.
+func checkDated(c *Conf) error {
+	if c.DatedFolder == "" { return nil }
+	switch {
+	case datedName(c.DatedFolder, time.Now()) == c.DatedFolder:
+		return fmt.Errorf("dated_folder: %q has no {date}, {time}, {hour} or {minute}, so every run would write into the same folder (try %q)", c.DatedFolder, datedFolder)
+	case strings.ContainsAny(c.DatedFolder, `/\`), strings.HasPrefix(c.DatedFolder, "."):
+		return fmt.Errorf("dated_folder: %q is not a folder name", c.DatedFolder)
+	case c.Direction != "" && c.Direction != "push":
+		return fmt.Errorf("dated_folder is for a push")
+	case c.StateFile == "":
+		return fmt.Errorf("dated_folder needs state_file to know what changed since the last run")
+	case c.Mirror, c.WriteOnce, c.DetectRenames, c.BackupDir != "", c.BackupSuffix != "":
+		return fmt.Errorf("dated_folder writes a new folder each run; mirror, write_once, detect_renames and backups have nothing to do there")
+	case c.WarmStart:
+		return fmt.Errorf("dated_folder cannot be used with warm_start, which skips the unchanged files a new folder needs")
+	}
+	return nil
+}
+
+// newDated names this run's folder and carries the state file's records
+// of the last one over to it; nil unless dated_folder is set.
+func newDated(c *Conf, st *syncState, start time.Time) *dated {
+	if c.DatedFolder == "" { return nil }
+	d := &dated{cur: datedName(c.DatedFolder, start), seen: map[string]bool{}}
+	if c.Site != "" { d.cur = c.Site + "/" + d.cur }
+	d.prev = st.Dated
+	if d.prev != "" && d.prev != d.cur { st.rekey(d.prev+"/", d.cur+"/") }
+	st.Dated = d.cur
+	debugf(catTransfer, "dated_folder: writing into %s, unchanged files from %q", d.cur, d.prev)
+	return d
+}
+
+// site is where the scanner puts this run's files.
+func (d *dated) site(site string) string {
+	if d == nil { return site }
+	return d.cur
+}
+
+// linker is implemented by targets that can give a file a second name.
+type linker interface {
+	link(ctx context.Context, from, to string) error // a hard link
+}
+
+// carry brings rel, a file of this run's folder, over from the last
+// folder when it is unchanged since, and reports whether it did.
+func (r *run) carry(ctx context.Context, t target, rel string, size int64, mtime time.Time) (bool, error) {
+	d := r.dated
+	d.mu.Lock()
+	d.seen[rel] = true
+	d.mu.Unlock()
+	last, ok := r.st.last(rel)
+	if !ok || last.Partial || last.Size != size || !last.LocalMTime.Equal(mtime) { return false, nil }
+	if _, broken := r.st.brokenOff(rel); broken { return false, nil }
+	switch {
+	case d.prev == d.cur:
+		debugf(catCompare, "%s: up to date", rel)
+		return true, nil
+	case d.prev == "":
+		return false, nil
+	case !d.link:
+		debugf(catCompare, "%s: unchanged since %s, not sent again", rel, d.prev)
+		return true, nil
+	}
+	from := d.prev + strings.TrimPrefix(rel, d.cur)
+	if r.opts.dryRun {
+		debugf(catCompare, "%s: would be linked from %s", rel, from)
+		r.linked.Add(1)
+		return true, nil
+	}
+	err := unshared(t).(linker).link(ctx, from, rel)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		debugf(catCompare, "%s: %s is gone, sent again", rel, from)
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("dated_folder: linking %s to %s: %w", rel, from, err)
+	}
+	debugf(catTransfer, "%s: linked from %s", rel, from)
+	r.linked.Add(1)
+	return true, nil
+}
+
+// sweep drops the records of files this run did not meet, deleted from
+// local_dir since the last folder.
+func (d *dated) sweep(st *syncState) {
+	if d == nil { return }
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for rel := range st.Files {
+		if strings.HasPrefix(rel, d.cur+"/") && !d.seen[rel] { delete(st.Files, rel) }
+	}
+}
+
+func (t *smbTarget) link(ctx context.Context, from, to string) error {
+	src, err := t.toRemote(from)
+	if err != nil { return err }
+	dst, err := t.toRemote(to)
+	if err != nil { return err }
+	if err = takeOp(ctx, opRename); err != nil { return err }
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil { return classifyOS(err) }
+	return classifyOS(os.Link(src, dst))
+}
+
+func (t *scpTarget) link(ctx context.Context, from, to string) error {
+	src, err := t.root.Resolve(from)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	dst, err := t.root.Resolve(to)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opRename); err != nil { return err }
+	_, err = t.run(ctx, fmt.Sprintf("mkdir -p %s && ln %s %s", shellQuote(rpath.Dir(dst)), shellQuote(src), shellQuote(dst)), nil)
+	return classifySCP(ctxErr(ctx, err))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zeebo/blake3"
+)
+
+// ────────── delta transfer ─────────────────────────────────
+// With transfer.delta_min_mb set, a file at least that large which the
+// SMB or local target already has is patched in place instead of copied:
+// it is cut into blocks (transfer.delta_block_kb, default 128), and only
+// blocks whose hash differs from the target's, and what the file grew
+// by, are written. The target's hashes come from a signature saved after
+// the last upload, under <state_file>.delta, as long as the copy on the
+// target still has the size and mtime it had then; otherwise the copy is
+// read back and hashed (reads are cheaper than writes on most links, and
+// always on a branch's upstream). A marker there says a patch is under
+// way; while it stands, stat reports the copy as of unknown size, so a
+// patch that broke off is made good by the next run whatever compare is.
+//
+// rsync also finds data that moved, with its rolling checksum, but builds
+// the new file on the far side from the old one. A share has no process
+// there: moved data would have to be read back and written again, which
+// costs what sending it does. So blocks are compared where they stand,
+// and an insertion near the front of a file sends what follows it again.
+// Readers on the target see the file change while it is patched, not in
+// one rename as with a copy.
+const deltaBlockKB = 128 // default transfer.delta_block_kb
+
+type deltaStore struct {
+	min   int64  // bytes
+	block int    // bytes
+	dir   string // signatures and markers
+}
+
+// newDeltaStore is nil unless delta transfer is on.
+func newDeltaStore(c *Conf) (*deltaStore, error) {
+	tc := c.Transfer
+	if tc.DeltaMinMB <= 0 { return nil, nil }
+	if c.StateFile == "" { return nil, fmt.Errorf("transfer.delta_min_mb needs state_file to keep block signatures") }
+	if tc.DeltaBlockKB < 0 { return nil, fmt.Errorf("transfer.delta_block_kb: %d is negative", tc.DeltaBlockKB) }
+	d := &deltaStore{min: tc.DeltaMinMB << 20, block: deltaBlockKB << 10, dir: c.StateFile + ".delta"}
+	if tc.DeltaBlockKB > 0 { d.block = tc.DeltaBlockKB << 10 }
+	return d, nil
+}
+
+// signature is what the target's copy of a file holds, block by block.
+type signature struct {
+	Format int       `json:"format,omitempty"` // see format.go
+	Rel    string    `json:"rel"`
+	Size   int64     `json:"size"`  // of the target's copy
+	MTime  time.Time `json:"mtime"` // of the target's copy
+	Block  int       `json:"block"`
+	Hash   string    `json:"hash,omitempty"` // of the blocks: "" for BLAKE3, "sha256" in FIPS mode
+	sums   [][16]byte
+}
+
+func (d *deltaStore) sigPath(dst string) string {
+	h := sha256.Sum256([]byte(dst))
+	return filepath.Join(d.dir, hex.EncodeToString(h[:12])+".sig")
+}
+
+// patching reports whether a patch of dst broke off.
+func (d *deltaStore) patching(dst string) bool {
+	if d == nil { return false }
+	_, err := os.Stat(d.sigPath(dst) + ".patching")
+	return err == nil
+}
+
+// load is the saved signature of dst if it still describes fi.
+func (d *deltaStore) load(dst string, fi os.FileInfo) *signature {
+	f, err := os.Open(d.sigPath(dst))
+	if err != nil { return nil }
+	defer f.Close()
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil { return nil }
+	var s signature
+	if json.Unmarshal(line, &s) != nil || formatOf(s.Format) != sigFormat || s.Rel != dst || s.Block != d.block || s.Hash != blockHash() || s.Size != fi.Size() || !s.MTime.Equal(fi.ModTime()) { return nil }
+	n := (s.Size + int64(s.Block) - 1) / int64(s.Block)
+	s.sums = make([][16]byte, n)
+	for i := range s.sums {
+		if _, err := io.ReadFull(r, s.sums[i][:]); err != nil { return nil }
+	}
+	return &s
+}
+
+func (d *deltaStore) save(s *signature) error {
+	if err := os.MkdirAll(d.dir, 0755); err != nil { return err }
+	p := d.sigPath(s.Rel)
+	var b bytes.Buffer
+	hdr, _ := json.Marshal(s)
+	b.Write(hdr)
+	b.WriteByte('\n')
+	for _, sum := range s.sums { b.Write(sum[:]) }
+	if err := os.WriteFile(p+".tmp", b.Bytes(), 0644); err != nil { return err }
+	return os.Rename(p+".tmp", p)
+}
+
+func (d *deltaStore) drop(dst string) { os.Remove(d.sigPath(dst)) }
+
+// blockSum is the first half of b's BLAKE3 hash, or of its SHA-256 in
+// FIPS mode.
+func blockSum(b []byte) (s [16]byte) {
+	if fipsMode { h := sha256.Sum256(b); copy(s[:], h[:]); return s }
+	h := blake3.Sum256(b)
+	copy(s[:], h[:])
+	return s
+}
+
+// blockHash is what signature.Hash is for the blocks blockSum makes.
+func blockHash() string {
+	if fipsMode { return hashSHA256 }
+	return ""
+}
+
+// patch brings dst, an existing copy, in line with src in place, and
+// reports whether it did: false when dst is no file to patch. It counts
+// only the bytes it writes.
+func (d *deltaStore) patch(ctx context.Context, src *os.File, dst string) (bool, error) {
+	fi, err := src.Stat()
+	if err != nil || fi.Size() < d.min { return false, err }
+	old, err := os.Stat(dst)
+	if err != nil || !old.Mode().IsRegular() { return false, nil }
+	out, err := os.OpenFile(dst, os.O_RDWR, 0)
+	if err != nil { return false, nil }
+	defer out.Close()
+	sig := d.load(dst, old)
+	d.drop(dst)
+	// until the patch is done, the copy holds neither version for sure
+	if err = os.MkdirAll(d.dir, 0755); err != nil { return true, err }
+	marker := d.sigPath(dst) + ".patching"
+	if err = os.WriteFile(marker, []byte(dst+"\n"), 0644); err != nil { return true, err }
+
+	next := &signature{Format: stamp(sigFormat), Rel: dst, Block: d.block, Hash: blockHash()}
+	buf, theirs := make([]byte, d.block), make([]byte, d.block)
+	var written, kept int64
+	for off := int64(0); off < fi.Size(); off += int64(d.block) {
+		if err := ctx.Err(); err != nil { return true, err }
+		n, err := io.ReadFull(src, buf)
+		if err != nil && err != io.ErrUnexpectedEOF { return true, err }
+		sum := blockSum(buf[:n])
+		next.sums = append(next.sums, sum)
+		same := false
+		switch i := off / int64(d.block); {
+		case sig != nil:
+			same = i < int64(len(sig.sums)) && sig.sums[i] == sum && off+int64(n) <= old.Size()
+		case off+int64(n) <= old.Size():
+			// no signature: read the target's block back to compare
+			if _, err := out.ReadAt(theirs[:n], off); err != nil { return true, err }
+			same = bytes.Equal(theirs[:n], buf[:n])
+		}
+		if same { kept += int64(n); continue }
+		if _, err := out.WriteAt(buf[:n], off); err != nil { return true, err }
+		written += int64(n)
+		countBytes(ctx, int64(n))
+	}
+	if old.Size() > fi.Size() {
+		if err := out.Truncate(fi.Size()); err != nil { return true, err }
+	}
+	if err := out.Close(); err != nil { return true, err }
+	if err := os.Chtimes(dst, time.Now(), fi.ModTime()); err != nil { return true, err }
+	os.Remove(marker)
+	debugf(catTransfer, "%s: delta wrote %d bytes, kept %d (signature: %t)", dst, written, kept, sig != nil)
+	if now, err := os.Stat(dst); err == nil {
+		next.Size, next.MTime = now.Size(), now.ModTime()
+		if err := d.save(next); err != nil { debugf(catTransfer, "%s: delta signature not saved: %v", dst, err) }
+	}
+	return true, nil
+}
+
+// signFull saves the signature of src as just copied to dst in full, so
+// the next change to it can go as a delta, and clears the marker of a
+// patch the copy made good.
+func (d *deltaStore) signFull(src *os.File, dst string) error {
+	os.Remove(d.sigPath(dst) + ".patching")
+	fi, err := src.Stat()
+	if err != nil || fi.Size() < d.min { return err }
+	if _, err = src.Seek(0, io.SeekStart); err != nil { return err }
+	s := &signature{Format: stamp(sigFormat), Rel: dst, Block: d.block, Hash: blockHash()}
+	buf := make([]byte, d.block)
+	r := bufio.NewReaderSize(src, 1<<20)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 { s.sums = append(s.sums, blockSum(buf[:n])) }
+		if err == io.EOF || err == io.ErrUnexpectedEOF { break }
+		if err != nil { return err }
+	}
+	now, err := os.Stat(dst)
+	if err != nil { return err }
+	s.Size, s.MTime = now.Size(), now.ModTime()
+	if s.Size != fi.Size() { return fmt.Errorf("target has %d bytes, %d were sent", s.Size, fi.Size()) }
+	return d.save(s)
+}
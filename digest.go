@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ────────── change digest ──────────────────────────────────
+// The digest tells business owners what data moved rather than how: each
+// run appends the paths it created, replaced or deleted on the target to
+// a journal, and the first run to finish once the period (default 24h) is
+// over sends the period's changes, grouped by top-level folder, by mail
+// and/or web hook, then starts a new period. A path changed several times
+// is listed once, by its net effect. Nothing is sent while no run
+// happens; the next digest then covers the longer period. If delivery
+// fails the journal is kept and the next run tries again.
+type DigestConf struct {
+	Every   string   `json:"every"`   // period, default "24h"
+	Journal string   `json:"journal"` // default <state_file>.digest
+	Webhook string   `json:"webhook"` // http(s) URL the digest is POSTed to as JSON
+	SMTP    string   `json:"smtp"`    // smtp://[user:pass@]host[:25] to mail it through
+	From    string   `json:"from"`
+	To      []string `json:"to"`
+}
+
+func (c DigestConf) enabled() bool { return c.Webhook != "" || c.SMTP != "" }
+
+// digestMax caps the paths listed per folder and change; the counts
+// always cover everything.
+const digestMax = 100
+
+type digestSink struct {
+	conf    DigestConf
+	every   time.Duration
+	journal string
+	site    string
+	smtp    *url.URL
+	client  *http.Client
+	f       *os.File
+}
+
+type journalEntry struct {
+	Start  time.Time `json:"start,omitzero"` // first line only: when the period began
+	Change string    `json:"change,omitempty"`
+	Path   string    `json:"path,omitempty"`
+}
+
+func newDigestSink(c DigestConf, stateFile, site string) (*digestSink, error) {
+	d := &digestSink{conf: c, every: 24 * time.Hour, journal: c.Journal, site: site, client: &http.Client{Timeout: 10 * time.Second}}
+	if c.Every != "" {
+		every, err := time.ParseDuration(c.Every)
+		if err != nil || every <= 0 { return nil, fmt.Errorf("notify.digest.every: %q is not a duration like 24h", c.Every) }
+		d.every = every
+	}
+	if d.journal == "" {
+		if stateFile == "" { return nil, fmt.Errorf("notify.digest needs journal or state_file to collect changes across runs") }
+		d.journal = stateFile + ".digest"
+	}
+	if c.Webhook != "" && !strings.HasPrefix(c.Webhook, "http://") && !strings.HasPrefix(c.Webhook, "https://") {
+		return nil, fmt.Errorf("notify.digest.webhook: %q is not an http(s) URL", c.Webhook)
+	}
+	if c.SMTP != "" {
+		u, err := url.Parse(c.SMTP)
+		if err != nil || u.Scheme != "smtp" || u.Host == "" { return nil, fmt.Errorf("notify.digest.smtp: %q is not an smtp://host:port URL", c.SMTP) }
+		if c.From == "" || len(c.To) == 0 { return nil, fmt.Errorf("notify.digest: smtp needs from and to") }
+		d.smtp = u
+	}
+	return d, nil
+}
+
+func (d *digestSink) send(e Event) error {
+	switch e.Type {
+	case EventFileUploaded:
+		change := e.Change
+		if change == "" { change = changeModified }
+		return d.note(journalEntry{Change: change, Path: e.Path})
+	case EventFileDeleted:
+		return d.note(journalEntry{Change: changeDeleted, Path: e.Path})
+	case EventRunCompleted:
+		return d.flush()
+	}
+	return nil
+}
+
+// note appends one change to the journal, starting a period if there is
+// none.
+func (d *digestSink) note(je journalEntry) error {
+	if d.f == nil {
+		f, err := os.OpenFile(d.journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil { return err }
+		d.f = f
+		if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+			if err = d.write(journalEntry{Start: time.Now().UTC()}); err != nil { return err }
+		}
+	}
+	return d.write(je)
+}
+
+func (d *digestSink) write(je journalEntry) error {
+	b, err := json.Marshal(je)
+	if err != nil { return err }
+	_, err = d.f.Write(append(b, '\n'))
+	return err
+}
+
+// flush sends the digest if the period is over and starts a new one.
+func (d *digestSink) flush() error {
+	d.close()
+	start, changes, err := readJournal(d.journal)
+	if errors.Is(err, os.ErrNotExist) {
+		// the period starts with the first run, even one that changed nothing
+		if d.f, err = os.Create(d.journal); err != nil { return err }
+		return d.write(journalEntry{Start: time.Now().UTC()})
+	}
+	if err != nil { return err }
+	if time.Since(start) < d.every { return nil }
+	dg := d.build(start, time.Now().UTC(), changes)
+	if d.conf.Webhook != "" {
+		if err = d.post(dg); err != nil { return err }
+	}
+	if d.smtp != nil {
+		if err = d.mail(dg); err != nil { return err }
+	}
+	return os.Remove(d.journal)
+}
+
+func (d *digestSink) close() {
+	if d.f != nil { d.f.Close(); d.f = nil }
+}
+
+const (
+	changeNew      = "new"
+	changeModified = "modified"
+	changeDeleted  = "deleted"
+)
+
+// readJournal returns when the period began and each path's net change:
+// a file created and then changed is new, one created and then deleted is
+// left out, one deleted and then created again is modified.
+func readJournal(p string) (time.Time, map[string]string, error) {
+	f, err := os.Open(p)
+	if err != nil { return time.Time{}, nil, err }
+	defer f.Close()
+	var start time.Time
+	changes := map[string]string{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var je journalEntry
+		if json.Unmarshal(sc.Bytes(), &je) != nil { continue } // a line cut short by a crash
+		if !je.Start.IsZero() {
+			if start.IsZero() { start = je.Start }
+			continue
+		}
+		prev, seen := changes[je.Path]
+		switch {
+		case je.Change == changeDeleted && prev == changeNew:
+			delete(changes, je.Path)
+		case je.Change == changeDeleted:
+			changes[je.Path] = changeDeleted
+		case prev == changeNew:
+		case seen:
+			changes[je.Path] = changeModified
+		default:
+			changes[je.Path] = je.Change
+		}
+	}
+	if err = sc.Err(); err != nil { return time.Time{}, nil, err }
+	if start.IsZero() { start = time.Now().UTC() } // header lost; restart the period
+	return start, changes, nil
+}
+
+type digestGroup struct {
+	Folder   string   `json:"folder"` // "" for files at the top level
+	New      []string `json:"new,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+	Deleted  []string `json:"deleted,omitempty"`
+}
+
+type digest struct {
+	Site     string        `json:"site,omitempty"`
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	New      int           `json:"new"`
+	Modified int           `json:"modified"`
+	Deleted  int           `json:"deleted"`
+	Groups   []digestGroup `json:"groups"`
+	Text     string        `json:"text"` // the same, as the mail body
+}
+
+func (d *digestSink) build(from, to time.Time, changes map[string]string) *digest {
+	dg := &digest{Site: d.site, From: from, To: to, Groups: []digestGroup{}}
+	groups := map[string]*digestGroup{}
+	for p, change := range changes {
+		rel := p
+		if d.site != "" { rel = strings.TrimPrefix(rel, d.site+"/") }
+		folder, _, ok := strings.Cut(rel, "/")
+		if !ok { folder = "" }
+		g := groups[folder]
+		if g == nil { g = &digestGroup{Folder: folder}; groups[folder] = g }
+		switch change {
+		case changeNew:
+			g.New = append(g.New, rel); dg.New++
+		case changeDeleted:
+			g.Deleted = append(g.Deleted, rel); dg.Deleted++
+		default:
+			g.Modified = append(g.Modified, rel); dg.Modified++
+		}
+	}
+	for _, g := range groups {
+		sort.Strings(g.New); sort.Strings(g.Modified); sort.Strings(g.Deleted)
+		dg.Groups = append(dg.Groups, *g)
+	}
+	sort.Slice(dg.Groups, func(i, j int) bool { return dg.Groups[i].Folder < dg.Groups[j].Folder })
+
+	var b strings.Builder
+	const stamp = "2006-01-02 15:04"
+	fmt.Fprintf(&b, "%s\n%s\n", tr("digest_period", from.Local().Format(stamp), to.Local().Format(stamp)), tr("digest_counts", dg.New, dg.Modified, dg.Deleted))
+	if len(changes) == 0 { fmt.Fprintf(&b, "\n%s\n", tr("digest_none")) }
+	for _, g := range dg.Groups {
+		name := g.Folder + "/"
+		if g.Folder == "" { name = "/" }
+		fmt.Fprintf(&b, "\n%s (%s)\n", name, tr("digest_counts", len(g.New), len(g.Modified), len(g.Deleted)))
+		for _, l := range []struct{ mark string; paths []string }{{"+", g.New}, {"~", g.Modified}, {"-", g.Deleted}} {
+			for i, p := range l.paths {
+				if i == digestMax { fmt.Fprintf(&b, "  %s %s\n", l.mark, tr("digest_more", len(l.paths)-i)); break }
+				fmt.Fprintf(&b, "  %s %s\n", l.mark, p)
+			}
+		}
+	}
+	dg.Text = b.String()
+	// the JSON carries the same cap, so one busy day stays a small request
+	for i := range dg.Groups {
+		g := &dg.Groups[i]
+		g.New, g.Modified, g.Deleted = capPaths(g.New), capPaths(g.Modified), capPaths(g.Deleted)
+	}
+	return dg
+}
+
+func capPaths(p []string) []string {
+	if len(p) > digestMax { return p[:digestMax] }
+	return p
+}
+
+func (d *digestSink) subject(dg *digest) string {
+	name := d.site
+	if name == "" { name, _ = os.Hostname() }
+	return tr("digest_subject", name, tr("digest_counts", dg.New, dg.Modified, dg.Deleted))
+}
+
+func (d *digestSink) post(dg *digest) error {
+	b, err := json.Marshal(dg)
+	if err != nil { return err }
+	for try := 1; try <= attempts; try++ {
+		if try > 1 { time.Sleep(time.Second) }
+		var resp *http.Response
+		if resp, err = d.client.Post(d.conf.Webhook, "application/json", bytes.NewReader(b)); err != nil { continue }
+		resp.Body.Close()
+		if resp.StatusCode < 300 { return nil }
+		err = fmt.Errorf("%s: %s", d.conf.Webhook, resp.Status)
+	}
+	return err
+}
+
+func (d *digestSink) mail(dg *digest) error {
+	addr := d.smtp.Host
+	if d.smtp.Port() == "" { addr = net.JoinHostPort(d.smtp.Hostname(), "25") }
+	var auth smtp.Auth
+	if u := d.smtp.User; u != nil {
+		pass, _ := u.Password()
+		auth = smtp.PlainAuth("", u.Username(), pass, d.smtp.Hostname())
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n", d.conf.From, strings.Join(d.conf.To, ", "), mime.QEncoding.Encode("utf-8", d.subject(dg)), time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(dg.Text, "\n", "\r\n"))
+	return smtp.SendMail(addr, auth, d.conf.From, d.conf.To, []byte(msg.String()))
+}
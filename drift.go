@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ────────── config drift ───────────────────────────────────
+// Every run reports which config it ran with: the SHA-256 of the config
+// file in a canonical form (keys sorted, whitespace and key order
+// dropped), so the same settings give the same hash on every machine and
+// binary version. It goes out in run_started (config), in the summary
+// (summary.config) and with -v. With central_config set to the
+// centrally kept version of this config, a path (a share, say) or an
+// http(s) URL, each run fetches it and compares the two. A difference
+// prints `!` with the top-level settings that differ, sends a
+// config_drift event (change: the published hash, error: the message)
+// and sets summary.config_drift, which finds sites running stale or
+// hand-edited configs. A published config that cannot be fetched is
+// logged and the run goes on.
+
+// canonConf is the config document b, decoded, and its hash.
+func canonConf(b []byte) (map[string]any, string, error) {
+	var v map[string]any
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil { return nil, "", err }
+	c, _ := json.Marshal(v) // maps marshal with sorted keys
+	sum := sha256.Sum256(c)
+	return v, hex.EncodeToString(sum[:]), nil
+}
+
+// digest is the hash of the config file c was loaded from, "" for a
+// config made up in code.
+func (c *Conf) digest() string {
+	if c.source == nil { return "" }
+	_, sum, _ := canonConf(c.source)
+	return sum
+}
+
+// checkDrift compares c with its central_config and reports whether
+// they differ.
+func checkDrift(ctx context.Context, c *Conf, p Progress) bool {
+	if c.source == nil { return false }
+	debugf(catTransfer, "config %s", c.digest())
+	if c.CentralConf == "" { return false }
+	raw, err := fetchConf(ctx, c.CentralConf)
+	if err != nil { log.Printf("central_config: %v", err); return false }
+	pub, pubSum, err := canonConf(raw)
+	if err != nil { log.Printf("central_config: %s: %v", c.CentralConf, err); return false }
+	local, sum, _ := canonConf(c.source)
+	if sum == pubSum {
+		debugf(catTransfer, "config as in %s", c.CentralConf)
+		return false
+	}
+	msg := tr("config_drift", c.CentralConf, strings.Join(differing(local, pub), ", "))
+	say("!", "%s", msg)
+	reportDrift(p, sum, pubSum, msg)
+	return true
+}
+
+// fetchConf reads src, a path or an http(s) URL.
+func fetchConf(ctx context.Context, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") { return os.ReadFile(src) }
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil { return nil, err }
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("%s: %s", src, resp.Status) }
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
+
+// differing is the top-level settings a and b do not agree on.
+func differing(a, b map[string]any) []string {
+	var keys []string
+	for k, va := range a {
+		ja, _ := json.Marshal(va)
+		jb, _ := json.Marshal(b[k])
+		if _, ok := b[k]; !ok || !bytes.Equal(ja, jb) { keys = append(keys, k) }
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok { keys = append(keys, k) }
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// driftReporter is implemented by progress sinks that want to hear of a
+// config that differs from the published one.
+type driftReporter interface{ onDrift(sum, published, msg string) }
+
+func reportDrift(p Progress, sum, published, msg string) {
+	if dr, ok := p.(driftReporter); ok { dr.onDrift(sum, published, msg) }
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── Dropbox target ─────────────────────────────────
+// dropboxTarget writes into a Dropbox through its v2 HTTP API. Files up
+// to 8 MiB go up in one /files/upload; larger ones through an upload
+// session in 8 MiB pieces, where a piece that failed is sent again from
+// the offset Dropbox says it has. Every upload sets client_modified to
+// the local mtime, which get_metadata gives back; Dropbox keeps whole
+// seconds, so it is rounded up and an unchanged file compares up to date.
+// Folders are made by the uploads themselves. The app signs in with a
+// refresh token kept in dropbox.token_file, as the OAuth flow for the
+// app key (dropbox.app_key, plus app_secret unless it used PKCE) left it.
+type DropboxConf struct {
+	AppKey     string `json:"app_key"`
+	AppSecret  string `json:"app_secret"`  // not for PKCE apps
+	TokenFile  string `json:"token_file"`  // {"refresh_token": "…"}
+	RemotePath string `json:"remote_path"` // folder in the Dropbox, or the app folder
+	APIURL     string `json:"api_url"`     // default https://api.dropboxapi.com
+	ContentURL string `json:"content_url"` // default https://content.dropboxapi.com
+}
+
+const dropboxPiece = 8 << 20 // larger files go through an upload session in pieces this size
+
+type dropboxTarget struct {
+	cfg    DropboxConf
+	root   rpath.Root
+	client *http.Client
+	tokens *tokenSource
+}
+
+func connectDropbox(ctx context.Context, cfg DropboxConf, tc TransferConf, form string) (*dropboxTarget, error) {
+	if cfg.AppKey == "" || cfg.TokenFile == "" { return nil, fmt.Errorf("dropbox.app_key and dropbox.token_file are required") }
+	if cfg.APIURL == "" { cfg.APIURL = "https://api.dropboxapi.com" }
+	if cfg.ContentURL == "" { cfg.ContentURL = "https://content.dropboxapi.com" }
+	cfg.APIURL, cfg.ContentURL = strings.TrimRight(cfg.APIURL, "/"), strings.TrimRight(cfg.ContentURL, "/")
+	client, err := tc.httpClient("dropbox", "")
+	if err != nil { return nil, err }
+	t := &dropboxTarget{cfg: cfg, root: rpath.NewRoot(cfg.RemotePath, form), client: client}
+	key := strings.Join([]string{"dropbox", cfg.APIURL, cfg.AppKey, cfg.TokenFile}, "\x00")
+	t.tokens, err = sharedTokens(key, func() (*tokenSource, error) {
+		return &tokenSource{file: cfg.TokenFile, fetch: func(ctx context.Context, old oauthToken) (oauthToken, error) {
+			if old.Refresh == "" { return oauthToken{}, withClass(ErrAuth, fmt.Errorf("dropbox.token_file %s holds no refresh_token", cfg.TokenFile)) }
+			form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {old.Refresh}, "client_id": {cfg.AppKey}}
+			if cfg.AppSecret != "" { form.Set("client_secret", cfg.AppSecret) }
+			return grant(ctx, client, cfg.APIURL+"/oauth2/token", form)
+		}}, nil
+	})
+	if err != nil { return nil, err }
+	// the account shows a refused sign-in once
+	if _, err = apiCall(ctx, t.client, t.tokens, "POST", cfg.APIURL+"/2/users/get_current_account", nil, nil, dropboxFailure); err != nil {
+		return nil, classifyHTTP(ctxErr(ctx, err))
+	}
+	return t, nil
+}
+
+// dropboxFailure reads the error_summary of a refusal. Dropbox answers
+// 409 for every endpoint error, so the summary decides the class.
+func dropboxFailure(method string, u *url.URL, resp *http.Response) error {
+	var e struct {
+		Summary string `json:"error_summary"`
+		Error   struct {
+			CorrectOffset *int64 `json:"correct_offset"` // append_v2
+			LookupFailed  struct {
+				CorrectOffset *int64 `json:"correct_offset"` // finish
+			} `json:"lookup_failed"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	se := &statusError{method: method, url: u.Redacted(), code: resp.StatusCode, status: resp.Status, detail: e.Summary}
+	if resp.StatusCode != http.StatusConflict { return se }
+	s := e.Summary
+	switch {
+	case strings.Contains(s, "incorrect_offset"):
+		at := e.Error.CorrectOffset
+		if at == nil { at = e.Error.LookupFailed.CorrectOffset }
+		if at != nil { return &offsetError{*at, se} }
+	case strings.Contains(s, "not_found"):
+		return withClass(ErrNotFound, se)
+	case strings.Contains(s, "insufficient_space"):
+		return withClass(ErrQuota, se)
+	case strings.Contains(s, "malformed_path"), strings.Contains(s, "disallowed_name"):
+		return withClass(ErrInvalidName, se)
+	case strings.Contains(s, "no_write_permission"):
+		return withClass(ErrPermission, se)
+	case strings.Contains(s, "too_many_write_operations"):
+		return withClass(ErrTransient, se)
+	}
+	// not the 409 of a missing WebDAV collection, which classifyHTTP assumes
+	if s == "" { s = resp.Status }
+	return fmt.Errorf("%s %s: %s", method, u.Redacted(), s)
+}
+
+// offsetError is an upload session refusing a piece because it holds a
+// different number of bytes than the piece started at.
+type offsetError struct {
+	at  int64
+	err error
+}
+
+func (e *offsetError) Error() string { return e.err.Error() }
+func (e *offsetError) Unwrap() error { return e.err }
+
+// dropboxArg is v as the Dropbox-API-Arg header, which must be ASCII.
+func dropboxArg(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil { return "", err }
+	var s strings.Builder
+	for _, r := range string(b) {
+		switch {
+		case r < utf8.RuneSelf:
+			s.WriteRune(r)
+		case r > 0xFFFF:
+			r -= 0x10000
+			fmt.Fprintf(&s, `\u%04x\u%04x`, 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+		default:
+			fmt.Fprintf(&s, `\u%04x`, r)
+		}
+	}
+	return s.String(), nil
+}
+
+// content calls an endpoint of the content host, with arg in the header
+// and body (if any) as the request body.
+func (t *dropboxTarget) content(ctx context.Context, endpoint string, arg any, body io.Reader, size int64) (*http.Response, error) {
+	a, err := dropboxArg(arg)
+	if err != nil { return nil, err }
+	tok, err := t.tokens.token(ctx)
+	if err != nil { return nil, err }
+	req, err := http.NewRequestWithContext(ctx, "POST", t.cfg.ContentURL+"/2/"+endpoint, body)
+	if err != nil { return nil, err }
+	req.ContentLength = max(size, 0)
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Dropbox-API-Arg", a)
+	if body != nil { req.Header.Set("Content-Type", "application/octet-stream") }
+	resp, err := t.client.Do(req)
+	if err != nil { return nil, err }
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, dropboxFailure("POST", req.URL, resp)
+	}
+	return resp, nil
+}
+
+func (t *dropboxTarget) path(rel string) (string, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return "", withClass(ErrInvalidName, err) }
+	return "/" + strings.TrimPrefix(remote, "/"), nil
+}
+
+func (t *dropboxTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	p, err := t.path(rel)
+	if err != nil { return FileInfo{}, err }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	var md struct {
+		Tag            string    `json:".tag"`
+		Size           int64     `json:"size"`
+		ClientModified time.Time `json:"client_modified"`
+	}
+	if _, err = apiCall(ctx, t.client, t.tokens, "POST", t.cfg.APIURL+"/2/files/get_metadata", map[string]string{"path": p}, &md, dropboxFailure); err != nil {
+		return FileInfo{}, classifyHTTP(ctxErr(ctx, err))
+	}
+	if md.Tag != "file" { return FileInfo{}, withClass(ErrInvalidName, fmt.Errorf("%s is a %s on the target", rel, md.Tag)) }
+	return FileInfo{Rel: rel, Size: md.Size, MTime: md.ClientModified, Exists: true}, nil
+}
+
+func (t *dropboxTarget) upload(ctx context.Context, local, rel string) error {
+	return classifyHTTP(ctxErr(ctx, t.put(ctx, local, rel)))
+}
+
+func (t *dropboxTarget) put(ctx context.Context, local, rel string) error {
+	p, err := t.path(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
+	src, err := os.Open(local)
+	if err != nil { return err }
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil { return err }
+	commit := map[string]any{"path": p, "mode": "overwrite", "mute": true,
+		"client_modified": ceilTime(fi.ModTime(), time.Second).UTC().Format("2006-01-02T15:04:05Z")}
+	if fi.Size() <= dropboxPiece {
+		resp, err := t.content(ctx, "files/upload", commit, ctxReader{ctx, src}, fi.Size())
+		if err != nil { return err }
+		resp.Body.Close()
+		return nil
+	}
+	return t.session(ctx, src, fi.Size(), commit)
+}
+
+// session sends src in pieces. A piece that fails in a way that may pass
+// is sent again, from the offset Dropbox reports when it has more or less
+// of the file than this side thought.
+func (t *dropboxTarget) session(ctx context.Context, src *os.File, size int64, commit map[string]any) error {
+	resp, err := t.content(ctx, "files/upload_session/start", map[string]any{"close": false}, ctxReader{ctx, io.NewSectionReader(src, 0, dropboxPiece)}, dropboxPiece)
+	if err != nil { return err }
+	var s struct {
+		ID string `json:"session_id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	resp.Body.Close()
+	if err != nil { return err }
+	off := int64(dropboxPiece)
+	for fails := 0; ; {
+		n := min(int64(dropboxPiece), size-off)
+		cursor := map[string]any{"session_id": s.ID, "offset": off}
+		endpoint, arg := "files/upload_session/append_v2", any(map[string]any{"cursor": cursor, "close": false})
+		if off+n == size { endpoint, arg = "files/upload_session/finish", map[string]any{"cursor": cursor, "commit": commit} }
+		resp, err := t.content(ctx, endpoint, arg, ctxReader{ctx, io.NewSectionReader(src, off, n)}, n)
+		if err == nil {
+			resp.Body.Close()
+			if off += n; off == size { return nil }
+			fails = 0
+			continue
+		}
+		var oe *offsetError
+		if errors.As(err, &oe) && oe.at != off {
+			debugf(catTransfer, "dropbox: session has %d bytes, not %d; going on from there", oe.at, off)
+			off = oe.at
+			continue
+		}
+		if fails++; fails >= attempts || !retryable(classifyHTTP(ctxErr(ctx, err))) { return err }
+		debugf(catTransfer, "dropbox: piece at %d failed, sending it again: %v", off, err)
+	}
+}
+
+func (t *dropboxTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	p, err := t.path(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	resp, err := t.content(ctx, "files/download", map[string]string{"path": p}, nil, -1)
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return classifyHTTP(ctxErr(ctx, err))
+}
+
+func (t *dropboxTarget) close() { t.client.CloseIdleConnections() }
@@ -0,0 +1,17 @@
+package main
+
+// ────────── dry run ────────────────────────────────────────
+// -dry-run walks and compares as a run would, and says what it would
+// upload, download or delete instead of doing it: the same checks apply
+// (never_transfer, hold, assert, conflicts, mirror's -delete and
+// max_delete), so the list is what the same run without -dry-run would
+// change as things stand. Under it every write is refused before it
+// reaches the target, as with read_only; the guard, the canary and the
+// snapshot are skipped, no events are published and the state file is
+// not saved. The summary counts what would have been transferred and
+// deleted, with dry_run set.
+
+// would tells the operator about a change a dry run leaves out.
+func (r *run) would(mark, key, rel string) {
+	if logOn(catTransfer, lvlInfo) { say(mark, "%s", tr(key, rel)) }
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/textproto"
+	"strings"
+	"syscall"
+)
+
+// ────────── error classes ──────────────────────────────────
+// Targets tag their errors with one of these classes so callers can
+// branch with errors.Is instead of matching strings: the retry loop only
+// retries what may work next time, and reports can say what went wrong.
+// ErrNetwork and ErrLocked are kinds of ErrTransient. The original error
+// stays in the chain and in the message.
+var (
+	ErrAuth        = errors.New("login failed")
+	ErrPermission  = errors.New("permission denied")
+	ErrNotFound    = errors.New("not found")
+	ErrQuota       = errors.New("quota or disk space exhausted")
+	ErrInvalidName = errors.New("name not allowed")
+	ErrConflict    = errors.New("changed on target by another writer")
+	ErrWithheld    = errors.New("withheld by the never-transfer list")
+	ErrHeld        = errors.New("under legal hold, the copy on the target is kept")
+	ErrAnomaly     = errors.New("changes look like ransomware")
+	ErrCanary      = errors.New("target did not keep the canary")
+	ErrLimit       = errors.New("remote operation limit reached")
+	ErrBlackout    = errors.New("blackout window began")
+	ErrDeferred    = errors.New("deferred until on AC power or an unmetered network")
+	ErrBusy        = errors.New("another run of this job is still going")
+	ErrTransient   = errors.New("transient failure")
+	ErrNetwork     = fmt.Errorf("network: %w", ErrTransient)
+	ErrLocked      = fmt.Errorf("file locked: %w", ErrTransient)
+)
+
+// errorKinds names each class for reports, most specific first, so
+// first-line support can route a ticket without reading Go errors.
+var errorKinds = []struct {
+	class error
+	name  string
+}{
+	{ErrAuth, "auth"},
+	{ErrPermission, "permission"},
+	{ErrQuota, "disk-full"},
+	{ErrLocked, "file-locked"},
+	{ErrInvalidName, "name-invalid"},
+	{ErrNotFound, "not-found"},
+	{ErrConflict, "conflict"},
+	{ErrWithheld, "withheld"},
+	{ErrHeld, "held"},
+	{ErrAnomaly, "anomaly"},
+	{ErrCanary, "canary"},
+	{ErrLimit, "limit"},
+	{ErrBlackout, "blackout"},
+	{ErrDeferred, "deferred"},
+	{ErrBusy, "busy"},
+	{ErrNetwork, "network"},
+	{ErrTransient, "transient"},
+}
+
+func errorKind(err error) string {
+	if err == nil { return "" }
+	for _, k := range errorKinds {
+		if errors.Is(err, k.class) { return k.name }
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) { return "cancelled" }
+	return "other"
+}
+
+type classErr struct{ class, err error }
+
+func (e *classErr) Error() string   { return e.err.Error() }
+func (e *classErr) Unwrap() []error { return []error{e.class, e.err} }
+
+func withClass(class, err error) error {
+	if err == nil || errors.Is(err, class) { return err }
+	return &classErr{class, err}
+}
+
+func classified(err error) bool {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.class) { return true }
+	}
+	return false
+}
+
+// retryable reports whether another attempt could succeed: transient
+// failures and anything unclassified are, cancellations are not.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) { return false }
+	return errors.Is(err, ErrTransient) || !classified(err)
+}
+
+func classifyFTP(err error) error {
+	if err == nil || classified(err) { return err }
+	var cv *tls.CertificateVerificationError
+	if errors.As(err, &cv) { return withClass(ErrAuth, err) } // the server's certificate, not ours to retry
+	var te *textproto.Error
+	if !errors.As(err, &te) { return classifyOS(err) }
+	msg := strings.ToLower(te.Msg)
+	switch {
+	case te.Code == 530 || te.Code == 331 || te.Code == 332:
+		return withClass(ErrAuth, err)
+	case te.Code == 452 || te.Code == 552:
+		return withClass(ErrQuota, err)
+	case te.Code == 553:
+		return withClass(ErrInvalidName, err)
+	case strings.Contains(msg, "permission denied") || strings.Contains(msg, "access denied"):
+		return withClass(ErrPermission, err)
+	case strings.Contains(msg, "in use") || strings.Contains(msg, "locked") || strings.Contains(msg, "busy"):
+		return withClass(ErrLocked, err)
+	case te.Code == 550 || strings.Contains(msg, "no such file") || strings.Contains(msg, "not found"):
+		return withClass(ErrNotFound, err)
+	case te.Code == 421 || te.Code == 425 || te.Code == 426:
+		return withClass(ErrNetwork, err)
+	case te.Code >= 400 && te.Code < 500:
+		return withClass(ErrTransient, err)
+	}
+	return err
+}
+
+// messageClass picks a class from the strerror text a remote tool
+// printed, such as scp or rsync; nil when there is none to go by.
+func messageClass(err error) error {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "permission denied"), strings.Contains(msg, "read-only file system"):
+		return ErrPermission
+	case strings.Contains(msg, "no space left"), strings.Contains(msg, "quota exceeded"):
+		return ErrQuota
+	case strings.Contains(msg, "file name too long"), strings.Contains(msg, "invalid argument"):
+		return ErrInvalidName
+	case strings.Contains(msg, "text file busy"), strings.Contains(msg, "resource busy"):
+		return ErrLocked
+	case strings.Contains(msg, "no such file"):
+		return ErrNotFound
+	}
+	return nil
+}
+
+func classifyHTTP(err error) error {
+	if err == nil || classified(err) { return err }
+	var cv *tls.CertificateVerificationError
+	if errors.As(err, &cv) { return withClass(ErrAuth, err) }
+	var se *statusError
+	if !errors.As(err, &se) { return classifyOS(err) }
+	switch c := se.code; {
+	case c == 401:
+		return withClass(ErrAuth, err)
+	case c == 403:
+		return withClass(ErrPermission, err)
+	case c == 404 || c == 409 || c == 410: // 409: the parent collection is missing
+		return withClass(ErrNotFound, err)
+	case c == 413 || c == 507:
+		return withClass(ErrQuota, err)
+	case c == 412: // an If-Match that no longer holds
+		return withClass(ErrConflict, err)
+	case c == 414:
+		return withClass(ErrInvalidName, err)
+	case c == 423:
+		return withClass(ErrLocked, err)
+	case c == 502 || c == 503 || c == 504:
+		return withClass(ErrNetwork, err)
+	case c == 408 || c == 429 || c >= 500:
+		return withClass(ErrTransient, err)
+	}
+	return err
+}
+
+func classifyOS(err error) error {
+	if err == nil || classified(err) { return err }
+	// every Errno is a net.Error, so the network case must not see one
+	// errnoClass left alone: a disk's EIO is not a network failure
+	var errno syscall.Errno
+	var ne net.Error
+	isErrno := errors.As(err, &errno)
+	if isErrno {
+		if c := errnoClass(errno); c != nil { return withClass(c, err) }
+	}
+	var op *net.OpError
+	var dns *net.DNSError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return withClass(ErrNotFound, err)
+	case errors.Is(err, fs.ErrPermission):
+		return withClass(ErrPermission, err)
+	case errors.As(err, &op), errors.As(err, &dns), !isErrno && errors.As(err, &ne), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return withClass(ErrNetwork, err)
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want string
+	}{
+		{&os.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist}, "not-found"},
+		{&os.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}, "permission"},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("refused")}, "network"},
+		{&net.DNSError{Err: "no such host", Name: "nas"}, "network"},
+		{fmt.Errorf("reading reply: %w", io.ErrUnexpectedEOF), "network"},
+		{errors.New("something else"), "other"},
+		{context.Canceled, "cancelled"},
+		{withClass(ErrHeld, errors.New("x")), "held"},
+	} {
+		if got := errorKind(classifyOS(c.err)); got != c.want { t.Errorf("%v: %s, want %s", c.err, got, c.want) }
+	}
+	if !retryable(classifyOS(&net.OpError{Op: "read", Err: errors.New("reset")})) { t.Error("a network error is not retried") }
+	if retryable(classifyOS(&os.PathError{Op: "open", Path: "x", Err: fs.ErrPermission})) { t.Error("permission denied is retried") }
+}
+
+// testErrnos checks how classifyOS labels a failed file operation with
+// each errno, and that a run's report counts it under that kind.
+func testErrnos(t *testing.T, cases map[error]string) {
+	t.Helper()
+	r, want := &run{}, map[string]int64{}
+	for errno, kind := range cases {
+		err := classifyOS(&os.PathError{Op: "write", Path: `D:\out\f.txt`, Err: errno})
+		if got := errorKind(err); got != kind { t.Errorf("%v (%d): %s, want %s", errno, errno, got, kind) }
+		r.fail(err)
+		want[kind]++
+	}
+	for kind, n := range want {
+		if r.kinds[kind] != n { t.Errorf("report counts %d %s, want %d", r.kinds[kind], kind, n) }
+	}
+	if len(r.kinds) != len(want) { t.Errorf("report kinds %v, want %v", r.kinds, want) }
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+func errnoClass(e syscall.Errno) error {
+	switch e {
+	case syscall.ENOSPC, syscall.EDQUOT:
+		return ErrQuota
+	case syscall.EBUSY, syscall.ETXTBSY:
+		return ErrLocked
+	case syscall.EROFS:
+		return ErrPermission
+	case syscall.ENAMETOOLONG, syscall.EILSEQ, syscall.EISDIR, syscall.ENOTDIR:
+		return ErrInvalidName
+	case syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.EPIPE, syscall.ETIMEDOUT, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		return ErrNetwork
+	case syscall.EAGAIN:
+		return ErrTransient
+	}
+	return nil
+}
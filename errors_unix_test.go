@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestErrnoKinds(t *testing.T) {
+	testErrnos(t, map[error]string{
+		syscall.ENOSPC:       "disk-full",
+		syscall.EDQUOT:       "disk-full",
+		syscall.EROFS:        "permission",
+		syscall.EACCES:       "permission",
+		syscall.ENOENT:       "not-found",
+		syscall.EBUSY:        "file-locked",
+		syscall.ENAMETOOLONG: "name-invalid",
+		syscall.EISDIR:       "name-invalid",
+		syscall.ENOTDIR:      "name-invalid",
+		syscall.ECONNRESET:   "network",
+		syscall.ETIMEDOUT:    "network",
+		syscall.EAGAIN:       "transient",
+		syscall.EIO:          "other",
+		syscall.EEXIST:       "other",
+	})
+}
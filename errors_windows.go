@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+func errnoClass(e syscall.Errno) error {
+	switch e {
+	case 39, 112: // ERROR_HANDLE_DISK_FULL, ERROR_DISK_FULL
+		return ErrQuota
+	case 1326, 86: // ERROR_LOGON_FAILURE, ERROR_INVALID_PASSWORD
+		return ErrAuth
+	case 19: // ERROR_WRITE_PROTECT
+		return ErrPermission
+	case 32, 33: // ERROR_SHARING_VIOLATION, ERROR_LOCK_VIOLATION
+		return ErrLocked
+	case 123, 161, 206, 267: // ERROR_INVALID_NAME, ERROR_BAD_PATHNAME, ERROR_FILENAME_EXCED_RANGE, ERROR_DIRECTORY
+		return ErrInvalidName
+	case 53, 59, 64, 67, 121, 1231: // bad netpath, net error, netname deleted, bad netname, timeout, network unreachable
+		return ErrNetwork
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestErrnoKinds(t *testing.T) {
+	testErrnos(t, map[error]string{
+		syscall.Errno(112):  "disk-full",   // ERROR_DISK_FULL
+		syscall.Errno(19):   "permission",  // ERROR_WRITE_PROTECT
+		syscall.Errno(5):    "permission",  // ERROR_ACCESS_DENIED
+		syscall.Errno(2):    "not-found",   // ERROR_FILE_NOT_FOUND
+		syscall.Errno(32):   "file-locked", // ERROR_SHARING_VIOLATION
+		syscall.Errno(123):  "name-invalid",
+		syscall.Errno(267):  "name-invalid", // ERROR_DIRECTORY
+		syscall.Errno(53):   "network",      // ERROR_BAD_NETPATH
+		syscall.Errno(1326): "auth",         // ERROR_LOGON_FAILURE
+		syscall.Errno(1117): "other",        // ERROR_IO_DEVICE
+		syscall.Errno(183):  "other",        // ERROR_ALREADY_EXISTS
+	})
+}
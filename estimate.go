@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// ────────── estimate ───────────────────────────────────────
+// `datasync estimate` makes the plan of a dry run and says how long the
+// run would take, to decide whether a seed fits before a maintenance
+// window:
+//
+//	datasync estimate -conf site.json [-within 6h] [-list]
+//
+// Every run with state_file keeps the time transfers were in flight
+// alongside the files and bytes they moved, for the last transferHistory
+// runs. Fitted over those, busy time = per-file cost × files + bytes /
+// throughput, which holds for many small files as for a few large ones;
+// with too little variety to tell the two apart, throughput alone. The
+// estimate is that for the plan plus the time the dry run itself took to
+// walk and compare, which a real run spends too. -within exits 1 when the
+// estimate is longer, or there is none.
+const transferHistory = 20
+
+// transferRecord is one run's transfers, for estimate.
+type transferRecord struct {
+	At    time.Time     `json:"at"`
+	Files int64         `json:"files"`
+	Bytes int64         `json:"bytes"`
+	Busy  time.Duration `json:"busy_ns"` // wall time with at least one transfer in flight
+}
+
+// measured keeps rec among the last transferHistory runs that moved data.
+func (s *syncState) measured(rec transferRecord) {
+	if rec.Bytes <= 0 || rec.Busy <= 0 { return }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Transfers = append(s.Transfers, rec)
+	if n := len(s.Transfers); n > transferHistory { s.Transfers = s.Transfers[n-transferHistory:] }
+}
+
+// busyClock adds up the wall time transfers are in flight, however many.
+type busyClock struct {
+	mu    sync.Mutex
+	n     int
+	since time.Time
+	busy  time.Duration
+}
+
+// start notes a transfer beginning; calling what it returns ends it.
+func (b *busyClock) start() func() {
+	b.mu.Lock()
+	if b.n == 0 { b.since = time.Now() }
+	b.n++
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		if b.n--; b.n == 0 { b.busy += time.Since(b.since) }
+		b.mu.Unlock()
+	}
+}
+
+func (b *busyClock) total() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.busy
+}
+
+// transferModel is busy time as perFile seconds a file plus perByte a byte.
+type transferModel struct {
+	perFile, perByte float64
+	runs             int
+}
+
+// fitTransfers fits the model to the history by least squares; ok is
+// false without a run that moved data.
+func fitTransfers(h []transferRecord) (m transferModel, ok bool) {
+	var ff, fb, bb, tf, tb, t, b float64
+	for _, r := range h {
+		if r.Bytes <= 0 || r.Busy <= 0 { continue }
+		f, n, s := float64(r.Files), float64(r.Bytes), r.Busy.Seconds()
+		ff, fb, bb, tf, tb, t, b = ff+f*f, fb+f*n, bb+n*n, tf+s*f, tb+s*n, t+s, b+n
+		m.runs++
+	}
+	if m.runs == 0 { return m, false }
+	if det := ff*bb - fb*fb; det > 1e-9*ff*bb {
+		m.perFile, m.perByte = (tf*bb-tb*fb)/det, (tb*ff-tf*fb)/det
+		if m.perFile >= 0 && m.perByte > 0 { return m, true }
+	}
+	m.perFile, m.perByte = 0, t/b // throughput alone
+	return m, true
+}
+
+func (m transferModel) predict(files, bytes int64) time.Duration {
+	return time.Duration((m.perFile*float64(files) + m.perByte*float64(bytes)) * float64(time.Second))
+}
+
+// planned is the printer of a dry run that keeps its summary.
+type planned struct {
+	printer
+	sum Summary
+}
+
+func (p *planned) OnSummary(s Summary) { p.sum = s; p.printer.OnSummary(s) }
+
+func estimateMain(args []string) {
+	fl := flag.NewFlagSet("estimate", flag.ExitOnError)
+	cfgPath := fl.String("conf", "dataxfer.conf", "config JSON")
+	full    := fl.Bool("full", false, "rescan every directory, ignoring the warm-start snapshot")
+	within  := fl.Duration("within", 0, "exit 1 unless the run is estimated to finish in this long, e.g. 6h")
+	list    := fl.Bool("list", false, "print every file the run would transfer or delete")
+	verbose := fl.Bool("v", false, "verbose: debug output for every category")
+	fl.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	fl.Parse(args)
+
+	conf, err := loadConf(*cfgPath)
+	if err != nil { log.Fatal(err) }
+	applyPriority(conf)
+	setLanguage(conf.Language)
+	if err = setLogLevels(conf.Log, false, *verbose); err != nil { log.Fatal(err) }
+	if !*list && !*verbose { logLevels[catTransfer] = lvlError }
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	p := &planned{}
+	if code := runSync(ctx, conf, runOpts{full: *full, dryRun: true, progress: p}); code != 0 { os.Exit(code) }
+	files, bytes := p.sum.Uploaded+p.sum.Pulled, p.sum.Bytes
+
+	var hist []transferRecord
+	if conf.StateFile != "" {
+		st, err := loadState(conf.StateFile)
+		if err != nil { log.Fatal(err) }
+		hist = st.Transfers
+	}
+	m, ok := fitTransfers(hist)
+	if !ok {
+		say("≈", "%s", tr("estimate_none", files, sizeText(bytes)))
+		if *within > 0 && files > 0 { os.Exit(1) }
+		return
+	}
+	debugf(catTransfer, "estimate: %.3fs a file, %s/s, fitted over %d run(s)", m.perFile, sizeText(int64(1/m.perByte)), m.runs)
+	d := m.predict(files, bytes) + p.sum.Elapsed
+	say("≈", "%s", tr("estimate", files, sizeText(bytes), roundDuration(d), sizeText(int64(1/m.perByte)), m.runs))
+	if *within > 0 {
+		if d > *within { say("!", "%s", tr("estimate_over", *within)); os.Exit(1) }
+		say("✓", "%s", tr("estimate_fits", *within))
+	}
+}
+
+// sizeText is n bytes for people, e.g. "1.5 GiB".
+func sizeText(n int64) string {
+	const unit = 1024
+	if n < unit { return fmt.Sprintf("%d B", n) }
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit { div *= unit; exp++ }
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// roundDuration drops the precision an estimate does not have.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Hour:
+		return d.Round(time.Minute)
+	case d >= time.Minute:
+		return d.Round(10 * time.Second)
+	}
+	return d.Round(time.Second)
+}
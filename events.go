@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ────────── sync events ────────────────────────────────────
+// A Bus turns a run's progress into events that other software on site
+// can react to. Subscribers run synchronously on the worker that caused
+// the event; external sinks (events.*, notify.*) each get a queue and their own
+// goroutine, so a slow or absent broker never holds up a sync.
+const (
+	EventRunStarted     = "run_started"
+	EventFileUploaded   = "file_uploaded"
+	EventFileDownloaded = "file_downloaded" // direction pull
+	EventFileFailed     = "file_failed"
+	EventFileDeleted    = "file_deleted"  // direction both and mirror: deleted on the target, as it was locally
+	EventLocalDeleted   = "local_deleted" // direction both: deleted in local_dir, as it was on the target
+	EventConflict       = "conflict_detected"
+	EventResolved       = "conflict_resolved" // on_conflict settled it; change names the copy that won
+	EventFileWithheld   = "file_withheld" // matched the never-transfer list
+	EventFileHeld       = "file_held"     // changed locally, but under hold on the target
+	EventFileDeferred   = "file_deferred" // power: large, held back on battery or a metered network
+	EventFileRenamed    = "file_renamed"  // detect_renames: moved on the target instead of uploaded again
+	EventRunCompleted   = "run_completed"
+	EventRunDeferred    = "run_deferred" // started in a blackout window, so not run
+	EventSLABreached    = "sla_breached" // sent before run_completed while the SLA is breached
+	EventConfigDrift    = "config_drift" // the config differs from central_config
+)
+
+type Event struct {
+	Type    string     `json:"type"`
+	Time    time.Time  `json:"time"`
+	Site    string     `json:"site,omitempty"`
+	Path    string     `json:"path,omitempty"`
+	From    string     `json:"from,omitempty"` // file_renamed: the old path
+	Size    int64      `json:"size,omitempty"`
+	Change  string     `json:"change,omitempty"` // file_uploaded: "new" or "modified" on the target (file_downloaded: in local_dir); conflict_resolved: "local", "remote" or "both"; config_drift: the published config's hash
+	Tags    []string   `json:"tags,omitempty"`   // file_uploaded: classification tags
+	Error   string     `json:"error,omitempty"`
+	Kind    string     `json:"kind,omitempty"` // error kind: auth, network, file-locked, ...
+	Summary *Summary   `json:"summary,omitempty"` // run_completed only
+	Until   *time.Time `json:"until,omitempty"`   // run_deferred: when the blackout ends
+	Config  string     `json:"config,omitempty"`  // run_started, config_drift: SHA-256 of the config, see drift.go
+}
+
+type EventsConf struct {
+	NATS    string   `json:"nats"`    // nats://[user:pass@]host:4222 to publish events to
+	Subject string   `json:"subject"` // default datasync.<site, or host name>
+	MQTT    string   `json:"mqtt"`    // mqtt://[user:pass@]host:1883 to publish status and events to
+	Topic   string   `json:"topic"`   // default datasync/<site, or host name>
+	SNMP    SNMPConf `json:"snmp"`    // traps on failure and recovery
+}
+
+type Bus struct {
+	mu    sync.Mutex
+	subs  []func(Event)
+	site  string
+	sinks []*sinkQueue
+}
+
+func (b *Bus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	b.subs = append(b.subs, fn)
+	b.mu.Unlock()
+}
+
+func (b *Bus) publish(e Event) {
+	e.Time, e.Site = time.Now().UTC(), b.site
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+	for _, fn := range subs { fn(e) }
+}
+
+func (b *Bus) OnFileStart(string, int64) {}
+func (b *Bus) OnBytes(string, int64)     {}
+
+func (b *Bus) OnFileDone(rel string, size int64) {
+	b.publish(Event{Type: EventFileUploaded, Path: rel, Size: size})
+}
+
+func (b *Bus) onUpload(u upload) {
+	change := changeNew
+	if u.replaced { change = changeModified }
+	typ := EventFileUploaded
+	if u.pulled { typ = EventFileDownloaded }
+	b.publish(Event{Type: typ, Path: u.rel, Size: u.size, Change: change, Tags: u.tags})
+}
+
+func (b *Bus) onRename(from, to string) { b.publish(Event{Type: EventFileRenamed, Path: to, From: from}) }
+func (b *Bus) onResolve(rel, won string) { b.publish(Event{Type: EventResolved, Path: rel, Change: won}) }
+func (b *Bus) onDrift(sum, published, msg string) { b.publish(Event{Type: EventConfigDrift, Config: sum, Change: published, Error: msg}) }
+
+func (b *Bus) onRemove(rel string, local bool) {
+	typ := EventFileDeleted
+	if local { typ = EventLocalDeleted }
+	b.publish(Event{Type: typ, Path: rel})
+}
+
+func (b *Bus) OnError(rel string, err error) {
+	typ := EventFileFailed
+	if errors.Is(err, ErrConflict) { typ = EventConflict }
+	if errors.Is(err, ErrWithheld) { typ = EventFileWithheld }
+	if errors.Is(err, ErrHeld) { typ = EventFileHeld }
+	if errors.Is(err, ErrDeferred) { typ = EventFileDeferred }
+	b.publish(Event{Type: typ, Path: rel, Error: err.Error(), Kind: errorKind(err)})
+}
+
+func (b *Bus) OnSummary(s Summary) {
+	if s.Stale > 0 { b.publish(Event{Type: EventSLABreached, Error: s.staleMessage(), Summary: &s}) }
+	e := Event{Type: EventRunCompleted, Summary: &s}
+	if s.Err != nil { e.Error, e.Kind = s.Err.Error(), s.ErrKind }
+	b.publish(e)
+}
+
+// eventSink delivers events somewhere outside the process. send is only
+// called from the sink's own goroutine.
+type eventSink interface {
+	send(e Event) error
+	close()
+}
+
+type sinkQueue struct {
+	name string
+	sink eventSink
+	q    chan Event
+	done chan struct{}
+}
+
+func (b *Bus) addSink(name string, s eventSink) {
+	sq := &sinkQueue{name: name, sink: s, q: make(chan Event, 1024), done: make(chan struct{})}
+	go sq.run()
+	b.sinks = append(b.sinks, sq)
+	var warned atomic.Bool
+	b.Subscribe(func(e Event) {
+		select {
+		case sq.q <- e:
+		default:
+			if !warned.Swap(true) { log.Printf("events: %s is not keeping up, dropping events", name) }
+		}
+	})
+}
+
+func (sq *sinkQueue) run() {
+	defer close(sq.done)
+	defer sq.sink.close()
+	var last string
+	for e := range sq.q {
+		// log each distinct failure once, not once per event
+		if err := sq.sink.send(e); err != nil && err.Error() != last {
+			last = err.Error()
+			log.Printf("events: %s: %v", sq.name, err)
+		}
+	}
+}
+
+// close flushes the sinks after the run's last event, giving a slow
+// broker or web hook a few seconds before giving up on it.
+func (b *Bus) close() {
+	for _, sq := range b.sinks { close(sq.q) }
+	timeout := time.After(15 * time.Second)
+	for _, sq := range b.sinks {
+		select {
+		case <-sq.done:
+		case <-timeout:
+			log.Printf("events: %s did not flush in time", sq.name)
+			return
+		}
+	}
+}
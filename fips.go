@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/fips140"
+	"fmt"
+)
+
+// ────────── FIPS 140-3 mode ────────────────────────────────
+// Built with GOFIPS140=v1.0.0, or started with GODEBUG=fips140=on,
+// datasync does its cryptography in Go's validated FIPS 140-3 module and
+// keeps to approved algorithms wherever it has a choice:
+//   - TLS (FTPS, WebDAV, S3, HTTP, the cloud targets and serve) offers
+//     only TLS 1.2 and 1.3 with approved suites, curves and signatures;
+//     Go's TLS does that by itself in the mode
+//   - SCP offers only approved key exchanges, ciphers, MACs and host key
+//     algorithms, which x/crypto/ssh also does by itself
+//   - hash_algorithm "blake3" and "xxhash" are refused; sha256 is approved
+//   - delta signatures take SHA-256 blocks instead of BLAKE3; signatures
+//     saved under the other are made again from the target's copy
+//   - S3 writes under Object Lock carry x-amz-checksum-sha256 instead of
+//     Content-MD5
+//   - WebDAV Digest auth with MD5 is not answered, only SHA-256 Digest or
+//     Basic (over https)
+// fips: true makes a run refuse to start outside the mode, so a binary
+// built or started without it is noticed before anything is sent. There
+// is no encryption at rest here: the state file, the logs and the copies
+// on the target are plain, and are kept on volumes encrypted with a
+// validated module (BitLocker) where that is required.
+var fipsMode = fips140.Enabled()
+
+// checkFIPS refuses fips: true outside FIPS mode.
+func checkFIPS(c *Conf) error {
+	if c.FIPS && !fipsMode { return fmt.Errorf("fips: this binary is not running in FIPS 140-3 mode; build it with GOFIPS140=v1.0.0 or start it with GODEBUG=fips140=on") }
+	if fipsMode { debugf(catProtocol, "FIPS 140-3 mode: approved algorithms only") }
+	return nil
+}
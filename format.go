@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ────────── file formats ───────────────────────────────────
+// The files datasync keeps between runs, or hands to another copy of
+// itself, have a format number: the state file, inventories and courier
+// manifests, and delta signatures. The number only goes up for a change
+// an older binary would misread. A new field does not change it: an
+// older binary skips fields it does not know, and a newer one takes a
+// missing field as empty. So across a fleet:
+//   - a newer binary reads every older format, migrates it as it loads,
+//     and saves the state file in its own; an upgrade never loses what
+//     the state file recorded, nor sends it all again
+//   - an older binary reads a state file a newer one of the same format
+//     saved, and keeps the top-level fields it does not know when it
+//     saves it again, so sites can be upgraded one at a time and rolled
+//     back; per-file fields it does not know are dropped
+//   - a file of a format newer than the binary knows is refused, naming
+//     both formats, and is never overwritten
+// Format 1 is what every version before the numbers wrote, and files of
+// format 1 carry no number, so older binaries read what this one writes
+// as before. Inventories are signed over the fields a binary knows, so
+// any new field there is a new format. Delta signatures are a cache: one
+// of another format is made again from the target's copy.
+const (
+	stateFormat = 1 // the state file, see state.go
+	invFormat   = 1 // inventories and courier manifests, see inventory.go
+	sigFormat   = 1 // delta signatures, see delta.go
+)
+
+// stateSteps[i] takes a state file's top-level fields from format i+1 to
+// i+2; a new state format comes with its step.
+var stateSteps []func(map[string]json.RawMessage) error
+
+// formatOf is the format of a file that says n.
+func formatOf(n int) int {
+	if n == 0 { return 1 }
+	return n
+}
+
+// stamp is the number a file of format n carries.
+func stamp(n int) int {
+	if n == 1 { return 0 }
+	return n
+}
+
+// checkFormat refuses a file of format n when this binary reads up to known.
+func checkFormat(n, known int) error {
+	if n = formatOf(n); n > known {
+		return fmt.Errorf("format %d is from a newer datasync; this one reads up to format %d, so the file is left as it is", n, known)
+	}
+	return nil
+}
+
+// decode reads a state file, migrating an older format.
+func (s *syncState) decode(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil { return err }
+	var n int
+	if f, ok := raw["format"]; ok {
+		if err := json.Unmarshal(f, &n); err != nil { return fmt.Errorf("format: %w", err) }
+	}
+	if err := checkFormat(n, stateFormat); err != nil { return err }
+	if err := migrate(raw, formatOf(n), stateSteps); err != nil { return err }
+	if formatOf(n) < stateFormat {
+		debugf(catTransfer, "state %s: migrated from format %d to %d", s.path, formatOf(n), stateFormat)
+		b, _ = json.Marshal(raw)
+	}
+	if err := json.Unmarshal(b, s); err != nil { return err }
+	known := jsonFields(s)
+	for k, v := range raw {
+		if known[k] { continue }
+		if s.extra == nil { s.extra = map[string]json.RawMessage{} }
+		s.extra[k] = v
+	}
+	s.Format = stamp(stateFormat)
+	return nil
+}
+
+// migrate takes raw, a file's top-level fields, from format v to the
+// last steps lead to, one step at a time.
+func migrate(raw map[string]json.RawMessage, v int, steps []func(map[string]json.RawMessage) error) error {
+	for ; v <= len(steps); v++ {
+		if err := steps[v-1](raw); err != nil { return fmt.Errorf("migrating from format %d: %w", v, err) }
+	}
+	return nil
+}
+
+// document is s as saved, with the fields a newer binary left in it.
+func (s *syncState) document() any {
+	if len(s.extra) == 0 { return s }
+	b, err := json.Marshal(s)
+	if err != nil { return s }
+	m := map[string]json.RawMessage{}
+	if json.Unmarshal(b, &m) != nil { return s }
+	for k, v := range s.extra {
+		if _, ok := m[k]; !ok { m[k] = v }
+	}
+	return m
+}
+
+// jsonFields is the JSON names of the fields of the struct v points to.
+func jsonFields(v any) map[string]bool {
+	t := reflect.TypeOf(v).Elem()
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() { continue }
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" { continue }
+		if name == "" { name = f.Name }
+		names[name] = true
+	}
+	return names
+}
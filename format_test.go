@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatNumbers(t *testing.T) {
+	if formatOf(0) != 1 || formatOf(3) != 3 { t.Error("formatOf: a file without a number is format 1") }
+	if stamp(1) != 0 || stamp(2) != 2 { t.Error("stamp: format 1 carries no number") }
+	if checkFormat(0, 1) != nil || checkFormat(1, 1) != nil { t.Error("checkFormat refused format 1") }
+	if err := checkFormat(2, 1); err == nil || !strings.Contains(err.Error(), "format 2") || !strings.Contains(err.Error(), "up to format 1") {
+		t.Errorf("checkFormat(2, 1) = %v, want both formats named", err)
+	}
+}
+
+func TestStateOldFormat(t *testing.T) {
+	// as every version before the format numbers wrote it
+	p := filepath.Join(t.TempDir(), "state.json")
+	old := `{"files": {"a.txt": {"remote_mtime": "2026-01-02T03:04:05Z", "size": 3}}, "failing": true}`
+	if err := os.WriteFile(p, []byte(old), 0644); err != nil { t.Fatal(err) }
+	st, err := loadState(p)
+	if err != nil { t.Fatal(err) }
+	if f, ok := st.Files["a.txt"]; !ok || f.Size != 3 || !st.Failing { t.Fatalf("loaded %+v", st) }
+	if err = st.save(); err != nil { t.Fatal(err) }
+	b, _ := os.ReadFile(p)
+	if strings.Contains(string(b), `"format"`) { t.Errorf("format 1 saved with a number:\n%s", b) }
+}
+
+func TestStateKeepsUnknownFields(t *testing.T) {
+	// saved by a newer binary of the same format
+	p := filepath.Join(t.TempDir(), "state.json")
+	newer := `{"files": {}, "quota": {"used": 12}, "site_note": "lab 3"}`
+	if err := os.WriteFile(p, []byte(newer), 0644); err != nil { t.Fatal(err) }
+	st, err := loadState(p)
+	if err != nil { t.Fatal(err) }
+	st.Dated = "2026-10-14"
+	if err = st.save(); err != nil { t.Fatal(err) }
+	var raw map[string]json.RawMessage
+	b, _ := os.ReadFile(p)
+	if err = json.Unmarshal(b, &raw); err != nil { t.Fatal(err) }
+	if string(raw["site_note"]) != `"lab 3"` || !strings.Contains(string(raw["quota"]), `"used": 12`) { t.Errorf("unknown fields lost on save:\n%s", b) }
+	if string(raw["dated"]) != `"2026-10-14"` { t.Errorf("own field not saved:\n%s", b) }
+}
+
+func TestStateNewerFormatRefused(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "state.json")
+	newer := `{"format": 2, "files": {}}`
+	if err := os.WriteFile(p, []byte(newer), 0644); err != nil { t.Fatal(err) }
+	if _, err := loadState(p); err == nil || !strings.Contains(err.Error(), "format 2") { t.Errorf("loadState of format 2 = %v", err) }
+	if b, _ := os.ReadFile(p); string(b) != newer { t.Errorf("refused state file changed:\n%s", b) }
+}
+
+func TestMigrate(t *testing.T) {
+	// format 1 named the field "mtimes", 2 "files"; 3 added "tree"
+	var order []int
+	steps := []func(map[string]json.RawMessage) error{
+		func(m map[string]json.RawMessage) error { order = append(order, 1); m["files"] = m["mtimes"]; delete(m, "mtimes"); return nil },
+		func(m map[string]json.RawMessage) error { order = append(order, 2); m["tree"] = json.RawMessage(`{}`); return nil },
+	}
+	raw := map[string]json.RawMessage{"mtimes": json.RawMessage(`{"a": {}}`)}
+	if err := migrate(raw, 1, steps); err != nil { t.Fatal(err) }
+	if len(order) != 2 || order[0] != 1 || string(raw["files"]) != `{"a": {}}` || raw["tree"] == nil || raw["mtimes"] != nil { t.Errorf("migrated %v in order %v", raw, order) }
+
+	order = nil
+	if err := migrate(map[string]json.RawMessage{}, 2, steps); err != nil || len(order) != 1 || order[0] != 2 { t.Errorf("from format 2 ran steps %v, %v", order, err) }
+	order = nil
+	if err := migrate(map[string]json.RawMessage{}, 3, steps); err != nil || len(order) != 0 { t.Errorf("at the last format ran steps %v, %v", order, err) }
+
+	bad := append(steps[:1:1], func(map[string]json.RawMessage) error { return errors.New("no") })
+	if err := migrate(map[string]json.RawMessage{"mtimes": nil}, 1, bad); err == nil || !strings.Contains(err.Error(), "from format 2") { t.Errorf("failed step = %v", err) }
+}
+
+func TestInventoryOldFormat(t *testing.T) {
+	// signed by a version before the format numbers, over its own fields
+	type oldInventory struct {
+		Root      string     `json:"root"`
+		Host      string     `json:"host"`
+		Created   time.Time  `json:"created"`
+		Files     []invEntry `json:"files"`
+		Deleted   []string   `json:"deleted,omitempty"`
+		Signature string     `json:"signature,omitempty"`
+	}
+	key := []byte("site key")
+	inv := testInventory()
+	old := oldInventory{Root: inv.Root, Host: inv.Host, Created: inv.Created, Files: inv.Files}
+	b, _ := json.Marshal(old)
+	var signed inventory
+	json.Unmarshal(b, &signed)
+	old.Signature = signed.mac(key) // what the old binary worked out, the same bytes
+
+	p := filepath.Join(t.TempDir(), "inv.json")
+	b, _ = json.Marshal(old)
+	if err := os.WriteFile(p, b, 0644); err != nil { t.Fatal(err) }
+	got, err := loadInventory(p)
+	if err != nil { t.Fatal(err) }
+	if err = got.verify(key); err != nil { t.Errorf("old inventory: %v", err) }
+
+	// and what this one writes, the old one reads and verifies the same
+	inv.Format = stamp(invFormat)
+	inv.sign(key)
+	b, _ = json.Marshal(inv)
+	if strings.Contains(string(b), `"format"`) { t.Errorf("format 1 inventory carries a number: %s", b) }
+	var back oldInventory
+	json.Unmarshal(b, &back)
+	if back.Signature != inv.Signature { t.Error("signature lost") }
+}
+
+func TestInventoryNewerFormatRefused(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "inv.json")
+	if err := os.WriteFile(p, []byte(`{"format": 2, "root": "x", "files": []}`), 0644); err != nil { t.Fatal(err) }
+	if _, err := loadInventory(p); err == nil || !strings.Contains(err.Error(), "format 2") { t.Errorf("loadInventory of format 2 = %v", err) }
+}
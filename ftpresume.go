@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"time"
+)
+
+// ────────── resuming broken-off uploads ────────────────────
+// An upload that breaks off is noted in the state file with the local
+// file's size and mtime. Until it goes through, the copy it left is ours:
+// the next attempt (a retry, or the next run) sends the file whatever
+// compare makes of that copy, which is shorter and usually newer. On FTP,
+// when the local file is unchanged and the copy is shorter, it goes on
+// from where the copy ends, with REST and STOR, or APPE on servers that
+// refuse REST for uploads; the last 64 KiB before that point are read
+// back first, where the server allows REST for downloads, and a copy that
+// does not end with them is sent again whole, as is one that comes out the
+// wrong size after resuming. Files that transfer.chunks splits are always
+// sent whole. Other targets replace the copy in one go.
+type brokenUpload struct {
+	Size       int64     `json:"size"`        // the local file's, when it broke off
+	LocalMTime time.Time `json:"local_mtime"`
+	At         time.Time `json:"at"`
+	Whole      bool      `json:"whole,omitempty"` // resuming failed once: send it whole
+}
+
+const resumeCheck = 64 << 10 // bytes read back before resuming
+
+// errResumeBroken is a resumed upload that left a copy of the wrong size.
+var errResumeBroken = errors.New("resumed upload left a copy of the wrong size")
+
+// broke notes that an upload of rel broke off, leaving a copy of l that
+// may be cut short.
+func (s *syncState) broke(rel string, l FileInfo, whole bool) {
+	if s == nil { return }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Broken == nil { s.Broken = map[string]brokenUpload{} }
+	s.Broken[rel] = brokenUpload{Size: l.Size, LocalMTime: l.MTime, At: time.Now().UTC(), Whole: whole}
+}
+
+// brokenOff is the note on rel, if an upload of it broke off.
+func (s *syncState) brokenOff(rel string) (brokenUpload, bool) {
+	if s == nil { return brokenUpload{}, false }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.Broken[rel]
+	return b, ok
+}
+
+// mended drops the note once rel went up.
+func (s *syncState) mended(rel string) {
+	if s == nil { return }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Broken, rel)
+}
+
+// resumeOffset is where an upload of l over remote can go on from, 0 to
+// send it whole.
+func (b brokenUpload) resumeOffset(l *FileInfo, remote FileInfo) int64 {
+	if b.Whole || b.Size != l.Size || !b.LocalMTime.Equal(l.MTime) { return 0 }
+	if !remote.Exists || remote.Size <= 0 || remote.Size >= l.Size { return 0 }
+	return remote.Size
+}
+
+type resumeKey struct{}
+
+// withResumeAt makes the upload under ctx go on from off, after what a
+// broken-off upload left on the target.
+func withResumeAt(ctx context.Context, off int64) context.Context {
+	return context.WithValue(ctx, resumeKey{}, off)
+}
+
+func resumeAt(ctx context.Context) int64 {
+	off, _ := ctx.Value(resumeKey{}).(int64)
+	return off
+}
+
+// storRest sends src from off on over the copy a broken-off upload left.
+func (t *ftpTarget) storRest(ctx context.Context, src *os.File, remote string, size, off int64) error {
+	if err := t.sameTail(src, remote, off); err != nil {
+		debugf(catTransfer, "%s: not resuming at %d, sending it whole: %v", remote, off, err)
+		return t.c.Stor(remote, ctxReader{ctx, src})
+	}
+	debugf(catTransfer, "%s: resuming at %d of %d bytes", remote, off, size)
+	rest := io.NewSectionReader(src, off, size-off)
+	err := t.c.StorFrom(remote, ctxReader{ctx, rest}, uint64(off))
+	if restRefused(err) {
+		debugf(catTransfer, "%s: REST refused for STOR, appending with APPE", remote)
+		err = t.c.Append(remote, ctxReader{ctx, rest})
+	}
+	if err != nil { return err }
+	if n, err := t.c.FileSize(remote); err == nil && n != size {
+		return fmt.Errorf("%s: %w (%d of %d bytes)", remote, errResumeBroken, n, size)
+	}
+	return nil
+}
+
+// sameTail checks that the copy on the target ends, at off, with what src
+// holds there. A server that refuses REST for downloads is taken at its
+// word for the size.
+func (t *ftpTarget) sameTail(src *os.File, remote string, off int64) error {
+	from := max(0, off-resumeCheck)
+	want := make([]byte, off-from)
+	if _, err := src.ReadAt(want, from); err != nil { return err }
+	resp, err := t.c.RetrFrom(remote, uint64(from))
+	if restRefused(err) { return nil }
+	if err != nil { return err }
+	got, err := io.ReadAll(io.LimitReader(resp, int64(len(want))+1))
+	if cerr := resp.Close(); err == nil { err = cerr }
+	if err != nil { return err }
+	if !bytes.Equal(got, want) { return errors.New("the copy there does not end with what the file has at that point") }
+	return nil
+}
+
+// restRefused reports a server answering REST with a permanent error.
+func restRefused(err error) bool {
+	var te *textproto.Error
+	return errors.As(err, &te) && (te.Code == 500 || te.Code == 501 || te.Code == 502 || te.Code == 504)
+}
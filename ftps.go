@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// ────────── FTPS ───────────────────────────────────────────
+// Explicit FTPS (ftp.tls, or tls_mode "explicit") sends AUTH TLS right
+// after the greeting. Implicit FTPS (tls_mode "implicit", port 990 by
+// default) starts TLS as soon as it connects, for legacy appliances. In
+// both modes the client logs in over the encrypted channel and asks for
+// protected data channels (PBSZ 0, PROT P). The client library only
+// encrypts connections it dials itself, and ours go through dialFunc for
+// cancellation, bind and DSCP, so tlsDial wraps them here. The session
+// cache lets data connections resume the control connection's TLS
+// session, which servers like vsftpd require by default.
+const (
+	tlsExplicit = "explicit"
+	tlsImplicit = "implicit"
+)
+
+// tlsMode returns "", "explicit" or "implicit". tls_mode alone turns TLS
+// on; tls: true without it means explicit.
+func (cfg FTPConf) tlsMode() (string, error) {
+	switch m := strings.ToLower(cfg.TLSMode); m {
+	case "":
+		if cfg.TLS { return tlsExplicit, nil }
+		return "", nil
+	case tlsExplicit, tlsImplicit:
+		return m, nil
+	}
+	return "", fmt.Errorf("ftp.tls_mode: unknown mode %q (use explicit or implicit)", cfg.TLSMode)
+}
+
+// addr is Host with the default port added when it has none: 990 for
+// implicit FTPS, 21 otherwise.
+func (cfg FTPConf) addr(mode string) string {
+	if _, _, err := net.SplitHostPort(cfg.Host); err == nil { return cfg.Host }
+	if mode == tlsImplicit { return net.JoinHostPort(cfg.Host, "990") }
+	return net.JoinHostPort(cfg.Host, "21")
+}
+
+func (cfg FTPConf) tlsConfig() (*tls.Config, error) {
+	name, _, err := net.SplitHostPort(cfg.Host)
+	if err != nil { name = cfg.Host }
+	c := &tls.Config{ServerName: name, MinVersion: tls.VersionTLS12, ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+	if c.RootCAs, err = rootCAs("ftp.tls_ca", cfg.TLSCA); err != nil { return nil, err }
+	return c, nil
+}
+
+// rootCAs loads a tls_ca setting; nil (the system roots) when it is empty.
+func rootCAs(setting, file string) (*x509.CertPool, error) {
+	if file == "" { return nil, nil }
+	pem, err := os.ReadFile(file)
+	if err != nil { return nil, fmt.Errorf("%s: %w", setting, err) }
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) { return nil, fmt.Errorf("%s: no PEM certificates in %s", setting, file) }
+	return pool, nil
+}
+
+// tlsDial returns every connection as a TLS client, except in explicit
+// mode the first one (control), which the library upgrades itself after
+// AUTH TLS.
+func tlsDial(dial func(network, addr string) (net.Conn, error), c *tls.Config, mode string) func(network, addr string) (net.Conn, error) {
+	var ctrl atomic.Bool
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := dial(network, addr)
+		if err != nil || mode == tlsExplicit && !ctrl.Swap(true) { return conn, err }
+		return tls.Client(conn, c), nil
+	}
+}
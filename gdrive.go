@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── Google Drive target ────────────────────────────
+// gdriveTarget writes into a Google Drive folder through the Drive v3
+// API. Drive knows items by ID, not by path, so the folder tree under
+// gdrive.folder is looked up one name at a time and made where missing;
+// the IDs are kept for the run, shared by all workers, so each folder is
+// looked up once and made only once. Drive lets several files in a folder
+// share a name; the most recently modified one counts. Files are sent as
+// resumable uploads with their mtime as modifiedTime, which the comparison
+// reads back. gdrive.credentials is a service account key, or the OAuth
+// client file of an app a user signed in to, whose refresh token is kept
+// in gdrive.token_file. Shared drives work with both.
+type GDriveConf struct {
+	Credentials string `json:"credentials"` // service account key or OAuth client JSON file
+	TokenFile   string `json:"token_file"`  // with an OAuth client: {"refresh_token": "…"} of the signed-in user
+	Folder      string `json:"folder"`      // ID of the folder to write into (default: the top of My Drive)
+	RemotePath  string `json:"remote_path"`
+	APIURL      string `json:"api_url"`     // default https://www.googleapis.com
+}
+
+const (
+	driveScope  = "https://www.googleapis.com/auth/drive"
+	driveFolder = "application/vnd.google-apps.folder"
+)
+
+// driveCreds is what gdrive.credentials holds: a service account key, or
+// a client from the Google Cloud console under "installed" or "web".
+type driveCreds struct {
+	Type        string       `json:"type"`
+	ClientEmail string       `json:"client_email"`
+	PrivateKey  string       `json:"private_key"`
+	TokenURI    string       `json:"token_uri"`
+	Installed   *driveClient `json:"installed"`
+	Web         *driveClient `json:"web"`
+}
+
+type driveClient struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// driveDirs maps folder paths under gdrive.folder to their IDs.
+type driveDirs struct {
+	mu  sync.Mutex
+	ids map[string]string // "" is gdrive.folder itself
+}
+
+var gdriveDirs = struct {
+	sync.Mutex
+	m map[string]*driveDirs
+}{m: map[string]*driveDirs{}}
+
+type gdriveTarget struct {
+	cfg    GDriveConf
+	api    string
+	root   rpath.Root
+	client *http.Client
+	tokens *tokenSource
+	dirs   *driveDirs
+}
+
+// driveFile is the part of a Drive file resource the target reads.
+type driveFile struct {
+	ID       string    `json:"id"`
+	MimeType string    `json:"mimeType"`
+	Size     int64     `json:"size,string"`
+	Modified time.Time `json:"modifiedTime"`
+}
+
+func connectGDrive(ctx context.Context, cfg GDriveConf, tc TransferConf, form string) (*gdriveTarget, error) {
+	if cfg.Credentials == "" { return nil, fmt.Errorf("gdrive.credentials is required") }
+	if cfg.Folder == "" { cfg.Folder = "root" }
+	if cfg.APIURL == "" { cfg.APIURL = "https://www.googleapis.com" }
+	b, err := os.ReadFile(cfg.Credentials)
+	if err != nil { return nil, fmt.Errorf("gdrive.credentials: %w", err) }
+	var creds driveCreds
+	if err = json.Unmarshal(b, &creds); err != nil { return nil, fmt.Errorf("gdrive.credentials %s: %w", cfg.Credentials, err) }
+	client, err := tc.httpClient("gdrive", "")
+	if err != nil { return nil, err }
+	t := &gdriveTarget{cfg: cfg, api: strings.TrimRight(cfg.APIURL, "/"), root: rpath.NewRoot(cfg.RemotePath, form), client: client}
+	var fetch func(context.Context, oauthToken) (oauthToken, error)
+	switch app := creds.Installed; {
+	case creds.Type == "service_account":
+		key, err := creds.rsaKey()
+		if err != nil { return nil, fmt.Errorf("gdrive.credentials %s: %w", cfg.Credentials, err) }
+		fetch = func(ctx context.Context, _ oauthToken) (oauthToken, error) {
+			jwt, err := creds.assertion(key, time.Now())
+			if err != nil { return oauthToken{}, err }
+			return grant(ctx, client, creds.TokenURI, url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {jwt}})
+		}
+	case app != nil || creds.Web != nil:
+		if app == nil { app = creds.Web }
+		if cfg.TokenFile == "" { return nil, fmt.Errorf("gdrive.token_file is required with an OAuth client in gdrive.credentials") }
+		fetch = func(ctx context.Context, old oauthToken) (oauthToken, error) {
+			if old.Refresh == "" { return oauthToken{}, withClass(ErrAuth, fmt.Errorf("gdrive.token_file %s holds no refresh_token", cfg.TokenFile)) }
+			return grant(ctx, client, app.TokenURI, url.Values{
+				"grant_type": {"refresh_token"}, "client_id": {app.ClientID}, "client_secret": {app.ClientSecret}, "refresh_token": {old.Refresh},
+			})
+		}
+	default:
+		return nil, fmt.Errorf("gdrive.credentials %s is neither a service account key nor an OAuth client", cfg.Credentials)
+	}
+	key := strings.Join([]string{"gdrive", cfg.Credentials, cfg.TokenFile}, "\x00")
+	if t.tokens, err = sharedTokens(key, func() (*tokenSource, error) { return &tokenSource{file: cfg.TokenFile, fetch: fetch}, nil }); err != nil { return nil, err }
+	gdriveDirs.Lock()
+	dk := key + "\x00" + cfg.Folder
+	if t.dirs = gdriveDirs.m[dk]; t.dirs == nil {
+		t.dirs = &driveDirs{ids: map[string]string{}}
+		gdriveDirs.m[dk] = t.dirs
+	}
+	gdriveDirs.Unlock()
+	// the folder shows a refused sign-in, or one not shared with the account
+	var top driveFile
+	if err = t.call(ctx, "GET", t.api+"/drive/v3/files/"+url.PathEscape(cfg.Folder)+"?fields=id,mimeType&supportsAllDrives=true", nil, &top); err != nil {
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusForbidden && !classified(err) { return nil, withClass(ErrAuth, err) }
+		return nil, classifyHTTP(ctxErr(ctx, err))
+	}
+	if top.MimeType != driveFolder { return nil, fmt.Errorf("gdrive.folder: %s is not a folder", cfg.Folder) }
+	t.dirs.mu.Lock()
+	t.dirs.ids[""] = top.ID
+	t.dirs.mu.Unlock()
+	return t, nil
+}
+
+// ────────── sign-in ────────────────────────────────────────
+func (c *driveCreds) rsaKey() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(c.PrivateKey))
+	if block == nil { return nil, fmt.Errorf("private_key is not PEM") }
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil { return nil, fmt.Errorf("private_key: %w", err) }
+	rk, ok := k.(*rsa.PrivateKey)
+	if !ok { return nil, fmt.Errorf("private_key is not an RSA key") }
+	return rk, nil
+}
+
+// assertion is the signed JWT a service account trades for a token.
+func (c *driveCreds) assertion(key *rsa.PrivateKey, now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	claims, err := json.Marshal(map[string]any{"iss": c.ClientEmail, "scope": driveScope, "aud": c.TokenURI, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+	if err != nil { return "", err }
+	msg := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(msg))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, sum[:])
+	if err != nil { return "", err }
+	return msg + "." + enc.EncodeToString(sig), nil
+}
+
+// ────────── Drive requests ─────────────────────────────────
+func (t *gdriveTarget) call(ctx context.Context, method, u string, in, v any) error {
+	_, err := apiCall(ctx, t.client, t.tokens, method, u, in, v, driveFailure)
+	return err
+}
+
+// driveFailure reads Drive's reason for a refusal. Drive answers 403 both
+// for a missing right and for going too fast or over quota.
+func driveFailure(method string, u *url.URL, resp *http.Response) error {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	se := &statusError{method: method, url: u.Redacted(), code: resp.StatusCode, status: resp.Status, detail: e.Error.Message}
+	if len(e.Error.Errors) == 0 { return se }
+	switch e.Error.Errors[0].Reason {
+	case "rateLimitExceeded", "userRateLimitExceeded":
+		return withClass(ErrTransient, se)
+	case "storageQuotaExceeded", "teamDriveFileLimitExceeded", "numChildrenInNonRootLimitExceeded":
+		return withClass(ErrQuota, se)
+	}
+	return se
+}
+
+// lookup finds the newest item called name in folder parent.
+func (t *gdriveTarget) lookup(ctx context.Context, parent, name string) (driveFile, bool, error) {
+	q := fmt.Sprintf("'%s' in parents and name = '%s' and trashed = false", parent, strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name))
+	v := url.Values{"q": {q}, "fields": {"files(id,mimeType,size,modifiedTime)"}, "orderBy": {"modifiedTime desc"}, "pageSize": {"10"},
+		"supportsAllDrives": {"true"}, "includeItemsFromAllDrives": {"true"}}
+	var r struct{ Files []driveFile `json:"files"` }
+	if err := t.call(ctx, "GET", t.api+"/drive/v3/files?"+v.Encode(), nil, &r); err != nil { return driveFile{}, false, err }
+	if len(r.Files) == 0 { return driveFile{}, false, nil }
+	return r.Files[0], true, nil
+}
+
+// dir returns the ID of folder p (slash separated, "" for the top),
+// making the missing ones if mk is set. It holds the lock throughout, so
+// two workers never both make the same folder.
+func (t *gdriveTarget) dir(ctx context.Context, p string, mk bool) (string, error) {
+	t.dirs.mu.Lock()
+	defer t.dirs.mu.Unlock()
+	if id, ok := t.dirs.ids[p]; ok { return id, nil }
+	id, cur := t.dirs.ids[""], ""
+	for _, name := range strings.Split(p, "/") {
+		cur = path.Join(cur, name)
+		if known, ok := t.dirs.ids[cur]; ok { id = known; continue }
+		f, found, err := t.lookup(ctx, id, name)
+		switch {
+		case err != nil:
+			return "", err
+		case found && f.MimeType != driveFolder:
+			return "", withClass(ErrInvalidName, fmt.Errorf("%s is a file on the target", cur))
+		case !found && !mk:
+			return "", withClass(ErrNotFound, fmt.Errorf("gdrive: no folder %s", cur))
+		case !found:
+			if err = t.call(ctx, "POST", t.api+"/drive/v3/files?fields=id&supportsAllDrives=true", map[string]any{"name": name, "mimeType": driveFolder, "parents": []string{id}}, &f); err != nil { return "", err }
+			debugf(catTransfer, "gdrive: made folder %s", cur)
+		}
+		id = f.ID
+		t.dirs.ids[cur] = id
+	}
+	return id, nil
+}
+
+// file finds rel's folder ID, making it if mk is set, and its file.
+func (t *gdriveTarget) file(ctx context.Context, rel string, mk bool) (parent, name string, f driveFile, found bool, err error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return "", "", driveFile{}, false, withClass(ErrInvalidName, err) }
+	remote = strings.TrimPrefix(remote, "/")
+	dir, name := path.Dir(remote), path.Base(remote)
+	if dir == "." { dir = "" }
+	if parent, err = t.dir(ctx, dir, mk); err != nil { return "", "", driveFile{}, false, err }
+	f, found, err = t.lookup(ctx, parent, name)
+	if err == nil && found && f.MimeType == driveFolder { err = withClass(ErrInvalidName, fmt.Errorf("%s is a folder on the target", rel)) }
+	return parent, name, f, found, err
+}
+
+func (t *gdriveTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	if err := takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	_, _, f, found, err := t.file(ctx, rel, false)
+	if err != nil { return FileInfo{}, classifyHTTP(ctxErr(ctx, err)) }
+	if !found { return FileInfo{}, withClass(ErrNotFound, os.ErrNotExist) }
+	return FileInfo{Rel: rel, Size: f.Size, MTime: f.Modified, Exists: true}, nil
+}
+
+func (t *gdriveTarget) upload(ctx context.Context, local, rel string) error {
+	return classifyHTTP(ctxErr(ctx, t.put(ctx, local, rel)))
+}
+
+// put starts a resumable upload, as a new file or a new revision of the
+// existing one, and sends the file to the session in one request. Drive
+// keeps milliseconds, so modifiedTime is the mtime rounded up to one.
+func (t *gdriveTarget) put(ctx context.Context, local, rel string) error {
+	if err := takeOp(ctx, opUpload); err != nil { return err }
+	src, err := os.Open(local)
+	if err != nil { return err }
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil { return err }
+	parent, name, f, found, err := t.file(ctx, rel, true)
+	if err != nil { return err }
+	meta := map[string]any{"modifiedTime": ceilTime(fi.ModTime(), time.Millisecond).UTC().Format("2006-01-02T15:04:05.000Z")}
+	method, u := "PATCH", t.api+"/upload/drive/v3/files/"+url.PathEscape(f.ID)+"?uploadType=resumable&supportsAllDrives=true"
+	if !found {
+		meta["name"], meta["parents"] = name, []string{parent}
+		method, u = "POST", t.api+"/upload/drive/v3/files?uploadType=resumable&supportsAllDrives=true"
+	}
+	hdr, err := apiCall(ctx, t.client, t.tokens, method, u, meta, nil, driveFailure)
+	if err != nil { return err }
+	session := hdr.Get("Location")
+	if session == "" { return fmt.Errorf("gdrive: %s %s started no upload session", method, rel) }
+	tok, err := t.tokens.token(ctx)
+	if err != nil { return err }
+	req, err := http.NewRequestWithContext(ctx, "PUT", session, ctxReader{ctx, src})
+	if err != nil { return err }
+	req.ContentLength = fi.Size()
+	req.Header.Set("Authorization", "Bearer "+tok)
+	resp, err := t.client.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 { return driveFailure("PUT", req.URL, resp) }
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return nil
+}
+
+func (t *gdriveTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	if err := takeOp(ctx, opDownload); err != nil { return err }
+	_, _, f, found, err := t.file(ctx, rel, false)
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	if !found { return withClass(ErrNotFound, os.ErrNotExist) }
+	tok, err := t.tokens.token(ctx)
+	if err != nil { return err }
+	req, err := http.NewRequestWithContext(ctx, "GET", t.api+"/drive/v3/files/"+url.PathEscape(f.ID)+"?alt=media&supportsAllDrives=true", nil)
+	if err != nil { return err }
+	req.Header.Set("Authorization", "Bearer "+tok)
+	resp, err := t.client.Do(req)
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return classifyHTTP(driveFailure("GET", req.URL, resp)) }
+	_, err = io.Copy(w, resp.Body)
+	return classifyHTTP(ctxErr(ctx, err))
+}
+
+func (t *gdriveTarget) close() { t.client.CloseIdleConnections() }
@@ -2,9 +2,17 @@ module datasync
 
 go 1.24.4
 
-require github.com/jlaffaye/ftp v0.2.0
+require (
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/jlaffaye/ftp v0.2.0
+	github.com/zeebo/blake3 v0.2.4
+	golang.org/x/crypto v0.43.0
+	golang.org/x/text v0.30.0
+)
 
 require (
 	github.com/hashicorp/errwrap v1.0.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	golang.org/x/sys v0.37.0 // indirect
 )
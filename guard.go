@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ────────── ransomware guard ───────────────────────────────
+// With guard.max_changed_pct set, a changed file that looks encrypted
+// is held back instead of uploaded. A file looks encrypted if its start
+// is near-random (entropy above guard.entropy bits per byte) but its
+// type is not one that is compressed anyway. A file can also look
+// renamed, if it has an extension the target never had (needs
+// state_file). Once the other files are done, the run decides:
+//   - If the held-back files reach both guard.min_files and
+//     max_changed_pct percent of the files, nothing of them is sent. The
+//     run fails with an anomaly error, which alerts like any other failure.
+//   - Otherwise the held-back files are uploaded as usual.
+// Each run judges afresh, so the sync stays paused while the files stay
+// that way. After a legitimate mass change, run once with
+// -accept-changes.
+type GuardConf struct {
+	MaxChangedPct float64 `json:"max_changed_pct"` // pause when this share of the files looks encrypted or renamed; 0 = off
+	MinFiles      int64   `json:"min_files"`       // and at least this many (default 20)
+	Entropy       float64 `json:"entropy"`         // bits per byte counted as encrypted (default 7.5)
+}
+
+// compressedExts are types whose content is near-random on its own.
+var compressedExts = map[string]bool{
+	".zip": true, ".7z": true, ".rar": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".cab": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true, ".jar": true, ".apk": true, ".msi": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true,
+	".mp3": true, ".mp4": true, ".m4a": true, ".mov": true, ".mkv": true, ".avi": true, ".webm": true,
+	".pdf": true, ".gpg": true, ".pgp": true, ".kdbx": true,
+}
+
+// guardSample is how much of each changed file is read for its entropy.
+const guardSample = 64 << 10
+
+type guard struct {
+	conf     GuardConf
+	known    map[string]bool // extensions of files on the target, from the state file
+	files    int             // files on the target, from the state file
+	mu       sync.Mutex
+	checked  int
+	held     []job
+	examples []string
+}
+
+func newGuard(c GuardConf, st *syncState) (*guard, error) {
+	if c.MaxChangedPct <= 0 { return nil, nil }
+	if c.MaxChangedPct > 100 { return nil, fmt.Errorf("guard.max_changed_pct: %v is not a percentage", c.MaxChangedPct) }
+	if c.MinFiles <= 0 { c.MinFiles = 20 }
+	if c.Entropy <= 0 { c.Entropy = 7.5 }
+	g := &guard{conf: c}
+	if st != nil {
+		st.mu.Lock()
+		g.files = len(st.Files)
+		g.known = map[string]bool{}
+		for rel := range st.Files { g.known[strings.ToLower(path.Ext(rel))] = true }
+		st.mu.Unlock()
+	}
+	return g, nil
+}
+
+// saw counts a file the run looked at.
+func (g *guard) saw() {
+	if g == nil { return }
+	g.mu.Lock()
+	g.checked++
+	g.mu.Unlock()
+}
+
+// suspect holds j back if the changed file looks encrypted or renamed.
+func (g *guard) suspect(j job, local *FileInfo) bool {
+	if g == nil || j.cleared { return false }
+	ext := strings.ToLower(path.Ext(local.Rel))
+	why := ""
+	switch {
+	case len(g.known) > 0 && !g.known[ext]:
+		why = "new extension"
+	case !compressedExts[ext]:
+		if e, err := entropy(local.Path); err == nil && e >= g.conf.Entropy { why = fmt.Sprintf("entropy %.2f", e) }
+	}
+	if why == "" { return false }
+	debugf(catCompare, "%s: held back by the guard (%s)", j.rel, why)
+	j.cleared = true
+	g.mu.Lock()
+	g.held = append(g.held, j)
+	if len(g.examples) < 5 { g.examples = append(g.examples, j.rel) }
+	g.mu.Unlock()
+	return true
+}
+
+// verdict returns the held-back files to upload, or the anomaly that
+// keeps them back.
+func (g *guard) verdict() ([]job, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n, total := int64(len(g.held)), max(g.checked, g.files)
+	if n == 0 { return nil, nil }
+	pct := 100 * float64(n) / float64(max(total, 1))
+	if n < g.conf.MinFiles || pct < g.conf.MaxChangedPct { return g.held, nil }
+	return nil, withClass(ErrAnomaly, fmt.Errorf("guard: %d of %d files (%.0f%%) changed to look encrypted or renamed, e.g. %s; they were not uploaded. Check this machine, then run with -accept-changes if the change is genuine",
+		n, total, pct, strings.Join(g.examples, ", ")))
+}
+
+// entropy is the Shannon entropy of the start of a file, in bits per
+// byte; small files say too little and count as 0.
+func entropy(p string) (float64, error) {
+	f, err := os.Open(p)
+	if err != nil { return 0, err }
+	defer f.Close()
+	buf := make([]byte, guardSample)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF { return 0, err }
+	if n < 512 { return 0, nil }
+	var counts [256]int
+	for _, b := range buf[:n] { counts[b]++ }
+	var h float64
+	for _, c := range counts {
+		if c == 0 { continue }
+		q := float64(c) / float64(n)
+		h -= q * math.Log2(q)
+	}
+	return h, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// noise is n random bytes, as encryption leaves a file.
+func noise(t *testing.T, n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil { t.Fatal(err) }
+	return string(b)
+}
+
+func TestEntropy(t *testing.T) {
+	dir := t.TempDir()
+	for _, c := range []struct {
+		name, body string
+		lo, hi     float64
+	}{
+		{"small", noise(t, 100), 0, 0},
+		{"zeros", strings.Repeat("\x00", 4096), 0, 0},
+		{"text", strings.Repeat("the quick brown fox jumps over the lazy dog ", 200), 3, 5},
+		{"random", noise(t, 64<<10), 7.9, 8},
+	} {
+		p := filepath.Join(dir, c.name)
+		if err := os.WriteFile(p, []byte(c.body), 0644); err != nil { t.Fatal(err) }
+		if e, err := entropy(p); err != nil || e < c.lo || e > c.hi { t.Errorf("%s: entropy %.2f, %v; want %v to %v", c.name, e, err, c.lo, c.hi) }
+	}
+}
+
+// newGuarded is a push over 20 text files with the guard pausing at 25%
+// of them (5 files).
+func newGuarded(t *testing.T) *twoWay {
+	w := newTwoWay(t)
+	w.conf.Direction = ""
+	w.conf.Guard = GuardConf{MaxChangedPct: 25, MinFiles: 5}
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < 20; i++ { put(t, w.here, fmt.Sprintf("doc%02d.txt", i), strings.Repeat("plain text ", 100), then) }
+	if s := w.sync(t); s.Uploaded != 20 { t.Fatalf("first run uploaded %d, want 20", s.Uploaded) }
+	return w
+}
+
+func TestGuardPauses(t *testing.T) {
+	w := newGuarded(t)
+	later := time.Now().Add(time.Minute).Truncate(time.Second)
+	for i := 0; i < 6; i++ { put(t, w.here, fmt.Sprintf("doc%02d.txt", i), noise(t, 4096), later) }
+	put(t, w.here, "doc19.txt", "edited", later)
+	p := &summed{}
+	if code := runSync(context.Background(), w.conf, runOpts{progress: p}); code == 0 || !errors.Is(p.sum.Err, ErrAnomaly) { t.Fatalf("exit %d, %v; want an anomaly", code, p.sum.Err) }
+	if p.sum.Uploaded != 1 || body(w.there, "doc19.txt") != "edited" { t.Errorf("%d uploaded; want only the plain edit", p.sum.Uploaded) }
+	for i := 0; i < 6; i++ {
+		if rel := fmt.Sprintf("doc%02d.txt", i); !strings.HasPrefix(body(w.there, rel), "plain text") { t.Errorf("%s replaced on the target", rel) }
+	}
+
+	// the next run judges afresh and still holds them back
+	p = &summed{}
+	if runSync(context.Background(), w.conf, runOpts{progress: p}); !errors.Is(p.sum.Err, ErrAnomaly) { t.Errorf("second run: %v, want an anomaly", p.sum.Err) }
+
+	p = &summed{}
+	runSync(context.Background(), w.conf, runOpts{progress: p, accept: true})
+	if p.sum.Err != nil { t.Errorf("-accept-changes: %v", p.sum.Err) }
+	for i := 0; i < 6; i++ {
+		if rel := fmt.Sprintf("doc%02d.txt", i); body(w.there, rel) != body(w.here, rel) { t.Errorf("-accept-changes: %s not uploaded", rel) }
+	}
+}
+
+func TestGuardLetsFewThrough(t *testing.T) {
+	w := newGuarded(t)
+	later := time.Now().Add(time.Minute).Truncate(time.Second)
+	for i := 0; i < 4; i++ { put(t, w.here, fmt.Sprintf("doc%02d.txt", i), noise(t, 4096), later) }
+	if s := w.sync(t); s.Uploaded != 4 { t.Errorf("4 files below min_files: %d uploaded, want 4", s.Uploaded) }
+}
+
+func TestGuardSuspects(t *testing.T) {
+	w := newGuarded(t)
+	later := time.Now().Add(time.Minute).Truncate(time.Second)
+	// near-random, but compressed anyway
+	for i := 0; i < 6; i++ { put(t, w.here, fmt.Sprintf("photo%02d.jpg", i), noise(t, 4096), later) }
+	w.conf.Guard.MinFiles = 1
+	g, _ := newGuard(w.conf.Guard, nil)
+	for i := 0; i < 6; i++ {
+		rel := fmt.Sprintf("photo%02d.jpg", i)
+		if g.suspect(job{rel: rel}, &FileInfo{Rel: rel, Path: filepath.Join(w.here, rel)}) { t.Errorf("%s held back", rel) }
+	}
+
+	// extensions the target never had, as a ransomware rename leaves
+	st, err := loadState(w.conf.StateFile)
+	if err != nil { t.Fatal(err) }
+	g, _ = newGuard(w.conf.Guard, st)
+	for rel, want := range map[string]bool{"doc00.txt.locked": true, "doc21.txt": false} {
+		put(t, w.here, rel, "plain", later)
+		if got := g.suspect(job{rel: rel}, &FileInfo{Rel: rel, Path: filepath.Join(w.here, rel)}); got != want { t.Errorf("%s held back: %v, want %v", rel, got, want) }
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ────────── hash index ─────────────────────────────────────
+// hash_index names a file that every run (not a dry run) rewrites from the
+// state file with the path on the target, size, mtime and content hash
+// of each file it holds, so archival and dedup systems downstream can
+// read what the target has without reading the target. The extension
+// decides the format: ".csv" has a header line, path,size,mtime,<algo>;
+// anything else is JSON Lines, {"path":…,"size":…,"mtime":…,"<algo>":…}.
+// <algo> is hash_algorithm, sha256 by default, and hashes are bare hex.
+// Hashes come from compare: hash, which is therefore needed; a file whose
+// hash was taken under another hash_algorithm is left out until the next
+// run hashes it again. The file is replaced in one rename, so a reader
+// never sees half of it.
+
+// checkHashIndex rejects settings the index cannot be made under.
+func checkHashIndex(c *Conf) error {
+	switch {
+	case c.HashIndex == "":
+		return nil
+	case c.StateFile == "":
+		return fmt.Errorf("hash_index needs state_file, which it is written from")
+	case !strings.EqualFold(c.Compare, "hash"):
+		return fmt.Errorf("hash_index needs compare: hash to know the content of each file")
+	}
+	return nil
+}
+
+// saveHashIndex writes the index unless the settings are what stopped the run.
+func (r *run) saveHashIndex() error {
+	algo, err := hashAlgorithm(r.conf)
+	if err != nil || checkHashIndex(r.conf) != nil { return nil } // the run's own error says why
+	return writeHashIndex(r.conf.HashIndex, r.st, algo)
+}
+
+type indexEntry struct {
+	path  string
+	size  int64
+	mtime time.Time
+	sum   string
+}
+
+// writeHashIndex writes the index for the state as it stands.
+func writeHashIndex(path string, st *syncState, algo string) error {
+	st.mu.Lock()
+	entries := make([]indexEntry, 0, len(st.Files))
+	for rel, f := range st.Files {
+		sum, ok := f.SHA256, true
+		if algo != hashSHA256 { sum, ok = strings.CutPrefix(f.Sum, algo+":") }
+		if !ok || sum == "" { continue } // not hashed under algo
+		entries = append(entries, indexEntry{rel, f.Size, f.RemoteMTime, sum})
+	}
+	st.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil { return err }
+	w := bufio.NewWriter(f)
+	if strings.EqualFold(strings.TrimPrefix(filepath.Ext(path), "."), "csv") {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"path", "size", "mtime", algo})
+		for _, e := range entries {
+			cw.Write([]string{e.path, strconv.FormatInt(e.size, 10), e.mtime.UTC().Format(time.RFC3339Nano), e.sum})
+		}
+		cw.Flush()
+		err = cw.Error()
+	} else {
+		for _, e := range entries {
+			p, _ := json.Marshal(e.path) // in the order of the CSV columns, which a map would not keep
+			fmt.Fprintf(w, "{\"path\":%s,\"size\":%d,\"mtime\":%q,%q:%q}\n", p, e.size, e.mtime.UTC().Format(time.RFC3339Nano), algo, e.sum)
+		}
+	}
+	if err == nil { err = w.Flush() }
+	if cerr := f.Close(); err == nil { err = cerr }
+	if err != nil { os.Remove(tmp); return err }
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ────────── dead-man's-switch pings ────────────────────────
+// healthcheckSink pings a Healthchecks.io-style URL: <url>/start when a
+// run begins, <url> when it succeeds and <url>/fail when it does not, with
+// the reason as the request body. The monitoring side alerts when the
+// pings stop, which also catches a scheduled task that no longer runs.
+type NotifyConf struct {
+	HealthcheckURL string     `json:"healthcheck_url"`
+	Digest         DigestConf `json:"digest"` // daily what-changed summary, see digest.go
+}
+
+type healthcheckSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHealthcheckSink(u string) (*healthcheckSink, error) {
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return nil, fmt.Errorf("notify.healthcheck_url: %q is not an http(s) URL", u)
+	}
+	return &healthcheckSink{url: strings.TrimRight(u, "/"), client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (h *healthcheckSink) send(e Event) error {
+	var u, body string
+	switch {
+	case e.Type == EventRunStarted:
+		u = h.url + "/start"
+	case e.Type != EventRunCompleted:
+		return nil
+	case e.Error != "":
+		u, body = h.url+"/fail", fmt.Sprintf("[%s] %s", kindLabel(e.Kind), e.Error)
+	case e.Summary != nil && e.Summary.Failed > 0:
+		u, body = h.url+"/fail", e.Summary.failures()
+	default:
+		u = h.url
+		if s := e.Summary; s != nil { body = tr("uploaded", s.Uploaded, s.Bytes, s.Elapsed.Round(time.Second)) }
+	}
+	var err error
+	for try := 1; try <= attempts; try++ {
+		if try > 1 { time.Sleep(time.Second) }
+		if err = h.ping(u, body); err == nil { return nil }
+	}
+	return err
+}
+
+func (h *healthcheckSink) ping(u, body string) error {
+	resp, err := h.client.Post(u, "text/plain", strings.NewReader(body))
+	if err != nil { return err }
+	resp.Body.Close()
+	if resp.StatusCode >= 300 { return fmt.Errorf("%s: %s", u, resp.Status) }
+	return nil
+}
+
+func (h *healthcheckSink) close() {}
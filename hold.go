@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── legal hold ─────────────────────────────────────
+// hold.patterns names files under legal hold, matched as in tags. A held
+// file is copied to the target while it is missing there, but a copy
+// already on the target is never replaced, even when the local file
+// changes: the run reports it and moves on. Nothing deletes remote files
+// on a hold's behalf either. That is enforced here, on the client; on S3,
+// hold.s3_object_lock also has the store enforce it with an Object Lock
+// legal hold on each held object, and s3_retain_days adds a retention
+// period (the bucket must have Object Lock enabled).
+type HoldConf struct {
+	Patterns   []string `json:"patterns"`
+	S3Lock     bool     `json:"s3_object_lock"` // S3: put held objects under an Object Lock legal hold
+	RetainDays int      `json:"s3_retain_days"` // S3: and retain them this many days
+	S3Mode     string   `json:"s3_mode"`        // retention mode: "GOVERNANCE" (default) | "COMPLIANCE"
+}
+
+type hold struct {
+	conf HoldConf
+	pats []string // lower case
+}
+
+func newHold(c HoldConf) (*hold, error) {
+	if len(c.Patterns) == 0 { return nil, nil }
+	h := &hold{conf: c}
+	for _, p := range c.Patterns {
+		if !rpath.ValidPattern(strings.ToLower(p)) { return nil, fmt.Errorf("hold.patterns: bad pattern %q", p) }
+		h.pats = append(h.pats, strings.ToLower(strings.ReplaceAll(p, `\`, "/")))
+	}
+	switch h.conf.S3Mode = strings.ToUpper(c.S3Mode); h.conf.S3Mode {
+	case "":
+		h.conf.S3Mode = "GOVERNANCE"
+	case "GOVERNANCE", "COMPLIANCE":
+	default:
+		return nil, fmt.Errorf("hold.s3_mode: unknown mode %q (use GOVERNANCE or COMPLIANCE)", c.S3Mode)
+	}
+	if c.RetainDays < 0 { return nil, fmt.Errorf("hold.s3_retain_days: %d is negative", c.RetainDays) }
+	return h, nil
+}
+
+// covers reports whether a slash-separated path under local_dir is held.
+func (h *hold) covers(rel string) bool {
+	if h == nil { return false }
+	rel = strings.ToLower(rel)
+	for _, p := range h.pats {
+		if rpath.Match(p, rel) { return true }
+	}
+	return false
+}
+
+type holdKey struct{}
+
+// withHold marks an upload under ctx as one of a held file, so targets
+// that can lock objects do.
+func withHold(ctx context.Context, h *hold) context.Context {
+	return context.WithValue(ctx, holdKey{}, h)
+}
+
+// s3LockHeaders returns the Object Lock headers for an upload under ctx,
+// nil when it is not held or locking is off.
+func s3LockHeaders(ctx context.Context) map[string]string {
+	h, _ := ctx.Value(holdKey{}).(*hold)
+	if h == nil || !h.conf.S3Lock { return nil }
+	hdr := map[string]string{"x-amz-object-lock-legal-hold": "ON"}
+	if h.conf.RetainDays > 0 {
+		hdr["x-amz-object-lock-mode"] = h.conf.S3Mode
+		hdr["x-amz-object-lock-retain-until-date"] = time.Now().AddDate(0, 0, h.conf.RetainDays).UTC().Format(time.RFC3339)
+	}
+	return hdr
+}
+
+// lockChecksum is the header and value for n bytes at off that S3
+// requires on every write of an object under Object Lock: Content-MD5,
+// or x-amz-checksum-sha256 in FIPS mode.
+func lockChecksum(r io.ReaderAt, off, n int64) (string, string, error) {
+	name, h := "Content-MD5", md5.New()
+	if fipsMode { name, h = "x-amz-checksum-sha256", sha256.New() }
+	if _, err := io.Copy(h, io.NewSectionReader(r, off, n)); err != nil { return "", "", err }
+	return name, base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
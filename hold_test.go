@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewHold(t *testing.T) {
+	if h, err := newHold(HoldConf{}); h != nil || err != nil { t.Errorf("no patterns: %v, %v; want no hold", h, err) }
+	for _, c := range []HoldConf{
+		{Patterns: []string{"[a"}},
+		{Patterns: []string{"*.pst"}, S3Mode: "strict"},
+		{Patterns: []string{"*.pst"}, RetainDays: -1},
+	} {
+		if _, err := newHold(c); err == nil { t.Errorf("%+v accepted", c) }
+	}
+	h, err := newHold(HoldConf{Patterns: []string{`Cases\LH-17\**`, "*.PST"}})
+	if err != nil { t.Fatal(err) }
+	for rel, want := range map[string]bool{
+		"cases/lh-17/a.doc":     true,
+		"Cases/LH-17/x/y.doc":   true,
+		"cases/lh-18/a.doc":     false,
+		"mail/old.pst":          true,
+		"mail/old.pst.txt":      false,
+	} {
+		if got := h.covers(rel); got != want { t.Errorf("covers(%q) = %v, want %v", rel, got, want) }
+	}
+	var none *hold
+	if none.covers("a.pst") { t.Error("a nil hold covers a file") }
+}
+
+func TestHoldPush(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction = ""
+	w.conf.Hold = HoldConf{Patterns: []string{"held/**"}}
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "held/a.txt", "v1", then)
+	put(t, w.here, "free.txt", "v1", then)
+	if s := w.sync(t); s.Uploaded != 2 { t.Fatalf("first run uploaded %d, want 2: a held file missing on the target is copied", s.Uploaded) }
+
+	later := time.Now().Add(time.Minute).Truncate(time.Second) // past the uploads' mtimes
+	put(t, w.here, "held/a.txt", "v2", later)
+	put(t, w.here, "free.txt", "v2", later)
+	s := w.sync(t)
+	if s.Uploaded != 1 || s.Held != 1 || s.Failed != 0 { t.Errorf("%d up, %d held, %d failed; want 1, 1, 0", s.Uploaded, s.Held, s.Failed) }
+	if got := body(w.there, "held/a.txt"); got != "v1" { t.Errorf("held/a.txt on the target = %q, the held copy was replaced", got) }
+	if got := body(w.there, "free.txt"); got != "v2" { t.Errorf("free.txt on the target = %q, want v2", got) }
+}
+
+func TestHoldTwoWay(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Hold = HoldConf{Patterns: []string{"held/**"}}
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "held/a.txt", "a", then)
+	put(t, w.here, "free.txt", "f", then)
+	put(t, w.here, "kept.txt", "k", then)
+	w.sync(t)
+
+	// deleted locally: the free file goes from the target too, the held one stays
+	os.Remove(filepath.Join(w.here, "held", "a.txt"))
+	os.Remove(filepath.Join(w.here, "free.txt"))
+	s := w.sync(t)
+	if s.Deleted != 1 || s.Held != 1 { t.Errorf("%d deleted, %d held; want 1, 1", s.Deleted, s.Held) }
+	if body(w.there, "held/a.txt") != "a" { t.Error("held/a.txt deleted from the target") }
+	if body(w.there, "free.txt") != "" { t.Error("free.txt still on the target") }
+}
+
+func TestS3LockHeaders(t *testing.T) {
+	ctx := context.Background()
+	if s3LockHeaders(ctx) != nil { t.Error("headers for an upload that is not held") }
+	h, _ := newHold(HoldConf{Patterns: []string{"*"}})
+	if s3LockHeaders(withHold(ctx, h)) != nil { t.Error("headers without s3_object_lock") }
+
+	h, _ = newHold(HoldConf{Patterns: []string{"*"}, S3Lock: true})
+	hdr := s3LockHeaders(withHold(ctx, h))
+	if hdr["x-amz-object-lock-legal-hold"] != "ON" || hdr["x-amz-object-lock-mode"] != "" { t.Errorf("legal hold only: %v", hdr) }
+
+	h, _ = newHold(HoldConf{Patterns: []string{"*"}, S3Lock: true, RetainDays: 30, S3Mode: "compliance"})
+	hdr = s3LockHeaders(withHold(ctx, h))
+	until, err := time.Parse(time.RFC3339, hdr["x-amz-object-lock-retain-until-date"])
+	if hdr["x-amz-object-lock-mode"] != "COMPLIANCE" || err != nil || until.Before(time.Now().AddDate(0, 0, 29)) { t.Errorf("with retention: %v", hdr) }
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── HTTP PUT target ────────────────────────────────
+// httpTarget is for services that only take uploads as plain HTTP PUTs,
+// such as an artifact store. http.url is a template for each file's URL:
+// {path} is its path under the target, {dir} its folder and {name} its
+// name, each segment escaped, e.g. https://artifacts.example.com/up/{path}.
+// A template without any of them gets /{path} appended. HEAD on that URL
+// gives the size and, from Last-Modified, the time to compare against;
+// such services stamp the upload time, which is later than the mtime of
+// the file that was sent, so an unchanged file stays up to date. Requests
+// carry http.token as a bearer token, or Basic auth with http.user/pass.
+type HTTPConf struct {
+	URL        string            `json:"url"`         // template, see above
+	User, Pass string
+	Token      string            `json:"token"`       // bearer token, instead of user and pass
+	Headers    map[string]string `json:"headers"`     // added to every request
+	RemotePath string            `json:"remote_path"` // folder {path} starts in
+	TLSCA      string            `json:"tls_ca"`      // PEM file with the CA that signed the server certificate (default: system roots)
+}
+
+type httpTarget struct {
+	cfg    HTTPConf
+	root   rpath.Root
+	client *http.Client
+}
+
+func connectHTTP(ctx context.Context, cfg HTTPConf, tc TransferConf, form string) (*httpTarget, error) {
+	if !strings.Contains(cfg.URL, "{path}") && !strings.Contains(cfg.URL, "{name}") {
+		cfg.URL = strings.TrimRight(cfg.URL, "/") + "/{path}"
+	}
+	u, err := url.Parse(strings.NewReplacer("{path}", "", "{dir}", "", "{name}", "").Replace(cfg.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("http.url: %q is not an http(s) URL", cfg.URL)
+	}
+	client, err := tc.httpClient("http.tls_ca", cfg.TLSCA)
+	if err != nil { return nil, err }
+	t := &httpTarget{cfg: cfg, root: rpath.NewRoot(cfg.RemotePath, form), client: client}
+	// there is no login; a HEAD on the empty template shows a refused token
+	// once instead of on every file. Any other answer will do.
+	resp, err := t.send(ctx, "HEAD", u, nil, -1, nil)
+	if err != nil { return nil, classifyHTTP(ctxErr(ctx, err)) }
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, withClass(ErrAuth, statusErr("HEAD", u, resp))
+	}
+	return t, nil
+}
+
+// url fills the template in for rel.
+func (t *httpTarget) url(rel string) (*url.URL, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return nil, withClass(ErrInvalidName, err) }
+	remote = strings.TrimPrefix(remote, "/")
+	s := strings.NewReplacer("{path}", escapePath(remote), "{dir}", escapePath(path.Dir(remote)), "{name}", url.PathEscape(path.Base(remote))).Replace(t.cfg.URL)
+	return url.Parse(s)
+}
+
+// strongETag is an ETag fit for If-Match; weak ones (W/"…") are not.
+func strongETag(e string) string {
+	if strings.HasPrefix(e, "W/") { return "" }
+	return e
+}
+
+// escapePath escapes each segment of a slash path for a URL.
+func escapePath(p string) string {
+	if p == "." { return "" }
+	parts := strings.Split(p, "/")
+	for i, s := range parts { parts[i] = url.PathEscape(s) }
+	return strings.Join(parts, "/")
+}
+
+func (t *httpTarget) send(ctx context.Context, method string, u *url.URL, body io.Reader, size int64, hdr map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil { return nil, err }
+	if size >= 0 { req.ContentLength = size }
+	if body != nil { req.Header.Set("Content-Type", "application/octet-stream") }
+	for k, v := range t.cfg.Headers { req.Header.Set(k, v) }
+	for k, v := range hdr { req.Header.Set(k, v) }
+	switch {
+	case t.cfg.Token != "":
+		req.Header.Set("Authorization", "Bearer "+t.cfg.Token)
+	case t.cfg.User != "":
+		req.SetBasicAuth(t.cfg.User, t.cfg.Pass)
+	}
+	return t.client.Do(req)
+}
+
+func (t *httpTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	u, err := t.url(rel)
+	if err != nil { return FileInfo{}, err }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	resp, err := t.send(ctx, "HEAD", u, nil, -1, nil)
+	if err != nil { return FileInfo{}, classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return FileInfo{}, classifyHTTP(statusErr("HEAD", u, resp)) }
+	fi := FileInfo{Rel: rel, Size: resp.ContentLength, Exists: true}
+	fi.MTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	fi.ETag = strongETag(resp.Header.Get("ETag"))
+	return fi, nil
+}
+
+func (t *httpTarget) upload(ctx context.Context, local, rel string) error {
+	return classifyHTTP(ctxErr(ctx, t.put(ctx, local, rel)))
+}
+
+func (t *httpTarget) put(ctx context.Context, local, rel string) error {
+	u, err := t.url(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
+	src, err := os.Open(local)
+	if err != nil { return err }
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil { return err }
+	resp, err := t.send(ctx, "PUT", u, ctxReader{ctx, src}, fi.Size(), ifMatchHeader(ctx))
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 { return statusErr("PUT", u, resp) }
+	return nil
+}
+
+func (t *httpTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	u, err := t.url(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	resp, err := t.send(ctx, "GET", u, nil, -1, nil)
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return classifyHTTP(statusErr("GET", u, resp)) }
+	_, err = io.Copy(w, resp.Body)
+	return classifyHTTP(ctxErr(ctx, err))
+}
+
+func (t *httpTarget) close() { t.client.CloseIdleConnections() }
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ────────── operator messages ──────────────────────────────
+// What branch staff read (the run's console lines and the text of
+// notifications) goes through tr so it can be shown in their language.
+// Raw error details come from the OS or the server and stay as they are;
+// machine-readable fields (event types, kind tokens) are never translated.
+// English shows the kind tokens themselves, which support routes on.
+var catalog = map[string]map[string]string{
+	"en": {
+		"conflict":           "%s changed on target since our last upload, skipped",
+		"conflict_both":      "%s changed on both sides since the last sync, both copies kept",
+		"conflict_gone":      "%s changed on one side and was deleted on the other, kept",
+		"resolved_local":     "%s: conflict settled, the local side won",
+		"resolved_remote":    "%s: conflict settled, the target's side won",
+		"resolved_both":      "%s: conflict settled, the local copy is kept as %s",
+		"resolved_total":     "%d conflict(s) settled by on_conflict",
+		"backed_up_total":    "%d replaced file(s) kept as backups on the target",
+		"linked_total":      "%d unchanged file(s) linked from the last folder",
+		"archived_total":    "%d file(s) verified on the target and handed off from local_dir",
+		"deleted_local":      "%s deleted here, as it was on the target",
+		"deleted_remote":     "%s deleted on the target, as it was here",
+		"trashed":           "%s moved to %s on the target, as it was deleted here",
+		"trash_purged":      "%s: %d file(s) deleted from the trash",
+		"renamed":            "%s renamed to %s on the target",
+		"would_rename":       "%s would be renamed to %s on the target",
+		"would_upload":       "%s would be uploaded",
+		"would_download":     "%s would be downloaded",
+		"would_delete_here":  "%s would be deleted here",
+		"would_delete":       "%s would be deleted on the target",
+		"dry_run_complete":   "Dry run complete, nothing changed: %d upload(s), %d download(s), %d rename(s), %d deletion(s) pending",
+		"estimate":           "Estimate: %d file(s), %s to transfer, about %s at %s/s over %d earlier run(s)",
+		"estimate_none":      "Estimate: %d file(s), %s to transfer; no earlier run with state_file measured a transfer, so no time can be given",
+		"estimate_fits":      "Expected to finish within %s",
+		"estimate_over":      "Not expected to finish within %s",
+		"blackout_deferred":  "Deferred due to blackout %s, until %s",
+		"boot_settle":        "Started at boot; waiting %s for the system to settle",
+		"boot_current":       "Last good run %s ago, within boot.catch_up %s; nothing to catch up",
+		"next_run":          "Next run at %s",
+		"mirror_pending":     "%d file(s) on the target are gone from local_dir; run with -delete (or set max_delete) to delete them",
+		"mirror_failed":      "%d upload(s) failed, so mirror deleted nothing this run",
+		"config_drift":      "this config differs from %s in: %s",
+		"exported":          "Exported %d of %d file(s) to %s",
+		"imported":          "Imported %d verified file(s) into %s",
+		"courier_mismatch":  "%s: content does not match manifest",
+		"packaged":          "Packaged %d changed file(s), %d deletion(s) into %s",
+		"inventoried":       "%d file(s) inventoried to %s",
+		"never_signed":       "Signed %s (%d entries)",
+		"audit_intact":       "%s: %d entries, chain intact",
+		"interrupted":        "Sync interrupted: %v",
+		"finished_failed":    "Sync finished, %s",
+		"complete_conflicts": "Sync complete, %d conflict(s) left untouched",
+		"withheld":           "%s withheld by the never-transfer list: %v",
+		"power_deferred":     "%s (%s) deferred: %s",
+		"power_battery":      "on battery",
+		"power_metered":      "on a metered connection",
+		"power_in_use":       "the machine is in use",
+		"deferred_total":     "%d large file(s) deferred until on AC power, an unmetered network or an idle machine",
+		"withheld_total":     "%d file(s) withheld by the never-transfer list",
+		"held":               "%s changed locally but is under legal hold; the copy on the target is kept",
+		"snapshot":           "Snapshot %s taken before changing the target",
+		"resume":             "Resuming run %s: %d folder(s) it finished are skipped",
+		"remote_modified":    "%s was changed on the target by someone else",
+		"remote_deleted":     "%s was deleted from the target by someone else",
+		"remote_corrupt":     "%s on the target no longer holds what was written, though size and mtime are unchanged",
+		"remote_intact":      "Target as last written: %d file(s) checked",
+		"state_exported":     "%d record(s) exported to %s",
+		"state_imported":     "%d record(s) imported into %s, %d already there kept",
+		"state_gone":         "%s is recorded, but gone from the target",
+		"state_changed":      "%s changed on the target since it was recorded",
+		"state_dropped":      "%s is gone from the target, its record dropped",
+		"state_matched":      "%s on the target reads back as in local_dir, recorded again",
+		"state_stale":        "%d record(s) of files gone from local_dir, for state gc",
+		"state_diverged":     "%d of %d record(s) do not match the target",
+		"state_checked":      "State file matches the target: %d record(s) checked",
+		"state_collected":    "%d record(s) of files gone from local_dir dropped, %d newly marked gone",
+		"state_seeded":       "State file seeded from %s, written on %s: %d record(s) taken, %d left out as local_dir differs",
+		"complete":           "Sync complete",
+		"sla_breached":       "Freshness SLA breached: %s",
+		"stale":              "no successful sync for %s (SLA %s)",
+		"files_failed":       "%d file(s) failed",
+		"sync_failed":        "sync failed: [%s] %s",
+		"recovered":          "sync recovered",
+		"uploaded":           "%d file(s), %d bytes uploaded in %s",
+		"digest_subject":     "Data changes at %s: %s",
+		"digest_period":      "Changes from %s to %s",
+		"digest_counts":      "%d new, %d modified, %d deleted",
+		"digest_none":        "Nothing changed.",
+		"digest_more":        "... and %d more",
+		"tag_total":          "%s: %d file(s), %d bytes",
+		"untagged":           "untagged",
+		"kind.auth":          "auth",
+		"kind.permission":    "permission",
+		"kind.disk-full":     "disk-full",
+		"kind.file-locked":   "file-locked",
+		"kind.name-invalid":  "name-invalid",
+		"kind.not-found":     "not-found",
+		"kind.conflict":      "conflict",
+		"kind.withheld":      "withheld",
+		"kind.held":          "held",
+		"kind.deferred":      "deferred",
+		"kind.anomaly":       "anomaly",
+		"kind.canary":        "canary",
+		"kind.limit":         "limit",
+		"kind.blackout":      "blackout",
+		"kind.busy":          "busy",
+		"kind.network":       "network",
+		"kind.transient":     "transient",
+		"kind.cancelled":     "cancelled",
+		"kind.other":         "other",
+	},
+	"de": {
+		"conflict":           "%s wurde seit unserem letzten Upload auf dem Ziel geändert, übersprungen",
+		"conflict_both":      "%s wurde auf beiden Seiten seit dem letzten Abgleich geändert, beide Fassungen bleiben",
+		"conflict_gone":      "%s wurde auf einer Seite geändert und auf der anderen gelöscht, bleibt erhalten",
+		"resolved_local":     "%s: Konflikt gelöst, die lokale Seite gilt",
+		"resolved_remote":    "%s: Konflikt gelöst, die Seite des Ziels gilt",
+		"resolved_both":      "%s: Konflikt gelöst, die lokale Fassung bleibt als %s",
+		"resolved_total":     "%d Konflikt(e) nach on_conflict gelöst",
+		"backed_up_total":    "%d ersetzte Datei(en) als Sicherung auf dem Ziel behalten",
+		"linked_total":      "%d unveränderte Datei(en) aus dem letzten Ordner verknüpft",
+		"archived_total":    "%d Datei(en) auf dem Ziel geprüft und aus local_dir abgegeben",
+		"deleted_local":      "%s hier gelöscht, wie auf dem Ziel",
+		"deleted_remote":     "%s auf dem Ziel gelöscht, wie hier",
+		"trashed":           "%s auf dem Ziel nach %s verschoben, da hier gelöscht",
+		"trash_purged":      "%s: %d Datei(en) aus dem Papierkorb gelöscht",
+		"renamed":            "%s auf dem Ziel in %s umbenannt",
+		"would_rename":       "%s würde auf dem Ziel in %s umbenannt",
+		"would_upload":       "%s würde hochgeladen",
+		"would_download":     "%s würde heruntergeladen",
+		"would_delete_here":  "%s würde hier gelöscht",
+		"would_delete":       "%s würde auf dem Ziel gelöscht",
+		"dry_run_complete":   "Probelauf abgeschlossen, nichts geändert: %d Upload(s), %d Download(s), %d Umbenennung(en), %d Löschung(en) ausstehend",
+		"estimate":           "Schätzung: %d Datei(en), %s zu übertragen, etwa %s bei %s/s aus %d früheren Läufen",
+		"estimate_none":      "Schätzung: %d Datei(en), %s zu übertragen; kein früherer Lauf mit state_file hat eine Übertragung gemessen, daher keine Dauer",
+		"estimate_fits":      "Voraussichtlich fertig innerhalb von %s",
+		"estimate_over":      "Voraussichtlich nicht fertig innerhalb von %s",
+		"blackout_deferred":  "Wegen Sperrzeit %s verschoben, bis %s",
+		"boot_settle":        "Beim Systemstart gestartet; warte %s, bis das System bereit ist",
+		"boot_current":       "Letzter erfolgreicher Lauf vor %s, innerhalb von boot.catch_up %s; nichts nachzuholen",
+		"next_run":          "Nächster Lauf um %s",
+		"mirror_pending":     "%d Datei(en) auf dem Ziel fehlen in local_dir; mit -delete (oder max_delete) werden sie gelöscht",
+		"mirror_failed":      "%d Upload(s) fehlgeschlagen, daher hat mirror in diesem Lauf nichts gelöscht",
+		"config_drift":      "diese Konfiguration weicht von %s ab in: %s",
+		"exported":          "%d von %d Datei(en) nach %s exportiert",
+		"imported":          "%d geprüfte Datei(en) nach %s importiert",
+		"courier_mismatch":  "%s: Inhalt stimmt nicht mit dem Manifest überein",
+		"packaged":          "%d geänderte Datei(en) und %d Löschung(en) in %s gepackt",
+		"inventoried":       "%d Datei(en) in %s inventarisiert",
+		"never_signed":       "%s signiert (%d Einträge)",
+		"audit_intact":       "%s: %d Einträge, Kette intakt",
+		"interrupted":        "Synchronisierung abgebrochen: %v",
+		"finished_failed":    "Synchronisierung beendet, %s",
+		"complete_conflicts": "Synchronisierung abgeschlossen, %d Konflikt(e) nicht angetastet",
+		"withheld":           "%s zurückgehalten (Sperrliste): %v",
+		"power_deferred":     "%s (%s) verschoben: %s",
+		"power_battery":      "im Akkubetrieb",
+		"power_metered":      "über eine getaktete Verbindung",
+		"power_in_use":       "der Rechner wird benutzt",
+		"deferred_total":     "%d große Datei(en) verschoben, bis Netzstrom, eine nicht getaktete Verbindung oder ein unbenutzter Rechner da ist",
+		"withheld_total":     "%d Datei(en) wegen der Sperrliste zurückgehalten",
+		"held":               "%s wurde lokal geändert, steht aber unter Aufbewahrungspflicht; die Kopie auf dem Ziel bleibt",
+		"snapshot":           "Snapshot %s vor der ersten Änderung am Ziel erstellt",
+		"resume":             "Lauf %s wird fortgesetzt: %d dort abgeschlossene Ordner werden übersprungen",
+		"remote_modified":    "%s wurde auf dem Ziel von jemand anderem geändert",
+		"remote_deleted":     "%s wurde auf dem Ziel von jemand anderem gelöscht",
+		"remote_corrupt":     "%s auf dem Ziel hat nicht mehr den geschriebenen Inhalt, obwohl Größe und Änderungszeit gleich sind",
+		"remote_intact":      "Ziel unverändert: %d Datei(en) geprüft",
+		"state_exported":     "%d Eintrag/Einträge nach %s exportiert",
+		"state_imported":     "%d Eintrag/Einträge in %s importiert, %d vorhandene beibehalten",
+		"state_gone":         "%s ist verzeichnet, fehlt aber auf dem Ziel",
+		"state_changed":      "%s wurde auf dem Ziel geändert, seit es verzeichnet wurde",
+		"state_dropped":      "%s fehlt auf dem Ziel, Eintrag entfernt",
+		"state_matched":      "%s auf dem Ziel gleicht der Datei in local_dir, neu verzeichnet",
+		"state_stale":        "%d Eintrag/Einträge von Dateien, die in local_dir fehlen, für state gc",
+		"state_diverged":     "%d von %d Eintrag/Einträgen passen nicht zum Ziel",
+		"state_checked":      "Zustandsdatei passt zum Ziel: %d Eintrag/Einträge geprüft",
+		"state_collected":    "%d Eintrag/Einträge von aus local_dir verschwundenen Dateien entfernt, %d neu als verschwunden markiert",
+		"state_seeded":       "Zustandsdatei aus %s übernommen, geschrieben auf %s: %d Eintrag/Einträge übernommen, %d ausgelassen, da local_dir abweicht",
+		"complete":           "Synchronisierung abgeschlossen",
+		"sla_breached":       "Aktualitäts-SLA verletzt: %s",
+		"stale":              "seit %s keine erfolgreiche Synchronisierung (SLA %s)",
+		"files_failed":       "%d Datei(en) fehlgeschlagen",
+		"sync_failed":        "Synchronisierung fehlgeschlagen: [%s] %s",
+		"recovered":          "Synchronisierung funktioniert wieder",
+		"uploaded":           "%d Datei(en), %d Bytes in %s hochgeladen",
+		"digest_subject":     "Datenänderungen bei %s: %s",
+		"digest_period":      "Änderungen von %s bis %s",
+		"digest_counts":      "%d neu, %d geändert, %d gelöscht",
+		"digest_none":        "Keine Änderungen.",
+		"digest_more":        "... und %d weitere",
+		"tag_total":          "%s: %d Datei(en), %d Bytes",
+		"untagged":           "ohne Klassifizierung",
+		"kind.auth":          "Anmeldung fehlgeschlagen",
+		"kind.permission":    "Zugriff verweigert",
+		"kind.disk-full":     "Datenträger voll",
+		"kind.file-locked":   "Datei gesperrt",
+		"kind.name-invalid":  "ungültiger Name",
+		"kind.not-found":     "nicht gefunden",
+		"kind.conflict":      "Konflikt",
+		"kind.withheld":      "zurückgehalten",
+		"kind.held":          "Aufbewahrung",
+		"kind.deferred":      "verschoben",
+		"kind.anomaly":       "Auffälligkeit",
+		"kind.canary":        "Kontrolldatei",
+		"kind.limit":         "Limit erreicht",
+		"kind.blackout":      "Sperrzeit",
+		"kind.busy":          "belegt",
+		"kind.network":       "Netzwerkfehler",
+		"kind.transient":     "vorübergehender Fehler",
+		"kind.cancelled":     "abgebrochen",
+		"kind.other":         "anderer Fehler",
+	},
+	"fr": {
+		"conflict":           "%s a été modifié sur la cible depuis notre dernier envoi, ignoré",
+		"conflict_both":      "%s a été modifié des deux côtés depuis la dernière synchronisation, les deux copies sont gardées",
+		"conflict_gone":      "%s a été modifié d'un côté et supprimé de l'autre, gardé",
+		"resolved_local":     "%s : conflit réglé, le côté local l'emporte",
+		"resolved_remote":    "%s : conflit réglé, le côté de la cible l'emporte",
+		"resolved_both":      "%s : conflit réglé, la copie locale est gardée sous %s",
+		"resolved_total":     "%d conflit(s) réglé(s) par on_conflict",
+		"backed_up_total":    "%d fichier(s) remplacé(s) gardé(s) en sauvegarde sur la cible",
+		"linked_total":      "%d fichier(s) inchangé(s) lié(s) depuis le dossier précédent",
+		"archived_total":    "%d fichier(s) vérifié(s) sur la cible et retiré(s) de local_dir",
+		"deleted_local":      "%s supprimé ici, comme sur la cible",
+		"deleted_remote":     "%s supprimé sur la cible, comme ici",
+		"trashed":           "%s déplacé vers %s sur la cible, car supprimé ici",
+		"trash_purged":      "%s : %d fichier(s) supprimé(s) de la corbeille",
+		"renamed":            "%s renommé en %s sur la cible",
+		"would_rename":       "%s serait renommé en %s sur la cible",
+		"would_upload":       "%s serait envoyé",
+		"would_download":     "%s serait téléchargé",
+		"would_delete_here":  "%s serait supprimé ici",
+		"would_delete":       "%s serait supprimé sur la cible",
+		"dry_run_complete":   "Simulation terminée, rien n'a changé : %d envoi(s), %d téléchargement(s), %d renommage(s), %d suppression(s) en attente",
+		"estimate":           "Estimation : %d fichier(s), %s à transférer, environ %s à %s/s sur %d exécution(s) précédente(s)",
+		"estimate_none":      "Estimation : %d fichier(s), %s à transférer ; aucune exécution précédente avec state_file n'a mesuré de transfert, pas de durée possible",
+		"estimate_fits":      "Devrait se terminer en moins de %s",
+		"estimate_over":      "Ne devrait pas se terminer en moins de %s",
+		"blackout_deferred":  "Reporté pour cause de plage d'interdiction %s, jusqu'à %s",
+		"boot_settle":        "Lancé au démarrage ; attente de %s que le système soit prêt",
+		"boot_current":       "Dernière exécution réussie il y a %s, dans boot.catch_up %s ; rien à rattraper",
+		"next_run":          "Prochaine exécution à %s",
+		"mirror_pending":     "%d fichier(s) sur la cible ne sont plus dans local_dir ; lancer avec -delete (ou régler max_delete) pour les supprimer",
+		"mirror_failed":      "%d envoi(s) en échec, mirror n'a donc rien supprimé lors de cette exécution",
+		"config_drift":      "cette configuration diffère de %s pour : %s",
+		"exported":          "%d fichier(s) sur %d exporté(s) vers %s",
+		"imported":          "%d fichier(s) vérifié(s) importé(s) dans %s",
+		"courier_mismatch":  "%s : le contenu ne correspond pas au manifeste",
+		"packaged":          "%d fichier(s) modifié(s) et %d suppression(s) empaquetés dans %s",
+		"inventoried":       "%d fichier(s) inventorié(s) dans %s",
+		"never_signed":       "%s signé (%d entrées)",
+		"audit_intact":       "%s : %d entrées, chaîne intacte",
+		"interrupted":        "Synchronisation interrompue : %v",
+		"finished_failed":    "Synchronisation terminée, %s",
+		"complete_conflicts": "Synchronisation terminée, %d conflit(s) laissé(s) en l'état",
+		"withheld":           "%s retenu par la liste d'exclusion : %v",
+		"power_deferred":     "%s (%s) reporté : %s",
+		"power_battery":      "sur batterie",
+		"power_metered":      "sur une connexion limitée",
+		"power_in_use":       "la machine est utilisée",
+		"deferred_total":     "%d gros fichier(s) reporté(s) jusqu'au retour sur secteur, sur un réseau non limité ou d'une machine inutilisée",
+		"withheld_total":     "%d fichier(s) retenu(s) par la liste d'exclusion",
+		"held":               "%s a été modifié localement mais est sous conservation légale ; la copie sur la cible est conservée",
+		"snapshot":           "Instantané %s pris avant de modifier la cible",
+		"resume":             "Reprise de l'exécution %s : %d dossier(s) déjà terminé(s) ignoré(s)",
+		"remote_modified":    "%s a été modifié sur la cible par quelqu'un d'autre",
+		"remote_deleted":     "%s a été supprimé de la cible par quelqu'un d'autre",
+		"remote_corrupt":     "%s sur la cible n'a plus le contenu écrit, bien que la taille et la date soient inchangées",
+		"remote_intact":      "Cible inchangée : %d fichier(s) vérifié(s)",
+		"state_exported":     "%d entrée(s) exportée(s) vers %s",
+		"state_imported":     "%d entrée(s) importée(s) dans %s, %d déjà présente(s) conservée(s)",
+		"state_gone":         "%s est enregistré, mais absent de la cible",
+		"state_changed":      "%s a été modifié sur la cible depuis son enregistrement",
+		"state_dropped":      "%s est absent de la cible, son entrée est supprimée",
+		"state_matched":      "%s sur la cible est identique à local_dir, enregistré à nouveau",
+		"state_stale":        "%d entrée(s) de fichiers absents de local_dir, pour state gc",
+		"state_diverged":     "%d entrée(s) sur %d ne correspondent pas à la cible",
+		"state_checked":      "Le fichier d'état correspond à la cible : %d entrée(s) vérifiée(s)",
+		"state_collected":    "%d entrée(s) de fichiers disparus de local_dir supprimée(s), %d nouvellement marquée(s) disparue(s)",
+		"state_seeded":       "Fichier d'état repris de %s, écrit sur %s : %d entrée(s) reprise(s), %d écartée(s) car local_dir diffère",
+		"complete":           "Synchronisation terminée",
+		"sla_breached":       "SLA de fraîcheur dépassé : %s",
+		"stale":              "aucune synchronisation réussie depuis %s (SLA %s)",
+		"files_failed":       "%d fichier(s) en échec",
+		"sync_failed":        "échec de la synchronisation : [%s] %s",
+		"recovered":          "synchronisation rétablie",
+		"uploaded":           "%d fichier(s), %d octets envoyés en %s",
+		"digest_subject":     "Modifications de données sur %s : %s",
+		"digest_period":      "Modifications du %s au %s",
+		"digest_counts":      "%d nouveau(x), %d modifié(s), %d supprimé(s)",
+		"digest_none":        "Aucune modification.",
+		"digest_more":        "... et %d de plus",
+		"tag_total":          "%s : %d fichier(s), %d octets",
+		"untagged":           "non classé",
+		"kind.auth":          "échec de connexion",
+		"kind.permission":    "accès refusé",
+		"kind.disk-full":     "disque plein",
+		"kind.file-locked":   "fichier verrouillé",
+		"kind.name-invalid":  "nom invalide",
+		"kind.not-found":     "introuvable",
+		"kind.conflict":      "conflit",
+		"kind.withheld":      "retenu",
+		"kind.held":          "conservé",
+		"kind.deferred":      "reporté",
+		"kind.anomaly":       "anomalie",
+		"kind.canary":        "fichier témoin",
+		"kind.limit":         "limite atteinte",
+		"kind.blackout":      "plage interdite",
+		"kind.busy":          "occupé",
+		"kind.network":       "erreur réseau",
+		"kind.transient":     "erreur temporaire",
+		"kind.cancelled":     "annulé",
+		"kind.other":         "autre erreur",
+	},
+	"es": {
+		"conflict":           "%s se modificó en el destino desde nuestra última subida, omitido",
+		"conflict_both":      "%s cambió en ambos lados desde la última sincronización, se conservan las dos copias",
+		"conflict_gone":      "%s cambió en un lado y se eliminó en el otro, se conserva",
+		"resolved_local":     "%s: conflicto resuelto, gana el lado local",
+		"resolved_remote":    "%s: conflicto resuelto, gana el lado del destino",
+		"resolved_both":      "%s: conflicto resuelto, la copia local se conserva como %s",
+		"resolved_total":     "%d conflicto(s) resuelto(s) según on_conflict",
+		"backed_up_total":    "%d archivo(s) reemplazado(s) guardado(s) como copia en el destino",
+		"linked_total":      "%d archivo(s) sin cambios enlazado(s) desde la carpeta anterior",
+		"archived_total":    "%d archivo(s) verificado(s) en el destino y retirado(s) de local_dir",
+		"deleted_local":      "%s eliminado aquí, como en el destino",
+		"deleted_remote":     "%s eliminado en el destino, como aquí",
+		"trashed":           "%s movido a %s en el destino, ya que se eliminó aquí",
+		"trash_purged":      "%s: %d archivo(s) eliminado(s) de la papelera",
+		"renamed":            "%s renombrado a %s en el destino",
+		"would_rename":       "%s se renombraría a %s en el destino",
+		"would_upload":       "%s se subiría",
+		"would_download":     "%s se descargaría",
+		"would_delete_here":  "%s se eliminaría aquí",
+		"would_delete":       "%s se eliminaría en el destino",
+		"dry_run_complete":   "Simulacro completo, nada cambió: %d subida(s), %d descarga(s), %d renombrado(s), %d eliminación(es) pendientes",
+		"estimate":           "Estimación: %d archivo(s), %s por transferir, unos %s a %s/s según %d ejecución(es) anterior(es)",
+		"estimate_none":      "Estimación: %d archivo(s), %s por transferir; ninguna ejecución anterior con state_file midió una transferencia, no se puede dar una duración",
+		"estimate_fits":      "Debería terminar en menos de %s",
+		"estimate_over":      "No debería terminar en menos de %s",
+		"blackout_deferred":  "Aplazado por periodo de bloqueo %s, hasta %s",
+		"boot_settle":        "Iniciado con el sistema; esperando %s a que el sistema esté listo",
+		"boot_current":       "Última ejecución correcta hace %s, dentro de boot.catch_up %s; nada que recuperar",
+		"next_run":          "Próxima ejecución a las %s",
+		"mirror_pending":     "%d archivo(s) en el destino ya no están en local_dir; ejecute con -delete (o defina max_delete) para eliminarlos",
+		"mirror_failed":      "%d subida(s) fallaron, así que mirror no eliminó nada en esta ejecución",
+		"config_drift":      "esta configuración difiere de %s en: %s",
+		"exported":          "%d de %d archivo(s) exportados a %s",
+		"imported":          "%d archivo(s) verificados importados en %s",
+		"courier_mismatch":  "%s: el contenido no coincide con el manifiesto",
+		"packaged":          "%d archivo(s) modificados y %d eliminación(es) empaquetados en %s",
+		"inventoried":       "%d archivo(s) inventariados en %s",
+		"never_signed":       "%s firmado (%d entradas)",
+		"audit_intact":       "%s: %d entradas, cadena intacta",
+		"interrupted":        "Sincronización interrumpida: %v",
+		"finished_failed":    "Sincronización finalizada, %s",
+		"complete_conflicts": "Sincronización completa, %d conflicto(s) sin tocar",
+		"withheld":           "%s retenido por la lista de exclusión: %v",
+		"power_deferred":     "%s (%s) aplazado: %s",
+		"power_battery":      "con batería",
+		"power_metered":      "con una conexión de uso medido",
+		"power_in_use":       "el equipo está en uso",
+		"deferred_total":     "%d archivo(s) grande(s) aplazado(s) hasta volver a la corriente, a una red sin límite o a un equipo sin uso",
+		"withheld_total":     "%d archivo(s) retenido(s) por la lista de exclusión",
+		"held":               "%s cambió localmente pero está bajo retención legal; se conserva la copia del destino",
+		"snapshot":           "Instantánea %s creada antes de modificar el destino",
+		"resume":             "Reanudando la ejecución %s: se omiten %d carpeta(s) ya terminada(s)",
+		"remote_modified":    "%s fue modificado en el destino por otra persona",
+		"remote_deleted":     "%s fue eliminado del destino por otra persona",
+		"remote_corrupt":     "%s en el destino ya no tiene el contenido escrito, aunque el tamaño y la fecha no han cambiado",
+		"remote_intact":      "Destino sin cambios: %d archivo(s) comprobado(s)",
+		"state_exported":     "%d registro(s) exportado(s) a %s",
+		"state_imported":     "%d registro(s) importado(s) en %s, %d ya presente(s) conservado(s)",
+		"state_gone":         "%s está registrado, pero falta en el destino",
+		"state_changed":      "%s cambió en el destino desde que se registró",
+		"state_dropped":      "%s falta en el destino, su registro se eliminó",
+		"state_matched":      "%s en el destino coincide con local_dir, registrado de nuevo",
+		"state_stale":        "%d registro(s) de archivos que faltan en local_dir, para state gc",
+		"state_diverged":     "%d de %d registro(s) no coinciden con el destino",
+		"state_checked":      "El archivo de estado coincide con el destino: %d registro(s) comprobado(s)",
+		"state_collected":    "%d registro(s) de archivos desaparecidos de local_dir eliminado(s), %d marcado(s) como desaparecido(s)",
+		"state_seeded":       "Archivo de estado tomado de %s, escrito en %s: %d registro(s) tomado(s), %d omitido(s) porque local_dir difiere",
+		"complete":           "Sincronización completa",
+		"sla_breached":       "SLA de actualización incumplido: %s",
+		"stale":              "ninguna sincronización correcta desde hace %s (SLA %s)",
+		"files_failed":       "%d archivo(s) con error",
+		"sync_failed":        "la sincronización falló: [%s] %s",
+		"recovered":          "sincronización restablecida",
+		"uploaded":           "%d archivo(s), %d bytes subidos en %s",
+		"digest_subject":     "Cambios de datos en %s: %s",
+		"digest_period":      "Cambios del %s al %s",
+		"digest_counts":      "%d nuevo(s), %d modificado(s), %d eliminado(s)",
+		"digest_none":        "Sin cambios.",
+		"digest_more":        "... y %d más",
+		"tag_total":          "%s: %d archivo(s), %d bytes",
+		"untagged":           "sin clasificar",
+		"kind.auth":          "inicio de sesión fallido",
+		"kind.permission":    "permiso denegado",
+		"kind.disk-full":     "disco lleno",
+		"kind.file-locked":   "archivo bloqueado",
+		"kind.name-invalid":  "nombre no válido",
+		"kind.not-found":     "no encontrado",
+		"kind.conflict":      "conflicto",
+		"kind.withheld":      "retenido",
+		"kind.held":          "retenido legal",
+		"kind.deferred":      "aplazado",
+		"kind.anomaly":       "anomalía",
+		"kind.canary":        "archivo testigo",
+		"kind.limit":         "límite alcanzado",
+		"kind.blackout":      "periodo de bloqueo",
+		"kind.busy":          "ocupado",
+		"kind.network":       "error de red",
+		"kind.transient":     "error temporal",
+		"kind.cancelled":     "cancelado",
+		"kind.other":         "otro error",
+	},
+}
+
+var lang = "en" // set once at startup, before any run
+
+// setLanguage picks the catalog from the language setting, or the OS
+// locale when it is empty. Unknown languages fall back to English.
+func setLanguage(want string) {
+	auto := want == ""
+	if auto { want = osLanguage() }
+	want = strings.ToLower(want)
+	if i := strings.IndexAny(want, "-_."); i >= 0 { want = want[:i] }
+	if _, ok := catalog[want]; ok {
+		lang = want
+		return
+	}
+	if !auto { log.Printf("language: no messages for %q, using English", want) }
+}
+
+func tr(key string, args ...interface{}) string {
+	f, ok := catalog[lang][key]
+	if !ok { f = catalog["en"][key] }
+	return fmt.Sprintf(f, args...)
+}
+
+func kindLabel(kind string) string { return tr("kind." + kind) }
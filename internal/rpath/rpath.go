@@ -0,0 +1,283 @@
+// Package rpath handles paths on a sync target. They always use forward
+// slashes, whatever OS the sync runs on. Local paths go through
+// path/filepath; anything sent to a target goes through here, so a Windows
+// build never puts a backslash into an FTP command. A backslash counts as
+// a separator too, since remote_path often comes from a Windows config
+// ("\exports\site").
+package rpath
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// ErrOutside is returned for a relative path that would leave its root.
+var ErrOutside = errors.New("path leaves the target directory")
+
+// ErrReserved is returned for a path element Windows cannot create.
+var ErrReserved = errors.New("reserved Windows device name")
+
+// Root is a target's remote_path. Every path sent to the target is
+// resolved against it, so all targets build paths the same way.
+type Root struct{ prefix, form string }
+
+// NewRoot returns the root for remote_path; "" is the login directory.
+// form is the normalize setting names are compared under.
+func NewRoot(prefix, form string) Root {
+	if prefix == "" { return Root{form: form} }
+	return Root{Clean(prefix), form}
+}
+
+// Same reports whether a name from a target listing is the file called
+// name: equal, or equal once both are normalized under the root's form.
+func (r Root) Same(listed, name string) bool {
+	return listed == name || r.form != "" && Normalize(listed, r.form) == Normalize(name, r.form)
+}
+
+func (r Root) String() string { return r.prefix }
+
+// Resolve maps rel, a slash path relative to the root such as the scan
+// produces, to the full remote path. rel must stay inside the root, see
+// Check.
+func (r Root) Resolve(rel string) (string, error) {
+	if err := Check(rel); err != nil { return "", err }
+	p := Join(r.prefix, rel)
+	if !Within(r.prefix, p) { return "", fmt.Errorf("%q: %w", rel, ErrOutside) }
+	return p, nil
+}
+
+// Check refuses a relative path that could end up outside its root or
+// that Windows cannot create. That covers absolute paths, ".." elements,
+// drive letters and NTFS streams (any ':'), and device names such as CON
+// or nul.txt.
+func Check(rel string) error {
+	s := slashes(rel)
+	if strings.HasPrefix(s, "/") { return fmt.Errorf("%q: %w", rel, ErrOutside) }
+	for _, e := range strings.Split(s, "/") {
+		switch {
+		case e == "..", strings.Contains(e, ":"):
+			return fmt.Errorf("%q: %w", rel, ErrOutside)
+		case Reserved(e):
+			return fmt.Errorf("%q: %w", rel, ErrReserved)
+		}
+	}
+	return nil
+}
+
+var devices = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true, "CONIN$": true, "CONOUT$": true}
+
+// Reserved reports whether name is a Windows device name. What counts is
+// the part before the first dot with trailing spaces removed, so "nul.txt"
+// and "COM1 .log" are reserved too.
+func Reserved(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	stem = strings.ToUpper(strings.TrimRight(stem, " "))
+	if devices[stem] { return true }
+	return len(stem) == 4 && (strings.HasPrefix(stem, "COM") || strings.HasPrefix(stem, "LPT")) && stem[3] >= '1' && stem[3] <= '9'
+}
+
+// Within reports whether p is root or lies below it. Both are clean slash
+// paths; an empty root is the login directory, so only relative paths
+// that do not climb out are inside it.
+func Within(root, p string) bool {
+	switch {
+	case root == "":
+		return p != ".." && !strings.HasPrefix(p, "../") && !strings.HasPrefix(p, "/")
+	case root == "/":
+		return strings.HasPrefix(p, "/")
+	}
+	return p == root || strings.HasPrefix(p, root+"/")
+}
+
+// Local maps rel, a slash path from a target listing, to a path under the
+// local directory root. It refuses anything Check refuses, so a hostile
+// listing cannot write outside root.
+func Local(root, rel string) (string, error) {
+	if err := Check(rel); err != nil { return "", err }
+	p := filepath.Join(root, filepath.FromSlash(slashes(rel)))
+	if r, err := filepath.Rel(root, p); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q: %w", rel, ErrOutside)
+	}
+	return p, nil
+}
+
+// Clean normalizes p: backslashes become slashes, duplicate and trailing
+// slashes, "." and "x/.." are removed. A leading slash is kept.
+func Clean(p string) string { return path.Clean(slashes(p)) }
+
+// Join joins the non-empty elements into one clean path. Like path.Join
+// it returns "" when all of them are empty.
+func Join(elem ...string) string {
+	for i, e := range elem { elem[i] = slashes(e) }
+	return path.Join(elem...)
+}
+
+// Dir returns everything but the last element of p, "." when there is none.
+func Dir(p string) string { return path.Dir(Clean(p)) }
+
+// Base returns the last element of p.
+func Base(p string) string { return path.Base(Clean(p)) }
+
+// Parents lists the directories leading to p, outermost first, for
+// creating them one by one: "/a/b/f" gives "/a", "/a/b".
+func Parents(p string) []string {
+	var dirs []string
+	for d := Dir(p); d != "." && d != "/"; d = path.Dir(d) { dirs = append(dirs, d) }
+	for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 { dirs[i], dirs[j] = dirs[j], dirs[i] }
+	return dirs
+}
+
+func slashes(p string) string { return strings.ReplaceAll(p, `\`, "/") }
+
+// Entry is the path of name, an entry of a target's listing of dir, and
+// false for an entry that is no file or folder of dir: "", "." and "..",
+// and names with a separator in them, which a hostile or broken server
+// could use to point outside dir.
+func Entry(dir, name string) (string, bool) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) { return "", false }
+	return Join(dir, name), true
+}
+
+// ────────── patterns ───────────────────────────────────────
+// Patterns pick files under local_dir for tags, hold and never_transfer.
+// One without a slash matches the file name, one with a slash the whole
+// path, where ** stands for zero or more folders. Callers fold case.
+
+// ValidPattern reports whether p is a pattern Match can use.
+func ValidPattern(p string) bool {
+	_, err := path.Match(p, "")
+	return err == nil
+}
+
+// Match reports whether the slash path rel matches pattern.
+func Match(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchParts(pat, parts []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchParts(pat[1:], parts[i:]) { return true }
+			}
+			return false
+		}
+		if len(parts) == 0 { return false }
+		if ok, _ := path.Match(pat[0], parts[0]); !ok { return false }
+		pat, parts = pat[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// ────────── Unicode normalization ──────────────────────────
+// macOS writes names decomposed (NFD: "e" plus a combining accent),
+// Windows and most servers composed (NFC). The two look identical but
+// compare different, so without a policy a file copied from a Mac share
+// is uploaded next to its twin or reported as a conflict.
+
+// Forms for the normalize setting; "" leaves names as they are.
+const (
+	NormNFC = "nfc"
+	NormNFD = "nfd"
+)
+
+// CheckForm validates the normalize setting.
+func CheckForm(form string) error {
+	switch form {
+	case "", NormNFC, NormNFD:
+		return nil
+	}
+	return fmt.Errorf("normalize: unknown form %q (use %s or %s)", form, NormNFC, NormNFD)
+}
+
+// Normalize returns p in form; "" returns p unchanged.
+func Normalize(p, form string) string {
+	switch form {
+	case NormNFC:
+		return norm.NFC.String(p)
+	case NormNFD:
+		return norm.NFD.String(p)
+	}
+	return p
+}
+
+// ────────── local name mapping ────────────────────────────
+// A listing from a Unix server can hold names Windows cannot create
+// (CON, "a:b", "x." with a trailing dot). Creating them fails or leaves
+// files Explorer cannot delete, so pulled names go through MapName first.
+
+// Name mapping schemes.
+const (
+	MapReplace = "replace" // invalid characters become '_', device names and trailing dots/spaces get a '_'
+	MapPercent = "percent" // invalid characters, '%' and what makes a name reserved become %XX, reversibly
+)
+
+const invalidChars = `<>:"/|?*\`
+
+// ValidName reports whether Windows can create a file called name.
+func ValidName(name string) bool {
+	if name == "" || Reserved(name) || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") { return false }
+	for _, r := range name {
+		if r < 0x20 || strings.ContainsRune(invalidChars, r) { return false }
+	}
+	return true
+}
+
+// MapName returns name unchanged when Windows can create it, and its
+// mapping under scheme otherwise. Under percent a '%' is escaped even in
+// a name Windows can create, so "a%3A" does not come out as "a:" does.
+// An unknown scheme is an error, so a typo in the config does not go
+// unnoticed.
+func MapName(name, scheme string) (string, error) {
+	if scheme != MapReplace && scheme != MapPercent {
+		return "", fmt.Errorf("unknown name mapping %q (use %s or %s)", scheme, MapReplace, MapPercent)
+	}
+	if ValidName(name) && (scheme == MapReplace || !strings.Contains(name, "%")) { return name, nil }
+	var b strings.Builder
+	esc := func(r rune) {
+		if scheme == MapPercent { fmt.Fprintf(&b, "%%%02X", r) } else { b.WriteByte('_') }
+	}
+	trimmed := strings.TrimRight(name, ". ")
+	for i, r := range trimmed {
+		switch {
+		case r < 0x20, strings.ContainsRune(invalidChars, r), scheme == MapPercent && r == '%':
+			esc(r)
+		case i == 0 && scheme == MapPercent && Reserved(trimmed):
+			esc(r) // "CON" becomes "%43ON"
+		default:
+			_, n := utf8.DecodeRuneInString(trimmed[i:])
+			b.WriteString(trimmed[i : i+n]) // as it was, even where it is not UTF-8
+		}
+	}
+	for _, r := range name[len(trimmed):] { esc(r) }
+	out := b.String()
+	if scheme == MapReplace && (out == "" || Reserved(out)) { out = "_" + out }
+	return out, nil
+}
+
+// UnmapName is the name MapName mapped to name under percent.
+func UnmapName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) {
+			if c, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
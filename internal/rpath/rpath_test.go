@@ -0,0 +1,181 @@
+package rpath
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	for _, c := range []struct{ prefix, rel, want string }{
+		{"/exports", "a/b.txt", "/exports/a/b.txt"},
+		{`\exports\site`, `sub\f.txt`, "/exports/site/sub/f.txt"},
+		{`exports\site\`, "f.txt", "exports/site/f.txt"},
+		{"/", "f.txt", "/f.txt"},
+		{"", "a/./b", "a/b"},
+		{"", "", ""},
+		{"/exports", "", "/exports"},
+	} {
+		got, err := NewRoot(c.prefix, "").Resolve(c.rel)
+		if err != nil || got != c.want { t.Errorf("Resolve(%q, %q) = %q, %v; want %q", c.prefix, c.rel, got, err, c.want) }
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, c := range []struct {
+		rel  string
+		want error
+	}{
+		{"a/b.txt", nil},
+		{`a\b.txt`, nil},
+		{"..", ErrOutside},
+		{"../x", ErrOutside},
+		{`..\x`, ErrOutside},
+		{`a\..\..\x`, ErrOutside},
+		{"/etc/passwd", ErrOutside},
+		{`\Windows\win.ini`, ErrOutside},
+		{"C:/x", ErrOutside},
+		{`C:\x`, ErrOutside},
+		{"C:x", ErrOutside},
+		{`\\server\share\x`, ErrOutside},
+		{"a/b.txt:stream", ErrOutside},
+		{"CON", ErrReserved},
+		{"sub/nul.txt", ErrReserved},
+		{"COM1 .log", ErrReserved},
+		{"COM0", nil},
+		{"console.txt", nil},
+	} {
+		if err := Check(c.rel); !errors.Is(err, c.want) { t.Errorf("Check(%q) = %v, want %v", c.rel, err, c.want) }
+	}
+}
+
+func TestLocal(t *testing.T) {
+	root := t.TempDir()
+	for _, rel := range []string{"a/b.txt", `a\b.txt`, "."} {
+		p, err := Local(root, rel)
+		if err != nil || !strings.HasPrefix(p, root) { t.Errorf("Local(%q) = %q, %v", rel, p, err) }
+	}
+	if p, _ := Local(root, `a\b.txt`); p != filepath.Join(root, "a", "b.txt") { t.Errorf(`Local("a\\b.txt") = %q`, p) }
+	for _, rel := range []string{"../x", `..\x`, "/x", `\x`, `C:\x`, "C:x", "a/../../x"} {
+		if p, err := Local(root, rel); !errors.Is(err, ErrOutside) { t.Errorf("Local(%q) = %q, %v; want ErrOutside", rel, p, err) }
+	}
+}
+
+func TestPaths(t *testing.T) {
+	for in, want := range map[string]string{`a\b\`: "a/b", `\a\\b`: "/a/b", "a/./b/../c": "a/c", "": "."} {
+		if got := Clean(in); got != want { t.Errorf("Clean(%q) = %q, want %q", in, got, want) }
+	}
+	if got := Join(`\exports`, "", `a\b`); got != "/exports/a/b" { t.Errorf("Join = %q", got) }
+	if got := strings.Join(Parents(`\a\b\f.txt`), " "); got != "/a /a/b" { t.Errorf("Parents = %q", got) }
+	if got := Dir("f.txt"); got != "." { t.Errorf("Dir = %q", got) }
+	for _, c := range []struct{ root, p string; want bool }{
+		{"", "a", true}, {"", "..", false}, {"", "../a", false}, {"", "/a", false},
+		{"/", "/a", true}, {"/", "a", false},
+		{"/ex", "/ex", true}, {"/ex", "/ex/a", true}, {"/ex", "/exports", false},
+	} {
+		if got := Within(c.root, c.p); got != c.want { t.Errorf("Within(%q, %q) = %v", c.root, c.p, got) }
+	}
+}
+
+func TestEntry(t *testing.T) {
+	if p, ok := Entry("/ex/sub", "f.txt"); !ok || p != "/ex/sub/f.txt" { t.Errorf("Entry = %q, %v", p, ok) }
+	for _, name := range []string{"", ".", "..", "../x", `..\x`, "a/b", `a\b`} {
+		if p, ok := Entry("sub", name); ok { t.Errorf("Entry(%q) = %q, want refused", name, p) }
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, c := range []struct{ pat, rel string; want bool }{
+		{"*.dcm", "scans/2026/a.dcm", true},
+		{"*.dcm", "a.dcm.bak", false},
+		{"ledger/**", "ledger/2026/q1.xlsx", true},
+		{"ledger/**", "ledger", true},
+		{"ledger/**", "old/ledger/q1.xlsx", false},
+		{"**/tmp/*", "tmp/x", true},
+		{"**/tmp/*", "a/b/tmp/x", true},
+		{"**/tmp/*", "a/b/tmp/x/y", false},
+		{"a/*/c", "a/b/c", true},
+		{"a/*/c", "a/b/b/c", false},
+	} {
+		if got := Match(c.pat, c.rel); got != c.want { t.Errorf("Match(%q, %q) = %v", c.pat, c.rel, got) }
+	}
+	if ValidPattern("[a") { t.Error(`ValidPattern("[a") = true`) }
+}
+
+func TestMapName(t *testing.T) {
+	for _, c := range []struct{ name, scheme, want string }{
+		{"ok.txt", MapReplace, "ok.txt"},
+		{"a:b", MapReplace, "a_b"},
+		{"CON", MapReplace, "_CON"},
+		{"x.", MapReplace, "x_"},
+		{"a/b", MapReplace, "a_b"},
+		{"a:b", MapPercent, "a%3Ab"},
+		{"CON", MapPercent, "%43ON"},
+		{"100%.", MapPercent, "100%25%2E"},
+		{"a%3A", MapPercent, "a%253A"},
+		{"a%3A", MapReplace, "a%3A"},
+	} {
+		if got, err := MapName(c.name, c.scheme); err != nil || got != c.want { t.Errorf("MapName(%q, %s) = %q, %v; want %q", c.name, c.scheme, got, err, c.want) }
+	}
+	if _, err := MapName("a", "typo"); err == nil { t.Error("MapName with an unknown scheme did not fail") }
+	for _, name := range []string{"a:b", "a%3Ab", "CON", "x. ", "100%", "%"} {
+		if m, _ := MapName(name, MapPercent); UnmapName(m) != name { t.Errorf("UnmapName(%q) = %q, want %q", m, UnmapName(m), name) }
+	}
+}
+
+// ────────── fuzz targets ───────────────────────────────────
+// go test ./internal/rpath -fuzz FuzzCheck (one target at a time)
+
+var seeds = []string{"a/b.txt", `a\b`, "..", "../x", `..\..\x`, "/abs", `\abs`, `C:\x`, "C:x", `\\srv\share`, "a/b:s", "CON", "nul.txt", ".", "", "a/./b/../../..", "é/ﬁ"}
+
+func FuzzCheck(f *testing.F) {
+	for _, s := range seeds { f.Add(s) }
+	f.Fuzz(func(t *testing.T, rel string) {
+		if Check(rel) != nil { return }
+		for _, prefix := range []string{"", "/", "/exports", `\exports\site`, "exports"} {
+			r := NewRoot(prefix, "")
+			p, err := r.Resolve(rel)
+			if err != nil { t.Fatalf("Check(%q) passed but Resolve under %q failed: %v", rel, prefix, err) }
+			if !Within(r.String(), p) || strings.Contains(p, `\`) { t.Fatalf("Resolve(%q) under %q = %q, outside the prefix", rel, prefix, p) }
+		}
+	})
+}
+
+func FuzzLocal(f *testing.F) {
+	for _, s := range seeds { f.Add(s) }
+	root := f.TempDir()
+	f.Fuzz(func(t *testing.T, rel string) {
+		p, err := Local(root, rel)
+		if err != nil { return }
+		r, err := filepath.Rel(root, p)
+		if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) || filepath.IsAbs(r) || !strings.HasPrefix(p, root) {
+			t.Fatalf("Local(%q) = %q, outside %s", rel, p, root)
+		}
+	})
+}
+
+func FuzzMapName(f *testing.F) {
+	for _, s := range seeds { f.Add(s) }
+	f.Add("x. ")
+	f.Add("COM1.txt")
+	f.Add("100%")
+	f.Add("a%3A")
+	f.Add("\xf4 ")
+	f.Fuzz(func(t *testing.T, name string) {
+		if name == "" { return }
+		for _, scheme := range []string{MapReplace, MapPercent} {
+			out, err := MapName(name, scheme)
+			if err != nil { t.Fatal(err) }
+			if !ValidName(out) || strings.ContainsAny(out, `/\`) { t.Fatalf("MapName(%q, %s) = %q, which Windows cannot create", name, scheme, out) }
+			switch {
+			case scheme == MapPercent:
+				if back := UnmapName(out); back != name { t.Fatalf("MapName(%q, percent) = %q, which unmaps to %q", name, out, back) }
+				if ValidName(name) && !strings.Contains(name, "%") && out != name { t.Fatalf("MapName changed the valid name %q to %q", name, out) }
+			default:
+				if again, _ := MapName(out, scheme); again != out { t.Fatalf("MapName(%q, %s) = %q, but that maps on to %q", name, scheme, out, again) }
+				if ValidName(name) && out != name { t.Fatalf("MapName changed the valid name %q to %q", name, out) }
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testInventory() *inventory {
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	return &inventory{Root: `D:\exports`, Host: "lab3", Created: at, Files: []invEntry{{Path: "a/b.txt", Size: 3, MTime: at, SHA256: "ab12"}}}
+}
+
+func TestInventorySignature(t *testing.T) {
+	key := []byte("site key")
+	inv := testInventory()
+	inv.sign(key)
+	if err := inv.verify(key); err != nil { t.Fatal(err) }
+	if err := inv.verify([]byte("other key")); err == nil { t.Error("verified with the wrong key") }
+	inv.Files[0].Size = 4
+	if err := inv.verify(key); err == nil { t.Error("verified a modified inventory") }
+	if err := testInventory().verify(key); err == nil { t.Error("verified an unsigned inventory") }
+}
+
+func TestInventoryWriteLoad(t *testing.T) {
+	key := []byte("site key")
+	inv := testInventory()
+	inv.sign(key)
+	p := filepath.Join(t.TempDir(), "inv.json")
+	if err := inv.write(p); err != nil { t.Fatal(err) }
+	got, err := loadInventory(p)
+	if err != nil { t.Fatal(err) }
+	if err = got.verify(key); err != nil { t.Errorf("written and loaded: %v", err) }
+}
+
+func TestBuildInventory(t *testing.T) {
+	root := t.TempDir()
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil { t.Fatal(err) }
+	for rel, body := range map[string]string{"a.txt": "abc", "sub/b.txt": ""} {
+		p := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.WriteFile(p, []byte(body), 0644); err != nil { t.Fatal(err) }
+		os.Chtimes(p, at, at)
+	}
+	inv, err := buildInventory(root)
+	if err != nil { t.Fatal(err) }
+	if len(inv.Files) != 2 { t.Fatalf("%d files, want 2", len(inv.Files)) }
+	for _, e := range inv.Files {
+		switch e.Path {
+		case "a.txt":
+			if e.Size != 3 || e.SHA256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" || !e.MTime.Equal(at) { t.Errorf("a.txt: %+v", e) }
+		case "sub/b.txt":
+			if e.SHA256 != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" { t.Errorf("sub/b.txt: %+v", e) }
+		default:
+			t.Errorf("unexpected path %q", e.Path)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ────────── jobs side by side ──────────────────────────────
+// One server can run the deployments of several teams, each from its own
+// config. Nothing of one may touch another's: job names the deployment,
+// and with it
+//   - a run takes a lock for its job before it reads the state file,
+//     <state_file>.lock (else run.lock in the job's temp folder), so a
+//     second run of the same job, or another config pointed at the same
+//     state file by mistake, stops as kind busy instead of both writing
+//     it; the lock file says which process and job hold it
+//   - temp files (the canary, rsync downloads) go to datasync-<job> in
+//     the system temp folder, readable by the job's account only
+//   - events without events.subject or events.topic go out under the job
+//     when site is not set, and the MQTT client ID carries it, so two
+//     jobs do not kick each other off the broker
+// log_file appends everything a run prints, with the status lines stamped
+// like log lines, so each job keeps its own log whatever the scheduler
+// does with the output. smb.drive picks the letter smb.mode "netuse"
+// maps (default Z:), which must differ between jobs that use net use
+// under one account.
+var jobName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// tempDir is where temp files go; "" for the system default.
+var tempDir string
+
+// setupJob checks job and log_file and sets the process up for them,
+// before anything is printed.
+func setupJob(c *Conf) error {
+	if c.Job != "" {
+		if !jobName.MatchString(c.Job) { return fmt.Errorf("job: %q is not a name of letters, digits, '.', '_' and '-'", c.Job) }
+		tempDir = filepath.Join(os.TempDir(), "datasync-"+c.Job)
+		if err := os.MkdirAll(tempDir, 0700); err != nil { return fmt.Errorf("job: %w", err) }
+	}
+	if c.LogFile != "" {
+		f, err := os.OpenFile(c.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil { return fmt.Errorf("log_file: %w", err) }
+		logFile = f
+	}
+	return nil
+}
+
+// instance is what the events of a run go out under, when not configured.
+func (c *Conf) instance() string {
+	switch {
+	case c.Site != "":
+		return c.Site
+	case c.Job != "":
+		return c.Job
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+// lockPath is the lock a run of c takes, "" for none: without state_file
+// and job there is nothing to keep apart.
+func (c *Conf) lockPath() string {
+	switch {
+	case c.StateFile != "":
+		return c.StateFile + ".lock"
+	case c.Job != "":
+		return filepath.Join(tempDir, "run.lock")
+	}
+	return ""
+}
+
+// lockJob takes the job's lock for the run; calling what it returns
+// gives it back. A lock held elsewhere is ErrBusy, naming the holder.
+func lockJob(c *Conf) (func(), error) {
+	p := c.lockPath()
+	if p == "" { return func() {}, nil }
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil { return nil, fmt.Errorf("lock %s: %w", p, err) }
+	if err = lockFile(f); err != nil {
+		holder, _ := io.ReadAll(io.LimitReader(f, 256))
+		f.Close()
+		if err == errLockHeld {
+			who := strings.TrimSpace(string(holder))
+			if who == "" { who = "another process" }
+			return nil, withClass(ErrBusy, fmt.Errorf("%s is held by %s", p, who))
+		}
+		return nil, fmt.Errorf("lock %s: %w", p, err)
+	}
+	f.Truncate(0)
+	fmt.Fprintf(f, "pid %d, job %q, since %s\n", os.Getpid(), c.Job, time.Now().Format(time.RFC3339))
+	debugf(catTransfer, "lock: %s", p)
+	return func() {
+		f.Truncate(0)
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// osLanguage returns the POSIX message locale, e.g. "de_DE.UTF-8".
+func osLanguage() string {
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if l := os.Getenv(v); l != "" && l != "C" && l != "POSIX" { return l }
+	}
+	return ""
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetUserDefaultLocaleName = syscall.NewLazyDLL("kernel32.dll").NewProc("GetUserDefaultLocaleName")
+
+// osLanguage returns the user's locale name, e.g. "de-DE".
+func osLanguage() string {
+	var buf [85]uint16 // LOCALE_NAME_MAX_LENGTH
+	if r, _, _ := procGetUserDefaultLocaleName.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))); r == 0 { return "" }
+	return syscall.UTF16ToString(buf[:])
+}
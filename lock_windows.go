@@ -0,0 +1,41 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procLockFileEx   = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+	procUnlockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("UnlockFileEx")
+)
+
+var errLockHeld = errors.New("lock held")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = 33
+)
+
+// lockFile locks one byte of f at 4 GiB, past what the file holds, so
+// the holder's note in it stays readable; Windows drops the lock when
+// the process ends.
+func lockFile(f *os.File) error {
+	var ov syscall.Overlapped
+	ov.OffsetHigh = 1
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ov)))
+	if r != 0 { return nil }
+	if errors.Is(err, syscall.Errno(errorLockViolation)) { return errLockHeld }
+	return err
+}
+
+func unlockFile(f *os.File) {
+	var ov syscall.Overlapped
+	ov.OffsetHigh = 1
+	procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ov)))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ────────── log levels ─────────────────────────────────────
+// Every category has its own level, so one misbehaving server can be
+// traced without 300k lines of per-file noise. -q keeps only failures and
+// the final line, and -v raises every category to debug. The `log` setting
+// (or -log) sets levels per category, e.g. "protocol=trace" or
+// "scan=error,compare=debug". A bare level applies to all categories.
+type level int
+
+const (
+	lvlError level = iota // failures, warnings and the final line
+	lvlInfo               // plus one line per uploaded file (default)
+	lvlDebug              // plus decisions, timings and retries
+	lvlTrace              // plus every file seen and every FTP command and reply
+)
+
+const (
+	catScan     = "scan"     // walking local_dir
+	catCompare  = "compare"  // deciding what to upload
+	catTransfer = "transfer" // uploads, retries, worker limit
+	catProtocol = "protocol" // target sessions: FTP commands, net use
+)
+
+var levelNames = map[string]level{"error": lvlError, "quiet": lvlError, "info": lvlInfo, "debug": lvlDebug, "trace": lvlTrace}
+
+var logLevels = map[string]level{catScan: lvlInfo, catCompare: lvlInfo, catTransfer: lvlInfo, catProtocol: lvlInfo}
+
+// setLogLevels applies -q/-v and then the per-category spec. It runs once
+// at startup, before anything logs.
+func setLogLevels(spec string, quiet, verbose bool) error {
+	all := func(l level) { for c := range logLevels { logLevels[c] = l } }
+	if quiet { all(lvlError) }
+	if verbose { all(lvlDebug) }
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" { continue }
+		cat, name, found := strings.Cut(part, "=")
+		if !found { cat, name = "", cat }
+		l, ok := levelNames[strings.ToLower(name)]
+		if !ok { return fmt.Errorf("log: unknown level %q (use error, info, debug or trace)", name) }
+		if cat == "" { all(l); continue }
+		if _, ok := logLevels[cat]; !ok { return fmt.Errorf("log: unknown category %q (use scan, compare, transfer or protocol)", cat) }
+		logLevels[cat] = l
+	}
+	return nil
+}
+
+func logOn(cat string, l level) bool { return logLevels[cat] >= l }
+
+func debugf(cat, format string, args ...interface{}) {
+	if logOn(cat, lvlDebug) { log.Printf("["+cat+"] "+format, args...) }
+}
+
+func tracef(cat, format string, args ...interface{}) {
+	if logOn(cat, lvlTrace) { log.Printf("["+cat+"] "+format, args...) }
+}
+
+// ftpTrace receives the FTP control channel and listings from the client
+// library and logs them line by line, with the password masked. Sessions
+// are numbered so the interleaved output of parallel workers can be told
+// apart.
+var ftpSessions atomic.Int64
+
+type ftpTrace struct {
+	host string
+	mu   sync.Mutex
+	buf  []byte
+}
+
+func (t *ftpTrace) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	for {
+		i := bytes.IndexByte(t.buf, '\n')
+		if i < 0 { break }
+		line := strings.TrimRight(string(t.buf[:i]), "\r")
+		t.buf = t.buf[i+1:]
+		if len(line) >= 5 && strings.EqualFold(line[:5], "PASS ") { line = "PASS ****" }
+		log.Printf("[%s] %s %s", catProtocol, t.host, line)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── mirror ─────────────────────────────────────────
+// With mirror set, a push also deletes what the target holds under the
+// site but local_dir no longer does: files first, then folders left with
+// nothing local_dir has. It runs after the uploads, on a fresh listing,
+// and only when they all went through. The canary file, the state_mirror
+// copy and anything the hold list covers stay. As a wrong local_dir or an
+// unmounted share would make everything stale, nothing is deleted unless
+// the run has -delete or the count is within max_delete; a run with more
+// than that stops with nothing deleted, and one with neither only says
+// how many are stale. A local_dir without a single file deletes nothing,
+// -delete or not.
+// Deletions are snapshot like any other change to the target. With
+// trash_dir they are moves into the trash instead, see trash.go.
+
+// checkMirror rejects settings mirror cannot work with.
+func checkMirror(c *Conf) error {
+	switch {
+	case !c.Mirror:
+		if c.MaxDelete != 0 { return fmt.Errorf("max_delete is for mirror") }
+		return nil
+	case c.MaxDelete < 0:
+		return fmt.Errorf("max_delete: %d is negative", c.MaxDelete)
+	case c.Direction == "pull", c.Direction == "both":
+		return fmt.Errorf("mirror is for a push; direction %s decides deletions itself", c.Direction)
+	case c.WriteOnce:
+		return fmt.Errorf("mirror cannot be used with write_once, which never deletes")
+	}
+	return nil
+}
+
+// staleTree is what mirror would delete, deepest folders last.
+type staleTree struct {
+	files, dirs []string
+	held        int
+}
+
+func (r *run) mirror(ctx context.Context, dial func() (target, error)) error {
+	t, err := dial()
+	if err != nil { return err }
+	defer t.close()
+	l, ok := listerOf(t)
+	if !ok { return fmt.Errorf("mirror: %s targets cannot be listed", r.conf.Type) }
+	rt := t
+	if sc, shared := t.(sharedConn); shared { rt = sc.target }
+	rm, ok := rt.(remover)
+	if !ok { return fmt.Errorf("mirror: %s targets cannot delete files", r.conf.Type) }
+	if r.trash != nil {
+		if _, ok := rt.(renamer); !ok { return fmt.Errorf("trash_dir: %s targets cannot move files", r.conf.Type) }
+	}
+	if err = r.purgeTrash(ctx, t, l, rm); err != nil { return err }
+
+	locals, err := r.scanLocal(ctx)
+	if err != nil { return err }
+	var st staleTree
+	if _, err = r.stale(ctx, l, r.conf.Site, locals, &st); err != nil { return err }
+	if st.held > 0 { debugf(catCompare, "mirror: %d stale file(s) kept by the hold list", st.held) }
+	n := len(st.files)
+	switch {
+	case n == 0:
+		return nil
+	case len(locals) == 0:
+		return fmt.Errorf("mirror: local_dir holds no files, the target %d; nothing was deleted (is local_dir right?)", n)
+	case r.opts.delete:
+	case r.conf.MaxDelete == 0:
+		say("!", "%s", tr("mirror_pending", n))
+		return nil
+	case n > r.conf.MaxDelete:
+		return fmt.Errorf("mirror: %d file(s) on the target are gone from local_dir, more than max_delete (%d); nothing was deleted, run with -delete to delete them", n, r.conf.MaxDelete)
+	}
+	if r.opts.dryRun {
+		for _, rel := range st.files { r.wouldRemove(rel, false) }
+		return nil
+	}
+	if err = r.snapshot.before(ctx, t); err != nil { return err }
+	for _, rel := range st.files {
+		if ctx.Err() != nil { return nil }
+		if r.trash != nil {
+			if err := r.toTrash(ctx, t, rm, rel); err != nil { r.skip(rel, err) }
+			continue
+		}
+		debugf(catCompare, "%s: not in local_dir, deleted from the target", rel)
+		if err := rm.remove(ctx, rel); err != nil && !errors.Is(err, ErrNotFound) {
+			r.skip(rel, err)
+			continue
+		}
+		r.removed(rel, false)
+	}
+	for _, dir := range st.dirs {
+		if ctx.Err() != nil { return nil }
+		// a folder something failed to leave is not empty; it goes next run
+		if err := rm.removeDir(ctx, dir); err != nil { debugf(catCompare, "mirror: folder %s stays: %v", dir, err) }
+	}
+	return nil
+}
+
+// stale collects under dir what local_dir no longer has and reports
+// whether dir would be left empty.
+func (r *run) stale(ctx context.Context, l lister, dir string, locals map[string]job, st *staleTree) (bool, error) {
+	if err := ctx.Err(); err != nil { return false, err }
+	files, dirs, err := l.list(ctx, dir)
+	if err != nil { return false, fmt.Errorf("listing %q on the target: %w", dir, err) }
+	sort.Slice(files, func(i, j int) bool { return files[i].Rel < files[j].Rel })
+	sort.Strings(dirs)
+	canary := r.conf.Canary
+	if canary != "" && r.conf.Site != "" { canary = r.conf.Site + "/" + canary }
+	kept := r.conf.stateMirrorRel()
+	empty := true
+	for _, f := range files {
+		_, here := locals[f.Rel]
+		switch {
+		case here, canary != "" && f.Rel == canary, kept != "" && f.Rel == kept:
+			empty = false
+		case r.hold.covers(r.under(f.Rel)):
+			st.held++
+			empty = false
+		case r.backups.covers(r.under(f.Rel)):
+			empty = false
+		default:
+			st.files = append(st.files, f.Rel)
+		}
+	}
+	for _, d := range dirs {
+		sub := rpath.Join(dir, d)
+		if r.trashCovers(sub) { empty = false; continue }
+		gone, err := r.stale(ctx, l, sub, locals, st)
+		if err != nil { return false, err }
+		if !gone || r.localDir(sub) {
+			empty = false
+			continue
+		}
+		st.dirs = append(st.dirs, sub)
+	}
+	return empty, nil
+}
+
+// under is rel below the site, as local_dir would hold it.
+func (r *run) under(rel string) string {
+	if r.conf.Site == "" { return rel }
+	return strings.TrimPrefix(rel, r.conf.Site+"/")
+}
+
+// localDir reports whether local_dir has a folder at the target path dir.
+func (r *run) localDir(dir string) bool {
+	p, err := rpath.Local(r.conf.LocalDir, r.under(dir))
+	if err != nil { return false }
+	fi, err := os.Stat(p)
+	return err == nil && fi.IsDir()
+}
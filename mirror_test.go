@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newMirror is a push with mirror on, allowed to delete up to 10 files.
+func newMirror(t *testing.T) *twoWay {
+	w := newTwoWay(t)
+	w.conf.Direction, w.conf.Mirror, w.conf.MaxDelete = "", true, 10
+	return w
+}
+
+func TestMirrorAfterFailedUpload(t *testing.T) {
+	w := newMirror(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "keep.txt", "k", then)
+	put(t, w.here, "f/x:y.txt", "x", then) // a name the target refuses
+	put(t, w.there, "f/old.txt", "o", then)
+	put(t, w.there, "stale.txt", "s", then)
+	if s := w.sync(t); s.Failed == 0 || s.Deleted != 0 { t.Errorf("%d failed, %d deleted; want a failure and no deletion", s.Failed, s.Deleted) }
+	for _, rel := range []string{"f/old.txt", "stale.txt"} {
+		if body(w.there, rel) == "" { t.Errorf("%s deleted although an upload failed", rel) }
+	}
+}
+
+func TestMirror(t *testing.T) {
+	w := newMirror(t)
+	w.conf.Hold = HoldConf{Patterns: []string{"held/**"}}
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "keep.txt", "k", then)
+	put(t, w.here, "sub/keep.txt", "k", then)
+	os.MkdirAll(filepath.Join(w.here, "empty"), 0755)
+	for _, rel := range []string{"stale.txt", "sub/stale.txt", "gone/a.txt", "gone/deep/b.txt", "held/c.txt", "empty/d.txt"} { put(t, w.there, rel, "s", then) }
+	s := w.sync(t)
+	if s.Uploaded != 2 || s.Deleted != 5 { t.Errorf("%d up, %d deleted; want 2, 5", s.Uploaded, s.Deleted) }
+	for rel, want := range map[string]string{"keep.txt": "k", "sub/keep.txt": "k", "stale.txt": "", "sub/stale.txt": "", "gone/a.txt": "", "gone/deep/b.txt": "", "held/c.txt": "s", "empty/d.txt": ""} {
+		if got := body(w.there, rel); got != want { t.Errorf("%s on the target = %q, want %q", rel, got, want) }
+	}
+	if _, err := os.Stat(filepath.Join(w.there, "gone")); err == nil { t.Error("folder gone/ left on the target") }
+	if fi, err := os.Stat(filepath.Join(w.there, "empty")); err != nil || !fi.IsDir() { t.Error("folder empty/, still in local_dir, deleted from the target") }
+	if s = w.sync(t); s.Deleted != 0 { t.Errorf("second run deleted %d, want 0", s.Deleted) }
+}
+
+func TestMirrorMaxDelete(t *testing.T) {
+	w := newMirror(t)
+	w.conf.MaxDelete = 2
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "keep.txt", "k", then)
+	for _, rel := range []string{"a.txt", "b.txt", "c.txt"} { put(t, w.there, rel, "s", then) }
+	p := &summed{}
+	if code := runSync(context.Background(), w.conf, runOpts{progress: p}); code == 0 || p.sum.Err == nil { t.Errorf("3 stale files over max_delete 2: exit %d", code) }
+	if p.sum.Deleted != 0 || len(names(t, w.there)) != 4 { t.Errorf("over max_delete: %d deleted, target holds %v", p.sum.Deleted, names(t, w.there)) }
+
+	// dry run with -delete: reported, not deleted
+	p = &summed{}
+	runSync(context.Background(), w.conf, runOpts{progress: p, delete: true, dryRun: true})
+	if len(names(t, w.there)) != 4 { t.Errorf("dry run deleted: target holds %v", names(t, w.there)) }
+
+	p = &summed{}
+	runSync(context.Background(), w.conf, runOpts{progress: p, delete: true})
+	if p.sum.Err != nil || p.sum.Deleted != 3 { t.Errorf("-delete: %d deleted, %v; want 3", p.sum.Deleted, p.sum.Err) }
+	if got := names(t, w.there); len(got) != 1 || got[0] != "keep.txt" { t.Errorf("-delete: target holds %v", got) }
+}
+
+func TestMirrorPending(t *testing.T) {
+	w := newMirror(t)
+	w.conf.MaxDelete = 0
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "keep.txt", "k", then)
+	put(t, w.there, "stale.txt", "s", then)
+	if s := w.sync(t); s.Deleted != 0 || body(w.there, "stale.txt") != "s" { t.Errorf("without max_delete or -delete: %d deleted, want only a report", s.Deleted) }
+}
+
+func TestMirrorEmptyLocal(t *testing.T) {
+	w := newMirror(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.there, "a.txt", "a", then)
+	for _, del := range []bool{false, true} {
+		p := &summed{}
+		if code := runSync(context.Background(), w.conf, runOpts{progress: p, delete: del}); code == 0 || p.sum.Err == nil { t.Errorf("-delete %v: an empty local_dir passed (exit %d)", del, code) }
+		if body(w.there, "a.txt") != "a" { t.Fatalf("-delete %v: a.txt deleted from the target of an empty local_dir", del) }
+	}
+}
+
+func TestCheckMirror(t *testing.T) {
+	for _, c := range []Conf{
+		{MaxDelete: 5},
+		{Mirror: true, MaxDelete: -1},
+		{Mirror: true, Direction: "pull"},
+		{Mirror: true, Direction: "both"},
+		{Mirror: true, WriteOnce: true},
+	} {
+		if err := checkMirror(&c); err == nil { t.Errorf("%+v accepted", c) }
+	}
+	if err := checkMirror(&Conf{Mirror: true, MaxDelete: 5}); err != nil { t.Error(err) }
+}
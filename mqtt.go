@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ────────── MQTT event sink ────────────────────────────────
+// mqttSink speaks just enough MQTT 3.1.1 (QoS 0) for plant monitoring:
+//
+//   <topic>/status    retained "online" while connected; the broker sets
+//                     "offline" through the last will if we vanish
+//   <topic>/events    every event as JSON
+//   <topic>/last_run  retained run_completed event
+//
+// A dropped connection is redialled on the next event.
+type mqttSink struct {
+	addr, user, pass, topic, clientID string
+
+	mu   sync.Mutex // guards conn; writes come from send and the keepalive
+	conn net.Conn
+	stop chan struct{}
+}
+
+const mqttKeepAlive = 60 * time.Second
+
+func newMQTTSink(raw, topic, clientID string) (*mqttSink, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "mqtt" || u.Host == "" {
+		return nil, fmt.Errorf("events.mqtt: %q is not a mqtt://host:port URL", raw)
+	}
+	m := &mqttSink{addr: u.Host, topic: topic, clientID: clientID}
+	if u.Port() == "" { m.addr = net.JoinHostPort(u.Hostname(), "1883") }
+	if u.User != nil { m.user = u.User.Username(); m.pass, _ = u.User.Password() }
+	return m, nil
+}
+
+func mqttString(b []byte, s string) []byte { return append(append(b, byte(len(s)>>8), byte(len(s))), s...) }
+
+// mqttPacket frames a control packet: type byte, varint remaining length, body.
+func mqttPacket(typ byte, body []byte) []byte {
+	p := []byte{typ}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 { b |= 0x80 }
+		p = append(p, b)
+		if n == 0 { break }
+	}
+	return append(p, body...)
+}
+
+func mqttPublish(topic string, payload []byte, retain bool) []byte {
+	typ := byte(0x30)
+	if retain { typ |= 1 }
+	return mqttPacket(typ, append(mqttString(nil, topic), payload...))
+}
+
+func (m *mqttSink) dial() error {
+	c, err := net.DialTimeout("tcp", m.addr, 10*time.Second)
+	if err != nil { return err }
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	flags := byte(0x02 | 0x04 | 0x20) // clean session, will, will retain
+	if m.user != "" { flags |= 0x80 | 0x40 }
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4, flags, byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second))
+	body = mqttString(body, m.clientID)
+	body = mqttString(body, m.topic+"/status")
+	body = mqttString(body, "offline")
+	if m.user != "" { body = mqttString(mqttString(body, m.user), m.pass) }
+	if _, err = c.Write(mqttPacket(0x10, body)); err != nil { c.Close(); return err }
+
+	br := bufio.NewReader(c)
+	var ack [4]byte
+	if _, err = io.ReadFull(br, ack[:]); err != nil { c.Close(); return err }
+	if ack[0] != 0x20 { c.Close(); return fmt.Errorf("%s does not look like an MQTT broker", m.addr) }
+	switch ack[3] {
+	case 0:
+	case 4, 5:
+		c.Close(); return fmt.Errorf("%s refused the login (code %d)", m.addr, ack[3])
+	default:
+		c.Close(); return fmt.Errorf("%s refused the connection (code %d)", m.addr, ack[3])
+	}
+	if _, err = c.Write(mqttPublish(m.topic+"/status", []byte("online"), true)); err != nil { c.Close(); return err }
+	c.SetDeadline(time.Time{})
+	m.conn, m.stop = c, make(chan struct{})
+	go m.keepAlive(c, m.stop)
+	go io.Copy(io.Discard, br) // PINGRESPs
+	return nil
+}
+
+// keepAlive pings well inside the keepalive interval, so a long upload
+// with no events does not make the broker declare us dead.
+func (m *mqttSink) keepAlive(c net.Conn, stop chan struct{}) {
+	t := time.NewTicker(mqttKeepAlive / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			m.mu.Lock()
+			c.Write([]byte{0xc0, 0})
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *mqttSink) send(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil { return err }
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		if err = m.dial(); err != nil { return err }
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	p := mqttPublish(m.topic+"/events", b, false)
+	if e.Type == EventRunCompleted { p = append(p, mqttPublish(m.topic+"/last_run", b, true)...) }
+	if _, err = m.conn.Write(p); err != nil { m.drop() }
+	return err
+}
+
+func (m *mqttSink) drop() {
+	close(m.stop)
+	m.conn.Close()
+	m.conn = nil
+}
+
+// close says goodbye properly: a clean DISCONNECT discards the will, so
+// the offline status is published by us instead.
+func (m *mqttSink) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil { return }
+	m.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	m.conn.Write(append(mqttPublish(m.topic+"/status", []byte("offline"), true), 0xe0, 0))
+	m.drop()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ────────── NATS event sink ────────────────────────────────
+// natsSink publishes each event as JSON with the plain NATS text protocol:
+// CONNECT once, then PUB per event, answering the server's PINGs. No
+// subscriptions, TLS or JetStream. A dropped connection is redialled on
+// the next event.
+type natsSink struct {
+	addr, user, pass, subject string
+
+	mu   sync.Mutex // guards conn; writes come from send and the PING reader
+	conn net.Conn
+}
+
+func newNATSSink(raw, subject string) (*natsSink, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("events.nats: %q is not a nats://host:port URL", raw)
+	}
+	n := &natsSink{addr: u.Host, subject: subject}
+	if u.Port() == "" { n.addr = net.JoinHostPort(u.Hostname(), "4222") }
+	if u.User != nil { n.user = u.User.Username(); n.pass, _ = u.User.Password() }
+	return n, nil
+}
+
+func (n *natsSink) dial() error {
+	c, err := net.DialTimeout("tcp", n.addr, 10*time.Second)
+	if err != nil { return err }
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	br := bufio.NewReader(c)
+	line, err := br.ReadString('\n')
+	if err != nil { c.Close(); return err }
+	if !strings.HasPrefix(line, "INFO ") { c.Close(); return fmt.Errorf("%s does not look like a NATS server", n.addr) }
+	var info struct{ TLSRequired bool `json:"tls_required"` }
+	json.Unmarshal([]byte(line[5:]), &info)
+	if info.TLSRequired { c.Close(); return fmt.Errorf("%s requires TLS, which is not supported", n.addr) }
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "datasync"}
+	if n.user != "" { opts["user"], opts["pass"] = n.user, n.pass }
+	b, _ := json.Marshal(opts)
+	// the PING makes the server answer, so a rejected CONNECT shows up here
+	if _, err = fmt.Fprintf(c, "CONNECT %s\r\nPING\r\n", b); err != nil { c.Close(); return err }
+	if line, err = br.ReadString('\n'); err != nil { c.Close(); return err }
+	if !strings.HasPrefix(line, "PONG") { c.Close(); return fmt.Errorf("%s: %s", n.addr, strings.TrimSpace(line)) }
+	c.SetDeadline(time.Time{})
+	n.conn = c
+	go n.answerPings(c, br)
+	return nil
+}
+
+func (n *natsSink) answerPings(c net.Conn, br *bufio.Reader) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil { return }
+		if strings.HasPrefix(line, "PING") {
+			n.mu.Lock()
+			c.Write([]byte("PONG\r\n"))
+			n.mu.Unlock()
+		}
+	}
+}
+
+func (n *natsSink) send(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil { return err }
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err = n.dial(); err != nil { return err }
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err = fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", n.subject, len(b), b); err != nil {
+		n.conn.Close()
+		n.conn = nil
+	}
+	return err
+}
+
+func (n *natsSink) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn != nil { n.conn.Close(); n.conn = nil }
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── never-transfer list ────────────────────────────
+// never_transfer.list names a centrally kept list of files that must not
+// leave the site (legal holds, data residency), by path pattern or by
+// SHA-256 of the content. Every file is checked against it before the
+// target is even asked about it, and by hash before its upload; a match
+// is withheld and counted, not failed. The list is read from a file, a
+// share or an http(s) URL at the start of each run; when it cannot be
+// read the last good copy (<audit_log>.list) is used, and with neither
+// the run does not start. With never_transfer.key the list must carry a
+// valid HMAC signature (see `never sign`).
+//
+// The audit log is the proof: one JSON line when the list is applied
+// (which list, its hash, where it came from), one per withheld file, and
+// one at the end with how many files were checked. Each line carries the
+// SHA-256 of the line before it, so removed or edited lines show up in
+// `never verify-log`.
+//
+//   dirsync.exe never sign -key legal.key never.json
+//   dirsync.exe never verify-log D:\datasync\never-audit.jsonl
+type NeverConf struct {
+	List     string `json:"list"`      // file, UNC path or http(s) URL
+	Key      string `json:"key"`       // file with the HMAC key the list must be signed with
+	AuditLog string `json:"audit_log"` // append-only JSON lines (required with list)
+}
+
+type neverList struct {
+	ID        string       `json:"id"` // name or version, recorded in the audit log
+	Entries   []neverEntry `json:"entries"`
+	Signature string       `json:"signature,omitempty"`
+}
+
+type neverEntry struct {
+	Pattern string `json:"pattern,omitempty"` // as in tags: file name, or path under local_dir with **
+	SHA256  string `json:"sha256,omitempty"`
+	Reason  string `json:"reason"`            // e.g. "legal hold LH-2026-17"
+}
+
+func (e neverEntry) rule() string {
+	if e.Pattern != "" { return "pattern:" + e.Pattern }
+	return "sha256:" + e.SHA256
+}
+
+// mac covers the whole list with the signature blanked, as for inventories.
+func (l *neverList) mac(key []byte) string {
+	c := *l
+	c.Signature = ""
+	b, _ := json.Marshal(&c)
+	m := hmac.New(sha256.New, key)
+	m.Write(b)
+	return "hmac-sha256:" + hex.EncodeToString(m.Sum(nil))
+}
+
+// never enforces a loaded list for one run.
+type never struct {
+	list    *neverList
+	pats    []string // lower case, per entry; "" for hash entries
+	hashes  map[string]*neverEntry
+	audit   *auditLog
+	mu      sync.Mutex
+	checked int64    // files matched against the list this run
+}
+
+type auditLog struct {
+	mu   sync.Mutex // workers withhold files side by side
+	f    *os.File
+	prev string
+}
+
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"` // list_applied | withheld | run_completed
+	Site       string    `json:"site,omitempty"`
+	Host       string    `json:"host,omitempty"`
+	ListID     string    `json:"list_id,omitempty"`
+	ListSHA256 string    `json:"list_sha256,omitempty"`
+	Source     string    `json:"source,omitempty"` // where the list was read from
+	Entries    int       `json:"entries,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Rule       string    `json:"rule,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	FileSHA256 string    `json:"file_sha256,omitempty"`
+	Checked    int64     `json:"checked,omitempty"`
+	Withheld   int64     `json:"withheld,omitempty"`
+	Prev       string    `json:"prev"` // SHA-256 of the previous line, "" for the first
+}
+
+// openNever loads the list, falling back to the cached copy, and records
+// it in the audit log. It returns nil without a list.
+func openNever(c NeverConf, site string) (*never, error) {
+	if c.List == "" { return nil, nil }
+	if c.AuditLog == "" { return nil, fmt.Errorf("never_transfer.list needs never_transfer.audit_log") }
+	var key []byte
+	if c.Key != "" {
+		var err error
+		if key, err = readKey(c.Key); err != nil { return nil, fmt.Errorf("never_transfer.key: %w", err) }
+	}
+	cache := c.AuditLog + ".list"
+	raw, l, err := readNeverList(c.List, key)
+	source := c.List
+	if err == nil {
+		if werr := os.WriteFile(cache, raw, 0644); werr != nil { log.Printf("never_transfer: caching the list: %v", werr) }
+	} else {
+		log.Printf("never_transfer: %s: %v; using the last good copy", c.List, err)
+		if raw, l, err = readNeverList(cache, key); err != nil { return nil, fmt.Errorf("never_transfer: no usable list (%s: %v)", cache, err) }
+		source = cache
+	}
+	n := &never{list: l, hashes: map[string]*neverEntry{}}
+	for i := range l.Entries {
+		e := &l.Entries[i]
+		p := strings.ToLower(strings.ReplaceAll(e.Pattern, `\`, "/"))
+		n.pats = append(n.pats, p)
+		if e.SHA256 != "" { n.hashes[strings.ToLower(e.SHA256)] = e }
+	}
+	if n.audit, err = openAuditLog(c.AuditLog); err != nil { return nil, fmt.Errorf("never_transfer.audit_log: %w", err) }
+	sum := sha256.Sum256(raw)
+	host, _ := os.Hostname()
+	err = n.audit.add(auditEntry{Event: "list_applied", Site: site, Host: host, ListID: l.ID, ListSHA256: hex.EncodeToString(sum[:]), Source: source, Entries: len(l.Entries)})
+	if err != nil { n.audit.close(); return nil, fmt.Errorf("never_transfer.audit_log: %w", err) }
+	return n, nil
+}
+
+func readNeverList(src string, key []byte) ([]byte, *neverList, error) {
+	var raw []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, herr := (&http.Client{Timeout: 30 * time.Second}).Get(src)
+		if herr != nil { return nil, nil, herr }
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK { return nil, nil, fmt.Errorf("%s", resp.Status) }
+		raw, err = io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	} else {
+		raw, err = os.ReadFile(src)
+	}
+	if err != nil { return nil, nil, err }
+	var l neverList
+	if err = json.Unmarshal(raw, &l); err != nil { return nil, nil, err }
+	for i, e := range l.Entries {
+		if (e.Pattern == "") == (e.SHA256 == "") { return nil, nil, fmt.Errorf("entry %d: needs a pattern or a sha256, not both", i+1) }
+		if e.Pattern != "" && !rpath.ValidPattern(strings.ToLower(e.Pattern)) { return nil, nil, fmt.Errorf("entry %d: bad pattern %q", i+1, e.Pattern) }
+	}
+	if key != nil {
+		if l.Signature == "" { return nil, nil, errors.New("list is not signed") }
+		if !hmac.Equal([]byte(l.Signature), []byte(l.mac(key))) { return nil, nil, errors.New("list signature does not match (wrong key or modified list)") }
+	}
+	return raw, &l, nil
+}
+
+// byPath returns the entry whose pattern matches rel (slash separated,
+// under local_dir), counting the file as checked.
+func (n *never) byPath(rel string) *neverEntry {
+	n.mu.Lock()
+	n.checked++
+	n.mu.Unlock()
+	rel = strings.ToLower(rel)
+	for i, p := range n.pats {
+		if p != "" && rpath.Match(p, rel) { return &n.list.Entries[i] }
+	}
+	return nil
+}
+
+func (n *never) hashed() bool { return len(n.hashes) > 0 }
+
+func (n *never) byHash(sum string) *neverEntry { return n.hashes[sum] }
+
+// withhold records a match in the audit log before the file is skipped.
+// If the log cannot be written the file fails instead: a withheld file
+// that was not recorded would leave the proof incomplete.
+func (n *never) withhold(rel string, e *neverEntry, sum string) error {
+	err := n.audit.add(auditEntry{Event: "withheld", Path: rel, Rule: e.rule(), Reason: e.Reason, FileSHA256: sum})
+	if err != nil { return fmt.Errorf("never_transfer.audit_log: %w", err) }
+	return withClass(ErrWithheld, fmt.Errorf("%s (%s)", e.Reason, e.rule()))
+}
+
+func (n *never) finish(withheld int64) {
+	n.mu.Lock()
+	checked := n.checked
+	n.mu.Unlock()
+	if err := n.audit.add(auditEntry{Event: "run_completed", Checked: checked, Withheld: withheld}); err != nil { log.Printf("never_transfer.audit_log: %v", err) }
+	n.audit.close()
+}
+
+func openAuditLog(p string) (*auditLog, error) {
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil { return nil, err }
+	a := &auditLog{f: f}
+	fi, err := f.Stat()
+	if err != nil { f.Close(); return nil, err }
+	// the chain continues from the last line; reading the tail is enough
+	off := max(0, fi.Size()-64<<10)
+	tail := make([]byte, fi.Size()-off)
+	if _, err = f.ReadAt(tail, off); err != nil && err != io.EOF { f.Close(); return nil, err }
+	if lines := bytes.Split(bytes.TrimRight(tail, "\n"), []byte("\n")); len(tail) > 0 {
+		a.prev = lineHash(lines[len(lines)-1])
+	}
+	return a, nil
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// add writes one entry and syncs it to disk. Entries go one at a time,
+// so each names the line written before it.
+func (a *auditLog) add(e auditEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e.Time, e.Prev = time.Now().UTC(), a.prev
+	b, err := json.Marshal(e)
+	if err != nil { return err }
+	if _, err = a.f.Write(append(b, '\n')); err != nil { return err }
+	a.prev = lineHash(b)
+	return a.f.Sync()
+}
+
+func (a *auditLog) close() { a.f.Close() }
+
+// neverMain signs lists for distribution and checks audit logs.
+func neverMain(args []string) {
+	usage := "usage: never sign -key k.key list.json | never verify-log audit.jsonl"
+	if len(args) < 1 { log.Fatal(usage) }
+	setLanguage("")
+	switch args[0] {
+	case "sign":
+		fl := flag.NewFlagSet("never sign", flag.ExitOnError)
+		keyPath := fl.String("key", "", "file holding the HMAC signing key")
+		fl.Parse(args[1:])
+		if *keyPath == "" || fl.NArg() != 1 { log.Fatal(usage) }
+		key, err := readKey(*keyPath)
+		if err != nil { log.Fatal(err) }
+		_, l, err := readNeverList(fl.Arg(0), nil)
+		if err != nil { log.Fatalf("%s: %v", fl.Arg(0), err) }
+		l.Signature = l.mac(key)
+		var b bytes.Buffer
+		if err = writeJSON(&b, l); err != nil { log.Fatal(err) }
+		if err = os.WriteFile(fl.Arg(0), b.Bytes(), 0644); err != nil { log.Fatal(err) }
+		say("✓", "%s", tr("never_signed", fl.Arg(0), len(l.Entries)))
+	case "verify-log":
+		if len(args) != 2 { log.Fatal(usage) }
+		n, err := verifyAuditLog(args[1])
+		if err != nil { say("✗", "%s: %v", args[1], err); os.Exit(1) }
+		say("✓", "%s", tr("audit_intact", args[1], n))
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// verifyAuditLog checks that every line names the hash of the one before.
+func verifyAuditLog(p string) (int, error) {
+	f, err := os.Open(p)
+	if err != nil { return 0, err }
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	prev, n := "", 0
+	for sc.Scan() {
+		n++
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil { return n, fmt.Errorf("line %d: %v", n, err) }
+		if e.Prev != prev { return n, fmt.Errorf("line %d: chain broken (a line before it was changed or removed)", n) }
+		prev = lineHash(sc.Bytes())
+	}
+	return n, sc.Err()
+}
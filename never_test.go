@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newNever is a push whose never_transfer list withholds secret/** by
+// path and the content "classified" by hash.
+func newNever(t *testing.T) *twoWay {
+	w := newTwoWay(t)
+	w.conf.Direction = ""
+	dir := filepath.Dir(w.here)
+	sum := sha256.Sum256([]byte("classified"))
+	l := neverList{ID: "LH-1", Entries: []neverEntry{
+		{Pattern: "secret/**", Reason: "legal hold LH-1"},
+		{SHA256: hex.EncodeToString(sum[:]), Reason: "residency"},
+	}}
+	b, _ := json.Marshal(&l)
+	w.conf.Never = NeverConf{List: filepath.Join(dir, "never.json"), AuditLog: filepath.Join(dir, "audit.jsonl")}
+	if err := os.WriteFile(w.conf.Never.List, b, 0644); err != nil { t.Fatal(err) }
+	return w
+}
+
+// auditEvents reads the audit log's entries.
+func auditEvents(t *testing.T, p string) []auditEntry {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil { t.Fatal(err) }
+	defer f.Close()
+	var es []auditEntry
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil { t.Fatal(err) }
+		es = append(es, e)
+	}
+	return es
+}
+
+func TestNeverTransfer(t *testing.T) {
+	w := newNever(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "secret/a.txt", "a", then)
+	put(t, w.here, "copy.txt", "classified", then)
+	put(t, w.here, "public.txt", "p", then)
+	s := w.sync(t)
+	if s.Uploaded != 1 || s.Withheld != 2 || s.Failed != 0 { t.Errorf("%d up, %d withheld, %d failed; want 1, 2, 0", s.Uploaded, s.Withheld, s.Failed) }
+	for _, rel := range []string{"secret/a.txt", "copy.txt"} {
+		if body(w.there, rel) != "" { t.Errorf("%s reached the target", rel) }
+	}
+	if body(w.there, "public.txt") != "p" { t.Error("public.txt not uploaded") }
+
+	es := auditEvents(t, w.conf.Never.AuditLog)
+	if len(es) != 4 || es[0].Event != "list_applied" || es[3].Event != "run_completed" { t.Fatalf("audit log: %+v; want list_applied, 2 withheld, run_completed", es) }
+	if e := es[0]; e.ListID != "LH-1" || e.Entries != 2 || e.Source != w.conf.Never.List { t.Errorf("list_applied: %+v", e) }
+	withheld := map[string]string{}
+	for _, e := range es[1:3] { withheld[e.Path] = e.Rule }
+	if r := withheld["secret/a.txt"]; r != "pattern:secret/**" { t.Errorf("secret/a.txt withheld by %q", r) }
+	if r := withheld["copy.txt"]; !strings.HasPrefix(r, "sha256:") { t.Errorf("copy.txt withheld by %q", r) }
+	if e := es[3]; e.Checked != 3 || e.Withheld != 2 { t.Errorf("run_completed: %d checked, %d withheld; want 3, 2", e.Checked, e.Withheld) }
+	if n, err := verifyAuditLog(w.conf.Never.AuditLog); err != nil || n != 4 { t.Errorf("verify-log: %d entries, %v; want 4, intact", n, err) }
+
+	// a second run continues the chain
+	w.sync(t)
+	if n, err := verifyAuditLog(w.conf.Never.AuditLog); err != nil || n != 8 { t.Errorf("verify-log after two runs: %d entries, %v; want 8, intact", n, err) }
+}
+
+func TestNeverLastGoodList(t *testing.T) {
+	w := newNever(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "secret/a.txt", "a", then)
+	w.sync(t)
+	// the share holding the list is gone: the cached copy still applies
+	os.Remove(w.conf.Never.List)
+	if s := w.sync(t); s.Withheld != 1 || body(w.there, "secret/a.txt") != "" { t.Errorf("without the list: %d withheld, want the cached list applied", s.Withheld) }
+	es := auditEvents(t, w.conf.Never.AuditLog)
+	var src string
+	for _, e := range es {
+		if e.Event == "list_applied" { src = e.Source }
+	}
+	if src != w.conf.Never.AuditLog+".list" { t.Errorf("second run's list from %q, want the cache", src) }
+
+	// and with no cache either the run does not start
+	os.Remove(w.conf.Never.AuditLog + ".list")
+	if _, err := openNever(w.conf.Never, ""); err == nil { t.Error("opened without a list or a cached copy") }
+}
+
+func TestNeverSigned(t *testing.T) {
+	w := newNever(t)
+	dir := filepath.Dir(w.here)
+	w.conf.Never.Key = filepath.Join(dir, "legal.key")
+	if err := os.WriteFile(w.conf.Never.Key, []byte("legal key\n"), 0600); err != nil { t.Fatal(err) }
+	if _, err := openNever(w.conf.Never, ""); err == nil { t.Error("an unsigned list was accepted with never_transfer.key") }
+
+	_, l, err := readNeverList(w.conf.Never.List, nil)
+	if err != nil { t.Fatal(err) }
+	l.Signature = l.mac([]byte("legal key"))
+	b, _ := json.Marshal(l)
+	os.WriteFile(w.conf.Never.List, b, 0644)
+	n, err := openNever(w.conf.Never, "")
+	if err != nil { t.Fatalf("signed list: %v", err) }
+	n.finish(0)
+
+	l.Entries = l.Entries[:1] // an entry dropped after signing
+	b, _ = json.Marshal(l)
+	os.WriteFile(w.conf.Never.List, b, 0644)
+	os.Remove(w.conf.Never.AuditLog + ".list")
+	if _, err := openNever(w.conf.Never, ""); err == nil { t.Error("a modified list was accepted") }
+}
+
+func TestAuditLogConcurrent(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := openAuditLog(p)
+	if err != nil { t.Fatal(err) }
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.add(auditEntry{Event: "withheld", Path: fmt.Sprintf("f%d.txt", i)}); err != nil { t.Error(err) }
+		}()
+	}
+	wg.Wait()
+	a.close()
+	if n, err := verifyAuditLog(p); err != nil || n != 64 { t.Errorf("verify-log: %d entries, %v; want 64, intact", n, err) }
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := openAuditLog(p)
+	if err != nil { t.Fatal(err) }
+	for i := 0; i < 3; i++ { a.add(auditEntry{Event: "withheld", Path: fmt.Sprintf("f%d.txt", i)}) }
+	a.close()
+	if n, err := verifyAuditLog(p); err != nil || n != 3 { t.Fatalf("verify-log: %d entries, %v; want 3, intact", n, err) }
+	raw, _ := os.ReadFile(p)
+	lines := strings.SplitAfter(string(raw), "\n")
+	for name, forged := range map[string]string{
+		"line removed": lines[0] + lines[2],
+		"line edited":  lines[0] + strings.Replace(lines[1], "f1.txt", "g1.txt", 1) + lines[2],
+	} {
+		os.WriteFile(p, []byte(forged), 0644)
+		if _, err := verifyAuditLog(p); err == nil { t.Errorf("%s: verify-log found the chain intact", name) }
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ────────── OAuth tokens ───────────────────────────────────
+// Cloud drive targets authorize with OAuth 2 bearer tokens. All the
+// workers of one target share a tokenSource, which fetches a new access
+// token shortly before the old one expires. Where the sign-in is a user's
+// (device code, a stored authorization), the refresh token is kept in a
+// token file, written like the state file, so later runs need no one at
+// the keyboard.
+type oauthToken struct {
+	Access  string    `json:"access_token"`
+	Refresh string    `json:"refresh_token,omitempty"`
+	Expiry  time.Time `json:"expiry"`
+}
+
+type tokenSource struct {
+	mu    sync.Mutex
+	tok   oauthToken
+	file  string // "" keeps the token in memory only
+	fetch func(ctx context.Context, old oauthToken) (oauthToken, error)
+}
+
+var tokenSources = struct {
+	sync.Mutex
+	m map[string]*tokenSource
+}{m: map[string]*tokenSource{}}
+
+// sharedTokens returns the source kept under key, making it with mk the
+// first time, so every connection of a run draws from one sign-in.
+func sharedTokens(key string, mk func() (*tokenSource, error)) (*tokenSource, error) {
+	tokenSources.Lock()
+	defer tokenSources.Unlock()
+	if s := tokenSources.m[key]; s != nil { return s, nil }
+	s, err := mk()
+	if err != nil { return nil, err }
+	if s.file != "" {
+		b, err := os.ReadFile(s.file)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		default:
+			if err = json.Unmarshal(b, &s.tok); err != nil { return nil, fmt.Errorf("token file %s: %w", s.file, err) }
+		}
+	}
+	tokenSources.m[key] = s
+	return s, nil
+}
+
+// token returns an access token valid for at least another minute.
+func (s *tokenSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.Access != "" && time.Until(s.tok.Expiry) > time.Minute { return s.tok.Access, nil }
+	tok, err := s.fetch(ctx, s.tok)
+	if err != nil { return "", err }
+	if tok.Refresh == "" { tok.Refresh = s.tok.Refresh } // not every refresh hands out a new one
+	s.tok = tok
+	if s.file != "" {
+		if err := writeToken(s.file, tok); err != nil { log.Printf("token file: %v", err) }
+	}
+	return tok.Access, nil
+}
+
+func writeToken(p string, tok oauthToken) error {
+	b, err := json.MarshalIndent(tok, "", "  ")
+	if err != nil { return err }
+	tmp := p + ".tmp"
+	if err = os.WriteFile(tmp, b, 0600); err != nil { return err }
+	return os.Rename(tmp, p)
+}
+
+// oauthError is an error answer from a token endpoint (RFC 6749 5.2).
+type oauthError struct {
+	Code string `json:"error"`
+	Desc string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	if e.Desc == "" { return "oauth: " + e.Code }
+	return fmt.Sprintf("oauth: %s: %s", e.Code, strings.SplitN(e.Desc, "\r\n", 2)[0])
+}
+
+// postForm posts form to an OAuth endpoint and decodes the JSON answer
+// into v. Refusals come back as an *oauthError classed ErrAuth.
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil { return err }
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	if resp.StatusCode != http.StatusOK {
+		var oe oauthError
+		if json.Unmarshal(body, &oe) == nil && oe.Code != "" {
+			if resp.StatusCode >= 500 { return withClass(ErrTransient, &oe) }
+			return withClass(ErrAuth, &oe)
+		}
+		u, _ := url.Parse(endpoint)
+		return classifyHTTP(&statusError{method: "POST", url: u.Redacted(), code: resp.StatusCode, status: resp.Status})
+	}
+	return json.Unmarshal(body, v)
+}
+
+// grant asks a token endpoint for a token.
+func grant(ctx context.Context, client *http.Client, endpoint string, form url.Values) (oauthToken, error) {
+	var r struct {
+		Access    string `json:"access_token"`
+		Refresh   string `json:"refresh_token"`
+		ExpiresIn int64  `json:"expires_in"`
+	}
+	if err := postForm(ctx, client, endpoint, form, &r); err != nil { return oauthToken{}, err }
+	if r.Access == "" { return oauthToken{}, fmt.Errorf("oauth: %s gave no access token", endpoint) }
+	return oauthToken{Access: r.Access, Refresh: r.Refresh, Expiry: time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)}, nil
+}
+
+// apiCall sends a JSON request (or none) to a REST API with a bearer
+// token from tokens, decodes the answer into v and returns its headers.
+// fail makes the error for a refusal. Throttled requests wait as long as
+// Retry-After says, up to a minute each time, a few times over.
+func apiCall(ctx context.Context, client *http.Client, tokens *tokenSource, method, u string, in, v any, fail func(string, *url.URL, *http.Response) error) (http.Header, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil { return nil, err }
+	}
+	for try := 1; ; try++ {
+		tok, err := tokens.token(ctx)
+		if err != nil { return nil, err }
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil { return nil, err }
+		req.Header.Set("Authorization", "Bearer "+tok)
+		if in != nil { req.Header.Set("Content-Type", "application/json") }
+		resp, err := client.Do(req)
+		if err != nil { return nil, err }
+		if wait, ok := throttled(resp); ok && try < 4 {
+			resp.Body.Close()
+			debugf(catProtocol, "%s %s throttled, waiting %s", method, req.URL.Path, wait)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 { return nil, fail(method, req.URL, resp) }
+		if v == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			return resp.Header, nil
+		}
+		return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+	}
+}
+
+// throttled reports a 429 or 503 and how long the service asks to wait.
+func throttled(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable { return 0, false }
+	wait := 5 * time.Second
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil { wait = time.Duration(s) * time.Second }
+	return min(wait, time.Minute), true
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── OneDrive target ────────────────────────────────
+// onedriveTarget writes into a OneDrive or SharePoint document library
+// through the Microsoft Graph drive API, addressing items by path, which
+// makes missing folders on the way. With onedrive.client_secret the app
+// signs in as itself (client credentials) and needs onedrive.drive or
+// onedrive.user to know whose drive; without it a user signs in once with
+// a device code, and the refresh token is kept in onedrive.token_file.
+// Files up to 4 MiB go in one PUT; larger ones through an upload session
+// in 10 MiB fragments, which picks up after a failed fragment where the
+// service says it stopped. The local mtime is set as the item's
+// fileSystemInfo, which OneDrive keeps in whole seconds.
+type OneDriveConf struct {
+	Tenant       string `json:"tenant"`        // directory ID or domain (default "organizations"; "consumers" for personal accounts)
+	ClientID     string `json:"client_id"`     // the app registration
+	ClientSecret string `json:"client_secret"` // app-only sign-in; without it a user signs in with a device code
+	TokenFile    string `json:"token_file"`    // keeps the user's sign-in (required without client_secret)
+	Drive        string `json:"drive"`         // drive ID (default: the signed-in user's OneDrive)
+	User         string `json:"user"`          // or the OneDrive of this user, by UPN or ID
+	RemotePath   string `json:"remote_path"`
+	GraphURL     string `json:"graph_url"`     // national clouds (default https://graph.microsoft.com/v1.0)
+	LoginURL     string `json:"login_url"`     // their sign-in (default https://login.microsoftonline.com)
+}
+
+const (
+	graphSimpleMax = 4 << 20  // larger files go through an upload session
+	graphFragment  = 10 << 20 // a multiple of the 320 KiB the service asks for
+)
+
+type onedriveTarget struct {
+	cfg    OneDriveConf
+	drive  string // Graph URL of the drive
+	root   rpath.Root
+	client *http.Client
+	tokens *tokenSource
+}
+
+func connectOneDrive(ctx context.Context, cfg OneDriveConf, tc TransferConf, form string) (*onedriveTarget, error) {
+	if cfg.ClientID == "" { return nil, fmt.Errorf("onedrive.client_id is required") }
+	if cfg.Tenant == "" { cfg.Tenant = "organizations" }
+	if cfg.GraphURL == "" { cfg.GraphURL = "https://graph.microsoft.com/v1.0" }
+	if cfg.LoginURL == "" { cfg.LoginURL = "https://login.microsoftonline.com" }
+	cfg.GraphURL, cfg.LoginURL = strings.TrimRight(cfg.GraphURL, "/"), strings.TrimRight(cfg.LoginURL, "/")
+	switch {
+	case cfg.ClientSecret != "" && cfg.Drive == "" && cfg.User == "":
+		return nil, fmt.Errorf("onedrive.client_secret signs in as the app, which has no drive of its own: set onedrive.drive or onedrive.user")
+	case cfg.ClientSecret == "" && cfg.TokenFile == "":
+		return nil, fmt.Errorf("onedrive.token_file is required for the device code sign-in")
+	}
+	client, err := tc.httpClient("onedrive", "")
+	if err != nil { return nil, err }
+	t := &onedriveTarget{cfg: cfg, root: rpath.NewRoot(cfg.RemotePath, form), client: client}
+	switch {
+	case cfg.Drive != "":
+		t.drive = cfg.GraphURL + "/drives/" + url.PathEscape(cfg.Drive)
+	case cfg.User != "":
+		t.drive = cfg.GraphURL + "/users/" + url.PathEscape(cfg.User) + "/drive"
+	default:
+		t.drive = cfg.GraphURL + "/me/drive"
+	}
+	key := strings.Join([]string{"onedrive", cfg.LoginURL, cfg.Tenant, cfg.ClientID, cfg.TokenFile}, "\x00")
+	t.tokens, err = sharedTokens(key, func() (*tokenSource, error) {
+		return &tokenSource{file: cfg.TokenFile, fetch: t.signIn}, nil
+	})
+	if err != nil { return nil, err }
+	// the drive's root shows a refused sign-in or a wrong drive once
+	if err = t.call(ctx, "GET", t.drive+"/root?$select=id", nil, nil); err != nil {
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusForbidden { return nil, withClass(ErrAuth, err) }
+		return nil, classifyHTTP(ctxErr(ctx, err))
+	}
+	return t, nil
+}
+
+// ────────── sign-in ────────────────────────────────────────
+func (t *onedriveTarget) tokenURL(kind string) string {
+	return t.cfg.LoginURL + "/" + url.PathEscape(t.cfg.Tenant) + "/oauth2/v2.0/" + kind
+}
+
+// signIn gets a token: as the app, from the refresh token, or else by a
+// user entering a device code.
+func (t *onedriveTarget) signIn(ctx context.Context, old oauthToken) (oauthToken, error) {
+	if t.cfg.ClientSecret != "" {
+		return grant(ctx, t.client, t.tokenURL("token"), url.Values{
+			"grant_type": {"client_credentials"}, "client_id": {t.cfg.ClientID}, "client_secret": {t.cfg.ClientSecret},
+			"scope": {"https://graph.microsoft.com/.default"},
+		})
+	}
+	const scope = "Files.ReadWrite.All offline_access"
+	if old.Refresh != "" {
+		tok, err := grant(ctx, t.client, t.tokenURL("token"), url.Values{
+			"grant_type": {"refresh_token"}, "client_id": {t.cfg.ClientID}, "refresh_token": {old.Refresh}, "scope": {scope},
+		})
+		var oe *oauthError
+		if !errors.As(err, &oe) { return tok, err }
+		log.Printf("onedrive: the stored sign-in was refused (%v); signing in again", err)
+	}
+	var dc struct {
+		DeviceCode string `json:"device_code"`
+		Message    string `json:"message"`
+		ExpiresIn  int    `json:"expires_in"`
+		Interval   int    `json:"interval"`
+	}
+	if err := postForm(ctx, t.client, t.tokenURL("devicecode"), url.Values{"client_id": {t.cfg.ClientID}, "scope": {scope}}, &dc); err != nil { return oauthToken{}, err }
+	say("!", "%s", dc.Message)
+	wait := time.Duration(max(dc.Interval, 1)) * time.Second
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return oauthToken{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		tok, err := grant(ctx, t.client, t.tokenURL("token"), url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:device_code"}, "client_id": {t.cfg.ClientID}, "device_code": {dc.DeviceCode},
+		})
+		var oe *oauthError
+		switch {
+		case !errors.As(err, &oe):
+			return tok, err
+		case oe.Code == "authorization_pending":
+		case oe.Code == "slow_down":
+			wait += 5 * time.Second
+		default:
+			return oauthToken{}, err
+		}
+	}
+	return oauthToken{}, withClass(ErrAuth, fmt.Errorf("onedrive: nobody entered the device code in time"))
+}
+
+// ────────── Graph requests ─────────────────────────────────
+// call sends a Graph request with a JSON body (or none) and decodes the
+// answer into v.
+func (t *onedriveTarget) call(ctx context.Context, method, u string, in, v any) error {
+	_, err := apiCall(ctx, t.client, t.tokens, method, u, in, v, statusErr)
+	return err
+}
+
+// item is the Graph URL of rel's drive item, by path.
+func (t *onedriveTarget) item(rel string) (string, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return "", withClass(ErrInvalidName, err) }
+	return t.drive + "/root:/" + escapePath(strings.TrimPrefix(remote, "/")) + ":", nil
+}
+
+// graphTime is the stamp for a local mtime. OneDrive drops fractions of a
+// second, so it rounds up: an unchanged file then compares up to date.
+func graphTime(mtime time.Time) string {
+	return ceilTime(mtime, time.Second).UTC().Format(time.RFC3339)
+}
+
+func (t *onedriveTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	u, err := t.item(rel)
+	if err != nil { return FileInfo{}, err }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	var it struct {
+		Size           int64     `json:"size"`
+		Folder         *struct{} `json:"folder"`
+		FileSystemInfo struct {
+			LastModified time.Time `json:"lastModifiedDateTime"`
+		} `json:"fileSystemInfo"`
+	}
+	if err = t.call(ctx, "GET", u+"?$select=size,folder,fileSystemInfo", nil, &it); err != nil { return FileInfo{}, classifyHTTP(ctxErr(ctx, err)) }
+	if it.Folder != nil { return FileInfo{}, withClass(ErrInvalidName, fmt.Errorf("%s is a folder on the target", rel)) }
+	return FileInfo{Rel: rel, Size: it.Size, MTime: it.FileSystemInfo.LastModified, Exists: true}, nil
+}
+
+func (t *onedriveTarget) upload(ctx context.Context, local, rel string) error {
+	return classifyHTTP(ctxErr(ctx, t.put(ctx, local, rel)))
+}
+
+func (t *onedriveTarget) put(ctx context.Context, local, rel string) error {
+	u, err := t.item(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
+	src, err := os.Open(local)
+	if err != nil { return err }
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil { return err }
+	fsInfo := map[string]string{"lastModifiedDateTime": graphTime(fi.ModTime())}
+	if fi.Size() > graphSimpleMax { return t.session(ctx, u, src, fi.Size(), fsInfo) }
+	tok, err := t.tokens.token(ctx)
+	if err != nil { return err }
+	req, err := http.NewRequestWithContext(ctx, "PUT", u+"/content?@microsoft.graph.conflictBehavior=replace", ctxReader{ctx, src})
+	if err != nil { return err }
+	req.ContentLength = fi.Size()
+	req.Header.Set("Authorization", "Bearer "+tok)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := t.client.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 { return statusErr("PUT", req.URL, resp) }
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	// a plain PUT stamps the upload time; the mtime is set afterwards
+	return t.call(ctx, "PATCH", u, map[string]any{"fileSystemInfo": fsInfo}, nil)
+}
+
+// session sends src through an upload session. A fragment that fails in
+// a way that may pass is sent again from where the service says it got
+// to; anything else cancels the session, so no half file is left behind.
+func (t *onedriveTarget) session(ctx context.Context, u string, src *os.File, size int64, fsInfo map[string]string) error {
+	var s struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	err := t.call(ctx, "POST", u+"/createUploadSession", map[string]any{"item": map[string]any{
+		"@microsoft.graph.conflictBehavior": "replace", "fileSystemInfo": fsInfo,
+	}}, &s)
+	if err != nil { return err }
+	// the upload URL carries its own authorization, and no token goes to it
+	cancel := func() {
+		if req, err := http.NewRequest("DELETE", s.UploadURL, nil); err == nil {
+			if resp, err := t.client.Do(req); err == nil { resp.Body.Close() }
+		}
+	}
+	var off int64
+	for fails := 0; off < size; {
+		n := min(int64(graphFragment), size-off)
+		done, err := t.fragment(ctx, s.UploadURL, src, off, n, size)
+		if err == nil {
+			if off += n; done { break }
+			fails = 0
+			continue
+		}
+		if fails++; fails >= attempts || !retryable(classifyHTTP(ctxErr(ctx, err))) || ctx.Err() != nil { cancel(); return err }
+		debugf(catTransfer, "onedrive: fragment at %d failed, asking where to go on: %v", off, err)
+		if off, err = t.nextRange(ctx, s.UploadURL); err != nil { cancel(); return err }
+	}
+	return nil
+}
+
+// fragment sends bytes [off, off+n) of src; done is true once the
+// service has the whole file.
+func (t *onedriveTarget) fragment(ctx context.Context, uploadURL string, src *os.File, off, n, size int64) (done bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, ctxReader{ctx, io.NewSectionReader(src, off, n)})
+	if err != nil { return false, err }
+	req.ContentLength = n
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", off, off+n-1, size))
+	resp, err := t.client.Do(req)
+	if err != nil { return false, err }
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return false, nil
+	case http.StatusOK, http.StatusCreated:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return true, nil
+	}
+	return false, statusErr("PUT", req.URL, resp)
+}
+
+// nextRange asks an upload session for the first byte it still needs.
+func (t *onedriveTarget) nextRange(ctx context.Context, uploadURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", uploadURL, nil)
+	if err != nil { return 0, err }
+	resp, err := t.client.Do(req)
+	if err != nil { return 0, err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return 0, statusErr("GET", req.URL, resp) }
+	var st struct {
+		Next []string `json:"nextExpectedRanges"` // "12345-" or "12345-67890"
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&st); err != nil { return 0, err }
+	if len(st.Next) == 0 { return 0, fmt.Errorf("onedrive: the upload session expects nothing more but never confirmed the file") }
+	start, _, _ := strings.Cut(st.Next[0], "-")
+	return strconv.ParseInt(start, 10, 64)
+}
+
+func (t *onedriveTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	u, err := t.item(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	tok, err := t.tokens.token(ctx)
+	if err != nil { return err }
+	// the answer is a redirect to a pre-authorized download URL, which the
+	// client follows without the token
+	req, err := http.NewRequestWithContext(ctx, "GET", u+"/content", nil)
+	if err != nil { return err }
+	req.Header.Set("Authorization", "Bearer "+tok)
+	resp, err := t.client.Do(req)
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return classifyHTTP(statusErr("GET", req.URL, resp)) }
+	_, err = io.Copy(w, resp.Body)
+	return classifyHTTP(ctxErr(ctx, err))
+}
+
+func (t *onedriveTarget) close() { t.client.CloseIdleConnections() }
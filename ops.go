@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// ────────── remote operation limits ────────────────────────
+// max_remote_ops caps what one run may ask of the target, to protect
+// rate-limited or fragile servers (old NAS firmware) from a misconfigured
+// job. A zero field is unlimited. The operation that would go over a
+// limit is not sent. The whole run stops as it does on Ctrl+C: transfers
+// in flight are aborted, finished files are kept in the state file, and
+// the run ends with a "limit" error.
+type OpsConf struct {
+	Listings  int64 `json:"listings"`  // directory listings and file lookups
+	Uploads   int64 `json:"uploads"`
+	Deletes   int64 `json:"deletes"`
+	Renames   int64 `json:"renames"`   // e.g. SMB moving a finished .tmp into place
+	Downloads int64 `json:"downloads"` // files read back, e.g. the canary
+}
+
+type opKind int
+
+const (
+	opListing opKind = iota
+	opUpload
+	opDelete
+	opRename
+	opDownload
+)
+
+var opNames = [...]string{"listings", "uploads", "deletes", "renames", "downloads"}
+
+type opBudget struct {
+	limit [len(opNames)]int64
+	used  [len(opNames)]atomic.Int64
+	stop  context.CancelCauseFunc
+}
+
+type opsKey struct{}
+
+// withOpBudget returns ctx carrying the run's limits, or ctx itself when
+// none are set. stop cancels the run once a limit is hit.
+func withOpBudget(ctx context.Context, c OpsConf, stop context.CancelCauseFunc) context.Context {
+	if c == (OpsConf{}) { return ctx }
+	b := &opBudget{limit: [len(opNames)]int64{c.Listings, c.Uploads, c.Deletes, c.Renames, c.Downloads}, stop: stop}
+	return context.WithValue(ctx, opsKey{}, b)
+}
+
+type readOnlyKey struct{}
+
+// withReadOnly makes every upload, delete and rename under ctx fail before
+// it is sent, for an instance with read_only set.
+func withReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// takeOp counts one operation against the run's limit for k.
+func takeOp(ctx context.Context, k opKind) error {
+	if k != opListing && k != opDownload && ctx.Value(readOnlyKey{}) != nil {
+		return withClass(ErrPermission, fmt.Errorf("read_only: %s are not allowed from this instance", opNames[k]))
+	}
+	b, _ := ctx.Value(opsKey{}).(*opBudget)
+	if b == nil || b.limit[k] <= 0 { return nil }
+	if b.used[k].Add(1) <= b.limit[k] { return nil }
+	err := withClass(ErrLimit, fmt.Errorf("max_remote_ops.%s: limit of %d reached, run stopped", opNames[k], b.limit[k]))
+	b.stop(err)
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ────────── battery and metered networks ───────────────────
+// On laptops in the field, power.battery holds back files of at least
+// power.large_mb (default 50) while the machine runs on battery, and
+// power.metered while its network connection is metered (a hotspot, a
+// mobile plan), as Windows' connection cost says. Small files still go.
+// A file held back is reported as deferred, not as a failure, and is
+// compared again on the next run: once on AC or an unmetered network it
+// goes. The state is looked at again every 30 seconds, so a run that
+// starts on battery sends the large files after the cable is put in.
+// Metered networks are only known on Windows.
+//
+// On shared workstations, power.user_idle holds large files back the
+// same way while someone works at the machine: its console session is
+// unlocked and had keyboard or mouse input in the last power.idle_min
+// minutes (default 5). power.busy_kbps slows all transfers of the run
+// down to that many KB/s meanwhile, large or not, and lets them go at
+// full speed again once the machine is locked or left alone. A run under
+// another session (a service, a task that runs whether the user is
+// logged on or not) cannot see the input and goes by the lock alone.
+// Users at the machine are only detected on Windows.
+type PowerConf struct {
+	Battery  bool  `json:"battery"`   // defer large transfers while on battery
+	Metered  bool  `json:"metered"`   // defer large transfers while the connection is metered (Windows)
+	UserIdle bool  `json:"user_idle"` // defer large transfers while someone uses the machine (Windows)
+	IdleMin  int   `json:"idle_min"`  // minutes without input that count as not in use (default 5)
+	BusyKBps int64 `json:"busy_kbps"` // while in use, transfers go no faster than this (0 = unlimited)
+	LargeMB  int64 `json:"large_mb"`  // what counts as large (default 50)
+}
+
+const (
+	powerLargeMB = 50
+	powerIdleMin = 5
+)
+
+type power struct {
+	cfg   PowerConf
+	large int64 // bytes
+	idle  time.Duration
+	rate  int64 // busy_kbps in bytes per second
+	mu    sync.Mutex
+	at    time.Time
+	why   string    // i18n key of the reason to defer, "" for none
+	inUse bool      // someone works at the machine
+	next  time.Time // busy_kbps: when the bytes moved so far are paid for
+	warn  sync.Once
+}
+
+// newPower is nil unless something is to be deferred or slowed down.
+func newPower(cfg PowerConf) (*power, error) {
+	if !cfg.Battery && !cfg.Metered && !cfg.UserIdle && cfg.BusyKBps == 0 { return nil, nil }
+	switch {
+	case cfg.LargeMB < 0:
+		return nil, fmt.Errorf("power.large_mb: %d is negative", cfg.LargeMB)
+	case cfg.IdleMin < 0:
+		return nil, fmt.Errorf("power.idle_min: %d is negative", cfg.IdleMin)
+	case cfg.BusyKBps < 0:
+		return nil, fmt.Errorf("power.busy_kbps: %d is negative", cfg.BusyKBps)
+	}
+	p := &power{cfg: cfg, large: powerLargeMB << 20, idle: powerIdleMin * time.Minute, rate: cfg.BusyKBps << 10}
+	if cfg.LargeMB > 0 { p.large = cfg.LargeMB << 20 }
+	if cfg.IdleMin > 0 { p.idle = time.Duration(cfg.IdleMin) * time.Minute }
+	return p, nil
+}
+
+// powerDeferral is a file held back until the machine is on AC or an
+// unmetered network; key names the reason for the operator message.
+type powerDeferral struct {
+	size int64
+	key  string
+}
+
+func (d *powerDeferral) Error() string { return fmt.Sprintf("%s deferred: %s", sizeText(d.size), d.key) }
+func (d *powerDeferral) Unwrap() error { return ErrDeferred }
+
+// hold returns a powerDeferral for a transfer of size bytes that must
+// wait, nil for one that may go now.
+func (p *power) hold(size int64) error {
+	if p == nil || size < p.large { return nil }
+	if why := p.reason(); why != "" { return &powerDeferral{size, why} }
+	return nil
+}
+
+func (p *power) reason() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	return p.why
+}
+
+// refresh looks at the machine again when the last look is 30 seconds
+// old; p.mu is held.
+func (p *power) refresh() {
+	if time.Since(p.at) < 30*time.Second { return }
+	p.at, p.why, p.inUse = time.Now(), "", false
+	if p.cfg.UserIdle || p.rate > 0 {
+		if on, err := userActive(p.idle); err != nil {
+			p.warn.Do(func() { log.Printf("power.user_idle: %v", err) })
+		} else {
+			p.inUse = on
+			if on && p.cfg.UserIdle { p.why = "power_in_use" }
+		}
+	}
+	if p.cfg.Battery && p.why == "" {
+		if on, err := onBattery(); err != nil {
+			p.warn.Do(func() { log.Printf("power.battery: %v", err) })
+		} else if on {
+			p.why = "power_battery"
+		}
+	}
+	if p.cfg.Metered && p.why == "" {
+		if on, err := metered(); err != nil {
+			p.warn.Do(func() { log.Printf("power.metered: %v", err) })
+		} else if on {
+			p.why = "power_metered"
+		}
+	}
+	if p.why != "" { debugf(catTransfer, "power: deferring files of %s and up (%s)", sizeText(p.large), p.why) }
+	if p.inUse && p.rate > 0 { debugf(catTransfer, "power: machine in use, transfers held to %d KB/s", p.cfg.BusyKBps) }
+}
+
+// pace holds back a transfer under ctx that just moved n bytes for as
+// long as busy_kbps asks while the machine is in use. All transfers of
+// the run share the rate.
+func (p *power) pace(ctx context.Context, n int64) {
+	if p == nil || p.rate == 0 { return }
+	p.mu.Lock()
+	p.refresh()
+	if !p.inUse {
+		p.next = time.Time{}
+		p.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if p.next.Before(now) { p.next = now }
+	p.next = p.next.Add(time.Duration(n) * time.Second / time.Duration(p.rate))
+	wait := p.next.Sub(now)
+	p.mu.Unlock()
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
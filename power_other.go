@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// onBattery reads the mains supplies in sysfs: on battery when there are
+// some and none is online. Machines without (desktops, servers, other
+// systems) never are.
+func onBattery() (bool, error) {
+	dirs, _ := filepath.Glob("/sys/class/power_supply/*")
+	mains, online := 0, 0
+	for _, d := range dirs {
+		typ, err := os.ReadFile(filepath.Join(d, "type"))
+		if err != nil || strings.TrimSpace(string(typ)) != "Mains" { continue }
+		mains++
+		if on, err := os.ReadFile(filepath.Join(d, "online")); err == nil && strings.TrimSpace(string(on)) == "1" { online++ }
+	}
+	return mains > 0 && online == 0, nil
+}
+
+func metered() (bool, error) {
+	return false, errors.New("metered connections are only detected on Windows")
+}
+
+func userActive(idle time.Duration) (bool, error) {
+	return false, errors.New("users at the machine are only detected on Windows")
+}
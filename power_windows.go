@@ -0,0 +1,108 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32                         = syscall.NewLazyDLL("kernel32.dll")
+	procGetSystemPowerStatus         = kernel32.NewProc("GetSystemPowerStatus")
+	procWTSGetActiveConsoleSessionId = kernel32.NewProc("WTSGetActiveConsoleSessionId")
+	procProcessIdToSessionId         = kernel32.NewProc("ProcessIdToSessionId")
+	procGetTickCount                 = kernel32.NewProc("GetTickCount")
+	ole32                            = syscall.NewLazyDLL("ole32.dll")
+	procCoInitializeEx               = ole32.NewProc("CoInitializeEx")
+	procCoUninitialize               = ole32.NewProc("CoUninitialize")
+	procCoCreateInstance             = ole32.NewProc("CoCreateInstance")
+	wtsapi32                         = syscall.NewLazyDLL("wtsapi32.dll")
+	procWTSQuerySessionInformationW  = wtsapi32.NewProc("WTSQuerySessionInformationW")
+	procWTSFreeMemory                = wtsapi32.NewProc("WTSFreeMemory")
+	procGetLastInputInfo             = syscall.NewLazyDLL("user32.dll").NewProc("GetLastInputInfo")
+)
+
+type systemPowerStatus struct {
+	ACLineStatus, BatteryFlag, BatteryLifePercent, SystemStatusFlag uint8
+	BatteryLifeTime, BatteryFullLifeTime                            uint32
+}
+
+// onBattery is true when the AC line is offline; unknown counts as AC.
+func onBattery() (bool, error) {
+	var s systemPowerStatus
+	if r, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&s))); r == 0 { return false, err }
+	return s.ACLineStatus == 0, nil
+}
+
+type comGUID struct {
+	Data1        uint32
+	Data2, Data3 uint16
+	Data4        [8]byte
+}
+
+var (
+	clsidNetworkListManager = comGUID{0xDCB00C01, 0x570F, 0x4A9B, [8]byte{0x8D, 0x69, 0x19, 0x9F, 0xDB, 0xA5, 0x72, 0x3B}}
+	iidINetworkCostManager  = comGUID{0xDCB00008, 0x570F, 0x4A9B, [8]byte{0x8D, 0x69, 0x19, 0x9F, 0xDB, 0xA5, 0x72, 0x3B}}
+)
+
+const (
+	clsctxAll     = 0x17
+	costFixed     = 0x2     // NLM_CONNECTION_COST_FIXED
+	costVariable  = 0x4     // NLM_CONNECTION_COST_VARIABLE
+	costOverLimit = 0x10000 // NLM_CONNECTION_COST_OVERDATALIMIT
+	costRoaming   = 0x40000 // NLM_CONNECTION_COST_ROAMING
+)
+
+// metered asks the Network List Manager what the machine's connection
+// costs (INetworkCostManager::GetCost), the cost Settings shows as a
+// metered connection.
+func metered() (bool, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if r, _, _ := procCoInitializeEx.Call(0, 0); int32(r) >= 0 { defer procCoUninitialize.Call() } // COINIT_MULTITHREADED
+	var mgr unsafe.Pointer
+	if r, _, _ := procCoCreateInstance.Call(uintptr(unsafe.Pointer(&clsidNetworkListManager)), 0, clsctxAll, uintptr(unsafe.Pointer(&iidINetworkCostManager)), uintptr(unsafe.Pointer(&mgr))); r != 0 {
+		return false, fmt.Errorf("network list manager: HRESULT %#x", uint32(r))
+	}
+	vtbl := *(**[6]uintptr)(mgr) // IUnknown, then GetCost
+	defer syscall.SyscallN(vtbl[2], uintptr(mgr))
+	var cost uint32
+	if r, _, _ := syscall.SyscallN(vtbl[3], uintptr(mgr), uintptr(unsafe.Pointer(&cost)), 0); r != 0 {
+		return false, fmt.Errorf("network cost: HRESULT %#x", uint32(r))
+	}
+	return cost&(costFixed|costVariable|costOverLimit|costRoaming) != 0, nil
+}
+
+const (
+	wtsSessionInfoEx = 25         // WTS_INFO_CLASS WTSSessionInfoEx
+	wtsActive        = 0          // WTS_CONNECTSTATE_CLASS WTSActive
+	wtsLocked        = 0          // WTS_SESSIONSTATE_LOCK (Windows 8 and later; 7 has it the other way round)
+	noSession        = 0xFFFFFFFF // WTSGetActiveConsoleSessionId: nobody at the console
+)
+
+// userActive is true while someone is logged on at the console, the
+// session is not locked and, when this process runs in that session, had
+// input within idle. From another session the input cannot be seen, so
+// an unlocked console counts as in use.
+func userActive(idle time.Duration) (bool, error) {
+	id, _, _ := procWTSGetActiveConsoleSessionId.Call()
+	if uint32(id) == noSession { return false, nil }
+	var info *[5]uint32 // WTSINFOEXW: Level, then WTSINFOEX_LEVEL1_W aligned to 8: SessionId, SessionState, SessionFlags
+	var n uint32
+	if r, _, err := procWTSQuerySessionInformationW.Call(0, id, wtsSessionInfoEx, uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&n))); r == 0 {
+		return false, fmt.Errorf("console session: %w", err)
+	}
+	state, flags := int32(info[3]), int32(info[4])
+	procWTSFreeMemory.Call(uintptr(unsafe.Pointer(info)))
+	if state != wtsActive || flags == wtsLocked { return false, nil }
+	var own uint32
+	if r, _, _ := procProcessIdToSessionId.Call(uintptr(syscall.Getpid()), uintptr(unsafe.Pointer(&own))); r == 0 || own != uint32(id) { return true, nil }
+	last := struct{ size, time uint32 }{size: 8} // LASTINPUTINFO
+	if r, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&last))); r == 0 { return false, fmt.Errorf("last input: %w", err) }
+	now, _, _ := procGetTickCount.Call()
+	return time.Duration(uint32(now)-last.time)*time.Millisecond < idle, nil
+}
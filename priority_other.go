@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// lowerPriority is Windows only: nice and I/O classes are per thread on
+// Linux and cannot be applied to the Go runtime's threads from inside.
+func lowerPriority() error {
+	return errors.New(`priority "low" is Windows only; run under nice/ionice instead`)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const processModeBackgroundBegin = 0x00100000
+
+var procSetPriorityClass = syscall.NewLazyDLL("kernel32.dll").NewProc("SetPriorityClass")
+
+// lowerPriority puts the whole process into background mode: low CPU
+// priority plus very low I/O and memory priority, so a till or desktop
+// in use stays responsive while the sync runs.
+func lowerPriority() error {
+	self, err := syscall.GetCurrentProcess()
+	if err != nil { return err }
+	if r, _, e := procSetPriorityClass.Call(uintptr(self), processModeBackgroundBegin); r == 0 { return e }
+	return nil
+}
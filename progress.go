@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ────────── progress reporting ─────────────────────────────
+// Progress receives what a run does, for display. The CLI prints lines
+// through printer; an embedding application can pass its own in runOpts.
+// Workers call it concurrently, so implementations must be safe for that.
+type Progress interface {
+	OnFileStart(rel string, size int64) // an upload begins
+	OnBytes(rel string, n int64)       // n more bytes of rel were sent
+	OnFileDone(rel string, size int64)  // rel was uploaded
+	OnError(rel string, err error)      // rel was not synced; ErrConflict, ErrWithheld or ErrHeld for skips
+	OnSummary(s Summary)                // once, at the end of the run
+}
+
+// uploadReporter is implemented by a Progress that wants more about a
+// finished upload than OnFileDone tells (the Bus, for events and the
+// digest). It is called instead of OnFileDone.
+type uploadReporter interface {
+	onUpload(u upload)
+}
+
+type upload struct {
+	rel      string
+	size     int64
+	replaced bool     // the target had a copy before
+	tags     []string // classification, see tags.go; nil without tags
+	pulled   bool     // downloaded into local_dir (direction pull); replaced is then about the local copy
+}
+
+func reportUpload(p Progress, u upload) {
+	if ur, ok := p.(uploadReporter); ok { ur.onUpload(u); return }
+	p.OnFileDone(u.rel, u.size)
+}
+
+// removalReporter is implemented by a Progress that wants to hear of the
+// files a two-way sync deleted (the Bus, for events and the digest).
+type removalReporter interface {
+	onRemove(rel string, local bool)
+}
+
+func reportRemoval(p Progress, rel string, local bool) {
+	if rr, ok := p.(removalReporter); ok { rr.onRemove(rel, local) }
+}
+
+type Summary struct {
+	RunID     string           `json:"run_id,omitempty"`
+	Uploaded  int64            `json:"uploaded"`
+	Pulled    int64            `json:"downloaded,omitempty"` // direction pull: files brought into local_dir
+	Deleted   int64            `json:"deleted,omitempty"`    // direction both and mirror: files deleted
+	Renamed   int64            `json:"renamed,omitempty"`    // detect_renames: files moved on the target instead of uploaded
+	BackedUp  int64            `json:"backed_up,omitempty"`  // backup_dir, backup_suffix: replaced copies kept on the target
+	Linked    int64            `json:"linked,omitempty"`     // dated_folder: unchanged files linked from the last folder
+	Archived  int64            `json:"archived,omitempty"`   // after_upload: local files deleted or moved once verified
+	Bytes     int64            `json:"bytes"`
+	Failed    int64            `json:"failed"`
+	Errors    map[string]int64 `json:"errors,omitempty"`     // failed files per error kind
+	Conflicts int64            `json:"conflicts"`
+	Resolved  int64            `json:"resolved,omitempty"`   // conflicts on_conflict settled, not counted in conflicts
+	Withheld  int64            `json:"withheld,omitempty"`   // files matching the never-transfer list
+	Held      int64            `json:"held,omitempty"`       // changed files under hold, not replaced on the target
+	Deferred  int64            `json:"deferred,omitempty"`   // large files held back on battery or a metered network
+	Elapsed   time.Duration    `json:"elapsed_ns"`
+	Recovered bool             `json:"recovered,omitempty"`  // succeeded after a failed run (needs state_file)
+	SLA       time.Duration    `json:"sla_ns,omitempty"`
+	Stale     time.Duration    `json:"stale_ns,omitempty"`   // SLA breached: time since the last good run
+	Err       error            `json:"-"`                    // why the run stopped early, nil if it finished
+	ErrKind   string           `json:"error_kind,omitempty"`
+	Tags      TagTotals        `json:"tags,omitempty"`       // uploads per classification tag, with tags set
+	Snapshot  string           `json:"snapshot,omitempty"`   // taken before the first change on the target
+	DryRun    bool             `json:"dry_run,omitempty"`    // -dry-run: the counts are what would have changed
+	Config    string           `json:"config,omitempty"`     // SHA-256 of the config, see drift.go
+	Drift     bool             `json:"drift,omitempty"`      // the config differs from central_config
+}
+
+// failures describes the failed files, e.g. "3 file(s) failed: 2 file-locked, 1 permission".
+func (s Summary) failures() string {
+	kinds := make([]string, 0, len(s.Errors))
+	for k := range s.Errors { kinds = append(kinds, k) }
+	sort.Slice(kinds, func(i, j int) bool {
+		if s.Errors[kinds[i]] != s.Errors[kinds[j]] { return s.Errors[kinds[i]] > s.Errors[kinds[j]] }
+		return kinds[i] < kinds[j]
+	})
+	parts := make([]string, len(kinds))
+	for i, k := range kinds { parts[i] = fmt.Sprintf("%d %s", s.Errors[k], kindLabel(k)) }
+	msg := tr("files_failed", s.Failed)
+	if len(parts) > 0 { msg += ": " + strings.Join(parts, ", ") }
+	return msg
+}
+
+// tagLines lists the uploads per tag, untagged last.
+func (s Summary) tagLines() []string {
+	names := make([]string, 0, len(s.Tags))
+	for t := range s.Tags { names = append(names, t) }
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == untagged) != (names[j] == untagged) { return names[j] == untagged }
+		return names[i] < names[j]
+	})
+	lines := make([]string, len(names))
+	for i, t := range names {
+		label := t
+		if t == untagged { label = tr("untagged") }
+		lines[i] = tr("tag_total", label, s.Tags[t].Files, s.Tags[t].Bytes)
+	}
+	return lines
+}
+
+func (s Summary) staleMessage() string {
+	return tr("stale", s.Stale.Round(time.Minute), s.SLA)
+}
+
+type printer struct{}
+
+func (printer) OnFileStart(rel string, _ int64) {
+	if logOn(catTransfer, lvlInfo) { say("↑", "%s", rel) }
+}
+func (printer) OnBytes(string, int64)           {}
+func (printer) OnFileDone(string, int64)        {}
+
+func (printer) OnError(rel string, err error) {
+	var tw *twoWayConflict
+	if errors.As(err, &tw) {
+		say("!", "%s", tr(tw.key, rel))
+		return
+	}
+	if errors.Is(err, ErrConflict) {
+		say("!", "%s", tr("conflict", rel))
+		return
+	}
+	if errors.Is(err, ErrWithheld) {
+		say("!", "%s", tr("withheld", rel, err))
+		return
+	}
+	if errors.Is(err, ErrHeld) {
+		say("!", "%s", tr("held", rel))
+		return
+	}
+	var pd *powerDeferral
+	if errors.As(err, &pd) {
+		say("!", "%s", tr("power_deferred", rel, sizeText(pd.size), tr(pd.key)))
+		return
+	}
+	say("✗", "%s: [%s] %v", rel, kindLabel(errorKind(err)), err)
+}
+
+func (printer) OnSummary(s Summary) {
+	if s.Stale > 0 { say("!", "%s", tr("sla_breached", s.staleMessage())) }
+	switch {
+	case errors.Is(s.Err, context.Canceled) || errors.Is(s.Err, context.DeadlineExceeded):
+		say("✗", "%s", tr("interrupted", s.Err))
+	case s.Err != nil:
+		log.Printf("[%s] %v", kindLabel(s.ErrKind), s.Err)
+	case s.Failed > 0:
+		say("✗", "%s", tr("finished_failed", s.failures()))
+	case s.DryRun:
+		say("✓", "%s", tr("dry_run_complete", s.Uploaded, s.Pulled, s.Renamed, s.Deleted))
+	case s.Conflicts > 0:
+		say("✓", "%s", tr("complete_conflicts", s.Conflicts))
+	default:
+		say("✓", "%s", tr("complete"))
+	}
+	if s.Withheld > 0 { say("!", "%s", tr("withheld_total", s.Withheld)) }
+	if s.Deferred > 0 { say("!", "%s", tr("deferred_total", s.Deferred)) }
+	if s.Resolved > 0 { say("!", "%s", tr("resolved_total", s.Resolved)) }
+	if logOn(catTransfer, lvlInfo) {
+		if s.BackedUp > 0 { say(" ", "%s", tr("backed_up_total", s.BackedUp)) }
+		if s.Linked > 0 { say(" ", "%s", tr("linked_total", s.Linked)) }
+		if s.Archived > 0 { say(" ", "%s", tr("archived_total", s.Archived)) }
+		for _, l := range s.tagLines() { say(" ", "%s", l) }
+	}
+}
+
+// progressTee hands every call to each Progress in turn.
+type progressTee []Progress
+
+func (t progressTee) OnFileStart(rel string, size int64) { for _, p := range t { p.OnFileStart(rel, size) } }
+func (t progressTee) OnBytes(rel string, n int64)        { for _, p := range t { p.OnBytes(rel, n) } }
+func (t progressTee) OnFileDone(rel string, size int64)  { for _, p := range t { p.OnFileDone(rel, size) } }
+func (t progressTee) onUpload(u upload)                  { for _, p := range t { reportUpload(p, u) } }
+func (t progressTee) onRemove(rel string, local bool)    { for _, p := range t { reportRemoval(p, rel, local) } }
+func (t progressTee) onRename(from, to string)           { for _, p := range t { reportRename(p, from, to) } }
+func (t progressTee) onResolve(rel, won string)          { for _, p := range t { reportResolve(p, rel, won) } }
+func (t progressTee) onDrift(sum, published, msg string) { for _, p := range t { reportDrift(p, sum, published, msg) } }
+func (t progressTee) OnError(rel string, err error)      { for _, p := range t { p.OnError(rel, err) } }
+func (t progressTee) OnSummary(s Summary)                { for _, p := range t { p.OnSummary(s) } }
+
+type bytesKey struct{}
+
+// withByteCount makes transfers under ctx report the bytes they move to fn.
+func withByteCount(ctx context.Context, fn func(n int64)) context.Context {
+	return context.WithValue(ctx, bytesKey{}, fn)
+}
+
+func countBytes(ctx context.Context, n int64) {
+	if fn, ok := ctx.Value(bytesKey{}).(func(int64)); ok && n > 0 { fn(n) }
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── pull direction ─────────────────────────────────
+// With direction "pull" the flow is reversed, for the receiving side of a
+// drop box: the target is walked under remote_path (and site) and every
+// file there that local_dir lacks, or has an older copy of, is downloaded,
+// with the folders it needs. The comparer decides as for an upload with
+// the two sides swapped, so compare: size+mtime also fetches a file whose
+// size changed. A download goes to a .part file next to its destination,
+// gets the remote mtime and is renamed into place, so a program reading
+// local_dir never sees half a file and the next run finds it up to date.
+// Names Windows cannot create are mapped under map_names, see
+// rpath.MapName; names the target path rules refuse (a ':' or a device
+// name) fail as name-invalid. Nothing local is ever deleted. With a
+// state file, a local copy edited since its last download is a conflict
+// when the target's copy changed too, settled by on_conflict. FTP, SMB
+// and local targets can be walked.
+
+// lister is implemented by targets a pull can walk.
+type lister interface {
+	// list returns the files in dir, a slash path under the root ("" for
+	// the root), and the names of its subfolders.
+	list(ctx context.Context, dir string) (files []FileInfo, dirs []string, err error)
+}
+
+// checkDirection rejects direction settings, and upload-only settings a
+// pull or two-way sync cannot honour.
+func checkDirection(c *Conf) error {
+	switch d := strings.ToLower(c.Direction); d {
+	case "", "push":
+		return nil
+	case "pull", "both":
+		c.Direction = d
+	default:
+		return fmt.Errorf("direction: %q is not \"push\", \"pull\" or \"both\"", c.Direction)
+	}
+	if c.MapNames == "" { c.MapNames = rpath.MapReplace }
+	if _, err := rpath.MapName("", c.MapNames); err != nil { return fmt.Errorf("map_names: %w", err) }
+	switch {
+	case c.Direction == "both" && c.StateFile == "":
+		return fmt.Errorf("direction both needs state_file to tell a deletion from a file never seen")
+	case c.Direction == "both" && c.Compare != "" && !strings.EqualFold(c.Compare, "mtime"):
+		return fmt.Errorf("direction both decides from the state file; compare cannot be set")
+	case strings.EqualFold(c.Compare, "hash"):
+		return fmt.Errorf("direction pull cannot use compare: hash, which hashes the uploaded copy")
+	case c.Canary != "", c.WriteOnce, c.Assert != "":
+		return fmt.Errorf("direction %s cannot use canary, write_once or assert", c.Direction)
+	}
+	return nil
+}
+
+// listerOf is t's listing, through the wrapper an SMB target is shared in.
+func listerOf(t target) (lister, bool) {
+	if sc, shared := t.(sharedConn); shared { t = sc.target }
+	l, ok := t.(lister)
+	return l, ok
+}
+
+// listTree calls fn for every file under dir, folder by folder in name order.
+func listTree(ctx context.Context, l lister, dir string, fn func(FileInfo) error) error {
+	files, dirs, err := l.list(ctx, dir)
+	if err != nil { return fmt.Errorf("listing %q on the target: %w", dir, err) }
+	sort.Slice(files, func(i, j int) bool { return files[i].Rel < files[j].Rel })
+	sort.Strings(dirs)
+	for _, f := range files {
+		if err := fn(f); err != nil { return err }
+	}
+	for _, d := range dirs {
+		if err := listTree(ctx, l, rpath.Join(dir, d), fn); err != nil { return err }
+	}
+	return nil
+}
+
+// pull walks the target on first while the pool downloads what it finds
+// on connections of its own.
+func (r *run) pull(ctx context.Context, first target, dial func() (target, error)) error {
+	defer first.close()
+	l, ok := listerOf(first)
+	if !ok { return fmt.Errorf("direction pull: %s targets cannot be listed", r.conf.Type) }
+	jobs := make(chan job)
+	done := make(chan struct{})
+	go func() { r.pool(ctx, nil, dial, jobs); close(done) }()
+	err := listTree(ctx, l, r.conf.Site, func(f FileInfo) error {
+		local, err := r.pullPath(f.Rel)
+		if err != nil {
+			err = withClass(ErrInvalidName, err)
+			r.fail(err)
+			r.prog.OnError(f.Rel, err)
+			return nil
+		}
+		select {
+		case jobs <- job{path: local, rel: f.Rel, size: f.Size, mtime: f.MTime, known: true, pull: true}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(jobs)
+	<-done
+	return err
+}
+
+// pullPath is where rel, a path on the target, lands under local_dir.
+func (r *run) pullPath(rel string) (string, error) {
+	under := rel
+	if r.conf.Site != "" { under = strings.TrimPrefix(rel, r.conf.Site+"/") }
+	parts := strings.Split(under, "/")
+	for i, p := range parts {
+		m, err := rpath.MapName(p, r.conf.MapNames)
+		if err != nil { return "", err }
+		if m != p { debugf(catCompare, "%s: %q is saved as %q", rel, p, m) }
+		parts[i] = m
+	}
+	return rpath.Local(r.conf.LocalDir, strings.Join(parts, "/"))
+}
+
+// bothChanged reports whether a pull finds local, the copy of j in
+// local_dir, changed since its last download and the target's copy too.
+// It takes the state file; without one there is no telling.
+func (r *run) bothChanged(j job, local FileInfo) bool {
+	last, ok := r.st.last(j.rel)
+	return ok && local.Exists && r.conf.Direction == "pull" &&
+		(local.Size != last.Size || !local.MTime.Equal(last.LocalMTime)) && (j.size != last.Size || j.mtime.After(last.RemoteMTime))
+}
+
+// pullFile downloads j.rel to j.path if the local copy is missing or the
+// comparer, asked with the sides swapped, says it is behind. The listing
+// was the lookup, so there is no round trip to report.
+func (r *run) pullFile(ctx context.Context, t target, j job) (rtt time.Duration, err error) {
+	if err := ctx.Err(); err != nil { return 0, err }
+	remote := FileInfo{Rel: j.rel, Path: j.path, Size: j.size, MTime: j.mtime, Exists: true}
+	var local FileInfo
+	fi, err := os.Stat(j.path)
+	switch {
+	case err == nil && fi.IsDir():
+		return 0, withClass(ErrInvalidName, fmt.Errorf("%s is a folder in local_dir", j.path))
+	case err == nil:
+		local = FileInfo{Rel: j.rel, Path: j.path, Size: fi.Size(), MTime: fi.ModTime(), Exists: true}
+	case !errors.Is(err, fs.ErrNotExist):
+		return 0, classifyOS(err)
+	}
+	if r.bothChanged(j, local) {
+		// changed here since the last download, and on the target too;
+		// asked first, as the comparer sees only which copy is newer
+		switch r.winner(local.MTime, j.mtime) {
+		case "local":
+			r.settled(j.rel, "local", "")
+			return 0, nil
+		case "remote":
+			r.settled(j.rel, "remote", "")
+		case "both":
+			if err := r.keepBoth(j.rel, j.path); err != nil { return 0, err }
+		default:
+			return 0, errBothChanged
+		}
+	} else {
+		need, err := r.cmp.NeedsUpload(&remote, local)
+		if err != nil { return 0, err }
+		if !need {
+			debugf(catCompare, "%s: up to date", j.rel)
+			return 0, nil
+		}
+	}
+	if local.Exists {
+		debugf(catCompare, "%s: download (target %d bytes, %s; local %d bytes, %s)", j.rel, j.size, j.mtime.Format(time.RFC3339), local.Size, local.MTime.Format(time.RFC3339))
+	} else {
+		debugf(catCompare, "%s: download (not in local_dir)", j.rel)
+	}
+	if r.opts.dryRun {
+		r.would("↓", "would_download", j.rel)
+		r.pulled.Add(1)
+		r.bytes.Add(j.size)
+		return 0, nil
+	}
+	if err := r.power.hold(j.size); err != nil { return 0, err }
+	if logOn(catTransfer, lvlInfo) { say("↓", "%s", j.rel) }
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil { return 0, classifyOS(err) }
+	tmp := j.path + ".part"
+	out, err := os.Create(tmp)
+	if err != nil { return 0, classifyOS(err) }
+	got := withByteCount(ctx, func(n int64) { r.bytes.Add(n); r.prog.OnBytes(j.rel, n); r.power.pace(ctx, n); r.bw.pace(ctx, n) })
+	began := time.Now()
+	done := r.busy.start()
+	err = t.fetch(got, j.rel, out)
+	done()
+	if cerr := out.Close(); err == nil { err = classifyOS(cerr) }
+	if err == nil && !j.mtime.IsZero() { err = classifyOS(os.Chtimes(tmp, time.Now(), j.mtime)) }
+	if err == nil { err = classifyOS(os.Rename(tmp, j.path)) }
+	if err != nil { os.Remove(tmp); return 0, err }
+	debugf(catTransfer, "%s: %d bytes in %s", j.rel, j.size, time.Since(began).Round(time.Millisecond))
+	r.pulled.Add(1)
+	if fi, err := os.Stat(j.path); err == nil && r.st != nil { r.st.record(j.rel, j.mtime, "", FileInfo{Size: j.size, MTime: fi.ModTime()}) }
+	reportUpload(r.prog, upload{rel: j.rel, size: j.size, replaced: local.Exists, pulled: true})
+	return 0, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPull is a two-way setup turned into a pull; stateFile false drops
+// the state file.
+func newPull(t *testing.T, stateFile bool) *twoWay {
+	w := newTwoWay(t)
+	w.conf.Direction = "pull"
+	if !stateFile { w.conf.StateFile = "" }
+	return w
+}
+
+func TestPullWithoutState(t *testing.T) {
+	w := newPull(t, false)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.there, "a.txt", "new", then)
+	put(t, w.there, "sub/b.txt", "newer", then.Add(time.Minute))
+	put(t, w.here, "sub/b.txt", "old", then)
+	put(t, w.here, "c.txt", "mine", then)
+	if s := w.sync(t); s.Pulled != 2 || s.Failed != 0 { t.Errorf("%d downloaded, %d failed; want 2, 0", s.Pulled, s.Failed) }
+	for rel, want := range map[string]string{"a.txt": "new", "sub/b.txt": "newer", "c.txt": "mine"} {
+		if got := body(w.here, rel); got != want { t.Errorf("%s = %q, want %q", rel, got, want) }
+	}
+	if fi, err := os.Stat(filepath.Join(w.here, "a.txt")); err != nil || !fi.ModTime().Equal(then) { t.Errorf("a.txt mtime not the target's") }
+	if s := w.sync(t); s.Pulled != 0 { t.Errorf("second run downloaded %d, want 0", s.Pulled) }
+}
+
+// TestPullConflict edits a file on both sides after a pull, the local
+// edit newer or older than the target's, under every on_conflict.
+func TestPullConflict(t *testing.T) {
+	for _, c := range []struct {
+		mode        string
+		localNewer  bool
+		want        string // local_dir's copy after the run
+		kept        bool   // the local edit moved aside
+		conflicts   int64
+	}{
+		{"", true, "local v2", false, 1},
+		{"", false, "local v2", false, 1},
+		{"local-wins", true, "local v2", false, 0},
+		{"local-wins", false, "local v2", false, 0},
+		{"remote-wins", true, "remote v2", false, 0},
+		{"remote-wins", false, "remote v2", false, 0},
+		{"newest-wins", true, "local v2", false, 0},
+		{"newest-wins", false, "remote v2", false, 0},
+		{"keep-both", true, "remote v2", true, 0},
+		{"keep-both", false, "remote v2", true, 0},
+	} {
+		name := c.mode
+		if name == "" { name = "skip-and-report" }
+		if c.localNewer { name += "/local-newer" } else { name += "/remote-newer" }
+		t.Run(name, func(t *testing.T) {
+			w := newPull(t, true)
+			w.conf.OnConflict = c.mode
+			then := time.Now().Add(-time.Hour).Truncate(time.Second)
+			put(t, w.there, "f.txt", "v1", then)
+			w.sync(t)
+
+			lm, rm := then.Add(2*time.Minute), then.Add(time.Minute)
+			if !c.localNewer { lm, rm = rm, lm }
+			put(t, w.here, "f.txt", "local v2", lm)
+			put(t, w.there, "f.txt", "remote v2", rm)
+			s := w.sync(t)
+			if got := body(w.here, "f.txt"); got != c.want { t.Errorf("f.txt = %q, want %q", got, c.want) }
+			if s.Conflicts != c.conflicts || s.Resolved != 1-c.conflicts { t.Errorf("%d conflicts, %d resolved; want %d, %d", s.Conflicts, s.Resolved, c.conflicts, 1-c.conflicts) }
+			if got := body(w.there, "f.txt"); got != "remote v2" { t.Errorf("target's copy = %q, a pull changed it", got) }
+			kept := false
+			ents, _ := os.ReadDir(w.here)
+			for _, e := range ents {
+				if strings.Contains(e.Name(), "(conflict ") { kept = body(w.here, e.Name()) == "local v2" }
+			}
+			if kept != c.kept { t.Errorf("local edit kept aside: %v, want %v", kept, c.kept) }
+		})
+	}
+}
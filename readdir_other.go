@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// readDir is the portable listing. Info comes from the DirEntry, which
+// on most systems is the lstat os.ReadDir already had to do; a symlink
+// reports no info so the worker's os.Stat follows it.
+func readDir(dir string) ([]dirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil { return nil, err }
+	out := make([]dirEntry, 0, len(entries))
+	for _, e := range entries {
+		de := dirEntry{name: e.Name(), dir: e.IsDir()}
+		if e.Type()&fs.ModeSymlink == 0 {
+			fi, err := e.Info()
+			if errors.Is(err, fs.ErrNotExist) { continue } // removed since the listing
+			if err != nil { return nil, err }
+			de.size, de.mtime, de.hasInfo = fi.Size(), fi.ModTime(), true
+		}
+		out = append(out, de)
+	}
+	return out, nil
+}
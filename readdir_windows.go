@@ -0,0 +1,61 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	findExInfoBasic       = 1
+	findExSearchNameMatch = 0
+	findFirstExLargeFetch = 2
+)
+
+var procFindFirstFileExW = syscall.NewLazyDLL("kernel32.dll").NewProc("FindFirstFileExW")
+
+// readDir lists dir with FindFirstFileExW, asking for basic info and large
+// fetch buffers, so each file's size and mtime come with the listing and
+// no per-file stat is needed. Directory times are left for the caller to
+// stat: NTFS may refresh the copy kept in the parent's index late, and warm
+// start depends on them.
+func readDir(dir string) ([]dirEntry, error) {
+	pattern, err := syscall.UTF16PtrFromString(dir + `\*`)
+	if err != nil { return nil, err }
+	var fd syscall.Win32finddata
+	h, _, e := procFindFirstFileExW.Call(uintptr(unsafe.Pointer(pattern)), findExInfoBasic,
+		uintptr(unsafe.Pointer(&fd)), findExSearchNameMatch, 0, findFirstExLargeFetch)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if e == syscall.ERROR_FILE_NOT_FOUND { return nil, nil }
+		return nil, &os.PathError{Op: "FindFirstFileEx", Path: dir, Err: e}
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+
+	var out []dirEntry
+	for {
+		name := syscall.UTF16ToString(fd.FileName[:])
+		if name != "." && name != ".." {
+			de := dirEntry{name: name}
+			switch {
+			case fd.FileAttributes&syscall.FILE_ATTRIBUTE_REPARSE_POINT != 0:
+				// links: leave it to os.Stat to follow them
+			case fd.FileAttributes&syscall.FILE_ATTRIBUTE_DIRECTORY != 0:
+				de.dir = true
+			default:
+				de.size = int64(fd.FileSizeHigh)<<32 | int64(fd.FileSizeLow)
+				de.mtime = time.Unix(0, fd.LastWriteTime.Nanoseconds())
+				de.hasInfo = true
+			}
+			out = append(out, de)
+		}
+		if err := syscall.FindNextFile(syscall.Handle(h), &fd); err != nil {
+			if err == syscall.ERROR_NO_MORE_FILES { break }
+			return nil, &os.PathError{Op: "FindNextFile", Path: dir, Err: err}
+		}
+	}
+	sortEntries(out)
+	return out, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ────────── session recording ──────────────────────────────
+// With -record <dir> every FTP connection keeps a transcript of the file
+// it is working on: the login, then each command and reply since that
+// file started. When a file fails for good, the transcript is written to
+// <dir>/<path>.rec so a "550 on exactly one path" report from a site can
+// be sent in and replayed here:
+//
+//   dirsync.exe -conf dataxfer.conf -record C:\datasync\rec
+//   dirsync.exe replay -host 127.0.0.1:2121 -user test -pass test rec\a_b_f.rec
+//
+// A transcript has one line per event: "> " command, "< " reply, "= " data
+// connection summary. USER and PASS are masked and file contents are not
+// kept, only their byte counts.
+var recordDir string
+
+type sessionRec struct {
+	host  string
+	mu    sync.Mutex
+	lines []string
+	login int    // lines kept across files: greeting and login
+	file  string
+	ctrl  bool   // the control connection has been dialled
+}
+
+var recordTLSWarning sync.Once
+
+// newSessionRec returns nil when not recording. Over FTPS the socket only
+// carries ciphertext, so nothing is recorded.
+func newSessionRec(cfg FTPConf) *sessionRec {
+	if recordDir == "" { return nil }
+	if mode, _ := cfg.tlsMode(); mode != "" {
+		recordTLSWarning.Do(func() { log.Printf("record: sessions over FTPS cannot be recorded") })
+		return nil
+	}
+	return &sessionRec{host: cfg.Host}
+}
+
+// wrap records the first connection dialled (the control connection) line
+// by line and later ones (data connections) as byte counts.
+func (s *sessionRec) wrap(dial func(network, addr string) (net.Conn, error)) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		c, err := dial(network, addr)
+		if err != nil { return nil, err }
+		s.mu.Lock()
+		ctrl := !s.ctrl
+		s.ctrl = true
+		s.mu.Unlock()
+		if ctrl { return &recConn{Conn: c, s: s}, nil }
+		return &recData{Conn: c, s: s}, nil
+	}
+}
+
+func (s *sessionRec) add(line string) {
+	if strings.HasPrefix(line, "> USER ") || strings.HasPrefix(line, "> PASS ") { line = line[:7] + "****" }
+	s.mu.Lock()
+	s.lines = append(s.lines, line)
+	s.mu.Unlock()
+}
+
+func (s *sessionRec) loggedIn() {
+	s.mu.Lock()
+	s.login = len(s.lines)
+	s.mu.Unlock()
+}
+
+// begin starts the transcript of a new file, keeping the login.
+func (s *sessionRec) begin(rel string) {
+	s.mu.Lock()
+	s.lines, s.file = s.lines[:s.login], rel
+	s.mu.Unlock()
+}
+
+// save writes the current file's transcript.
+func (s *sessionRec) save(cause error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := filepath.Join(recordDir, strings.NewReplacer("/", "_", "\\", "_").Replace(s.file)+".rec")
+	var b strings.Builder
+	fmt.Fprintf(&b, "# datasync session with %s, %s\n# file %s: %v\n", s.host, time.Now().UTC().Format(time.RFC3339), s.file, cause)
+	for _, l := range s.lines { b.WriteString(l + "\n") }
+	err := os.MkdirAll(recordDir, 0755)
+	if err == nil { err = os.WriteFile(name, []byte(b.String()), 0644) }
+	if err != nil { log.Printf("record: %v", err); return }
+	log.Printf("record: session for %s saved to %s", s.file, name)
+}
+
+// recConn splits the control channel into lines per direction.
+type recConn struct {
+	net.Conn
+	s       *sessionRec
+	in, out []byte
+}
+
+func (c *recConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in = c.s.split(c.in, p[:n], "< ")
+	return n, err
+}
+
+func (c *recConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out = c.s.split(c.out, p[:n], "> ")
+	return n, err
+}
+
+// split records the complete lines in buf+p and returns the remainder.
+func (s *sessionRec) split(buf, p []byte, dir string) []byte {
+	buf = append(buf, p...)
+	for {
+		i := strings.IndexByte(string(buf), '\n')
+		if i < 0 { return buf }
+		s.add(dir + strings.TrimRight(string(buf[:i]), "\r"))
+		buf = buf[i+1:]
+	}
+}
+
+type recData struct {
+	net.Conn
+	s        *sessionRec
+	sent, rx int64
+	once     sync.Once
+}
+
+func (d *recData) Read(p []byte) (int, error)  { n, err := d.Conn.Read(p); d.rx += int64(n); return n, err }
+func (d *recData) Write(p []byte) (int, error) { n, err := d.Conn.Write(p); d.sent += int64(n); return n, err }
+
+func (d *recData) Close() error {
+	d.once.Do(func() { d.s.add(fmt.Sprintf("= data sent %d received %d", d.sent, d.rx)) })
+	return d.Conn.Close()
+}
+
+// ────────── replay ─────────────────────────────────────────
+// replay sends a recorded session's commands to another server, opening
+// data connections where the recording had them (uploads send as many
+// zero bytes as were recorded), and compares each reply code with the
+// recorded one.
+func replayMain(args []string) {
+	fl := flag.NewFlagSet("replay", flag.ExitOnError)
+	host := fl.String("host", "127.0.0.1:2121", "FTP server to replay against")
+	user := fl.String("user", "anonymous", "login used in place of the masked USER")
+	pass := fl.String("pass", "", "password used in place of the masked PASS")
+	fl.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	fl.Parse(args)
+	if fl.NArg() != 1 { log.Fatal("usage: replay [-host h:port] [-user u -pass p] session.rec") }
+
+	steps, err := loadSession(fl.Arg(0))
+	if err != nil { log.Fatal(err) }
+	conn, err := net.DialTimeout("tcp", *host, 10*time.Second)
+	if err != nil { log.Fatal(err) }
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+
+	differ, total := 0, 0
+	check := func(label, want string, code int, msg string) {
+		total++
+		msg, _, _ = strings.Cut(msg, "\n")
+		if want == "" || want == strconv.Itoa(code) { say("✓", "%s → %d %s", label, code, msg); return }
+		say("✗", "%s → %d %s (recorded %s)", label, code, msg, want)
+		differ++
+	}
+	code, msg, _ := tp.ReadResponse(0)
+	check("greeting", steps[0].reply, code, msg)
+
+	var data net.Conn
+	for _, st := range steps[1:] {
+		cmd := st.cmd
+		switch {
+		case cmd == "USER ****": cmd = "USER " + *user
+		case cmd == "PASS ****": cmd = "PASS " + *pass
+		}
+		if err = tp.PrintfLine("%s", cmd); err != nil { log.Fatal(err) }
+		verb, _, _ := strings.Cut(strings.ToUpper(cmd), " ")
+		if data != nil && st.data {
+			code, msg, err = tp.ReadResponse(0)
+			if err == nil && code < 200 {
+				if verb == "STOR" || verb == "APPE" || verb == "STOU" {
+					io.CopyN(data, zeros{}, st.sent)
+				} else {
+					io.Copy(io.Discard, data)
+				}
+			}
+			data.Close(); data = nil
+			if err != nil || code >= 200 { check(st.cmd, st.reply, code, msg); continue }
+		}
+		code, msg, _ = tp.ReadResponse(0)
+		check(st.cmd, st.reply, code, msg)
+		if verb == "PASV" || verb == "EPSV" {
+			if data, err = dialData(conn, code, msg); err != nil { log.Printf("replay: %s: %v", verb, err) }
+		}
+		if verb == "QUIT" { break }
+	}
+	if differ > 0 {
+		say("✗", "%d of %d reply code(s) differ from the recording", differ, total)
+		os.Exit(1)
+	}
+	say("✓", "Replayed %d exchange(s), all reply codes match the recording", total)
+}
+
+// step is one recorded command with its final reply code and, when it had
+// a data connection, how much was sent on it.
+type step struct {
+	cmd, reply string
+	data       bool
+	sent       int64
+}
+
+func loadSession(p string) ([]step, error) {
+	f, err := os.Open(p)
+	if err != nil { return nil, err }
+	defer f.Close()
+	steps := []step{{}} // steps[0] is the greeting
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		cur := &steps[len(steps)-1]
+		switch {
+		case strings.HasPrefix(line, "> "):
+			steps = append(steps, step{cmd: line[2:]})
+		case strings.HasPrefix(line, "< ") && len(line) >= 6 && line[5] != '-':
+			// the last line of a reply carries "nnn " (multi-line ones use "nnn-" before)
+			if _, err := strconv.Atoi(line[2:5]); err == nil { cur.reply = line[2:5] }
+		case strings.HasPrefix(line, "= data "):
+			cur.data = true
+			fmt.Sscanf(line, "= data sent %d", &cur.sent)
+		}
+	}
+	if err = sc.Err(); err != nil { return nil, err }
+	if len(steps) < 2 { return nil, fmt.Errorf("%s: no commands recorded", p) }
+	return steps, nil
+}
+
+var pasvAddr = regexp.MustCompile(`(\d+),(\d+),(\d+),(\d+),(\d+),(\d+)`)
+var epsvAddr = regexp.MustCompile(`\|\|\|(\d+)\|`)
+
+// dialData opens the data connection a PASV or EPSV reply points to. The
+// host is always the control connection's, as behind NAT the address in a
+// PASV reply is often unreachable.
+func dialData(ctrl net.Conn, code int, msg string) (net.Conn, error) {
+	var port int
+	switch {
+	case code == 227:
+		m := pasvAddr.FindStringSubmatch(msg)
+		if m == nil { return nil, fmt.Errorf("cannot parse %q", msg) }
+		hi, _ := strconv.Atoi(m[5]); lo, _ := strconv.Atoi(m[6])
+		port = hi<<8 | lo
+	case code == 229:
+		m := epsvAddr.FindStringSubmatch(msg)
+		if m == nil { return nil, fmt.Errorf("cannot parse %q", msg) }
+		port, _ = strconv.Atoi(m[1])
+	default:
+		return nil, nil
+	}
+	h, _, _ := net.SplitHostPort(ctrl.RemoteAddr().String())
+	return net.DialTimeout("tcp", net.JoinHostPort(h, strconv.Itoa(port)), 10*time.Second)
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) { clear(p); return len(p), nil }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+)
+
+// ────────── remote change check ───────────────────────────
+// The mirror is meant to be read only downstream, so a file there that
+// someone else changed or deleted is a fault to hear about, not only on
+// the next upload of it. `datasync check-remote` looks up every file the
+// state file recorded and compares it with the record, the way assert
+// does (size, mtime later than recorded, ETag): each difference is logged
+// and published as remote_modified or remote_deleted on the configured
+// event sinks, and the check exits 1. With -every it keeps watching,
+// reporting each change once, e.g. next to scheduled syncs:
+//
+//	datasync check-remote -conf site.json -every 1h
+//
+// It only reads, so it also serves as an independent monitor of a mirror
+// another instance maintains: on another machine, with read-only
+// credentials and read_only set, state_file naming the maintaining
+// instance's state file (which is never written here). -content also
+// reads every file back and compares its hash with the recorded one,
+// or, where none was recorded, with what an earlier pass read while size
+// and mtime stayed the same. A difference is remote_corrupt: the content
+// changed under an unchanged listing, as bit rot or a tampering writer
+// that restores mtimes leaves it.
+const (
+	EventRemoteModified = "remote_modified" // check-remote: changed on the target since our upload
+	EventRemoteDeleted  = "remote_deleted"  // check-remote: gone from the target
+	EventRemoteCorrupt  = "remote_corrupt"  // check-remote -content: other content, same size and mtime
+)
+
+// remoteCheck is what one pass of check-remote hands to the next.
+type remoteCheck struct {
+	content bool
+	algo    string             // hash_algorithm, for baselines
+	seen    map[string]string  // reported changes, so -every tells each once
+	sums    map[string]readSum // -content: what earlier passes read, for files recorded without a hash
+}
+
+type readSum struct {
+	stamp string // the listing and record it was read under
+	sum   string
+}
+
+func checkRemoteMain(args []string) {
+	fl := flag.NewFlagSet("check-remote", flag.ExitOnError)
+	cfgPath := fl.String("conf", "dataxfer.conf", "config JSON")
+	every   := fl.Duration("every", 0, "check again at this interval, e.g. 1h (0 = once)")
+	content := fl.Bool("content", false, "also read every file back and compare its hash")
+	fl.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	fl.Parse(args)
+
+	conf, err := loadConf(*cfgPath)
+	if err != nil { log.Fatal(err) }
+	if err = setupJob(conf); err != nil { log.Fatal(err) }
+	if err = checkFIPS(conf); err != nil { log.Fatal(err) }
+	applyPriority(conf)
+	setLanguage(conf.Language)
+	if conf.StateFile == "" { log.Fatal("check-remote needs state_file to know what was written") }
+	algo, err := hashAlgorithm(conf)
+	if err != nil { log.Fatal(err) }
+	bus, err := eventBus(conf, nil)
+	if err != nil { log.Fatal(err) }
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := &remoteCheck{content: *content, algo: algo, seen: map[string]string{}, sums: map[string]readSum{}}
+	code := checkRemote(ctx, conf, bus, c)
+	for *every > 0 && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(*every):
+			code = checkRemote(ctx, conf, bus, c)
+		}
+	}
+	if bus != nil { bus.close() }
+	stop()
+	os.Exit(code)
+}
+
+// checkRemote makes one pass; 1 means something changed or could not be
+// checked. State file changes by a sync running meanwhile are picked up
+// on the next pass.
+func checkRemote(ctx context.Context, conf *Conf, bus *Bus, c *remoteCheck) int {
+	ctx = withReadOnly(ctx)
+	st, err := loadState(conf.StateFile)
+	if err != nil { log.Print(err); return 1 }
+	t, err := connect(ctx, conf)
+	if err != nil { log.Printf("[%s] %v", errorKind(err), err); return 1 }
+	defer func() { t.close() }()
+	rels := make([]string, 0, len(st.Files))
+	for rel := range st.Files { rels = append(rels, rel) }
+	sort.Strings(rels)
+	changed, failed := 0, 0
+	for _, rel := range rels {
+		if ctx.Err() != nil { return 1 }
+		last := st.Files[rel]
+		remote, err := t.stat(ctx, rel)
+		if err != nil && !errors.Is(err, ErrNotFound) && retryable(err) {
+			// the connection may be what broke; one more try on a fresh one
+			t.close()
+			if t, err = connect(ctx, conf); err != nil { log.Printf("[%s] %v", errorKind(err), err); return 1 }
+			remote, err = t.stat(ctx, rel)
+		}
+		change, typ := "", ""
+		switch {
+		case errors.Is(err, ErrNotFound):
+			change, typ = "deleted", EventRemoteDeleted
+		case err != nil:
+			log.Printf("%s: [%s] %v", rel, errorKind(err), err)
+			failed++
+			continue
+		case changedSince(remote, last):
+			change, typ = "modified", EventRemoteModified
+		case c.content:
+			same, err := c.sameContent(ctx, t, rel, remote, last)
+			if err != nil {
+				log.Printf("%s: [%s] %v", rel, errorKind(err), err)
+				failed++
+				continue
+			}
+			if !same { change, typ = "corrupt", EventRemoteCorrupt }
+		}
+		if change == "" {
+			delete(c.seen, rel) // put back as it was: report it again if it changes again
+			continue
+		}
+		changed++
+		key := change + " " + remote.MTime.String()
+		if c.seen[rel] == key { continue }
+		c.seen[rel] = key
+		say("!", "%s", tr("remote_"+change, rel))
+		if bus != nil { bus.publish(Event{Type: typ, Path: rel, Size: remote.Size}) }
+	}
+	if changed == 0 && failed == 0 {
+		say("✓", "%s", tr("remote_intact", len(rels)))
+		return 0
+	}
+	if failed > 0 { say("✗", "%s", tr("files_failed", failed)) }
+	return 1
+}
+
+// changedSince reports whether the target's copy is no longer the one
+// last recorded: another size, a later mtime, or another ETag.
+func changedSince(remote FileInfo, last fileState) bool {
+	return remote.Size != last.Size || remote.MTime.After(last.RemoteMTime) ||
+		last.ETag != "" && remote.ETag != "" && remote.ETag != last.ETag
+}
+
+// sameContent reads rel back and compares it with the recorded hash,
+// else with what an earlier pass read under the same listing and record.
+// The first read of a file without either is taken as its baseline.
+func (c *remoteCheck) sameContent(ctx context.Context, t target, rel string, remote FileInfo, last fileState) (bool, error) {
+	algo, want := last.content()
+	if want == "" { algo = c.algo }
+	h := newHash(algo)
+	if err := t.fetch(ctx, rel, h); err != nil { return false, err }
+	sum := sumString(algo, h)
+	if want != "" {
+		if sum != want { debugf(catCompare, "%s: target content %s, recorded %s", rel, sum, want) }
+		return sum == want, nil
+	}
+	stamp := fmt.Sprint(remote.Size, " ", remote.MTime.UnixNano(), " ", last.RemoteMTime.UnixNano(), " ", last.Size)
+	if prev, ok := c.sums[rel]; ok && prev.stamp == stamp {
+		if sum != prev.sum { debugf(catCompare, "%s: target content %s, an earlier pass read %s", rel, sum, prev.sum) }
+		return sum == prev.sum, nil
+	}
+	c.sums[rel] = readSum{stamp, sum}
+	return true, nil
+}
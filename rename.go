@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── rename detection ───────────────────────────────
+// With detect_renames, a push that meets a file the target does not have
+// yet looks in the state file for one it wrote before with the same size
+// and content hash, which local_dir no longer has under its old path: the
+// file was renamed or moved. The copy on the target is then renamed to
+// the new path instead of uploading the file again and leaving the old
+// copy behind, so a reorganized tree costs one rename per file. The old
+// copy must still be as recorded (size, and an mtime no later than the
+// one written), must not be under hold, and each one is moved at most
+// once: of two new copies of one file, the second is uploaded. Where the
+// rename fails, the file is uploaded as usual. This needs compare: hash,
+// whose hashes of both sides it matches, and FTP, SMB, local or SCP
+// targets; two-way sync, which decides deletions itself, does without,
+// and so does write_once, whose copies stay where they were written.
+type renamer interface {
+	rename(ctx context.Context, from, to string) error
+}
+
+// checkRenames rejects settings detect_renames cannot work with.
+func checkRenames(c *Conf) error {
+	if !c.DetectRenames { return nil }
+	if !strings.EqualFold(c.Compare, "hash") { return fmt.Errorf("detect_renames needs compare: hash, to know files by their content") }
+	if c.Direction != "" && c.Direction != "push" { return fmt.Errorf("detect_renames is for direction push") }
+	if c.WriteOnce { return fmt.Errorf("detect_renames cannot be used with write_once, which never moves anything on the target") }
+	return nil
+}
+
+// renames is what the state file knew at the start of the run, by hash.
+type renames struct {
+	mu      sync.Mutex
+	bySum   map[string][]string // recorded sum → paths on the target
+	claimed map[string]bool
+}
+
+func newRenames(c *Conf, st *syncState) *renames {
+	if !c.DetectRenames || st == nil { return nil }
+	rn := &renames{bySum: map[string][]string{}, claimed: map[string]bool{}}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for rel, f := range st.Files {
+		if c.Site != "" && !strings.HasPrefix(rel, c.Site+"/") { continue }
+		if _, sum := f.content(); sum != "" { rn.bySum[sum] = append(rn.bySum[sum], rel) }
+	}
+	return rn
+}
+
+// claim picks a path on the target that local, new there, was renamed
+// from: one recorded with its size and hash that is gone from local_dir.
+func (r *run) claim(local *FileInfo) (string, fileState, bool) {
+	rn := r.renames
+	sum := local.SHA256
+	if sum == "" { sum = local.Sum }
+	if rn == nil || sum == "" { return "", fileState{}, false }
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	for _, from := range rn.bySum[sum] {
+		if rn.claimed[from] || from == local.Rel { continue }
+		last, ok := r.st.last(from)
+		if !ok || last.Size != local.Size { continue }
+		if _, s := last.content(); s != sum { continue }
+		p, err := rpath.Local(r.conf.LocalDir, r.under(from))
+		if err != nil { continue }
+		if _, err := os.Lstat(p); !errors.Is(err, fs.ErrNotExist) { continue } // still here: a copy, not a move
+		if r.hold.covers(r.localRel(p)) { continue }
+		rn.claimed[from] = true
+		return from, last, true
+	}
+	return "", fileState{}, false
+}
+
+// renameFrom moves the copy of from on the target to local's path. false
+// means it could not, and local is to be uploaded.
+func (r *run) renameFrom(ctx context.Context, t target, from string, last fileState, local *FileInfo) (bool, error) {
+	if sc, ok := t.(sharedConn); ok { t = sc.target }
+	rn, ok := t.(renamer)
+	if !ok { return false, nil }
+	old, err := t.stat(ctx, from)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	case old.Size != last.Size || old.MTime.After(last.RemoteMTime):
+		debugf(catCompare, "%s: not renamed from %s, which changed on the target", local.Rel, from)
+		return false, nil
+	}
+	if r.opts.dryRun {
+		if logOn(catTransfer, lvlInfo) { say("→", "%s", tr("would_rename", from, local.Rel)) }
+		r.renamed.Add(1)
+		return true, nil
+	}
+	if err := r.snapshot.before(ctx, t); err != nil { return false, err }
+	if err := rn.rename(ctx, from, local.Rel); err != nil {
+		debugf(catTransfer, "%s: renaming %s failed, uploading it: %v", local.Rel, from, err)
+		return false, nil
+	}
+	r.renamed.Add(1)
+	if logOn(catTransfer, lvlInfo) { say("→", "%s", tr("renamed", from, local.Rel)) }
+	reportRename(r.prog, from, local.Rel)
+	r.st.forget(from)
+	if ri, err := t.stat(ctx, local.Rel); err == nil { r.st.record(local.Rel, ri.MTime, ri.ETag, *local) }
+	return true, nil
+}
+
+// renameReporter is implemented by a Progress that wants to hear of the
+// files moved on the target (the Bus, for events).
+type renameReporter interface {
+	onRename(from, to string)
+}
+
+func reportRename(p Progress, from, to string) {
+	if rr, ok := p.(renameReporter); ok { rr.onRename(from, to) }
+}
+
+func (t *ftpTarget) rename(ctx context.Context, from, to string) error {
+	defer t.w.during(ctx)()
+	src, err := t.root.Resolve(from)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	dst, err := t.root.Resolve(to)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opRename); err != nil { return err }
+	for _, d := range rpath.Parents(dst) { t.c.MakeDir(d) }
+	return classifyFTP(ctxErr(ctx, t.c.Rename(src, dst)))
+}
+
+func (t *smbTarget) rename(ctx context.Context, from, to string) error {
+	src, err := t.toRemote(from)
+	if err != nil { return err }
+	dst, err := t.toRemote(to)
+	if err != nil { return err }
+	if err = takeOp(ctx, opRename); err != nil { return err }
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil { return classifyOS(err) }
+	if _, err = os.Lstat(dst); err == nil { return withClass(ErrConflict, fmt.Errorf("%s is already there", to)) }
+	return classifyOS(os.Rename(src, dst))
+}
+
+func (t *scpTarget) rename(ctx context.Context, from, to string) error {
+	src, err := t.root.Resolve(from)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	dst, err := t.root.Resolve(to)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opRename); err != nil { return err }
+	_, err = t.run(ctx, fmt.Sprintf("mkdir -p %s && mv -n %s %s && [ ! -e %s ]", shellQuote(rpath.Dir(dst)), shellQuote(src), shellQuote(dst), shellQuote(src)), nil)
+	return classifySCP(ctxErr(ctx, err))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckRenames(t *testing.T) {
+	for i, c := range []struct {
+		conf Conf
+		ok   bool
+	}{
+		{Conf{}, true},
+		{Conf{DetectRenames: true, Compare: "hash"}, true},
+		{Conf{DetectRenames: true, Compare: "hash", Direction: "push"}, true},
+		{Conf{DetectRenames: true}, false},
+		{Conf{DetectRenames: true, Compare: "hash", Direction: "both"}, false},
+		{Conf{DetectRenames: true, Compare: "hash", WriteOnce: true}, false},
+	} {
+		if err := checkRenames(&c.conf); (err == nil) != c.ok { t.Errorf("case %d: %v, want ok %v", i, err, c.ok) }
+	}
+}
+
+// newRenaming is a push with detect_renames over a.txt, b.txt and
+// held/c.txt, already uploaded once.
+func newRenaming(t *testing.T) *twoWay {
+	w := newTwoWay(t)
+	w.conf.Direction, w.conf.Compare, w.conf.DetectRenames = "", "hash", true
+	w.conf.Hold = HoldConf{Patterns: []string{"held/**"}}
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for rel, b := range map[string]string{"a.txt": "alpha", "b.txt": "bravo", "held/c.txt": "charlie"} { put(t, w.here, rel, b, then) }
+	if s := w.sync(t); s.Uploaded != 3 { t.Fatalf("first run uploaded %d, want 3", s.Uploaded) }
+	return w
+}
+
+// move renames from to to under root.
+func move(t *testing.T, root, from, to string) {
+	t.Helper()
+	dst := filepath.Join(root, filepath.FromSlash(to))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil { t.Fatal(err) }
+	if err := os.Rename(filepath.Join(root, filepath.FromSlash(from)), dst); err != nil { t.Fatal(err) }
+}
+
+func TestDetectRenames(t *testing.T) {
+	w := newRenaming(t)
+	move(t, w.here, "a.txt", "sub/a2.txt")
+	s := w.sync(t)
+	if s.Renamed != 1 || s.Uploaded != 0 { t.Errorf("%d renamed, %d uploaded; want 1, 0", s.Renamed, s.Uploaded) }
+	if body(w.there, "sub/a2.txt") != "alpha" || body(w.there, "a.txt") != "" { t.Errorf("target: a.txt %q, sub/a2.txt %q; want it moved", body(w.there, "a.txt"), body(w.there, "sub/a2.txt")) }
+	if s = w.sync(t); s.Renamed+s.Uploaded != 0 { t.Errorf("second run: %d renamed, %d uploaded; want nothing", s.Renamed, s.Uploaded) }
+
+	// moved back: the state file followed the first move
+	move(t, w.here, "sub/a2.txt", "a.txt")
+	if s = w.sync(t); s.Renamed != 1 || body(w.there, "a.txt") != "alpha" { t.Errorf("moved back: %d renamed, a.txt %q", s.Renamed, body(w.there, "a.txt")) }
+}
+
+func TestDetectRenamesUploads(t *testing.T) {
+	w := newRenaming(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// a copy, with the original still in local_dir
+	put(t, w.here, "copy-of-b.txt", "bravo", then)
+	// two copies of a.txt, with the original gone: one move, one upload
+	move(t, w.here, "a.txt", "a1.txt")
+	put(t, w.here, "a2.txt", "alpha", then)
+	// a held file is not moved away from its place on the target
+	move(t, w.here, "held/c.txt", "c.txt")
+	s := w.sync(t)
+	if s.Renamed != 1 || s.Uploaded != 3 { t.Errorf("%d renamed, %d uploaded; want 1, 3", s.Renamed, s.Uploaded) }
+	for rel, want := range map[string]string{"b.txt": "bravo", "copy-of-b.txt": "bravo", "a.txt": "", "a1.txt": "alpha", "a2.txt": "alpha", "held/c.txt": "charlie", "c.txt": "charlie"} {
+		if got := body(w.there, rel); got != want { t.Errorf("%s on the target = %q, want %q", rel, got, want) }
+	}
+}
+
+func TestDetectRenamesChangedOnTarget(t *testing.T) {
+	w := newRenaming(t)
+	// someone changed the old copy on the target since it was written
+	put(t, w.there, "b.txt", "bravo, edited there", time.Now().Add(time.Minute))
+	move(t, w.here, "b.txt", "b2.txt")
+	if s := w.sync(t); s.Renamed != 0 || s.Uploaded != 1 { t.Errorf("%d renamed, %d uploaded; want 0, 1", s.Renamed, s.Uploaded) }
+	if body(w.there, "b.txt") != "bravo, edited there" || body(w.there, "b2.txt") != "bravo" { t.Error("the changed copy was moved") }
+}
+
+func TestDetectRenamesDryRun(t *testing.T) {
+	w := newRenaming(t)
+	move(t, w.here, "a.txt", "a2.txt")
+	p := &summed{}
+	runSync(context.Background(), w.conf, runOpts{progress: p, dryRun: true})
+	if p.sum.Renamed != 1 || body(w.there, "a.txt") != "alpha" || body(w.there, "a2.txt") != "" { t.Errorf("dry run: %d renamed; want 1 reported and the target unchanged", p.sum.Renamed) }
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ────────── resuming an interrupted run ────────────────────
+// A run that does not finish cleanly (Ctrl+C, a shutdown, a timeout, or
+// files failing once the network went away) leaves a resume token in the
+// state file: the folders whose files it had all handled. The next run
+// skips those files, comparing nothing there, and only lists the folders
+// for their subfolders. A folder counts as handled once each of its files
+// is up to date or was uploaded; one failure, conflict or hold keeps it
+// open. The first clean run drops the token, so the skipped files are
+// compared again then. A token from other settings, or -full, is ignored.
+type resumeToken struct {
+	Run   string    `json:"run"`   // the run that left it
+	Since time.Time `json:"since"` // when that run started
+	Conf  string    `json:"conf"`  // fingerprint of the settings
+	Done  []string  `json:"done"`  // folders under local_dir, "" for the top
+}
+
+type resumer struct {
+	mu      sync.Mutex
+	skip    map[string]bool // done in an earlier run
+	done    map[string]bool // done by now, skip included
+	pending map[string]int  // queued files not yet handled
+	listed  map[string]bool // every file of the folder is queued
+	open    map[string]bool // a file there was not handled cleanly
+}
+
+// confPrint is a fingerprint of the settings that decide what a folder
+// being done means: which files go where, and how they are compared.
+// Limits, logging and the like can change between the runs, and so can
+// passwords, which are left out.
+func confPrint(c *Conf) string {
+	var all map[string]json.RawMessage
+	b, _ := json.Marshal(c)
+	json.Unmarshal(b, &all)
+	var dest map[string]any
+	json.Unmarshal(all[strings.ToLower(c.Type)], &dest) // each type's section is named after it
+	for k := range dest {
+		if lk := strings.ToLower(k); strings.Contains(lk, "pass") || strings.Contains(lk, "secret") || strings.Contains(lk, "token") { delete(dest, k) }
+	}
+	b, _ = json.Marshal([]any{c.LocalDir, c.Site, strings.ToLower(c.Type), dest, c.Normalize, c.Compare})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// newResumer picks up st's token unless it does not apply; nil without a
+// state file.
+func newResumer(c *Conf, st *syncState, full bool) *resumer {
+	if st == nil { return nil }
+	r := &resumer{skip: map[string]bool{}, done: map[string]bool{}, pending: map[string]int{}, listed: map[string]bool{}, open: map[string]bool{}}
+	tok := st.Resume
+	switch {
+	case tok == nil:
+	case full:
+		debugf(catScan, "resume: -full, not resuming run %s", tok.Run)
+	case tok.Conf != confPrint(c):
+		log.Printf("resume: the settings changed since run %s; starting over", tok.Run)
+	default:
+		for _, d := range tok.Done { r.skip[d], r.done[d] = true, true }
+		say("↻", "%s", tr("resume", tok.Run, len(tok.Done)))
+	}
+	return r
+}
+
+// skips reports whether the files of folder dir were handled before.
+func (r *resumer) skips(dir string) bool {
+	if r == nil { return false }
+	return r.skip[dir] // only read once the run has started
+}
+
+// queued counts a file of dir handed to the workers.
+func (r *resumer) queued(dir string) {
+	if r == nil { return }
+	r.mu.Lock()
+	r.pending[dir]++
+	r.mu.Unlock()
+}
+
+// listedAll marks that every file of dir has been queued.
+func (r *resumer) listedAll(dir string) {
+	if r == nil { return }
+	r.mu.Lock()
+	r.listed[dir] = true
+	r.check(dir)
+	r.mu.Unlock()
+}
+
+// handled counts a file of dir as done; clean is false if it failed or
+// was skipped for a reason that holds next time too.
+func (r *resumer) handled(dir string, clean bool) {
+	if r == nil { return }
+	r.mu.Lock()
+	r.pending[dir]--
+	if !clean { r.open[dir] = true }
+	r.check(dir)
+	r.mu.Unlock()
+}
+
+// keepOpen marks dir as not done, for a file decided later in the run.
+func (r *resumer) keepOpen(dir string) {
+	if r == nil { return }
+	r.mu.Lock()
+	r.open[dir] = true
+	r.mu.Unlock()
+}
+
+func (r *resumer) check(dir string) {
+	if r.listed[dir] && r.pending[dir] == 0 && !r.open[dir] { r.done[dir] = true }
+}
+
+// token is what to leave for the next run.
+func (r *resumer) token(run string, start time.Time, c *Conf) *resumeToken {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.done) == 0 { return nil }
+	tok := &resumeToken{Run: run, Since: start, Conf: confPrint(c)}
+	for d := range r.done { tok.Done = append(tok.Done, d) }
+	sort.Strings(tok.Done)
+	return tok
+}
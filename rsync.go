@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── rsync daemon target ────────────────────────────
+// rsyncdTarget writes into a module of an rsync daemon (rsync://, port
+// 873), such as the ones a NAS exports, by running the rsync binary once
+// per operation: --list-only for the comparison, a copy with --times for
+// an upload. rsync sends only what changed in a file the module already
+// has, and renames its temp file into place itself. Missing folders are
+// made with --mkpath, which needs rsync 3.2.3 or later on this side.
+// rsyncd.pass reaches rsync as RSYNC_PASSWORD, never on its command line.
+// transfer.bind goes to --address; transfer.dscp does not apply.
+type RsyncdConf struct {
+	Host       string   `json:"host"`        // host[:873]
+	User       string   `json:"user"`
+	Pass       string   `json:"pass"`
+	Module     string   `json:"module"`
+	RemotePath string   `json:"remote_path"` // folder inside the module
+	Binary     string   `json:"binary"`      // rsync to run (default: rsync on the PATH)
+	Args       []string `json:"args"`        // added to every call, e.g. ["--bwlimit=2m"]
+}
+
+type rsyncdTarget struct {
+	cfg  RsyncdConf
+	base string   // rsync://[user@]host:port/module
+	args []string // before each call's own
+	root rpath.Root
+}
+
+func connectRsyncd(ctx context.Context, cfg RsyncdConf, tc TransferConf, form string) (*rsyncdTarget, error) {
+	if cfg.Host == "" || cfg.Module == "" { return nil, fmt.Errorf("rsyncd.host and rsyncd.module are required") }
+	if cfg.Binary == "" { cfg.Binary = "rsync" }
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil { addr = net.JoinHostPort(addr, "873") }
+	base := "rsync://" + addr + "/" + strings.Trim(cfg.Module, "/")
+	if cfg.User != "" { base = "rsync://" + cfg.User + "@" + addr + "/" + strings.Trim(cfg.Module, "/") }
+	d, err := tc.dialer()
+	if err != nil { return nil, err }
+	// -s keeps the daemon from splitting names at spaces or expanding wildcards
+	args := []string{"-s", fmt.Sprintf("--contimeout=%d", int(d.Timeout.Seconds()))}
+	if tc.Bind != "" {
+		ip, err := bindIP(tc.Bind)
+		if err != nil { return nil, err }
+		args = append(args, "--address="+ip.String())
+	}
+	t := &rsyncdTarget{cfg: cfg, base: base, args: append(args, cfg.Args...), root: rpath.NewRoot(cfg.RemotePath, form)}
+	// listing the module checks the login, like the other targets' connect
+	if _, err = t.rsync(ctx, "--list-only", "--dirs", base+"/"); err != nil { return nil, classifyRsync(ctxErr(ctx, err)) }
+	return t, nil
+}
+
+func (t *rsyncdTarget) url(rel string) (string, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return "", withClass(ErrInvalidName, err) }
+	return t.base + "/" + strings.TrimPrefix(remote, "/"), nil
+}
+
+// rsyncError is a failed rsync run: its exit code and what it printed.
+type rsyncError struct {
+	code int
+	msg  string
+}
+
+func (e *rsyncError) Error() string { return fmt.Sprintf("rsync: %s (exit %d)", e.msg, e.code) }
+
+func (t *rsyncdTarget) rsync(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, t.cfg.Binary, append(append([]string{}, t.args...), args...)...)
+	// listing times are printed in the local zone and numbers in the locale's form
+	cmd.Env = append(os.Environ(), "TZ=UTC", "LC_ALL=C")
+	if t.cfg.Pass != "" { cmd.Env = append(cmd.Env, "RSYNC_PASSWORD="+t.cfg.Pass) }
+	var out, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	debugf(catProtocol, "rsyncd: %s", strings.Join(cmd.Args[1:], " "))
+	err := cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndex(msg, "\n"); i >= 0 && strings.HasPrefix(msg[i+1:], "rsync error:") { msg = strings.TrimSpace(msg[:i]) }
+		return out.Bytes(), &rsyncError{exit.ExitCode(), msg}
+	}
+	if err != nil { return nil, fmt.Errorf("rsyncd.binary: %w", err) }
+	return out.Bytes(), nil
+}
+
+func (t *rsyncdTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	u, err := t.url(rel)
+	if err != nil { return FileInfo{}, err }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	out, err := t.rsync(ctx, "--list-only", u)
+	if err != nil { return FileInfo{}, classifyRsync(ctxErr(ctx, err)) }
+	// -rw-r--r--         15,342 2026/10/14 07:36:36 name
+	line := strings.TrimSpace(string(out))
+	f := strings.Fields(line)
+	if len(f) < 5 { return FileInfo{}, fmt.Errorf("rsyncd: %s: unexpected listing %q", u, line) }
+	if f[0][0] == 'd' { return FileInfo{}, withClass(ErrInvalidName, fmt.Errorf("%s is a folder on the target", rel)) }
+	var size int64
+	if _, err = fmt.Sscan(strings.ReplaceAll(f[1], ",", ""), &size); err != nil { return FileInfo{}, fmt.Errorf("rsyncd: %s: unexpected listing %q", u, line) }
+	mtime, err := time.Parse("2006/01/02 15:04:05", f[2]+" "+f[3])
+	if err != nil { return FileInfo{}, fmt.Errorf("rsyncd: %s: unexpected listing %q", u, line) }
+	return FileInfo{Rel: rel, Size: size, MTime: mtime, Exists: true}, nil
+}
+
+func (t *rsyncdTarget) upload(ctx context.Context, local, rel string) error {
+	u, err := t.url(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
+	if _, err = t.rsync(ctx, "--times", "--mkpath", rsyncLocal(local), u); err != nil { return classifyRsync(ctxErr(ctx, err)) }
+	if fi, err := os.Stat(local); err == nil { countBytes(ctx, fi.Size()) }
+	return nil
+}
+
+func (t *rsyncdTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	u, err := t.url(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	dir, err := os.MkdirTemp(tempDir, "datasync-rsync-")
+	if err != nil { return err }
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "f")
+	if _, err = t.rsync(ctx, u, rsyncLocal(dst)); err != nil { return classifyRsync(ctxErr(ctx, err)) }
+	f, err := os.Open(dst)
+	if err != nil { return err }
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (t *rsyncdTarget) close() {}
+
+// rsyncLocal is a local path as rsync wants it. The Windows builds
+// (cwRsync, cygwin) take C:\x for a host named C and want /cygdrive/c/x.
+func rsyncLocal(p string) string {
+	if runtime.GOOS != "windows" { return p }
+	if v := filepath.VolumeName(p); len(v) == 2 && v[1] == ':' {
+		return "/cygdrive/" + strings.ToLower(v[:1]) + filepath.ToSlash(p[2:])
+	}
+	return filepath.ToSlash(p)
+}
+
+// classifyRsync sorts failures by the daemon's @ERROR line, then by the
+// file error rsync passed on, then by exit code.
+func classifyRsync(err error) error {
+	if err == nil || classified(err) { return err }
+	var re *rsyncError
+	if !errors.As(err, &re) { return classifyOS(err) }
+	msg := strings.ToLower(re.msg)
+	switch {
+	case strings.Contains(msg, "@error: auth failed"):
+		return withClass(ErrAuth, err)
+	case strings.Contains(msg, "@error: unknown module"):
+		return withClass(ErrNotFound, err)
+	case strings.Contains(msg, "@error: access denied"), strings.Contains(msg, "module is read only"):
+		return withClass(ErrPermission, err)
+	case strings.Contains(msg, "@error: max connections"):
+		return withClass(ErrNetwork, err)
+	}
+	if c := messageClass(err); c != nil { return withClass(c, err) }
+	switch re.code {
+	case 10, 12, 30, 35: // socket I/O, protocol stream, timeouts
+		return withClass(ErrNetwork, err)
+	}
+	return err
+}
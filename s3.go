@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── S3 target ──────────────────────────────────────
+// s3Target puts each file as an object, signed with AWS Signature V4, so
+// it works with AWS S3 and compatible stores (MinIO, Wasabi, Ceph). The
+// local mtime goes along as x-amz-meta-mtime and stat reads it back, so
+// the newer-than comparison sees the file's own age; objects written by
+// something else fall back to LastModified, the upload time. A file above
+// 5 GiB, or one transfer.chunks splits, goes as a multipart upload. The
+// bucket is checked with a HEAD when connecting, which catches wrong keys,
+// region or bucket name before any file is read.
+type S3Conf struct {
+	Bucket      string `json:"bucket"`
+	Prefix      string `json:"prefix"`       // key prefix, like remote_path
+	Region      string `json:"region"`       // default us-east-1
+	Endpoint    string `json:"endpoint"`     // e.g. https://minio.example.com:9000 (default: AWS for the region)
+	AccessKey   string `json:"access_key"`
+	SecretKey   string `json:"secret_key"`
+	VirtualHost bool   `json:"virtual_host"` // custom endpoint: bucket in the host name, not the path
+	TLSCA       string `json:"tls_ca"`       // PEM file with the CA that signed the endpoint's certificate
+}
+
+// s3MaxPut is the largest object a single PUT may create.
+const s3MaxPut = 5 << 30
+
+type s3Target struct {
+	cfg    S3Conf
+	base   *url.URL // the bucket: scheme, host and path prefix
+	root   rpath.Root
+	client *http.Client
+	tc     TransferConf
+}
+
+func connectS3(ctx context.Context, cfg S3Conf, tc TransferConf, form string) (*s3Target, error) {
+	if cfg.Bucket == "" { return nil, fmt.Errorf("s3.bucket is required") }
+	if cfg.Region == "" { cfg.Region = "us-east-1" }
+	base := &url.URL{Scheme: "https", Host: cfg.Bucket + ".s3." + cfg.Region + ".amazonaws.com"}
+	if cfg.Endpoint != "" {
+		u, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("s3.endpoint: %q is not an http(s) URL", cfg.Endpoint)
+		}
+		base = u
+		if cfg.VirtualHost {
+			base.Host = cfg.Bucket + "." + u.Host
+		} else {
+			base.Path += "/" + cfg.Bucket
+		}
+	}
+	client, err := tc.httpClient("s3.tls_ca", cfg.TLSCA)
+	if err != nil { return nil, err }
+	t := &s3Target{cfg: cfg, base: base, root: rpath.NewRoot(cfg.Prefix, form), client: client, tc: tc}
+	resp, err := t.send(ctx, "HEAD", "", nil, nil, nil, -1)
+	if err != nil { return nil, classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = t.failure("HEAD", "", resp)
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized { err = withClass(ErrAuth, err) }
+		if resp.StatusCode == http.StatusMovedPermanently { err = fmt.Errorf("%w: bucket is in another region (%s)", err, resp.Header.Get("X-Amz-Bucket-Region")) }
+		return nil, classifyHTTP(err)
+	}
+	debugf(catProtocol, "s3: bucket %s at %s", cfg.Bucket, base.Host)
+	return t, nil
+}
+
+func (t *s3Target) key(rel string) (string, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return "", withClass(ErrInvalidName, err) }
+	return strings.TrimPrefix(remote, "/"), nil
+}
+
+func (t *s3Target) stat(ctx context.Context, rel string) (FileInfo, error) {
+	key, err := t.key(rel)
+	if err != nil { return FileInfo{}, err }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	resp, err := t.send(ctx, "HEAD", key, nil, nil, nil, -1)
+	if err != nil { return FileInfo{}, classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return FileInfo{}, classifyHTTP(t.failure("HEAD", key, resp)) }
+	fi := FileInfo{Rel: rel, Size: resp.ContentLength, Exists: true}
+	fi.MTime, err = time.Parse(time.RFC3339Nano, resp.Header.Get("X-Amz-Meta-Mtime"))
+	if err != nil { fi.MTime, _ = http.ParseTime(resp.Header.Get("Last-Modified")) }
+	fi.ETag = strongETag(resp.Header.Get("ETag"))
+	return fi, nil
+}
+
+func (t *s3Target) upload(ctx context.Context, local, rel string) error {
+	return classifyHTTP(ctxErr(ctx, t.put(ctx, local, rel)))
+}
+
+func (t *s3Target) put(ctx context.Context, local, rel string) error {
+	key, err := t.key(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
+	src, err := os.Open(local)
+	if err != nil { return err }
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil { return err }
+	meta := map[string]string{"x-amz-meta-mtime": fi.ModTime().UTC().Format(time.RFC3339Nano)}
+	lock := s3LockHeaders(ctx)
+	for k, v := range lock { meta[k] = v }
+	parts := t.tc.split(fi.Size())
+	if parts != nil && parts[0].n < 5<<20 { parts = nil } // S3 parts are at least 5 MiB
+	if parts == nil && fi.Size() > s3MaxPut {
+		for off := int64(0); off < fi.Size(); off += s3MaxPut / 2 { parts = append(parts, chunk{off, min(s3MaxPut/2, fi.Size()-off)}) }
+		return t.multipart(ctx, src, key, meta, parts, false, lock != nil)
+	}
+	if parts != nil { return t.multipart(ctx, src, key, meta, parts, true, lock != nil) }
+	if lock != nil {
+		name, sum, err := lockChecksum(src, 0, fi.Size())
+		if err != nil { return err }
+		meta[name] = sum
+	}
+	for k, v := range ifMatchHeader(ctx) { meta[k] = v } // on the PUT only: a multipart upload checks when completing
+	resp, err := t.send(ctx, "PUT", key, nil, meta, ctxReader{ctx, src}, fi.Size())
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return t.failure("PUT", key, resp) }
+	return nil
+}
+
+// multipart uploads the ranges as the parts of one object, in parallel
+// when transfer.chunks asked for the split; each part of a locked object
+// carries its checksum (see lockChecksum). A failed upload is aborted so the store does
+// not keep (and bill) the parts.
+func (t *s3Target) multipart(ctx context.Context, src *os.File, key string, meta map[string]string, parts []chunk, parallel, locked bool) error {
+	if locked && fipsMode { meta["x-amz-checksum-algorithm"] = "SHA256" } // part checksums are declared up front
+	resp, err := t.send(ctx, "POST", key, url.Values{"uploads": {""}}, meta, nil, 0)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return t.failure("POST", key, resp) }
+	var init struct{ UploadID string `xml:"UploadId"` }
+	if err = xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&init); err != nil || init.UploadID == "" {
+		return fmt.Errorf("s3: %s: no upload id in the answer: %v", key, err)
+	}
+	etags, sums := make([]string, len(parts)), make([]string, len(parts))
+	sendPart := func(i int) error {
+		q := url.Values{"partNumber": {strconv.Itoa(i + 1)}, "uploadId": {init.UploadID}}
+		var hdr map[string]string
+		if locked {
+			name, sum, err := lockChecksum(src, parts[i].off, parts[i].n)
+			if err != nil { return err }
+			hdr = map[string]string{name: sum}
+			if fipsMode { sums[i] = sum }
+		}
+		resp, err := t.send(ctx, "PUT", key, q, hdr, ctxReader{ctx, io.NewSectionReader(src, parts[i].off, parts[i].n)}, parts[i].n)
+		if err != nil { return err }
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK { return t.failure("PUT", key, resp) }
+		etags[i] = resp.Header.Get("ETag")
+		return nil
+	}
+	if parallel {
+		idx := map[chunk]int{}
+		for i, p := range parts { idx[p] = i }
+		err = parallelChunks(parts, func(p chunk) error { return sendPart(idx[p]) })
+	} else {
+		for i := range parts {
+			if err = sendPart(i); err != nil { break }
+		}
+	}
+	if err == nil { err = t.complete(ctx, key, init.UploadID, etags, sums) }
+	if err != nil {
+		// a fresh context: the run's may be what ended the upload
+		abort, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if resp, aerr := t.send(abort, "DELETE", key, url.Values{"uploadId": {init.UploadID}}, nil, nil, 0); aerr == nil { resp.Body.Close() }
+	}
+	return err
+}
+
+// complete joins the parts; sums are their SHA-256 checksums where the
+// upload declared them, else "".
+func (t *s3Target) complete(ctx context.Context, key, id string, etags, sums []string) error {
+	var b bytes.Buffer
+	b.WriteString("<CompleteMultipartUpload>")
+	for i, e := range etags {
+		sum := ""
+		if sums[i] != "" { sum = "<ChecksumSHA256>" + sums[i] + "</ChecksumSHA256>" }
+		fmt.Fprintf(&b, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag>%s</Part>", i+1, e, sum)
+	}
+	b.WriteString("</CompleteMultipartUpload>")
+	resp, err := t.send(ctx, "POST", key, url.Values{"uploadId": {id}}, ifMatchHeader(ctx), bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil { return err }
+	defer resp.Body.Close()
+	// S3 can answer 200 and still fail, with an <Error> in the body
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK || bytes.Contains(body, []byte("<Error>")) {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return t.failure("POST", key, resp)
+	}
+	return nil
+}
+
+func (t *s3Target) fetch(ctx context.Context, rel string, w io.Writer) error {
+	key, err := t.key(rel)
+	if err != nil { return err }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	resp, err := t.send(ctx, "GET", key, nil, nil, nil, -1)
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return classifyHTTP(t.failure("GET", key, resp)) }
+	_, err = io.Copy(w, resp.Body)
+	return classifyHTTP(ctxErr(ctx, err))
+}
+
+func (t *s3Target) close() { t.client.CloseIdleConnections() }
+
+// failure turns an unexpected answer into a statusError, with the code
+// and message from S3's XML error body when there is one.
+func (t *s3Target) failure(method, key string, resp *http.Response) error {
+	var e struct{ Code, Message string }
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	detail := e.Code
+	if e.Message != "" { detail += ": " + e.Message }
+	return &statusError{method: method, url: t.object(key, nil).Redacted(), code: resp.StatusCode, status: resp.Status, detail: detail}
+}
+
+func (t *s3Target) object(key string, q url.Values) *url.URL {
+	u := *t.base
+	u.Path = t.base.Path + "/" + key
+	u.RawPath = awsEscape(t.base.Path, false) + "/" + awsEscape(key, false)
+	u.RawQuery = awsQuery(q)
+	return &u
+}
+
+// send signs and sends one request. size -1 means no body; a body that
+// is a file part goes unsigned (UNSIGNED-PAYLOAD), small ones are hashed.
+func (t *s3Target) send(ctx context.Context, method, key string, q url.Values, hdr map[string]string, body io.Reader, size int64) (*http.Response, error) {
+	u := t.object(key, q)
+	payload := "UNSIGNED-PAYLOAD"
+	switch b := body.(type) {
+	case nil:
+		payload = hex.EncodeToString(sha256.New().Sum(nil))
+	case *bytes.Reader:
+		data, _ := io.ReadAll(b)
+		b.Seek(0, io.SeekStart)
+		sum := sha256.Sum256(data)
+		payload = hex.EncodeToString(sum[:])
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil { return nil, err }
+	if size >= 0 { req.ContentLength = size }
+	for k, v := range hdr { req.Header.Set(k, v) }
+	t.sign(req, payload, time.Now().UTC())
+	return t.client.Do(req)
+}
+
+// sign adds an AWS Signature V4 Authorization header.
+func (t *s3Target) sign(req *http.Request, payload string, now time.Time) {
+	stamp, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	names := []string{"host"}
+	vals := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			names = append(names, lk)
+			vals[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	sort.Strings(names)
+	var canon strings.Builder
+	fmt.Fprintf(&canon, "%s\n%s\n%s\n", req.Method, req.URL.EscapedPath(), req.URL.RawQuery)
+	for _, n := range names { fmt.Fprintf(&canon, "%s:%s\n", n, vals[n]) }
+	signed := strings.Join(names, ";")
+	fmt.Fprintf(&canon, "\n%s\n%s", signed, payload)
+
+	scope := day + "/" + t.cfg.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canon.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	k := hmacSHA256([]byte("AWS4"+t.cfg.SecretKey), day)
+	for _, s := range []string{t.cfg.Region, "s3", "aws4_request"} { k = hmacSHA256(k, s) }
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.cfg.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(k, toSign))))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but the RFC 3986 unreserved
+// characters, and slashes unless all is set, as SigV4 wants.
+func awsEscape(s string, all bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~', c == '/' && !all:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsQuery is q in SigV4 canonical form: sorted, strictly escaped, and
+// "key=" for empty values.
+func awsQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q { keys = append(keys, k) }
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] { parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true)) }
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── local scan ─────────────────────────────────────
+// With warm_start the tree seen by the last run is kept in the state file.
+// A directory whose mtime has not moved is replayed from that snapshot:
+// its files are not listed or stat'ed again, those already in sync are
+// skipped, and only its subdirectories are checked. A directory mtime
+// changes when entries are added, removed or renamed but not when a file
+// is rewritten in place, so run with -full now and then to catch those.
+type fileSnap struct {
+	Size   int64     `json:"size"`
+	MTime  time.Time `json:"mtime"`
+	Synced bool      `json:"synced"`
+}
+
+type dirSnap struct {
+	MTime time.Time            `json:"mtime"`
+	Files map[string]*fileSnap `json:"files"`
+	Dirs  []string             `json:"dirs,omitempty"`
+}
+
+// dirEntry is one listing result. hasInfo means size and mtime were
+// delivered with the listing itself.
+type dirEntry struct {
+	name    string
+	dir     bool
+	hasInfo bool
+	size    int64
+	mtime   time.Time
+}
+
+func sortEntries(es []dirEntry) {
+	sort.Slice(es, func(i, j int) bool { return es[i].name < es[j].name })
+}
+
+// pathOrder sorts a listing so that walking it depth first visits the
+// paths in byte order: a folder sorts as its name plus "/", so a-b
+// comes before the files in a.
+func pathOrder(es []dirEntry) {
+	key := func(e dirEntry) string {
+		if e.dir { return e.name + "/" }
+		return e.name
+	}
+	sort.SliceStable(es, func(i, j int) bool { return key(es[i]) < key(es[j]) })
+}
+
+type scanner struct {
+	ctx        context.Context
+	root, site string
+	form       string // normalize setting
+	threads    int
+	ordered    bool                // one folder at a time, in path order (threads is 1)
+	prev, cur  map[string]*dirSnap // both nil unless warm start is on
+	resume     *resumer            // folders an interrupted run finished
+	jobs       chan<- job
+
+	sem chan struct{}
+	wg  sync.WaitGroup
+	mu  sync.Mutex // guards cur and err
+	err error
+}
+
+// run walks the tree with up to threads directory listings in flight:
+// on a share with a million files enumeration is bound by round trips,
+// not bandwidth.
+func (s *scanner) run() error {
+	fi, err := os.Stat(s.root)
+	if err != nil { return err }
+	s.sem = make(chan struct{}, max(0, s.threads-1))
+	s.descend("", fi.ModTime())
+	s.wg.Wait()
+	return s.err
+}
+
+// descend walks a directory on a spare goroutine if one is free and on
+// the caller's otherwise, which keeps the walk bounded without deadlock.
+func (s *scanner) descend(rel string, mtime time.Time) {
+	select {
+	case s.sem <- struct{}{}:
+		s.wg.Add(1)
+		go func() {
+			defer func() { <-s.sem; s.wg.Done() }()
+			s.fail(s.walk(rel, mtime))
+		}()
+	default:
+		s.fail(s.walk(rel, mtime))
+	}
+}
+
+func (s *scanner) fail(err error) {
+	if err == nil { return }
+	s.mu.Lock()
+	if s.err == nil { s.err = err }
+	s.mu.Unlock()
+}
+
+func (s *scanner) failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+func (s *scanner) record(rel string, d *dirSnap) {
+	s.mu.Lock()
+	s.cur[rel] = d
+	s.mu.Unlock()
+}
+
+// remoteRel is the path a local file has on the target and in the state
+// file: under the site, in the form the normalize setting asks for.
+func (s *scanner) remoteRel(rel string) string {
+	rel = rpath.Normalize(rel, s.form)
+	if s.site != "" { return s.site + "/" + rel }
+	return rel
+}
+
+func displayDir(rel string) string {
+	if rel == "" { return "." }
+	return rel
+}
+
+// queue hands a job to the workers, giving up when the run is cancelled.
+func (s *scanner) queue(j job) error {
+	tracef(catScan, "%s: queued", j.rel)
+	select {
+	case s.jobs <- j:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *scanner) walk(rel string, mtime time.Time) error {
+	if s.failed() { return nil }
+	if err := s.ctx.Err(); err != nil { return err }
+	if old := s.prev[rel]; old != nil && old.MTime.Equal(mtime) {
+		return s.replay(rel, old)
+	}
+	dir := filepath.Join(s.root, filepath.FromSlash(rel))
+	entries, err := readDir(dir)
+	if err != nil { return err }
+	debugf(catScan, "%s: listed, %d entries", displayDir(rel), len(entries))
+	if s.ordered { pathOrder(entries) }
+	skip := s.resume.skips(rel)
+	if skip { debugf(catScan, "%s: done by the interrupted run, files skipped", displayDir(rel)) }
+	var snap *dirSnap
+	if s.cur != nil && !skip { // a skipped folder is listed afresh next time
+		snap = &dirSnap{MTime: mtime, Files: map[string]*fileSnap{}}
+		s.record(rel, snap)
+	}
+	for _, e := range entries {
+		child := path.Join(rel, e.name)
+		if e.dir {
+			mtime := e.mtime
+			if !e.hasInfo {
+				fi, err := os.Stat(filepath.Join(dir, e.name))
+				if err != nil { return err }
+				mtime = fi.ModTime()
+			}
+			if snap != nil { snap.Dirs = append(snap.Dirs, e.name) }
+			s.descend(child, mtime)
+			continue
+		}
+		if skip { continue }
+		j := job{path: filepath.Join(dir, e.name), rel: s.remoteRel(child), dir: rel, size: e.size, mtime: e.mtime, known: e.hasInfo}
+		if snap != nil {
+			j.snap = &fileSnap{}
+			snap.Files[e.name] = j.snap
+		}
+		s.resume.queued(rel)
+		if err := s.queue(j); err != nil { return err }
+	}
+	if !skip { s.resume.listedAll(rel) }
+	return nil
+}
+
+// replay queues an unchanged directory's files from the snapshot, with
+// their recorded size and mtime, then descends into its subdirectories.
+func (s *scanner) replay(rel string, old *dirSnap) error {
+	debugf(catScan, "%s: unchanged, replayed from the snapshot", displayDir(rel))
+	s.record(rel, old)
+	dir := filepath.Join(s.root, filepath.FromSlash(rel))
+	entries := make([]dirEntry, 0, len(old.Files)+len(old.Dirs))
+	for name := range old.Files { entries = append(entries, dirEntry{name: name}) }
+	sortEntries(entries)
+	for _, name := range old.Dirs { entries = append(entries, dirEntry{name: name, dir: true}) }
+	if s.ordered { pathOrder(entries) }
+	skip := s.resume.skips(rel)
+	for _, e := range entries {
+		if e.dir {
+			fi, err := os.Stat(filepath.Join(dir, e.name))
+			if err != nil { return err }
+			s.descend(path.Join(rel, e.name), fi.ModTime())
+			continue
+		}
+		f := old.Files[e.name]
+		if f.Synced || skip { continue }
+		j := job{path: filepath.Join(dir, e.name), rel: s.remoteRel(path.Join(rel, e.name)), dir: rel, size: f.Size, mtime: f.MTime, known: true, snap: f}
+		s.resume.queued(rel)
+		if err := s.queue(j); err != nil { return err }
+	}
+	if !skip { s.resume.listedAll(rel) }
+	return nil
+}
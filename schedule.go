@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ────────── built-in schedule ──────────────────────────────
+// With schedule.interval set, datasync does not exit after a run: it
+// waits that long and runs again until it is stopped (Ctrl+C, or the
+// task ended), so a lab PC needs one task that starts it at logon or
+// boot rather than a repeating trigger set up by hand on each machine.
+// The wait counts from the end of a run, so a long run never overlaps
+// the next. Each run connects afresh and reads the state file again, as
+// a separately started one would; the config is read once, so a change
+// to it needs a restart. -timeout applies to each run, -at-boot's settle
+// and catch-up to the first, and -dry-run runs once. A run in a blackout
+// window is deferred as usual and the loop goes on. The exit code is the
+// last run's.
+type ScheduleConf struct {
+	Interval string `json:"interval"` // wait this long after each run, then run again, e.g. "15m"; none: run once
+}
+
+// every is the wait between runs, 0 to run once.
+func (s ScheduleConf) every() (time.Duration, error) {
+	if s.Interval == "" { return 0, nil }
+	d, err := time.ParseDuration(s.Interval)
+	if err != nil || d <= 0 { return 0, fmt.Errorf("schedule.interval: %q is not a duration like 15m", s.Interval) }
+	return d, nil
+}
+
+// loop calls run, at once if first, and again each d after it returns
+// until ctx ends; with d 0, only the first time.
+func loop(ctx context.Context, d time.Duration, first bool, run func() int) int {
+	code, clock := 0, "15:04"
+	if d < time.Minute { clock = "15:04:05" }
+	for {
+		if first { code = run() }
+		first = true
+		if d == 0 || ctx.Err() != nil { return code }
+		next := time.Now().Add(d)
+		say("…", "%s", tr("next_run", next.Format(clock)))
+		if !sleepUntil(ctx, next) { return code }
+	}
+}
+
+// sleepUntil waits for the clock to reach t, and reports false when ctx
+// ends first. A timer stands still while the machine sleeps, so the
+// clock is looked at again every minute.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	t = t.Round(0) // the wall clock, which goes on in standby
+	for {
+		wait := time.Until(t)
+		if wait <= 0 { return true }
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(min(wait, time.Minute)):
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ────────── SCP target ─────────────────────────────────────
+// scpTarget is for appliances that take SSH logins but have no SFTP
+// subsystem. Every file costs a few commands on one SSH connection: a
+// shell stat for the comparison, mkdir -p for its folder, scp -t to
+// receive it under a temp name with its mtime kept, and mv into place.
+// The remote side needs a POSIX shell and the scp binary. The server's
+// key must be known, from scp.known_hosts or pinned as scp.host_key
+// (SHA256:... as ssh-keygen -l prints it).
+type SCPConf struct {
+	Host       string `json:"host"`        // host[:22]
+	User       string `json:"user"`
+	Pass       string `json:"pass"`        // password or keyboard-interactive
+	Key        string `json:"key"`         // private key file (OpenSSH or PEM), instead of or with pass
+	KeyPass    string `json:"key_pass"`    // passphrase for key
+	KnownHosts string `json:"known_hosts"` // known_hosts file to check the server key against
+	HostKey    string `json:"host_key"`    // or the key's SHA256 fingerprint
+	RemotePath string `json:"remote_path"`
+}
+
+type scpTarget struct {
+	c    *ssh.Client
+	root rpath.Root
+}
+
+func connectSCP(ctx context.Context, cfg SCPConf, tc TransferConf, form string) (*scpTarget, error) {
+	if cfg.Host == "" || cfg.User == "" { return nil, fmt.Errorf("scp.host and scp.user are required") }
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil { addr = net.JoinHostPort(addr, "22") }
+	hostKey, err := cfg.hostKeyCallback()
+	if err != nil { return nil, err }
+	var auth []ssh.AuthMethod
+	if cfg.Key != "" {
+		pem, err := os.ReadFile(cfg.Key)
+		if err != nil { return nil, fmt.Errorf("scp.key: %w", err) }
+		var signer ssh.Signer
+		if cfg.KeyPass != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, []byte(cfg.KeyPass))
+		} else {
+			signer, err = ssh.ParsePrivateKey(pem)
+		}
+		if err != nil { return nil, fmt.Errorf("scp.key: %w", err) }
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Pass != "" {
+		auth = append(auth, ssh.Password(cfg.Pass), ssh.KeyboardInteractive(func(_, _ string, qs []string, _ []bool) ([]string, error) {
+			answers := make([]string, len(qs))
+			for i := range answers { answers[i] = cfg.Pass }
+			return answers, nil
+		}))
+	}
+	if len(auth) == 0 { return nil, fmt.Errorf("scp needs scp.key or scp.pass") }
+
+	d, err := tc.dialer()
+	if err != nil { return nil, err }
+	debugf(catProtocol, "scp: dial %s", addr)
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil { return nil, classifyOS(ctxErr(ctx, err)) }
+	sc := &ssh.ClientConfig{User: cfg.User, Auth: auth, HostKeyCallback: hostKey, Timeout: d.Timeout}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	cc, chans, reqs, err := ssh.NewClientConn(conn, addr, sc)
+	if err != nil {
+		conn.Close()
+		if strings.Contains(err.Error(), "unable to authenticate") { return nil, withClass(ErrAuth, err) }
+		var ke *knownhosts.KeyError
+		if errors.As(err, &ke) || errors.Is(err, errHostKey) { return nil, withClass(ErrAuth, err) }
+		return nil, classifyOS(ctxErr(ctx, err))
+	}
+	return &scpTarget{c: ssh.NewClient(cc, chans, reqs), root: rpath.NewRoot(cfg.RemotePath, form)}, nil
+}
+
+var errHostKey = errors.New("host key does not match scp.host_key")
+
+func (cfg SCPConf) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch {
+	case cfg.HostKey != "":
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != cfg.HostKey { return fmt.Errorf("%w (server has %s)", errHostKey, got) }
+			return nil
+		}, nil
+	case cfg.KnownHosts != "":
+		cb, err := knownhosts.New(cfg.KnownHosts)
+		if err != nil { return nil, fmt.Errorf("scp.known_hosts: %w", err) }
+		return cb, nil
+	}
+	return nil, fmt.Errorf("scp needs scp.known_hosts or scp.host_key to check the server")
+}
+
+// run executes one command, feeding it stdin, and returns its output.
+func (t *scpTarget) run(ctx context.Context, cmd string, stdin io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	err := t.exec(ctx, cmd, stdin, &out)
+	return out.Bytes(), err
+}
+
+// exec executes one command, feeding it stdin and copying its output to w.
+func (t *scpTarget) exec(ctx context.Context, cmd string, stdin io.Reader, w io.Writer) error {
+	s, err := t.c.NewSession()
+	if err != nil { return err }
+	defer s.Close()
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
+	var stderr bytes.Buffer
+	s.Stdin, s.Stdout, s.Stderr = stdin, w, &stderr
+	debugf(catProtocol, "scp: %s", cmd)
+	if err = s.Run(cmd); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" { err = fmt.Errorf("%s: %s", cmd, msg) }
+		return ctxErr(ctx, err)
+	}
+	return nil
+}
+
+func (t *scpTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return FileInfo{}, withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	p := shellQuote(remote)
+	// GNU and busybox stat take -c, the BSDs -f
+	out, err := t.run(ctx, fmt.Sprintf("if [ -e %s ]; then stat -c '%%s %%Y' %s 2>/dev/null || stat -f '%%z %%m' %s; else echo missing; fi", p, p, p), nil)
+	if err != nil { return FileInfo{}, classifySCP(err) }
+	line := strings.TrimSpace(string(out))
+	if line == "missing" { return FileInfo{}, withClass(ErrNotFound, os.ErrNotExist) }
+	var size, mtime int64
+	if _, err = fmt.Sscanf(line, "%d %d", &size, &mtime); err != nil { return FileInfo{}, fmt.Errorf("scp: %s: unexpected stat output %q", remote, line) }
+	return FileInfo{Rel: rel, Size: size, MTime: time.Unix(mtime, 0), Exists: true}, nil
+}
+
+func (t *scpTarget) upload(ctx context.Context, local, rel string) error {
+	return classifySCP(ctxErr(ctx, t.put(ctx, local, rel)))
+}
+
+func (t *scpTarget) put(ctx context.Context, local, rel string) error {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
+	src, err := os.Open(local)
+	if err != nil { return err }
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil { return err }
+	dir, base := rpath.Dir(remote), path.Base(remote)
+	if _, err = t.run(ctx, "mkdir -p "+shellQuote(dir), nil); err != nil { return err }
+	tmp := base + ".tmp"
+	if err = t.scp(ctx, dir, tmp, src, fi); err != nil { return err }
+	if err = takeOp(ctx, opRename); err != nil { return err }
+	_, err = t.run(ctx, fmt.Sprintf("mv -f %s %s", shellQuote(path.Join(dir, tmp)), shellQuote(remote)), nil)
+	return err
+}
+
+// scp runs the receiving end, scp -p -t, and speaks the sending side of
+// the protocol: a T line with the times, a C line with mode, size and
+// name, then the data; each step is acknowledged with a zero byte.
+func (t *scpTarget) scp(ctx context.Context, dir, name string, src io.Reader, fi os.FileInfo) error {
+	s, err := t.c.NewSession()
+	if err != nil { return err }
+	defer s.Close()
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
+	in, err := s.StdinPipe()
+	if err != nil { return err }
+	outPipe, err := s.StdoutPipe()
+	if err != nil { return err }
+	out := bufio.NewReader(outPipe)
+	var stderr bytes.Buffer
+	s.Stderr = &stderr
+	if err = s.Start("scp -p -t " + shellQuote(dir)); err != nil { return err }
+	fail := func(err error) error {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" { err = fmt.Errorf("%v: %s", err, msg) }
+		return fmt.Errorf("scp %s: %w", path.Join(dir, name), err)
+	}
+	if err = scpAck(out); err != nil { return fail(err) }
+	mt := fi.ModTime().Unix()
+	if _, err = fmt.Fprintf(in, "T%d 0 %d 0\n", mt, mt); err != nil { return fail(err) }
+	if err = scpAck(out); err != nil { return fail(err) }
+	if _, err = fmt.Fprintf(in, "C0644 %d %s\n", fi.Size(), name); err != nil { return fail(err) }
+	if err = scpAck(out); err != nil { return fail(err) }
+	if _, err = io.Copy(in, ctxReader{ctx, src}); err != nil { return fail(err) }
+	if _, err = in.Write([]byte{0}); err != nil { return fail(err) }
+	if err = scpAck(out); err != nil { return fail(err) }
+	in.Close()
+	if err = s.Wait(); err != nil { return fail(err) }
+	return nil
+}
+
+// scpAck reads one reply: 0 is fine, 1 and 2 carry a message.
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil { return err }
+	if b == 0 { return nil }
+	msg, _ := r.ReadString('\n')
+	return errors.New(strings.TrimSpace(msg))
+}
+
+func (t *scpTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	return classifySCP(t.exec(ctx, "cat "+shellQuote(remote), nil, w))
+}
+
+func (t *scpTarget) close() { t.c.Close() }
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" }
+
+// classifySCP sorts remote failures by the message the shell or scp gave.
+func classifySCP(err error) error {
+	if err == nil || classified(err) { return err }
+	if c := messageClass(err); c != nil { return withClass(c, err) }
+	var exit *ssh.ExitError
+	if errors.As(err, &exit) { return err }
+	var missing *ssh.ExitMissingError
+	if errors.As(err, &missing) { return withClass(ErrNetwork, err) }
+	return classifyOS(err)
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── embedded FTP server (serve) ────────────────────
+// A small passive-mode FTP server for lab testing and ad-hoc transfers
+// between two machines running this tool. It serves one directory tree
+// and supports the subset of commands our own FTP target uses.
+//
+//   dirsync.exe serve -root D:\incoming -listen :2121 -user u -pass p
+//
+// Only that login is accepted, and a data connection only from the
+// client's address. -anonymous takes the -user login with any password.
+//
+// With -tls-cert and -tls-key it only accepts explicit FTPS (AUTH TLS),
+// like servers that reject plaintext logins; add -tls-implicit to speak
+// TLS from the first byte instead, like port-990 appliances.
+func serveMain(args []string) {
+	fl := flag.NewFlagSet("serve", flag.ExitOnError)
+	root   := fl.String("root", ".", "directory to serve")
+	proto  := fl.String("proto", "ftp", "protocol to serve (ftp)")
+	listen := fl.String("listen", ":2121", "listen address")
+	user   := fl.String("user", "", "login user (required)")
+	pass   := fl.String("pass", "", "login password (required unless -anonymous)")
+	anon   := fl.Bool("anonymous", false, "accept the -user login with any password, for a throwaway lab")
+	cert   := fl.String("tls-cert", "", "PEM certificate: require FTPS (AUTH TLS)")
+	key    := fl.String("tls-key", "", "PEM private key for -tls-cert")
+	impl   := fl.Bool("tls-implicit", false, "implicit FTPS: TLS from connect, no AUTH TLS")
+	fl.Parse(args)
+
+	if *user == "" { log.Fatal("serve: -user is required; no login is accepted without one") }
+	switch {
+	case *pass == "" && !*anon:
+		log.Fatal("serve: -pass is required; give -anonymous to accept the -user login with any password")
+	case *pass != "" && *anon:
+		log.Fatal("serve: -pass and -anonymous exclude each other")
+	}
+	if strings.ToLower(*proto) != "ftp" {
+		log.Fatalf("unsupported protocol: %s (only 'ftp' is built in)", *proto)
+	}
+	abs, err := filepath.Abs(*root)
+	if err != nil { log.Fatal(err) }
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		log.Fatalf("root %s is not a directory", abs)
+	}
+	var tlsConf *tls.Config
+	if *cert != "" || *key != "" {
+		pair, err := tls.LoadX509KeyPair(*cert, *key)
+		if err != nil { log.Fatal(err) }
+		tlsConf = &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil { log.Fatal(err) }
+	fmt.Printf("serving %s on ftp://%s\n", abs, ln.Addr())
+
+	for {
+		c, err := ln.Accept()
+		if err != nil { log.Fatal(err) }
+		s := &ftpSession{ctrl: c, tp: textproto.NewConn(c), root: abs, cwd: "/", wantUser: *user, wantPass: *pass, anyPass: *anon, tls: tlsConf}
+		if *impl && tlsConf != nil {
+			tc := tls.Server(c, tlsConf)
+			s.ctrl, s.tp, s.secure, s.prot = tc, textproto.NewConn(tc), true, true
+		}
+		go s.serve()
+	}
+}
+
+type ftpSession struct {
+	ctrl               net.Conn
+	tp                 *textproto.Conn
+	root, cwd          string
+	wantUser, wantPass string
+	anyPass            bool // -anonymous
+	user               string
+	authed             bool
+	pasv               net.Listener
+	rest               int64
+	rnfr               string
+	tls                *tls.Config // nil: plain FTP only
+	secure, prot       bool        // control channel is TLS; PROT P asked for TLS data channels
+}
+
+func (s *ftpSession) reply(code int, format string, a ...interface{}) {
+	s.tp.PrintfLine("%d %s", code, fmt.Sprintf(format, a...))
+}
+
+// resolve maps a client path onto the served tree. Backslashes count as
+// separators, as they do for Windows, and cleaning against "/" keeps ".."
+// from climbing above the root. A path that would still leave it, or
+// that Windows cannot create (a drive letter, a stream, CON), resolves to
+// "/" and "", which every command refuses.
+func (s *ftpSession) resolve(p string) (virt, real string) {
+	p = rpath.Clean(p)
+	if !strings.HasPrefix(p, "/") { p = path.Join(s.cwd, p) }
+	virt = path.Clean("/" + p)
+	real, err := rpath.Local(s.root, strings.TrimPrefix(virt, "/"))
+	if err != nil { return "/", "" }
+	return virt, real
+}
+
+func (s *ftpSession) serve() {
+	defer func() { s.ctrl.Close() }() // s.ctrl becomes the TLS conn after AUTH TLS
+	defer s.closePasv()
+	log.Printf("ftp: %s connected", s.ctrl.RemoteAddr())
+	s.reply(220, "datasync ftp ready")
+	for {
+		line, err := s.tp.ReadLine()
+		if err != nil { return }
+		cmd, arg, _ := strings.Cut(line, " ")
+		cmd = strings.ToUpper(cmd)
+		if !s.authed {
+			switch cmd {
+			case "USER", "PASS", "QUIT", "FEAT", "SYST", "NOOP", "AUTH", "PBSZ", "PROT":
+			default:
+				s.reply(530, "Please login with USER and PASS")
+				continue
+			}
+		}
+		if s.handle(cmd, arg) { return }
+	}
+}
+
+// handle runs one command and reports whether the session is over.
+func (s *ftpSession) handle(cmd, arg string) bool {
+	switch cmd {
+	case "AUTH":
+		if s.tls == nil || s.secure || !strings.EqualFold(arg, "TLS") && !strings.EqualFold(arg, "SSL") {
+			s.reply(504, "AUTH %s not available", arg)
+			return false
+		}
+		s.reply(234, "Proceed with TLS")
+		tc := tls.Server(s.ctrl, s.tls)
+		if err := tc.Handshake(); err != nil { log.Printf("ftp: %s: %v", s.ctrl.RemoteAddr(), err); return true }
+		s.ctrl, s.tp, s.secure = tc, textproto.NewConn(tc), true
+	case "PBSZ":
+		s.reply(200, "PBSZ=0")
+	case "PROT":
+		switch {
+		case !s.secure:
+			s.reply(503, "Use AUTH TLS first")
+		case strings.EqualFold(arg, "P"), strings.EqualFold(arg, "C"):
+			s.prot = strings.EqualFold(arg, "P")
+			s.reply(200, "OK")
+		default:
+			s.reply(504, "PROT %s not supported", arg)
+		}
+	case "USER":
+		if s.tls != nil && !s.secure {
+			s.reply(530, "Plaintext login refused, use AUTH TLS")
+			return false
+		}
+		s.user, s.authed = arg, false
+		s.reply(331, "Password required")
+	case "PASS":
+		if s.wantUser == "" || s.user != s.wantUser || !s.anyPass && (s.wantPass == "" || arg != s.wantPass) {
+			s.reply(530, "Login incorrect")
+			return false
+		}
+		s.authed = true
+		s.reply(230, "Logged in")
+	case "QUIT":
+		s.reply(221, "Bye")
+		return true
+	case "NOOP":
+		s.reply(200, "OK")
+	case "SYST":
+		s.reply(215, "UNIX Type: L8")
+	case "FEAT":
+		s.tp.PrintfLine("211-Features:")
+		feats := []string{"MLST type*;size*;modify*;", "MDTM", "SIZE", "EPSV", "PASV", "REST STREAM", "UTF8"}
+		if s.tls != nil { feats = append(feats, "AUTH TLS", "PBSZ", "PROT") }
+		for _, f := range feats {
+			s.tp.PrintfLine(" %s", f)
+		}
+		s.reply(211, "End")
+	case "OPTS", "TYPE", "MODE", "STRU":
+		s.reply(200, "OK")
+	case "PWD", "XPWD":
+		s.reply(257, "%q is current directory", s.cwd)
+	case "CWD", "CDUP":
+		if cmd == "CDUP" { arg = ".." }
+		virt, real := s.resolve(arg)
+		if fi, err := os.Stat(real); err != nil || !fi.IsDir() {
+			s.reply(550, "No such directory")
+			return false
+		}
+		s.cwd = virt
+		s.reply(250, "Directory changed to %s", virt)
+	case "PASV", "EPSV":
+		s.openPasv(cmd)
+	case "REST":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || n < 0 {
+			s.reply(501, "Bad offset")
+			return false
+		}
+		s.rest = n
+		s.reply(350, "Restarting at %d", n)
+	case "LIST", "NLST", "MLSD":
+		s.list(cmd, arg)
+	case "MLST":
+		_, real := s.resolve(arg)
+		fi, err := os.Stat(real)
+		if err != nil {
+			s.reply(550, "No such file")
+			return false
+		}
+		s.tp.PrintfLine("250-Listing %s", arg)
+		s.tp.PrintfLine(" %s", mlsxLine(fi, path.Base(arg)))
+		s.reply(250, "End")
+	case "RETR":
+		s.retr(arg)
+	case "STOR", "APPE":
+		s.stor(arg, cmd == "APPE")
+	case "SIZE", "MDTM":
+		_, real := s.resolve(arg)
+		fi, err := os.Stat(real)
+		if err != nil || fi.IsDir() {
+			s.reply(550, "No such file")
+			return false
+		}
+		if cmd == "SIZE" {
+			s.reply(213, "%d", fi.Size())
+		} else {
+			s.reply(213, "%s", fi.ModTime().UTC().Format("20060102150405"))
+		}
+	case "MKD", "XMKD":
+		virt, real := s.resolve(arg)
+		if err := os.Mkdir(real, 0755); err != nil {
+			s.reply(550, "Create directory failed")
+			return false
+		}
+		s.reply(257, "%q created", virt)
+	case "RMD", "XRMD", "DELE":
+		virt, real := s.resolve(arg)
+		if virt == "/" {
+			s.reply(550, "Permission denied")
+			return false
+		}
+		if err := os.Remove(real); err != nil {
+			s.reply(550, "Remove failed")
+			return false
+		}
+		s.reply(250, "Removed")
+	case "RNFR":
+		virt, real := s.resolve(arg)
+		if _, err := os.Stat(real); err != nil || virt == "/" {
+			s.reply(550, "No such file")
+			return false
+		}
+		s.rnfr = real
+		s.reply(350, "Ready for RNTO")
+	case "RNTO":
+		_, real := s.resolve(arg)
+		from := s.rnfr
+		s.rnfr = ""
+		if from == "" {
+			s.reply(503, "RNFR required first")
+			return false
+		}
+		if err := os.Rename(from, real); err != nil {
+			s.reply(550, "Rename failed")
+			return false
+		}
+		s.reply(250, "Renamed")
+	default:
+		s.reply(502, "Command not implemented")
+	}
+	return false
+}
+
+func (s *ftpSession) closePasv() {
+	if s.pasv != nil { s.pasv.Close(); s.pasv = nil }
+}
+
+func (s *ftpSession) openPasv(cmd string) {
+	s.closePasv()
+	local := s.ctrl.LocalAddr().(*net.TCPAddr)
+	ln, err := net.Listen("tcp", net.JoinHostPort(local.IP.String(), "0"))
+	if err != nil {
+		s.reply(425, "Cannot open data connection")
+		return
+	}
+	s.pasv = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+	if cmd == "EPSV" {
+		s.reply(229, "Entering Extended Passive Mode (|||%d|)", port)
+		return
+	}
+	ip4 := local.IP.To4()
+	if ip4 == nil {
+		s.closePasv()
+		s.reply(425, "PASV needs IPv4, use EPSV")
+		return
+	}
+	s.reply(227, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff)
+}
+
+// dataConn announces the transfer and waits for the client to connect to
+// the passive listener opened by the preceding PASV/EPSV.
+func (s *ftpSession) dataConn() (net.Conn, bool) {
+	if s.pasv == nil {
+		s.reply(425, "Use PASV or EPSV first")
+		return nil, false
+	}
+	s.reply(150, "Opening data connection")
+	ln := s.pasv.(*net.TCPListener)
+	ln.SetDeadline(time.Now().Add(30 * time.Second))
+	var c net.Conn
+	var err error
+	for {
+		// only the client on the control connection may take the data,
+		// not whoever reaches the port first
+		if c, err = ln.Accept(); err != nil || samePeer(c, s.ctrl) { break }
+		log.Printf("ftp: %s: data connection from %s refused", s.ctrl.RemoteAddr(), c.RemoteAddr())
+		c.Close()
+	}
+	s.closePasv()
+	if err != nil {
+		s.reply(425, "Data connection failed")
+		return nil, false
+	}
+	if s.prot { c = tls.Server(c, s.tls) }
+	return c, true
+}
+
+// samePeer reports whether a and b come from the same IP address.
+func samePeer(a, b net.Conn) bool {
+	x, ok1 := a.RemoteAddr().(*net.TCPAddr)
+	y, ok2 := b.RemoteAddr().(*net.TCPAddr)
+	return ok1 && ok2 && x.IP.Equal(y.IP)
+}
+
+func (s *ftpSession) list(cmd, arg string) {
+	// ignore "LIST -a" style flags
+	if strings.HasPrefix(arg, "-") {
+		_, arg, _ = strings.Cut(arg, " ")
+	}
+	_, real := s.resolve(arg)
+	fi, err := os.Stat(real)
+	if err != nil {
+		s.closePasv()
+		s.reply(550, "No such file or directory")
+		return
+	}
+	var infos []os.FileInfo
+	if fi.IsDir() {
+		entries, err := os.ReadDir(real)
+		if err != nil {
+			s.closePasv()
+			s.reply(550, "Cannot read directory")
+			return
+		}
+		for _, e := range entries {
+			if info, err := e.Info(); err == nil { infos = append(infos, info) }
+		}
+	} else {
+		infos = append(infos, fi)
+	}
+
+	c, ok := s.dataConn()
+	if !ok { return }
+	w := bufio.NewWriter(c)
+	now := time.Now()
+	for _, info := range infos {
+		switch cmd {
+		case "NLST":
+			fmt.Fprintf(w, "%s\r\n", info.Name())
+		case "MLSD":
+			fmt.Fprintf(w, "%s\r\n", mlsxLine(info, info.Name()))
+		default:
+			fmt.Fprintf(w, "%s\r\n", listLine(info, now))
+		}
+	}
+	err = w.Flush()
+	c.Close()
+	if err != nil {
+		s.reply(426, "Transfer aborted")
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
+
+func mlsxLine(fi os.FileInfo, name string) string {
+	kind := "file"
+	if fi.IsDir() { kind = "dir" }
+	return fmt.Sprintf("type=%s;size=%d;modify=%s; %s", kind, fi.Size(), fi.ModTime().UTC().Format("20060102150405"), name)
+}
+
+func listLine(fi os.FileInfo, now time.Time) string {
+	mode := "-rw-r--r--"
+	if fi.IsDir() { mode = "drwxr-xr-x" }
+	stamp := fi.ModTime().Format("Jan _2 15:04")
+	if now.Sub(fi.ModTime()) > 180*24*time.Hour || fi.ModTime().After(now) {
+		stamp = fi.ModTime().Format("Jan _2  2006")
+	}
+	return fmt.Sprintf("%s 1 owner group %12d %s %s", mode, fi.Size(), stamp, fi.Name())
+}
+
+func (s *ftpSession) retr(arg string) {
+	offset := s.rest
+	s.rest = 0
+	_, real := s.resolve(arg)
+	f, err := os.Open(real)
+	if err != nil {
+		s.closePasv()
+		s.reply(550, "No such file")
+		return
+	}
+	defer f.Close()
+	if offset > 0 {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			s.closePasv()
+			s.reply(550, "Seek failed")
+			return
+		}
+	}
+	c, ok := s.dataConn()
+	if !ok { return }
+	_, err = io.Copy(c, f)
+	c.Close()
+	if err != nil {
+		s.reply(426, "Transfer aborted")
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
+
+func (s *ftpSession) stor(arg string, appendMode bool) {
+	offset := s.rest
+	s.rest = 0
+	virt, real := s.resolve(arg)
+	if virt == "/" {
+		s.closePasv()
+		s.reply(553, "Bad file name")
+		return
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case appendMode:
+		flags |= os.O_APPEND
+	case offset == 0:
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(real, flags, 0644)
+	if err != nil {
+		s.closePasv()
+		switch {
+		case errors.Is(err, fs.ErrPermission):
+			s.reply(550, "Permission denied")
+		case errors.Is(err, syscall.ENOSPC):
+			s.reply(452, "Insufficient storage space")
+		default:
+			s.reply(550, "Cannot create file")
+		}
+		return
+	}
+	defer f.Close()
+	if offset > 0 && !appendMode {
+		if _, err = f.Seek(offset, io.SeekStart); err != nil {
+			s.closePasv()
+			s.reply(553, "Seek failed")
+			return
+		}
+	}
+	c, ok := s.dataConn()
+	if !ok { return }
+	_, err = io.Copy(f, c)
+	c.Close()
+	if err != nil {
+		s.reply(426, "Transfer aborted")
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
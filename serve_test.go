@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveOne serves root to one connection at a time on a loopback port,
+// as serve does, and returns the port's address.
+func serveOne(t *testing.T, root, user, pass string, anyPass bool) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil { return }
+			s := &ftpSession{ctrl: c, tp: textproto.NewConn(c), root: root, cwd: "/", wantUser: user, wantPass: pass, anyPass: anyPass}
+			go s.serve()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// ftpLogin connects to addr and logs in, returning the control
+// connection and the reply code to PASS.
+func ftpLogin(t *testing.T, addr, user, pass string) (*textproto.Conn, int) {
+	c, err := textproto.Dial("tcp", addr)
+	if err != nil { t.Fatal(err) }
+	t.Cleanup(func() { c.Close() })
+	if _, _, err = c.ReadResponse(220); err != nil { t.Fatal(err) }
+	c.PrintfLine("USER %s", user)
+	if _, _, err = c.ReadResponse(331); err != nil { t.Fatal(err) }
+	c.PrintfLine("PASS %s", pass)
+	code, _, _ := c.ReadResponse(0)
+	return c, code
+}
+
+func TestServeLogin(t *testing.T) {
+	root := t.TempDir()
+	addr := serveOne(t, root, "u", "p", false)
+	for _, c := range []struct {
+		user, pass string
+		want       int
+	}{
+		{"u", "p", 230},
+		{"u", "", 530},
+		{"u", "wrong", 530},
+		{"anonymous", "p", 530},
+	} {
+		if _, code := ftpLogin(t, addr, c.user, c.pass); code != c.want { t.Errorf("USER %s, PASS %q: %d, want %d", c.user, c.pass, code, c.want) }
+	}
+	anon := serveOne(t, root, "u", "", true)
+	if _, code := ftpLogin(t, anon, "u", "guest@example.com"); code != 230 { t.Errorf("-anonymous: PASS refused with %d", code) }
+	if _, code := ftpLogin(t, anon, "other", "x"); code != 530 { t.Errorf("-anonymous: another user logged in with %d", code) }
+}
+
+func TestServeDataPeer(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "f.txt"), []byte("served"), 0644); err != nil { t.Fatal(err) }
+	c, code := ftpLogin(t, serveOne(t, root, "u", "p", false), "u", "p")
+	if code != 230 { t.Fatalf("login: %d", code) }
+	c.PrintfLine("EPSV")
+	_, msg, err := c.ReadResponse(229)
+	if err != nil { t.Fatal(err) }
+	port := strings.TrimSuffix(msg[strings.Index(msg, "|||")+3:], "|)")
+
+	// another host reaches the port first
+	foreign, err := (&net.Dialer{LocalAddr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2)}}).Dial("tcp", "127.0.0.1:"+port)
+	if err != nil { t.Skipf("cannot dial from 127.0.0.2: %v", err) }
+	defer foreign.Close()
+	c.PrintfLine("RETR f.txt")
+	if _, _, err = c.ReadResponse(150); err != nil { t.Fatal(err) }
+	foreign.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if b, _ := io.ReadAll(foreign); len(b) != 0 { t.Errorf("foreign data connection got %q", b) }
+
+	own, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil { t.Fatal(err) }
+	defer own.Close()
+	b, err := io.ReadAll(own)
+	if err != nil || string(b) != "served" { t.Errorf("client's data connection got %q, %v", b, err) }
+	if _, _, err = c.ReadResponse(226); err != nil { t.Error(err) }
+}
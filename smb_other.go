@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// smbConnect needs the Windows SMB client; elsewhere mount the share.
+func smbConnect(unc, user, pass string) error {
+	return errors.New("smb: this build has no SMB client (Windows only)")
+}
+
+func smbDisconnect(string) {}
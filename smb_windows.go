@@ -0,0 +1,46 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	mpr                       = syscall.NewLazyDLL("mpr.dll")
+	procWNetAddConnection2    = mpr.NewProc("WNetAddConnection2W")
+	procWNetCancelConnection2 = mpr.NewProc("WNetCancelConnection2W")
+)
+
+const (
+	resourceTypeDisk = 1
+	connectTemporary = 4
+)
+
+type netResource struct {
+	Scope, Type, DisplayType, Usage                uint32
+	LocalName, RemoteName, Comment, Provider *uint16
+}
+
+// smbConnect logs on to a share the way net use does, but in-process and
+// without a drive letter: the connection is to the UNC path only, which
+// accounts that may not map drives are allowed to make.
+func smbConnect(unc, user, pass string) error {
+	remote, err := syscall.UTF16PtrFromString(unc)
+	if err != nil { return err }
+	u, err := syscall.UTF16PtrFromString(user)
+	if err != nil { return err }
+	p, err := syscall.UTF16PtrFromString(pass)
+	if err != nil { return err }
+	nr := netResource{Type: resourceTypeDisk, RemoteName: remote}
+	r, _, _ := procWNetAddConnection2.Call(uintptr(unsafe.Pointer(&nr)), uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(u)), connectTemporary)
+	if r != 0 { return syscall.Errno(r) }
+	return nil
+}
+
+func smbDisconnect(unc string) {
+	remote, err := syscall.UTF16PtrFromString(unc)
+	if err != nil { return }
+	procWNetCancelConnection2.Call(uintptr(unsafe.Pointer(remote)), 0, 0)
+}
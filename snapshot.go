@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ────────── snapshot before changes ────────────────────────
+// With snapshot.mode set, the run makes a server-side snapshot of the
+// target before the first file there is replaced or deleted, so a bad run
+// can be rolled back in one step. Runs that only add files take none.
+//   - "s3-versioning" checks that the bucket keeps object versions; the
+//     run's start is then the point to roll back to.
+//   - "vss" creates a shadow copy of snapshot.volume on snapshot.host
+//     (default smb.host) over WMI; the account needs admin rights there.
+//   - "zfs" runs zfs snapshot <snapshot.volume>@<name> on snapshot.host
+//     (user@host) with ssh, which must log on with a key.
+//   - "command" runs snapshot.command, with {name} replaced.
+// If the snapshot fails the run stops before changing anything.
+type SnapshotConf struct {
+	Mode    string `json:"mode"`    // "" | "s3-versioning" | "vss" | "zfs" | "command"
+	Host    string `json:"host"`    // vss: file server; zfs: user@host
+	Volume  string `json:"volume"`  // vss: e.g. D:\ ; zfs: dataset, e.g. tank/backup
+	Command string `json:"command"` // command: run through the shell
+}
+
+type snapshotter struct {
+	conf  SnapshotConf
+	stop  context.CancelCauseFunc
+	start time.Time
+	once  sync.Once
+	name  string
+	err   error
+}
+
+func newSnapshotter(conf *Conf, stop context.CancelCauseFunc, start time.Time) (*snapshotter, error) {
+	c := conf.Snapshot
+	switch c.Mode {
+	case "":
+		return nil, nil
+	case "s3-versioning":
+		if !strings.EqualFold(conf.Type, "s3") { return nil, fmt.Errorf("snapshot.mode s3-versioning needs type s3") }
+	case "vss":
+		if c.Host == "" { c.Host = strings.Split(conf.SMB.Host, ":")[0] }
+		if c.Host == "" || c.Volume == "" { return nil, fmt.Errorf("snapshot.mode vss needs snapshot.host (or smb.host) and snapshot.volume") }
+	case "zfs":
+		if c.Host == "" || c.Volume == "" { return nil, fmt.Errorf("snapshot.mode zfs needs snapshot.host and snapshot.volume (the dataset)") }
+	case "command":
+		if c.Command == "" { return nil, fmt.Errorf("snapshot.mode command needs snapshot.command") }
+	default:
+		return nil, fmt.Errorf("snapshot.mode: unknown mode %q (use s3-versioning, vss, zfs or command)", c.Mode)
+	}
+	return &snapshotter{conf: c, stop: stop, start: start}, nil
+}
+
+// before is called ahead of every change to an existing remote file; the
+// first call takes the snapshot, and a failure stops the run.
+func (s *snapshotter) before(ctx context.Context, t target) error {
+	if s == nil { return nil }
+	s.once.Do(func() {
+		s.name = "datasync-" + s.start.UTC().Format(versionStamp)
+		if s.err = s.take(ctx, t); s.err != nil {
+			s.err = fmt.Errorf("snapshot (%s): %w; run stopped before changing the target", s.conf.Mode, s.err)
+			s.stop(s.err)
+			return
+		}
+		say("✓", "%s", tr("snapshot", s.name))
+	})
+	return s.err
+}
+
+// taken is the snapshot's name, "" if none was needed.
+func (s *snapshotter) taken() string {
+	if s == nil || s.err != nil { return "" }
+	return s.name
+}
+
+func (s *snapshotter) take(ctx context.Context, t target) error {
+	var cmd *exec.Cmd
+	switch s.conf.Mode {
+	case "s3-versioning":
+		st, ok := t.(*s3Target)
+		if !ok { return fmt.Errorf("target is not S3") }
+		if err := st.versioned(ctx); err != nil { return err }
+		s.name = s.start.UTC().Format(time.RFC3339) // versions from before the run
+		return nil
+	case "vss":
+		ps := fmt.Sprintf(`$r = Invoke-CimMethod -ComputerName '%s' -ClassName Win32_ShadowCopy -MethodName Create -Arguments @{Volume='%s'}; if ($r.ReturnValue -ne 0) { Write-Error "Win32_ShadowCopy.Create returned $($r.ReturnValue)"; exit 1 }; $r.ShadowID`,
+			psQuote(s.conf.Host), psQuote(s.conf.Volume))
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", ps)
+	case "zfs":
+		cmd = exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", s.conf.Host, "zfs", "snapshot", s.conf.Volume+"@"+s.name)
+	case "command":
+		line := strings.ReplaceAll(s.conf.Command, "{name}", s.name)
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/c", line)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", line)
+		}
+	}
+	debugf(catProtocol, "snapshot: %s", strings.Join(cmd.Args, " "))
+	out, err := cmd.CombinedOutput()
+	if err != nil { return fmt.Errorf("%v – %s", ctxErr(ctx, err), strings.TrimSpace(string(out))) }
+	if s.conf.Mode == "vss" { s.name = strings.TrimSpace(string(out)) } // the shadow copy ID
+	return nil
+}
+
+// psQuote escapes s for a single-quoted PowerShell string.
+func psQuote(s string) string { return strings.ReplaceAll(s, "'", "''") }
+
+// versioned checks that the bucket keeps every version of an object.
+func (t *s3Target) versioned(ctx context.Context) error {
+	resp, err := t.send(ctx, "GET", "", url.Values{"versioning": {""}}, nil, nil, -1)
+	if err != nil { return classifyHTTP(err) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return classifyHTTP(t.failure("GET", "?versioning", resp)) }
+	var v struct{ Status string }
+	if err = xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&v); err != nil && err != io.EOF { return err }
+	if v.Status == "" { v.Status = "never enabled" }
+	if v.Status != "Enabled" { return fmt.Errorf("bucket %s does not keep versions (versioning %s)", t.cfg.Bucket, strings.ToLower(v.Status)) }
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ────────── SNMP trap sink ─────────────────────────────────
+// snmpSink sends an SNMPv2c trap when a run fails, another when a run
+// succeeds after a failed one (which needs state_file to know), and one
+// per run while the freshness SLA is breached. Besides
+// sysUpTime and snmpTrapOID each trap carries one string varbind with the
+// site and what happened, under text_oid.
+type SNMPConf struct {
+	Target      string `json:"target"`       // host[:162] receiving traps
+	Community   string `json:"community"`    // default "public"
+	FailureOID  string `json:"failure_oid"`  // snmpTrapOID for a failed run
+	RecoveryOID string `json:"recovery_oid"` // snmpTrapOID for the first good run after a failure
+	TextOID     string `json:"text_oid"`     // varbind with the message (default <trap oid>.1)
+	SLAOID      string `json:"sla_oid"`      // snmpTrapOID for a freshness SLA breach (default failure_oid)
+}
+
+type snmpSink struct {
+	cfg      SNMPConf
+	failure  []int
+	recovery []int
+	sla      []int
+	text     []int // nil: derive from the trap OID
+	started  time.Time
+}
+
+func newSNMPSink(cfg SNMPConf) (*snmpSink, error) {
+	if cfg.FailureOID == "" || cfg.RecoveryOID == "" { return nil, fmt.Errorf("events.snmp needs failure_oid and recovery_oid") }
+	s := &snmpSink{cfg: cfg, started: time.Now()}
+	if s.cfg.Community == "" { s.cfg.Community = "public" }
+	if _, _, err := net.SplitHostPort(s.cfg.Target); err != nil { s.cfg.Target = net.JoinHostPort(s.cfg.Target, "162") }
+	var err error
+	if s.failure, err = parseOID(cfg.FailureOID); err != nil { return nil, fmt.Errorf("events.snmp.failure_oid: %w", err) }
+	if s.recovery, err = parseOID(cfg.RecoveryOID); err != nil { return nil, fmt.Errorf("events.snmp.recovery_oid: %w", err) }
+	s.sla = s.failure
+	if cfg.SLAOID != "" {
+		if s.sla, err = parseOID(cfg.SLAOID); err != nil { return nil, fmt.Errorf("events.snmp.sla_oid: %w", err) }
+	}
+	if cfg.TextOID != "" {
+		if s.text, err = parseOID(cfg.TextOID); err != nil { return nil, fmt.Errorf("events.snmp.text_oid: %w", err) }
+	}
+	return s, nil
+}
+
+func parseOID(s string) ([]int, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	if len(parts) < 2 { return nil, fmt.Errorf("%q is not a dotted OID", s) }
+	oid := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 { return nil, fmt.Errorf("%q is not a dotted OID", s) }
+		oid[i] = n
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] > 39) { return nil, fmt.Errorf("%q is not a valid OID", s) }
+	return oid, nil
+}
+
+func (s *snmpSink) send(e Event) error {
+	if (e.Type != EventRunCompleted && e.Type != EventSLABreached) || e.Summary == nil { return nil }
+	trap, msg := s.failure, ""
+	switch {
+	case e.Type == EventSLABreached:
+		trap, msg = s.sla, e.Error
+	case e.Error != "":
+		msg = tr("sync_failed", kindLabel(e.Kind), e.Error)
+	case e.Summary.Failed > 0:
+		msg = tr("finished_failed", e.Summary.failures())
+	case e.Summary.Recovered:
+		trap, msg = s.recovery, tr("recovered")
+	default:
+		return nil
+	}
+	if e.Site != "" { msg = e.Site + ": " + msg }
+	text := s.text
+	if text == nil { text = append(append([]int(nil), trap...), 1) }
+
+	uptime := time.Since(s.started) / (10 * time.Millisecond)
+	vbs := berSeq(
+		berSeq(berOID(1, 3, 6, 1, 2, 1, 1, 3, 0), berUint(0x43, uint64(uptime))), // sysUpTime.0
+		berSeq(berOID(1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0), berOID(trap...)),           // snmpTrapOID.0
+		berSeq(berOID(text...), berTLV(0x04, []byte(msg))),
+	)
+	pdu := berTLV(0xa7, cat(berInt(int64(rand.Int32())), berInt(0), berInt(0), vbs)) // SNMPv2-Trap-PDU
+	packet := berSeq(berInt(1), berTLV(0x04, []byte(s.cfg.Community)), pdu)       // version 1 = v2c
+
+	c, err := net.Dial("udp", s.cfg.Target)
+	if err != nil { return err }
+	defer c.Close()
+	_, err = c.Write(packet)
+	return err
+}
+
+func (s *snmpSink) close() {}
+
+// ── minimal BER encoding for the trap above
+
+func cat(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts { b = append(b, p...) }
+	return b
+}
+
+func berTLV(tag byte, v []byte) []byte {
+	b := []byte{tag}
+	switch n := len(v); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, v...)
+}
+
+func berSeq(items ...[]byte) []byte { return berTLV(0x30, cat(items...)) }
+
+func berInt(n int64) []byte {
+	b := []byte{byte(n)}
+	for n >= 0x80 || n < -0x80 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return berTLV(0x02, b)
+}
+
+// berUint encodes an unsigned application type such as TimeTicks.
+func berUint(tag byte, n uint64) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 { b = append([]byte{byte(n)}, b...) }
+	if b[0]&0x80 != 0 { b = append([]byte{0}, b...) }
+	return berTLV(tag, b)
+}
+
+func berOID(oid ...int) []byte {
+	b := base128(nil, oid[0]*40+oid[1])
+	for _, n := range oid[2:] { b = base128(b, n) }
+	return berTLV(0x06, b)
+}
+
+func base128(b []byte, n int) []byte {
+	var tmp []byte
+	for {
+		tmp = append([]byte{byte(n & 0x7f)}, tmp...)
+		if n >>= 7; n == 0 { break }
+	}
+	for i := 0; i < len(tmp)-1; i++ { tmp[i] |= 0x80 }
+	return append(b, tmp...)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ────────── socket options ─────────────────────────────────
+// dialer builds the net.Dialer used for every FTP control and data
+// connection: an optional source address (IP or interface name, so
+// routers can tell sync traffic apart) and an optional DSCP mark.
+func (tc TransferConf) dialer() (net.Dialer, error) {
+	d := net.Dialer{Timeout: 10 * time.Second}
+	if tc.Bind != "" {
+		ip, err := bindIP(tc.Bind)
+		if err != nil { return d, err }
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if tc.DSCP != 0 {
+		if tc.DSCP < 0 || tc.DSCP > 63 { return d, fmt.Errorf("transfer.dscp %d is outside 0-63", tc.DSCP) }
+		tos := tc.DSCP << 2
+		d.Control = func(network, _ string, c syscall.RawConn) error {
+			var serr error
+			if err := c.Control(func(fd uintptr) { serr = setTOS(fd, network, tos) }); err != nil { return err }
+			return serr
+		}
+	}
+	return d, nil
+}
+
+func bindIP(s string) (net.IP, error) {
+	if ip := net.ParseIP(s); ip != nil { return ip, nil }
+	ifc, err := net.InterfaceByName(s)
+	if err != nil { return nil, fmt.Errorf("transfer.bind: %q is neither an IP nor an interface", s) }
+	addrs, err := ifc.Addrs()
+	if err != nil { return nil, err }
+	var v6 net.IP
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok { continue }
+		if ipn.IP.To4() != nil { return ipn.IP, nil }
+		if v6 == nil && !ipn.IP.IsLinkLocalUnicast() { v6 = ipn.IP }
+	}
+	if v6 == nil { return nil, fmt.Errorf("transfer.bind: interface %s has no usable address", s) }
+	return v6, nil
+}
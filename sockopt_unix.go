@@ -0,0 +1,12 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+func setTOS(fd uintptr, network string, tos int) error {
+	if network == "tcp6" {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
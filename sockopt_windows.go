@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+const ipv6TClass = 39 // IPV6_TCLASS, ws2ipdef.h
+
+// setTOS asks for the mark on the socket. Windows only honours it when
+// user TOS setting is allowed; otherwise a QoS policy for dirsync.exe in
+// Group Policy does the same job.
+func setTOS(fd uintptr, network string, tos int) error {
+	if network == "tcp6" {
+		return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IPV6, ipv6TClass, tos)
+	}
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
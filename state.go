@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ────────── sync state ─────────────────────────────────────
+// The state file remembers what this site last wrote to each remote path,
+// so a run can tell when another writer has replaced a file since.
+type fileState struct {
+	RemoteMTime time.Time   `json:"remote_mtime"`
+	Size        int64       `json:"size"`
+	SHA256      string      `json:"sha256,omitempty"`      // compare: hash
+	Sum         string      `json:"sum,omitempty"`         // compare: hash under another hash_algorithm, "xxhash:…"
+	ETag        string      `json:"etag,omitempty"`        // HTTP and S3 targets, for assert
+	LocalMTime  time.Time   `json:"local_mtime"`           // the local copy's when the two were last in sync
+	Resume      *hashResume `json:"hash_resume,omitempty"` // large files under compare: hash, see compare.go
+	Partial     bool        `json:"partial,omitempty"`     // an upload of ours broke off since, the target's copy may be cut short
+	Gone        *time.Time  `json:"gone,omitempty"`        // when a run first found the file gone from local_dir, see statetool.go
+}
+
+type syncState struct {
+	Format     int                     `json:"format,omitempty"`         // see format.go
+	Files      map[string]fileState    `json:"files"`
+	Tree       map[string]*dirSnap     `json:"tree,omitempty"`           // warm start, see scan.go
+	Failing    bool                    `json:"failing,omitempty"`        // the last run failed
+	FreshSince time.Time               `json:"fresh_since,omitempty"`    // last good run, or the first run as a baseline (sla)
+	Resume     *resumeToken            `json:"resume,omitempty"`         // left by a run that did not finish cleanly, see resume.go
+	Transfers  []transferRecord        `json:"transfers,omitempty"`      // recent runs' throughput, see estimate.go
+	Broken     map[string]brokenUpload `json:"broken_uploads,omitempty"` // uploads that broke off, see ftpresume.go
+	Dated      string                  `json:"dated,omitempty"`          // the last run's dated_folder, see dated.go
+	Collected  time.Time               `json:"collected,omitempty"`      // the last pass for records of files gone, see statetool.go
+	extra      map[string]json.RawMessage // fields of a newer binary, kept
+	path       string
+	mu         sync.Mutex
+}
+
+func loadState(p string) (*syncState, error) {
+	s := &syncState{Files: map[string]fileState{}, path: p}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) { return s, nil }
+	if err != nil { return nil, err }
+	if err = s.decode(b); err != nil {
+		return nil, fmt.Errorf("state %s: %w", p, err)
+	}
+	if s.Files == nil { s.Files = map[string]fileState{} }
+	return s, nil
+}
+
+// conflict reports whether the remote copy is newer than the one we last
+// wrote. Only "newer" counts: FTP LIST drops to day precision once a file
+// is old, which makes an untouched file look earlier, never later. After
+// an upload of ours broke off, the newer copy is what it left.
+func (s *syncState) conflict(rel string, remote time.Time) bool {
+	if s == nil || remote.IsZero() { return false }
+	s.mu.Lock()
+	last, ok := s.Files[rel]
+	s.mu.Unlock()
+	return ok && !last.Partial && remote.After(last.RemoteMTime)
+}
+
+// interrupted notes that an upload of rel failed after it may have begun
+// writing, so the target's copy is ours even though it changed.
+func (s *syncState) interrupted(rel string) {
+	if s == nil { return }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.Files[rel]; ok && !f.Partial {
+		f.Partial = true
+		s.Files[rel] = f
+	}
+}
+
+// record notes rel as in sync: the target reports remote (and etag) for
+// the local file l.
+func (s *syncState) record(rel string, remote time.Time, etag string, l FileInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[rel] = fileState{RemoteMTime: remote, Size: l.Size, SHA256: l.SHA256, Sum: l.Sum, ETag: etag, LocalMTime: l.MTime, Resume: l.resume}
+}
+
+// forget drops rel, gone from both sides.
+func (s *syncState) forget(rel string) {
+	if s == nil { return }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Files, rel)
+	delete(s.Broken, rel)
+}
+
+// rekey moves the records under the path prefix from to prefix to.
+func (s *syncState) rekey(from, to string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for rel, f := range s.Files {
+		if !strings.HasPrefix(rel, from) { continue }
+		delete(s.Files, rel)
+		s.Files[to+strings.TrimPrefix(rel, from)] = f
+	}
+	for rel := range s.Broken {
+		if strings.HasPrefix(rel, from) { delete(s.Broken, rel) } // what broke off there stays there
+	}
+}
+
+// last is what was recorded for rel, if anything.
+func (s *syncState) last(rel string) (fileState, bool) {
+	if s == nil { return fileState{}, false }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.Files[rel]
+	return f, ok
+}
+
+// hash is the sum recorded for rel under algo, "" if none was.
+func (s *syncState) hash(rel, algo string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := s.Files[rel]
+	if algo == hashSHA256 { return f.SHA256 }
+	if strings.HasPrefix(f.Sum, algo+":") { return f.Sum }
+	return ""
+}
+
+// resumable is the saved hash state for rel under algo, nil if none.
+func (s *syncState) resumable(rel, algo string) *hashResume {
+	if s.hash(rel, algo) == "" { return nil }
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Files[rel].Resume
+}
+
+// content is the algorithm and sum of what was recorded, "" if neither.
+func (f fileState) content() (algo, sum string) {
+	if f.SHA256 != "" { return hashSHA256, f.SHA256 }
+	if a, _, ok := strings.Cut(f.Sum, ":"); ok { return a, f.Sum }
+	return "", ""
+}
+
+func (s *syncState) save() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil { return err }
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(s.document()); err != nil {
+		f.Close(); return err
+	}
+	if err = f.Close(); err != nil { return err }
+	return os.Rename(tmp, s.path)
+}
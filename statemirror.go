@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ────────── state mirror ───────────────────────────────────
+// With state_mirror set to a file name, a push that went through ends by
+// writing the state file's records to the target under that name, in the
+// form `datasync state export` gives them. A branch machine that is
+// reinstalled or re-imaged comes back with local_dir restored and no
+// state file. Its first run then reads the copy back, before it compares
+// anything, and takes each record whose file is in local_dir as it was
+// when recorded: with the same SHA-256 where one was recorded, as under
+// compare: hash, else with the same size and mtime. The run goes on from
+// there as if the state file had never gone. Without the records,
+// compare: hash would send again every file the restore gave a new mtime,
+// and the conflict check would miss what changed on the target
+// meanwhile. A record whose file is missing or different is left out, so
+// that file is decided afresh. A state file that has records is never
+// seeded, and a missing or unreadable copy only means a run without one.
+// The copy replaces itself each run, so write_once cannot be used, and
+// neither can a pull or two-way sync, which would take it for a file of
+// the target.
+
+// checkStateMirror rejects settings the state mirror cannot work with.
+func checkStateMirror(c *Conf) error {
+	if c.StateMirror == "" { return nil }
+	switch {
+	case c.StateFile == "":
+		return fmt.Errorf("state_mirror needs state_file")
+	case c.Direction == "pull" || c.Direction == "both":
+		return fmt.Errorf("state_mirror is for a push; direction %s would take it for a file of the target", c.Direction)
+	case c.WriteOnce:
+		return fmt.Errorf("state_mirror replaces its file every run, which write_once forbids")
+	case c.DatedFolder != "":
+		return fmt.Errorf("state_mirror does not work with dated_folder, whose records name each run's folder")
+	case c.StateMirror == c.Canary:
+		return fmt.Errorf("state_mirror and canary both name %s", c.Canary)
+	}
+	if _, err := os.Lstat(filepath.Join(c.LocalDir, filepath.FromSlash(c.StateMirror))); err == nil {
+		return fmt.Errorf("state_mirror: %s is also a file in local_dir", c.StateMirror)
+	}
+	return nil
+}
+
+// stateMirrorRel is the copy's path on the target, under the site.
+func (c *Conf) stateMirrorRel() string {
+	if c.StateMirror == "" || c.Site == "" { return c.StateMirror }
+	return c.Site + "/" + c.StateMirror
+}
+
+// seedState takes the records of the target's copy into a state file
+// without any.
+func (r *run) seedState(ctx context.Context) error {
+	if r.conf.StateMirror == "" || len(r.st.Files) > 0 { return nil }
+	rel := r.conf.stateMirrorRel()
+	ctx = withReadOnly(ctx)
+	t, err := connect(ctx, r.conf)
+	if err != nil { return err }
+	defer t.close()
+	if _, err = t.stat(ctx, rel); errors.Is(err, ErrNotFound) {
+		debugf(catCompare, "state_mirror: no %s on the target yet", rel)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("state_mirror: %s: %w", rel, err)
+	}
+	var b bytes.Buffer
+	if err = t.fetch(ctx, rel, &b); err != nil { return fmt.Errorf("state_mirror: reading %s: %w", rel, err) }
+	e, err := decodeStateExport(rel, b.Bytes())
+	if err == nil && e.Site != r.conf.Site { err = fmt.Errorf("state_mirror: %s is of site %q, not %q", rel, e.Site, r.conf.Site) }
+	if err != nil { log.Printf("%v; going on without it", err); return nil }
+	good := &stateExport{Files: map[string]fileState{}}
+	for rel, f := range e.Files {
+		if err := ctx.Err(); err != nil { return err }
+		ok, err := r.asRecorded(rel, f)
+		if err != nil { return fmt.Errorf("state_mirror: %s: %w", rel, err) }
+		if ok { f.Gone = nil; good.Files[rel] = f }
+	}
+	took, _ := r.st.take(good, false)
+	say("↻", "%s", tr("state_seeded", rel, e.Host, took, len(e.Files)-took))
+	return nil
+}
+
+// asRecorded reports whether rel's file in local_dir is as f recorded it.
+func (r *run) asRecorded(rel string, f fileState) (bool, error) {
+	if f.Partial { return false, nil }
+	p, fi, err := r.findLocal(rel)
+	if errors.Is(err, fs.ErrNotExist) { return false, nil }
+	if err != nil { return false, classifyOS(err) }
+	if !fi.Mode().IsRegular() || fi.Size() != f.Size { return false, nil }
+	if f.SHA256 == "" { return fi.ModTime().Equal(f.LocalMTime), nil }
+	sum, err := hashFile(p)
+	if err != nil { return false, classifyOS(err) }
+	return sum == f.SHA256, nil
+}
+
+// mirrorState writes the records to the target, retrying what may pass
+// on a fresh connection as file uploads do.
+func (r *run) mirrorState(ctx context.Context, dial func() (target, error)) error {
+	rel := r.conf.stateMirrorRel()
+	f, err := os.CreateTemp(tempDir, "datasync-state-*")
+	if err != nil { return err }
+	defer os.Remove(f.Name())
+	err = writeJSON(f, r.st.export(r.conf.Site))
+	if cerr := f.Close(); err == nil { err = cerr }
+	if err != nil { return err }
+	put := func() error {
+		t, err := dial()
+		if err != nil { return err }
+		defer t.close()
+		return t.upload(ctx, f.Name(), rel)
+	}
+	for try := 1; try <= attempts; try++ {
+		if err = put(); err == nil || !retryable(err) { break }
+		if try < attempts { debugf(catTransfer, "state_mirror: attempt %d failed, retrying: %v", try, err) }
+	}
+	if err != nil { return fmt.Errorf("writing %s: %w", rel, err) }
+	debugf(catTransfer, "state_mirror: %s written", rel)
+	return nil
+}
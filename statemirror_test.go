@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStateMirror is a push keeping its records on the target.
+func newStateMirror(t *testing.T) *twoWay {
+	w := newTwoWay(t)
+	w.conf.Direction, w.conf.StateMirror = "", ".datasync-state.json"
+	return w
+}
+
+func TestStateMirror(t *testing.T) {
+	w := newStateMirror(t)
+	w.conf.Compare = "hash"
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "a.txt", "a", then)
+	put(t, w.here, "b.txt", "b", then)
+	put(t, w.here, "sub/c.txt", "c", then)
+	if s := w.sync(t); s.Uploaded != 3 { t.Fatalf("first run: %d uploaded, want 3", s.Uploaded) }
+	e, err := loadStateExport(filepath.Join(w.there, w.conf.StateMirror))
+	if err != nil { t.Fatal(err) }
+	if len(e.Files) != 3 { t.Fatalf("copy on the target: %d records, want 3", len(e.Files)) }
+
+	// the machine is re-imaged: local_dir is copied back from backup, which
+	// gives every file a new mtime, with one file edited and one missing,
+	// and the state file is gone
+	os.Remove(w.conf.StateFile)
+	restored := time.Now().Add(time.Minute).Truncate(time.Second)
+	put(t, w.here, "a.txt", "a", restored)
+	put(t, w.here, "b.txt", "B", restored)
+	os.Remove(filepath.Join(w.here, "sub", "c.txt"))
+	if s := w.sync(t); s.Uploaded != 1 || body(w.there, "b.txt") != "B" { t.Errorf("after re-imaging: %d uploaded; want b.txt only", s.Uploaded) }
+	st, _ := loadState(w.conf.StateFile)
+	if _, ok := st.last("a.txt"); !ok { t.Error("a.txt not seeded") }
+	if _, ok := st.last("sub/c.txt"); ok { t.Error("sub/c.txt, gone from local_dir, seeded") }
+
+	// a state file with records is never seeded again
+	put(t, w.here, "a.txt", "a", restored.Add(time.Minute))
+	st, _ = loadState(w.conf.StateFile)
+	delete(st.Files, "a.txt")
+	st.save()
+	if s := w.sync(t); s.Uploaded != 1 { t.Errorf("with records left: %d uploaded, want a.txt sent again", s.Uploaded) }
+}
+
+func TestStateMirrorAsRecorded(t *testing.T) {
+	w := newStateMirror(t)
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, rel := range []string{"same.txt", "touched.txt", "grown.txt"} { put(t, w.here, rel, "v1", then) }
+	w.sync(t)
+	os.Remove(w.conf.StateFile)
+	put(t, w.here, "touched.txt", "v1", then.Add(time.Minute))
+	put(t, w.here, "grown.txt", "v1 and more", then)
+
+	st, _ := loadState(w.conf.StateFile)
+	r := &run{conf: w.conf, st: st}
+	if err := r.seedState(context.Background()); err != nil { t.Fatal(err) }
+	if len(st.Files) != 1 { t.Errorf("seeded %d records, want same.txt only", len(st.Files)) }
+	if _, ok := st.last("same.txt"); !ok { t.Error("same.txt not seeded") }
+
+	// with a recorded hash the content decides, whatever the mtime
+	sum, _ := hashFile(filepath.Join(w.here, "touched.txt"))
+	if ok, err := r.asRecorded("touched.txt", fileState{Size: 2, SHA256: sum}); err != nil || !ok { t.Errorf("same content, new mtime: %v, %v; want taken", ok, err) }
+	if ok, _ := r.asRecorded("touched.txt", fileState{Size: 2, SHA256: strings.Repeat("0", 64)}); ok { t.Error("a file of another hash taken") }
+	if ok, _ := r.asRecorded("same.txt", fileState{Size: 2, LocalMTime: then, Partial: true}); ok { t.Error("a record of an upload that broke off taken") }
+}
+
+func TestStateMirrorDryRun(t *testing.T) {
+	w := newStateMirror(t)
+	put(t, w.here, "a.txt", "a", time.Now().Add(-time.Hour))
+	runSync(context.Background(), w.conf, runOpts{progress: &summed{}, dryRun: true})
+	if _, err := os.Stat(filepath.Join(w.there, w.conf.StateMirror)); err == nil { t.Error("a dry run wrote the copy") }
+	w.sync(t)
+	if body(w.there, w.conf.StateMirror) == "" { t.Error("no copy after a run") }
+}
+
+func TestStateMirrorKept(t *testing.T) {
+	w := newMirror(t)
+	w.conf.StateMirror, w.conf.Site = ".datasync-state.json", "lab3"
+	put(t, w.here, "a.txt", "a", time.Now().Add(-time.Hour))
+	w.sync(t)
+	if s := w.sync(t); s.Deleted != 0 { t.Errorf("mirror deleted %d, want the copy kept", s.Deleted) }
+	if body(w.there, "lab3/.datasync-state.json") == "" { t.Error("copy gone from the target") }
+}
+
+func TestCheckStateMirror(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "taken.json"), nil, 0644)
+	for _, c := range []Conf{
+		{StateMirror: "s.json"},
+		{StateMirror: "s.json", StateFile: "st.json", Direction: "pull"},
+		{StateMirror: "s.json", StateFile: "st.json", Direction: "both"},
+		{StateMirror: "s.json", StateFile: "st.json", WriteOnce: true},
+		{StateMirror: "s.json", StateFile: "st.json", DatedFolder: "{date}"},
+		{StateMirror: "s.json", StateFile: "st.json", Canary: "s.json"},
+		{StateMirror: "taken.json", StateFile: "st.json", LocalDir: dir},
+	} {
+		if err := checkStateMirror(&c); err == nil { t.Errorf("%+v accepted", c) }
+	}
+	if err := checkStateMirror(&Conf{StateMirror: "s.json", StateFile: "st.json", LocalDir: dir}); err != nil { t.Error(err) }
+}
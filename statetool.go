@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── state tools ────────────────────────────────────
+// `datasync state` works on a job's state file between runs:
+//
+//	datasync state export -conf site.json -out state.json
+//	datasync state import -conf site.json [-replace] state.json
+//	datasync state verify -conf site.json
+//	datasync state repair -conf site.json
+//	datasync state gc -conf site.json [-days 30]
+//
+// export writes the file records in a portable form, to move a site to
+// another machine, keep a copy of them or seed a new one. It keeps what
+// describes the files on the target, keyed by their path there, and
+// leaves out what only holds on this machine: the warm-start tree, the
+// resume token, the throughput history and uploads that broke off.
+// import takes such a copy into state_file for the files it has no record
+// of, or in place of every record with -replace; a copy of another site
+// is refused. verify compares every record with the target, as
+// check-remote does, and with local_dir. A file gone from the target or
+// changed there since it was recorded is a divergence and makes it exit
+// 1; a record whose file is gone from local_dir is only counted, as
+// `state gc` drops those. repair drops the records of files gone from the
+// target, so the next run decides them afresh, and records a changed file
+// again when its copy on the target reads back with the local file's
+// SHA-256. Other changed copies stay conflicts for someone to look at.
+// import and repair take the job's lock, so they never run under a sync.
+type stateExport struct {
+	Format   int                  `json:"format,omitempty"` // the state file's, see format.go
+	Exported time.Time            `json:"exported"`
+	Host     string               `json:"host,omitempty"`
+	Site     string               `json:"site,omitempty"`
+	Files    map[string]fileState `json:"files"`
+}
+
+// export is the portable copy of the file records.
+func (s *syncState) export(site string) *stateExport {
+	host, _ := os.Hostname()
+	e := &stateExport{Format: stamp(stateFormat), Exported: time.Now().UTC(), Host: host, Site: site, Files: map[string]fileState{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for rel, f := range s.Files { e.Files[rel] = f }
+	return e
+}
+
+func loadStateExport(p string) (*stateExport, error) {
+	b, err := os.ReadFile(p)
+	if err != nil { return nil, err }
+	return decodeStateExport(p, b)
+}
+
+// decodeStateExport reads an export from b, named p in its errors.
+func decodeStateExport(p string, b []byte) (*stateExport, error) {
+	var raw map[string]json.RawMessage
+	var err error
+	if err = json.Unmarshal(b, &raw); err != nil { return nil, fmt.Errorf("state export %s: %w", p, err) }
+	var n int
+	if f, ok := raw["format"]; ok {
+		if err = json.Unmarshal(f, &n); err != nil { return nil, fmt.Errorf("state export %s: format: %w", p, err) }
+	}
+	if err = checkFormat(n, stateFormat); err != nil { return nil, fmt.Errorf("state export %s: %w", p, err) }
+	if err = migrate(raw, formatOf(n), stateSteps); err != nil { return nil, fmt.Errorf("state export %s: %w", p, err) }
+	b, _ = json.Marshal(raw)
+	var e stateExport
+	if err = json.Unmarshal(b, &e); err != nil { return nil, fmt.Errorf("state export %s: %w", p, err) }
+	if e.Files == nil { return nil, fmt.Errorf("state export %s: no files", p) }
+	return &e, nil
+}
+
+// take adds the records of e the state has none of, or with replace puts
+// them in place of all of them; it returns how many it took and kept.
+func (s *syncState) take(e *stateExport, replace bool) (took, kept int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if replace { s.Files = map[string]fileState{} }
+	for rel, f := range e.Files {
+		if _, ok := s.Files[rel]; ok { kept++; continue }
+		s.Files[rel] = f
+		took++
+	}
+	return took, kept
+}
+
+// stateCheck is what verify and repair found.
+type stateCheck struct {
+	records   int
+	gone      int // gone from the target
+	changed   int // changed on the target since recorded
+	stale     int // gone from local_dir
+	dropped   int
+	refreshed int
+}
+
+// checkState compares every record with the target and local_dir, and
+// with repair puts right what it can.
+func checkState(ctx context.Context, conf *Conf, st *syncState, repair bool) (stateCheck, error) {
+	var c stateCheck
+	if !repair { ctx = withReadOnly(ctx) }
+	t, err := connect(ctx, conf)
+	if err != nil { return c, err }
+	defer t.close()
+	r := &run{conf: conf, st: st}
+	st.mu.Lock()
+	rels := make([]string, 0, len(st.Files))
+	for rel := range st.Files { rels = append(rels, rel) }
+	st.mu.Unlock()
+	sort.Strings(rels)
+	for _, rel := range rels {
+		if err := ctx.Err(); err != nil { return c, err }
+		c.records++
+		last, _ := st.last(rel)
+		local, fi, lerr := r.findLocal(rel)
+		if errors.Is(lerr, fs.ErrNotExist) {
+			c.stale++
+		} else if lerr != nil {
+			return c, fmt.Errorf("%s: %w", rel, classifyOS(lerr))
+		}
+		remote, err := t.stat(ctx, rel)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			c.gone++
+			if !repair { say("!", "%s", tr("state_gone", rel)); continue }
+			st.forget(rel)
+			c.dropped++
+			say("-", "%s", tr("state_dropped", rel))
+		case err != nil:
+			return c, fmt.Errorf("%s: %w", rel, err)
+		case changedSince(remote, last):
+			c.changed++
+			if repair && lerr == nil && fi.Size() == remote.Size {
+				sum, err := matchBack(ctx, t, rel, local)
+				if err != nil { return c, fmt.Errorf("%s: %w", rel, err) }
+				if sum != "" {
+					st.record(rel, remote.MTime, remote.ETag, FileInfo{Rel: rel, Path: local, Size: fi.Size(), MTime: fi.ModTime(), SHA256: sum})
+					c.refreshed++
+					say("✓", "%s", tr("state_matched", rel))
+					continue
+				}
+			}
+			say("!", "%s", tr("state_changed", rel))
+		}
+	}
+	return c, nil
+}
+
+// matchBack reads rel back from t and returns its SHA-256 if it is the
+// local file's, "" if it is not.
+func matchBack(ctx context.Context, t target, rel, local string) (string, error) {
+	h := sha256.New()
+	if err := t.fetch(ctx, rel, h); err != nil { return "", err }
+	here, err := hashFile(local)
+	if err != nil { return "", classifyOS(err) }
+	if there := hex.EncodeToString(h.Sum(nil)); there != here { return "", nil }
+	return here, nil
+}
+
+// findLocal is the file in local_dir that rel was recorded for. A pushed
+// name the normalize setting changed on its way to the target is looked
+// up in its folder's listing.
+func (r *run) findLocal(rel string) (string, fs.FileInfo, error) {
+	if r.conf.Direction == "pull" || r.conf.Direction == "both" {
+		p, err := r.pullPath(rel)
+		if err != nil { return "", nil, fs.ErrNotExist } // no name local_dir could hold
+		fi, err := os.Lstat(p)
+		return p, fi, err
+	}
+	p, err := rpath.Local(r.conf.LocalDir, r.under(rel))
+	if err != nil { return "", nil, fs.ErrNotExist }
+	fi, err := os.Lstat(p)
+	if !errors.Is(err, fs.ErrNotExist) || r.conf.Normalize == "" { return p, fi, err }
+	p = r.conf.LocalDir
+	for _, part := range strings.Split(r.under(rel), "/") {
+		ents, err := os.ReadDir(p)
+		if err != nil { return "", nil, err }
+		found := ""
+		for _, e := range ents {
+			if rpath.Normalize(e.Name(), r.conf.Normalize) == part { found = e.Name(); break }
+		}
+		if found == "" { return "", nil, fs.ErrNotExist }
+		p = filepath.Join(p, found)
+	}
+	fi, err = os.Lstat(p)
+	return p, fi, err
+}
+
+func (e *stateExport) write(p string) error {
+	if p == "-" { return writeJSON(os.Stdout, e) }
+	f, err := os.Create(p)
+	if err != nil { return err }
+	if err = writeJSON(f, e); err != nil {
+		f.Close(); return err
+	}
+	return f.Close()
+}
+
+// stateMain runs `datasync state`. The job's lock, where taken, goes
+// with the process.
+func stateMain(args []string) {
+	usage := "usage: state export -conf f -out state.json | state import -conf f [-replace] state.json | state verify -conf f | state repair -conf f | state gc -conf f [-days n]"
+	if len(args) < 1 { log.Fatal(usage) }
+	fl := flag.NewFlagSet("state "+args[0], flag.ExitOnError)
+	cfgPath := fl.String("conf", "dataxfer.conf", "config JSON")
+	out     := fl.String("out", "-", "export: output file, - for stdout")
+	replace := fl.Bool("replace", false, "import: replace every record instead of adding the missing ones")
+	days    := fl.Int("days", -1, "gc: drop records of files gone this many days (default state_gc_days)")
+	fl.BoolVar(&asciiOnly, "ascii", false, "plain ASCII output, no Unicode marks")
+	fl.Parse(args[1:])
+
+	conf, err := loadConf(*cfgPath)
+	if err != nil { log.Fatal(err) }
+	if err = setupJob(conf); err != nil { log.Fatal(err) }
+	applyPriority(conf)
+	setLanguage(conf.Language)
+	if conf.StateFile == "" { log.Fatal("state: the config has no state_file") }
+	if err = checkDirection(conf); err != nil { log.Fatal(err) }
+	lock := func() {
+		if _, err := lockJob(conf); err != nil { log.Fatal(err) }
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	switch args[0] {
+	case "export":
+		if fl.NArg() != 0 { log.Fatal(usage) }
+		st, err := loadState(conf.StateFile)
+		if err != nil { log.Fatal(err) }
+		e := st.export(conf.Site)
+		if err = e.write(*out); err != nil { log.Fatal(err) }
+		if *out != "-" { say("✓", "%s", tr("state_exported", len(e.Files), *out)) }
+	case "import":
+		if fl.NArg() != 1 { log.Fatal(usage) }
+		e, err := loadStateExport(fl.Arg(0))
+		if err != nil { log.Fatal(err) }
+		if e.Site != conf.Site { log.Fatalf("state import: %s is of site %q, the config's is %q", fl.Arg(0), e.Site, conf.Site) }
+		lock()
+		st, err := loadState(conf.StateFile)
+		if err != nil { log.Fatal(err) }
+		took, kept := st.take(e, *replace)
+		if err = st.save(); err != nil { log.Fatal(err) }
+		say("✓", "%s", tr("state_imported", took, conf.StateFile, kept))
+	case "verify", "repair":
+		if fl.NArg() != 0 { log.Fatal(usage) }
+		if conf.DatedFolder != "" { log.Fatalf("state %s: not for dated_folder, whose records name each run's folder", args[0]) }
+		repair := args[0] == "repair"
+		if repair { lock() }
+		st, err := loadState(conf.StateFile)
+		if err != nil { log.Fatal(err) }
+		c, err := checkState(ctx, conf, st, repair)
+		if repair && c.dropped+c.refreshed > 0 {
+			if serr := st.save(); serr != nil && err == nil { err = serr }
+		}
+		if err != nil { log.Fatalf("[%s] %v", errorKind(err), err) }
+		if c.stale > 0 { say("!", "%s", tr("state_stale", c.stale)) }
+		if bad := c.gone + c.changed - c.dropped - c.refreshed; bad > 0 {
+			say("✗", "%s", tr("state_diverged", bad, c.records))
+			os.Exit(1)
+		}
+		say("✓", "%s", tr("state_checked", c.records))
+	case "gc":
+		if fl.NArg() != 0 { log.Fatal(usage) }
+		if conf.DatedFolder != "" { log.Fatal("state gc: not for dated_folder, which drops its own records") }
+		if *days < 0 { *days = max(conf.gcDays(), 0) }
+		lock()
+		st, err := loadState(conf.StateFile)
+		if err != nil { log.Fatal(err) }
+		marked, dropped, err := (&run{conf: conf, st: st}).collect(*days, time.Now().UTC())
+		if err != nil { log.Fatalf("state gc: %v", err) }
+		if err = st.save(); err != nil { log.Fatal(err) }
+		say("✓", "%s", tr("state_collected", dropped, marked))
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// ────────── state garbage collection ───────────────────────
+// In a push a record outlives its file: the copy stays on the target,
+// and the record keeps the conflict check and detect_renames working for
+// it. On drop folders with high churn such records pile up, so once a day
+// a run that finished cleanly looks up the files of the records under its
+// site in local_dir. A record whose file is gone is marked with when that
+// was first seen, and dropped state_gc_days (default 90) later; a file
+// that is back before then loses its mark. The state file is written
+// whole at every save, so a dropped record leaves the file with it. A
+// pass that finds none of the files, as an unmounted local_dir would
+// look, changes nothing. `datasync state gc` makes a pass at once, and
+// with -days 0 drops every record whose file is gone now. state_gc_days
+// -1 keeps records for good; dated_folder, which drops its own records,
+// makes no pass.
+const collectEvery = 24 * time.Hour
+
+// gcDays is state_gc_days with its default.
+func (c *Conf) gcDays() int {
+	if c.StateGCDays == 0 { return 90 }
+	return c.StateGCDays
+}
+
+// collectDue makes the daily pass at the end of a clean run.
+func (r *run) collectDue() {
+	if r.opts.dryRun || r.conf.DatedFolder != "" || r.conf.gcDays() < 0 { return }
+	now := time.Now().UTC()
+	if now.Sub(r.st.Collected) < collectEvery { return }
+	marked, dropped, err := r.collect(r.conf.gcDays(), now)
+	if err != nil { log.Printf("state: %v", err); return }
+	debugf(catCompare, "state: %d record(s) of files gone from local_dir marked, %d dropped", marked, dropped)
+}
+
+// collect marks the records of files gone from local_dir and drops those
+// gone for days; days 0 drops them at once.
+func (r *run) collect(days int, now time.Time) (marked, dropped int, err error) {
+	st := r.st
+	st.mu.Lock()
+	var rels []string
+	for rel := range st.Files {
+		if r.conf.Site == "" || strings.HasPrefix(rel, r.conf.Site+"/") { rels = append(rels, rel) }
+	}
+	st.mu.Unlock()
+	gone := map[string]bool{}
+	for _, rel := range rels {
+		_, _, err := r.findLocal(rel)
+		if errors.Is(err, fs.ErrNotExist) {
+			gone[rel] = true
+		} else if err != nil {
+			return 0, 0, fmt.Errorf("%s: %w", rel, classifyOS(err))
+		}
+	}
+	if len(rels) > 0 && len(gone) == len(rels) {
+		return 0, 0, fmt.Errorf("local_dir holds none of the %d recorded file(s); no record was dropped (is local_dir right?)", len(rels))
+	}
+	keep := time.Duration(days) * 24 * time.Hour
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, rel := range rels {
+		f, ok := st.Files[rel]
+		switch {
+		case !ok:
+		case !gone[rel]:
+			if f.Gone != nil { f.Gone = nil; st.Files[rel] = f }
+		case days > 0 && f.Gone == nil:
+			f.Gone = &now
+			st.Files[rel] = f
+			marked++
+		case days == 0 || now.Sub(*f.Gone) >= keep:
+			delete(st.Files, rel)
+			delete(st.Broken, rel)
+			dropped++
+		}
+	}
+	st.Collected = now
+	return marked, dropped, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateExportImport(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction, w.conf.Site = "", "lab3"
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "a.txt", "a", then)
+	put(t, w.here, "sub/b.txt", "b", then)
+	w.sync(t)
+	st, err := loadState(w.conf.StateFile)
+	if err != nil { t.Fatal(err) }
+	p := filepath.Join(t.TempDir(), "export.json")
+	if err = st.export(w.conf.Site).write(p); err != nil { t.Fatal(err) }
+	e, err := loadStateExport(p)
+	if err != nil { t.Fatal(err) }
+	if e.Site != "lab3" || len(e.Files) != 2 { t.Fatalf("export: site %q, %d records; want lab3, 2", e.Site, len(e.Files)) }
+	for rel, f := range st.Files {
+		if g := e.Files[rel]; !g.RemoteMTime.Equal(f.RemoteMTime) || g.Size != f.Size || !g.LocalMTime.Equal(f.LocalMTime) { t.Errorf("%s: exported %+v, recorded %+v", rel, g, f) }
+	}
+
+	// into a new machine's state file: nothing is uploaded again, and the
+	// target's copies are still those this site wrote
+	fresh, _ := loadState(filepath.Join(t.TempDir(), "new.json"))
+	fresh.Files["lab3/mine.txt"] = fileState{Size: 1}
+	if took, kept := fresh.take(e, false); took != 2 || kept != 0 || len(fresh.Files) != 3 { t.Errorf("take: %d took, %d kept, %d records; want 2, 0, 3", took, kept, len(fresh.Files)) }
+	e.Files["lab3/mine.txt"] = fileState{Size: 2}
+	if took, kept := fresh.take(e, false); took != 0 || kept != 3 || fresh.Files["lab3/mine.txt"].Size != 1 { t.Errorf("take again: %d took, %d kept; want 0, 3 and the own record left", took, kept) }
+	if took, _ := fresh.take(e, true); took != 3 || fresh.Files["lab3/mine.txt"].Size != 2 { t.Errorf("take -replace: %d took, want 3 and the export's record", took) }
+}
+
+func TestStateExportFormat(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "export.json")
+	os.WriteFile(p, []byte(`{"format": 99, "files": {}}`), 0644)
+	if _, err := loadStateExport(p); err == nil { t.Error("an export of a newer format was read") }
+	os.WriteFile(p, []byte(`{"site": "x"}`), 0644)
+	if _, err := loadStateExport(p); err == nil { t.Error("an export without files was read") }
+}
+
+func TestStateVerifyRepair(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction = ""
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, rel := range []string{"ok.txt", "gone.txt", "changed.txt", "copied.txt", "local-gone.txt"} { put(t, w.here, rel, "v1", then) }
+	w.sync(t)
+	os.Remove(filepath.Join(w.there, "gone.txt"))
+	put(t, w.there, "changed.txt", "someone else's", time.Now().Add(time.Minute))
+	put(t, w.there, "copied.txt", "v1", time.Now().Add(time.Minute)) // same content, copied over again
+	os.Remove(filepath.Join(w.here, "local-gone.txt"))
+
+	ctx := context.Background()
+	st, _ := loadState(w.conf.StateFile)
+	c, err := checkState(ctx, w.conf, st, false)
+	if err != nil { t.Fatal(err) }
+	if c.records != 5 || c.gone != 1 || c.changed != 2 || c.stale != 1 || c.dropped+c.refreshed != 0 { t.Errorf("verify: %+v", c) }
+	if len(st.Files) != 5 { t.Errorf("verify changed the records: %d left", len(st.Files)) }
+
+	c, err = checkState(ctx, w.conf, st, true)
+	if err != nil { t.Fatal(err) }
+	if c.dropped != 1 || c.refreshed != 1 { t.Errorf("repair: %+v; want gone.txt dropped and copied.txt recorded again", c) }
+	if _, ok := st.last("gone.txt"); ok { t.Error("gone.txt still recorded") }
+	if c, _ = checkState(ctx, w.conf, st, false); c.gone+c.changed != 1 { t.Errorf("after repair: %+v; want only changed.txt left", c) }
+
+	// gone.txt goes up as new; the changed copy stays for someone to look at
+	if s := w.sync(t); s.Uploaded != 1 || body(w.there, "gone.txt") != "v1" || body(w.there, "changed.txt") != "someone else's" { t.Errorf("after repair: %d uploaded; want gone.txt only", s.Uploaded) }
+}
+
+func TestFindLocal(t *testing.T) {
+	dir := t.TempDir()
+	nfd := "Cafe\u0301"
+	os.MkdirAll(filepath.Join(dir, nfd), 0755)
+	os.WriteFile(filepath.Join(dir, nfd, "a.txt"), []byte("a"), 0644)
+	r := &run{conf: &Conf{LocalDir: dir, Site: "s", Normalize: "nfc"}}
+	if p, _, err := r.findLocal("s/Caf\u00e9/a.txt"); err != nil || p != filepath.Join(dir, nfd, "a.txt") { t.Errorf("normalized name: %q, %v", p, err) }
+	if _, _, err := r.findLocal("s/Caf\u00e9/b.txt"); !errors.Is(err, fs.ErrNotExist) { t.Errorf("missing file: %v", err) }
+	r.conf.Direction, r.conf.MapNames = "pull", "replace"
+	os.WriteFile(filepath.Join(dir, "a_b.txt"), []byte("x"), 0644)
+	if p, _, err := r.findLocal("s/a:b.txt"); err != nil || filepath.Base(p) != "a_b.txt" { t.Errorf("mapped pull name: %q, %v", p, err) }
+}
+
+func TestStateCollect(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction = ""
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, rel := range []string{"keep.txt", "gone.txt", "back.txt"} { put(t, w.here, rel, "v1", then) }
+	w.sync(t)
+	os.Remove(filepath.Join(w.here, "gone.txt"))
+	os.Rename(filepath.Join(w.here, "back.txt"), filepath.Join(w.here, "away.txt"))
+
+	st, _ := loadState(w.conf.StateFile)
+	r := &run{conf: w.conf, st: st}
+	now := time.Now().UTC()
+	if marked, dropped, err := r.collect(90, now); err != nil || marked != 2 || dropped != 0 { t.Fatalf("first pass: %d marked, %d dropped, %v; want 2, 0", marked, dropped, err) }
+	if f := st.Files["gone.txt"]; f.Gone == nil || !f.Gone.Equal(now) { t.Errorf("gone.txt marked %v, want %v", f.Gone, now) }
+	if !st.Collected.Equal(now) { t.Errorf("pass noted at %v, want %v", st.Collected, now) }
+
+	// a day later nothing new; back.txt returns and loses its mark
+	os.Rename(filepath.Join(w.here, "away.txt"), filepath.Join(w.here, "back.txt"))
+	if marked, dropped, _ := r.collect(90, now.Add(24*time.Hour)); marked+dropped != 0 { t.Errorf("second pass: %d marked, %d dropped; want none", marked, dropped) }
+	if f := st.Files["back.txt"]; f.Gone != nil { t.Error("back.txt still marked gone") }
+	if f := st.Files["gone.txt"]; !f.Gone.Equal(now) { t.Error("gone.txt's mark moved") }
+
+	// 90 days after it was first seen gone, the record goes
+	if _, dropped, _ := r.collect(90, now.Add(90*24*time.Hour)); dropped != 1 { t.Errorf("after 90 days: %d dropped, want 1", dropped) }
+	if _, ok := st.last("gone.txt"); ok { t.Error("gone.txt still recorded") }
+	if len(st.Files) != 2 { t.Errorf("%d records left, want keep.txt and back.txt", len(st.Files)) }
+}
+
+func TestStateCollectNoneFound(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction = ""
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "a.txt", "a", then)
+	put(t, w.here, "b.txt", "b", then)
+	w.sync(t)
+	st, _ := loadState(w.conf.StateFile)
+	// local_dir not mounted: every recorded file looks gone
+	r := &run{conf: &Conf{LocalDir: t.TempDir()}, st: st}
+	last := st.Collected
+	if _, _, err := r.collect(0, time.Now().UTC()); err == nil { t.Error("a pass that found none of the files went ahead") }
+	if len(st.Files) != 2 || !st.Collected.Equal(last) { t.Errorf("%d records left, pass noted %v; want both records and no pass", len(st.Files), st.Collected) }
+
+	// -days 0 drops at once, without marking first
+	os.Remove(filepath.Join(w.here, "b.txt"))
+	r.conf = w.conf
+	if marked, dropped, err := r.collect(0, time.Now().UTC()); err != nil || marked != 0 || dropped != 1 { t.Errorf("-days 0: %d marked, %d dropped, %v; want 0, 1", marked, dropped, err) }
+}
+
+func TestStateCollectDue(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction = ""
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "keep.txt", "k", then)
+	put(t, w.here, "gone.txt", "g", then)
+	w.sync(t)
+	os.Remove(filepath.Join(w.here, "gone.txt"))
+	gone := func() *time.Time {
+		st, err := loadState(w.conf.StateFile)
+		if err != nil { t.Fatal(err) }
+		return st.Files["gone.txt"].Gone
+	}
+
+	// the first run made the day's pass, so the next one makes none
+	w.sync(t)
+	if gone() != nil { t.Error("marked within a day of the last pass") }
+
+	st, _ := loadState(w.conf.StateFile)
+	st.Collected = st.Collected.Add(-25 * time.Hour)
+	st.save()
+	runSync(context.Background(), w.conf, runOpts{progress: &summed{}, dryRun: true})
+	if gone() != nil { t.Error("a dry run marked gone.txt") }
+	w.sync(t)
+	if gone() == nil { t.Error("gone.txt not marked by the day's pass") }
+
+	w.conf.StateGCDays = -1
+	st, _ = loadState(w.conf.StateFile)
+	f := st.Files["gone.txt"]
+	f.Gone = nil
+	st.Files["gone.txt"] = f
+	st.Collected = time.Time{}
+	st.save()
+	w.sync(t)
+	if gone() != nil { t.Error("state_gc_days -1 marked gone.txt") }
+}
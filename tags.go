@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── classification tags ────────────────────────────
+// tags maps a data classification to the files it covers, e.g.
+// {"patient-imaging": ["*.dcm"], "finance": ["ledger/**"]}, so a run can
+// say how much of each kind of data it moved. A pattern without a slash
+// matches the file name; one with a slash matches the path under
+// local_dir, where ** stands for any number of folders. Matching ignores
+// case, as Windows does. A file can carry several tags; one matching none
+// is counted as untagged.
+const untagged = "untagged"
+
+type TagsConf map[string][]string
+
+type TagTotal struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+type TagTotals map[string]TagTotal
+
+type tagger struct {
+	names []string   // sorted, so tag lists come out in the same order
+	pats  [][]string // per name, lower case
+}
+
+func newTagger(tags TagsConf) (*tagger, error) {
+	if len(tags) == 0 { return nil, nil }
+	t := &tagger{}
+	for name := range tags { t.names = append(t.names, name) }
+	sort.Strings(t.names)
+	for _, name := range t.names {
+		var pats []string
+		for _, p := range tags[name] {
+			p = strings.ToLower(strings.ReplaceAll(p, `\`, "/"))
+			if !rpath.ValidPattern(p) { return nil, fmt.Errorf("tags.%s: bad pattern %q", name, p) }
+			pats = append(pats, p)
+		}
+		t.pats = append(t.pats, pats)
+	}
+	return t, nil
+}
+
+// match returns the tags of a slash-separated path under local_dir.
+func (t *tagger) match(rel string) []string {
+	if t == nil { return nil }
+	rel = strings.ToLower(rel)
+	var out []string
+	for i, pats := range t.pats {
+		for _, p := range pats {
+			if rpath.Match(p, rel) { out = append(out, t.names[i]); break }
+		}
+	}
+	if len(out) == 0 { out = []string{untagged} }
+	return out
+}
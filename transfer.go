@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// ────────── transfer tuning ────────────────────────────────
+type TransferConf struct {
+	Workers      int    `json:"workers"`        // max files in flight; grows from 1 while transfers succeed
+	Chunks       int    `json:"chunks"`         // parallel streams for one large file; 0/1 = off
+	ChunkMinMB   int64  `json:"chunk_min_mb"`   // only split files at least this big (default 256)
+	Bind         string `json:"bind"`           // source IP or interface name for FTP sockets
+	DSCP         int    `json:"dscp"`           // DSCP mark (0-63) for FTP sockets
+	BufferKB     int    `json:"buffer_kb"`      // copy block size; 0 = let the OS copy path decide
+	ReadAhead    int    `json:"read_ahead"`     // blocks read ahead of the writer (default 4)
+	DeltaMinMB   int64  `json:"delta_min_mb"`   // SMB/local: patch existing copies at least this big in place; 0 = off
+	DeltaBlockKB int    `json:"delta_block_kb"` // delta block size (default 128)
+}
+
+type chunk struct{ off, n int64 }
+
+// split cuts a file of the given size into byte ranges to send in
+// parallel, or returns nil when the file should go as one stream.
+func (tc TransferConf) split(size int64) []chunk {
+	minMB := tc.ChunkMinMB
+	if minMB <= 0 { minMB = 256 }
+	if tc.Chunks < 2 || size < minMB<<20 { return nil }
+	per := (size + int64(tc.Chunks) - 1) / int64(tc.Chunks)
+	var parts []chunk
+	for off := int64(0); off < size; off += per {
+		parts = append(parts, chunk{off, min(per, size-off)})
+	}
+	return parts
+}
+
+// parallelChunks runs fn for every range concurrently and returns the
+// first error.
+func parallelChunks(parts []chunk, fn func(chunk) error) error {
+	errs := make(chan error, len(parts))
+	for _, p := range parts {
+		go func(p chunk) { errs <- fn(p) }(p)
+	}
+	var first error
+	for range parts {
+		if err := <-errs; err != nil && first == nil { first = err }
+	}
+	return first
+}
+
+// readAhead reads its source in a goroutine, keeping up to depth blocks
+// ready, and hands out whole blocks: a high-latency FTP data connection
+// gets large writes while the next disk read is already under way.
+type readAhead struct {
+	blocks   chan []byte
+	free     chan []byte
+	stop     chan struct{}
+	stopOnce sync.Once
+	cur      []byte
+	held     []byte
+	err      error // set by fill before blocks is closed
+}
+
+func (tc TransferConf) newReadAhead(r io.Reader) *readAhead {
+	depth := tc.ReadAhead
+	if depth <= 0 { depth = 4 }
+	ra := &readAhead{blocks: make(chan []byte, depth), free: make(chan []byte, depth+1), stop: make(chan struct{})}
+	for i := 0; i <= depth; i++ { ra.free <- make([]byte, tc.BufferKB<<10) }
+	go ra.fill(r)
+	return ra
+}
+
+func (ra *readAhead) fill(r io.Reader) {
+	defer close(ra.blocks)
+	for {
+		var buf []byte
+		select {
+		case buf = <-ra.free:
+		case <-ra.stop:
+			return
+		}
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			select {
+			case ra.blocks <- buf[:n]:
+			case <-ra.stop:
+				return
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF { return }
+		if err != nil { ra.err = err; return }
+	}
+}
+
+func (ra *readAhead) next() bool {
+	if ra.held != nil { ra.free <- ra.held[:cap(ra.held)]; ra.held = nil }
+	buf, ok := <-ra.blocks
+	if !ok { return false }
+	ra.cur, ra.held = buf, buf
+	return true
+}
+
+func (ra *readAhead) Read(p []byte) (int, error) {
+	for len(ra.cur) == 0 {
+		if !ra.next() {
+			if ra.err != nil { return 0, ra.err }
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, ra.cur)
+	ra.cur = ra.cur[n:]
+	return n, nil
+}
+
+// WriteTo lets io.Copy pass whole blocks straight to the destination
+// instead of going through its own 32 KB buffer.
+func (ra *readAhead) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for len(ra.cur) > 0 || ra.next() {
+		n, err := w.Write(ra.cur)
+		total += int64(n)
+		ra.cur = ra.cur[n:]
+		if err != nil { return total, err }
+	}
+	return total, ra.err
+}
+
+func (ra *readAhead) Close() error {
+	ra.stopOnce.Do(func() { close(ra.stop) })
+	return nil
+}
+
+func copyBuffered(src io.Reader, dst string, tc TransferConf) error {
+	ra := tc.newReadAhead(src)
+	defer ra.Close()
+	out, err := os.Create(dst)
+	if err != nil { return err }
+	if _, err = ra.WriteTo(out); err != nil {
+		out.Close(); return err
+	}
+	return out.Close()
+}
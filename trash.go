@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── recycle bin on the target ──────────────────────
+// With trash_dir set, mirror moves a stale file into that folder on the
+// target instead of deleting it, under a folder for the day and the
+// file's path below the site:
+//
+//	sub/report.xlsx → .trash/2026-10-14/sub/report.xlsx
+//
+// A file trashed twice on one day keeps the later copy. With trash_days,
+// each mirror run deletes the day folders older than that, by their name
+// in the machine's local time; 0 keeps everything. The trash folder sits
+// under remote_path (and site), is never stale itself, and needs a
+// target that can rename (FTP, SMB, local, SCP). Moves count as
+// deletions, and -delete and max_delete apply to them as before.
+type trash struct {
+	dir  string // under the site
+	days int
+}
+
+const trashDay = "2006-01-02"
+
+// newTrash is nil unless trash_dir is set.
+func newTrash(c *Conf) (*trash, error) {
+	switch {
+	case c.TrashDays < 0:
+		return nil, fmt.Errorf("trash_days: %d is negative", c.TrashDays)
+	case c.TrashDir == "":
+		if c.TrashDays != 0 { return nil, fmt.Errorf("trash_days is for trash_dir") }
+		return nil, nil
+	case !c.Mirror:
+		return nil, fmt.Errorf("trash_dir is for mirror, which is what deletes on the target")
+	}
+	dir := path.Clean(strings.Trim(strings.ReplaceAll(c.TrashDir, `\`, "/"), "/"))
+	if dir == "." || dir == ".." || strings.HasPrefix(dir, "../") { return nil, fmt.Errorf("trash_dir: %q is not a folder under remote_path", c.TrashDir) }
+	return &trash{dir: dir, days: c.TrashDays}, nil
+}
+
+// trashRoot is the trash folder as a path on the target.
+func (r *run) trashRoot() string {
+	if r.conf.Site != "" { return r.conf.Site + "/" + r.trash.dir }
+	return r.trash.dir
+}
+
+// trashCovers reports whether dir, a path on the target, is the trash folder.
+func (r *run) trashCovers(dir string) bool { return r.trash != nil && dir == r.trashRoot() }
+
+// toTrash moves rel, stale on the target, into today's trash folder.
+func (r *run) toTrash(ctx context.Context, t target, rm remover, rel string) error {
+	rn, ok := unshared(t).(renamer)
+	if !ok { return fmt.Errorf("trash_dir: %s targets cannot move files", r.conf.Type) }
+	to := r.trashRoot() + "/" + time.Now().Format(trashDay) + "/" + r.under(rel)
+	if _, err := t.stat(ctx, to); err == nil {
+		if err = rm.remove(ctx, to); err != nil && !errors.Is(err, ErrNotFound) { return fmt.Errorf("trash: %w", err) }
+	}
+	if err := rn.rename(ctx, rel, to); err != nil { return fmt.Errorf("trash: moving %s to %s: %w", rel, to, err) }
+	r.st.forget(rel)
+	r.deleted.Add(1)
+	if logOn(catTransfer, lvlInfo) { say("-", "%s", tr("trashed", rel, to)) }
+	reportRemoval(r.prog, rel, false)
+	return nil
+}
+
+// purgeTrash deletes the day folders in the trash older than trash_days.
+func (r *run) purgeTrash(ctx context.Context, t target, l lister, rm remover) error {
+	if r.trash == nil || r.trash.days == 0 { return nil }
+	root := r.trashRoot()
+	_, days, err := l.list(ctx, root)
+	if errors.Is(err, ErrNotFound) { return nil } // nothing trashed yet
+	if err != nil { return fmt.Errorf("trash: listing %q on the target: %w", root, err) }
+	sort.Strings(days)
+	cut := time.Now().AddDate(0, 0, -r.trash.days)
+	for _, d := range days {
+		day, err := time.ParseInLocation(trashDay, d, time.Local)
+		if err != nil || !day.AddDate(0, 0, 1).Before(cut) { continue } // not ours, or not old enough
+		dir := rpath.Join(root, d)
+		if r.opts.dryRun { debugf(catCompare, "trash: would delete %s", dir); continue }
+		if err = r.snapshot.before(ctx, t); err != nil { return err }
+		n, err := removeTree(ctx, l, rm, dir)
+		if err != nil { return fmt.Errorf("trash: deleting %s: %w", dir, err) }
+		if logOn(catTransfer, lvlInfo) { say("-", "%s", tr("trash_purged", dir, n)) }
+	}
+	return nil
+}
+
+// removeTree deletes dir on the target with everything in it, and counts
+// the files.
+func removeTree(ctx context.Context, l lister, rm remover, dir string) (int, error) {
+	if err := ctx.Err(); err != nil { return 0, err }
+	files, dirs, err := l.list(ctx, dir)
+	if err != nil { return 0, err }
+	n := 0
+	for _, f := range files {
+		if err := rm.remove(ctx, f.Rel); err != nil && !errors.Is(err, ErrNotFound) { return n, err }
+		n++
+	}
+	for _, d := range dirs {
+		m, err := removeTree(ctx, l, rm, rpath.Join(dir, d))
+		n += m
+		if err != nil { return n, err }
+	}
+	return n, rm.removeDir(ctx, dir)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"datasync/internal/rpath"
+)
+
+// ────────── WebDAV target ───────────────────────────────────
+// webdavTarget talks plain WebDAV (RFC 4918) over HTTP(S), enough for
+// Nextcloud, ownCloud and IIS shares: PROPFIND Depth 0 for a file's size
+// and mtime, MKCOL for missing folders and PUT for the file. The login is
+// checked with a PROPFIND on webdav.url when connecting, which also
+// learns whether the server wants Basic or Digest auth, so no upload is
+// sent twice. PUT carries X-OC-Mtime, which Nextcloud and ownCloud use as
+// the file's mtime; other servers stamp the upload time, as FTP does.
+type WebDAVConf struct {
+	URL        string `json:"url"`         // e.g. https://cloud.example.com/remote.php/dav/files/<user>
+	User, Pass string
+	RemotePath string `json:"remote_path"` // folder under url
+	TLSCA      string `json:"tls_ca"`      // PEM file with the CA that signed the server certificate (default: system roots)
+}
+
+type webdavTarget struct {
+	base   *url.URL
+	root   rpath.Root
+	client *http.Client
+	user   string
+	pass   string
+	basic  bool            // send Basic credentials
+	digest *digestAuth     // or answer this Digest challenge
+	dirs   map[string]bool // collections known to exist
+}
+
+func connectWebDAV(ctx context.Context, cfg WebDAVConf, tc TransferConf, form string) (*webdavTarget, error) {
+	u, err := url.Parse(strings.TrimRight(cfg.URL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webdav.url: %q is not an http(s) URL", cfg.URL)
+	}
+	client, err := tc.httpClient("webdav.tls_ca", cfg.TLSCA)
+	if err != nil { return nil, err }
+	t := &webdavTarget{base: u, root: rpath.NewRoot(cfg.RemotePath, form), client: client, user: cfg.User, pass: cfg.Pass, dirs: map[string]bool{}}
+	resp, err := t.do(ctx, "PROPFIND", u, map[string]string{"Depth": "0"}, propfindBody)
+	if err != nil { return nil, classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		err = classifyHTTP(statusErr("PROPFIND", u, resp))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden { err = withClass(ErrAuth, err) }
+		return nil, err
+	}
+	debugf(catProtocol, "webdav: logged in to %s as %q (%s)", u.Host, cfg.User, t.scheme())
+	return t, nil
+}
+
+func (t *webdavTarget) scheme() string {
+	switch {
+	case t.digest != nil: return "digest"
+	case t.basic: return "basic"
+	}
+	return "anonymous"
+}
+
+// httpClient is the client for HTTP targets: sockets from dialer, so bind
+// and DSCP apply, and tls_ca for the server certificate.
+func (tc TransferConf) httpClient(caSetting, caFile string) (*http.Client, error) {
+	d, err := tc.dialer()
+	if err != nil { return nil, err }
+	tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tlsConf.RootCAs, err = rootCAs(caSetting, caFile); err != nil { return nil, err }
+	return &http.Client{Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.DialContext,
+		TLSClientConfig:       tlsConf,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 2 * time.Minute, // servers may hash or scan a large PUT before answering
+	}}, nil
+}
+
+// href is the URL of a path under the target root.
+func (t *webdavTarget) href(remote string) *url.URL {
+	u := *t.base
+	u.Path, u.RawPath = path.Join(t.base.Path, "/", remote), ""
+	return &u
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/><d:getlastmodified/><d:resourcetype/></d:prop></d:propfind>`
+
+// multistatus is the part of a PROPFIND answer stat reads. Names match
+// in any namespace, so the DAV: prefix the server picks does not matter.
+type multistatus struct {
+	Responses []struct {
+		Propstats []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				Length   int64  `xml:"getcontentlength"`
+				Modified string `xml:"getlastmodified"`
+				Type     struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func (t *webdavTarget) stat(ctx context.Context, rel string) (FileInfo, error) {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return FileInfo{}, withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opListing); err != nil { return FileInfo{}, err }
+	u := t.href(remote)
+	resp, err := t.do(ctx, "PROPFIND", u, map[string]string{"Depth": "0"}, propfindBody)
+	if err != nil { return FileInfo{}, classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus { return FileInfo{}, classifyHTTP(statusErr("PROPFIND", u, resp)) }
+	var ms multistatus
+	if err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&ms); err != nil {
+		return FileInfo{}, classifyHTTP(ctxErr(ctx, fmt.Errorf("PROPFIND %s: %w", u.Redacted(), err)))
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") { continue }
+			fi := FileInfo{Rel: rel, Size: ps.Prop.Length, Exists: true}
+			fi.MTime, _ = http.ParseTime(ps.Prop.Modified)
+			if ps.Prop.Type.Collection != nil { return fi, withClass(ErrInvalidName, fmt.Errorf("%s is a folder on the target", remote)) }
+			return fi, nil
+		}
+	}
+	return FileInfo{}, fmt.Errorf("PROPFIND %s: no properties in the answer", u.Redacted())
+}
+
+func (t *webdavTarget) upload(ctx context.Context, local, rel string) error {
+	return classifyHTTP(ctxErr(ctx, t.put(ctx, local, rel)))
+}
+
+func (t *webdavTarget) put(ctx context.Context, local, rel string) error {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opUpload); err != nil { return err }
+	for _, d := range rpath.Parents(remote) {
+		if err = t.mkcol(ctx, d); err != nil { return err }
+	}
+	src, err := os.Open(local)
+	if err != nil { return err }
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil { return err }
+	u := t.href(remote)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), ctxReader{ctx, src})
+	if err != nil { return err }
+	req.ContentLength = fi.Size() // a fixed length: some servers refuse chunked PUTs
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-OC-Mtime", strconv.FormatInt(fi.ModTime().Unix(), 10))
+	t.authorize(req)
+	resp, err := t.client.Do(req)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	err = statusErr("PUT", u, resp)
+	// a Digest nonce that went stale mid-run: the retry answers the new one
+	if resp.StatusCode == http.StatusUnauthorized && t.challenge(resp) { return withClass(ErrTransient, err) }
+	if resp.StatusCode >= 300 { return err }
+	return nil
+}
+
+// mkcol creates a collection unless it is known to exist. 405 means it
+// already does.
+func (t *webdavTarget) mkcol(ctx context.Context, dir string) error {
+	if t.dirs[dir] { return nil }
+	u := t.href(dir)
+	resp, err := t.do(ctx, "MKCOL", u, nil, "")
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed { return statusErr("MKCOL", u, resp) }
+	t.dirs[dir] = true
+	return nil
+}
+
+func (t *webdavTarget) fetch(ctx context.Context, rel string, w io.Writer) error {
+	remote, err := t.root.Resolve(rel)
+	if err != nil { return withClass(ErrInvalidName, err) }
+	if err = takeOp(ctx, opDownload); err != nil { return err }
+	u := t.href(remote)
+	resp, err := t.do(ctx, "GET", u, nil, "")
+	if err != nil { return classifyHTTP(ctxErr(ctx, err)) }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return classifyHTTP(statusErr("GET", u, resp)) }
+	_, err = io.Copy(w, resp.Body)
+	return classifyHTTP(ctxErr(ctx, err))
+}
+
+func (t *webdavTarget) close() { t.client.CloseIdleConnections() }
+
+// statusError is an HTTP answer other than the one a request expects.
+type statusError struct {
+	method, url string
+	code        int
+	status      string
+	detail      string // the server's reason, when it gives one (S3)
+}
+
+func (e *statusError) Error() string {
+	if e.detail != "" { return fmt.Sprintf("%s %s: %s (%s)", e.method, e.url, e.status, e.detail) }
+	return fmt.Sprintf("%s %s: %s", e.method, e.url, e.status)
+}
+
+// statusErr reads the rest of resp, so its connection can be reused.
+func statusErr(method string, u *url.URL, resp *http.Response) error {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return &statusError{method: method, url: u.Redacted(), code: resp.StatusCode, status: resp.Status}
+}
+
+// do sends a request with a small body, answering one auth challenge.
+// Uploads go without the retry, after connect has learned the scheme.
+func (t *webdavTarget) do(ctx context.Context, method string, u *url.URL, hdr map[string]string, body string) (*http.Response, error) {
+	for try := 0; ; try++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(body))
+		if err != nil { return nil, err }
+		for k, v := range hdr { req.Header.Set(k, v) }
+		if body != "" { req.Header.Set("Content-Type", "application/xml; charset=utf-8") }
+		t.authorize(req)
+		resp, err := t.client.Do(req)
+		if err != nil { return nil, err }
+		if resp.StatusCode != http.StatusUnauthorized || try > 0 || !t.challenge(resp) { return resp, nil }
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+func (t *webdavTarget) authorize(req *http.Request) {
+	switch {
+	case t.digest != nil:
+		req.Header.Set("Authorization", t.digest.answer(t.user, t.pass, req.Method, req.URL.RequestURI()))
+	case t.basic:
+		req.SetBasicAuth(t.user, t.pass)
+	}
+}
+
+// challenge picks the auth scheme from a 401, Digest over Basic (a
+// Digest algorithm we cannot answer is passed over), and
+// reports whether the request is worth sending again: not when the
+// credentials were already sent and refused, unless a Digest nonce went
+// stale.
+func (t *webdavTarget) challenge(resp *http.Response) bool {
+	if t.user == "" { return false }
+	var basic bool
+	for _, h := range resp.Header.Values("WWW-Authenticate") {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			p := authParams(rest)
+			if t.digest != nil && !strings.EqualFold(p["stale"], "true") { return false }
+			if d := newDigestAuth(p); d != nil { t.digest = d; return true }
+			// an algorithm we do not answer: another challenge may do
+		case "basic":
+			basic = true
+		}
+	}
+	if !basic || t.basic { return false }
+	t.basic = true
+	return true
+}
+
+// ────────── HTTP Digest auth (RFC 7616) ────────────────────
+type digestAuth struct {
+	realm, nonce, opaque, qop, algorithm string
+	h                                    func() hash.Hash
+	nc                                   int
+}
+
+func newDigestAuth(p map[string]string) *digestAuth {
+	a := &digestAuth{realm: p["realm"], nonce: p["nonce"], opaque: p["opaque"], algorithm: p["algorithm"]}
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(a.algorithm), "-sess")) {
+	case "", "MD5":
+		if fipsMode { return nil } // not approved
+		a.h = md5.New
+	case "SHA-256":
+		a.h = sha256.New
+	default:
+		return nil
+	}
+	for _, q := range strings.Split(p["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" { a.qop = "auth" }
+	}
+	return a
+}
+
+func (a *digestAuth) sum(parts ...string) string {
+	h := a.h()
+	io.WriteString(h, strings.Join(parts, ":"))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (a *digestAuth) answer(user, pass, method, uri string) string {
+	a.nc++
+	nc := fmt.Sprintf("%08x", a.nc)
+	b := make([]byte, 8)
+	rand.Read(b)
+	cnonce := hex.EncodeToString(b)
+	ha1 := a.sum(user, a.realm, pass)
+	if strings.HasSuffix(strings.ToLower(a.algorithm), "-sess") { ha1 = a.sum(ha1, a.nonce, cnonce) }
+	ha2 := a.sum(method, uri)
+	var resp string
+	if a.qop != "" {
+		resp = a.sum(ha1, a.nonce, nc, cnonce, a.qop, ha2)
+	} else {
+		resp = a.sum(ha1, a.nonce, ha2)
+	}
+	s := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q`, user, a.realm, a.nonce, uri, resp)
+	if a.algorithm != "" { s += ", algorithm=" + a.algorithm }
+	if a.opaque != "" { s += fmt.Sprintf(", opaque=%q", a.opaque) }
+	if a.qop != "" { s += fmt.Sprintf(", qop=%s, nc=%s, cnonce=%q", a.qop, nc, cnonce) }
+	return s
+}
+
+// authParams splits `realm="x", qop="auth,auth-int", nonce=abc` into its
+// parameters; quoted values may contain commas.
+func authParams(s string) map[string]string {
+	p := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; {
+		k, rest, ok := strings.Cut(s, "=")
+		if !ok { break }
+		k = strings.ToLower(strings.TrimSpace(strings.TrimLeft(k, ", ")))
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' { end++ }
+				end++
+			}
+			v = strings.ReplaceAll(rest[1:min(end, len(rest))], `\`, "")
+			rest = rest[min(end+1, len(rest)):]
+		} else {
+			v, rest, _ = strings.Cut(rest, ",")
+			v = strings.TrimSpace(v)
+		}
+		p[k] = v
+		s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return p
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ────────── adaptive worker pool ───────────────────────────
+// aimd caps how many files are in flight. It adds a slot after a full
+// round of clean transfers and halves on any retryable error, so one config settles
+// near what the link can take, fibre or LTE. A lookup much slower than the
+// fastest seen so far stops growth until latency recovers.
+type aimd struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	max    int
+	active int
+	streak int
+	minRTT time.Duration
+}
+
+func newAIMD(max int) *aimd {
+	a := &aimd{limit: 1, max: max}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+func (a *aimd) acquire() {
+	a.mu.Lock()
+	for a.active >= a.limit { a.cond.Wait() }
+	a.active++
+	a.mu.Unlock()
+}
+
+func (a *aimd) release(err error, rtt time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.active--
+	switch {
+	case err != nil && retryable(err):
+		if a.limit > 1 { debugf(catTransfer, "workers: down to %d", a.limit/2) }
+		a.limit = max(1, a.limit/2)
+		a.streak = 0
+	case err != nil:
+	case a.minRTT > 0 && rtt > 3*a.minRTT+20*time.Millisecond:
+		a.streak = 0
+	default:
+		a.streak++
+		if a.streak >= a.limit && a.limit < a.max {
+			a.limit++; a.streak = 0
+			debugf(catTransfer, "workers: up to %d", a.limit)
+		}
+	}
+	if rtt > 0 && (a.minRTT == 0 || rtt < a.minRTT) { a.minRTT = rtt }
+	a.cond.Broadcast()
+}
+
+const attempts = 3
+
+// pool runs up to transfer.workers workers over jobs. The first worker
+// reuses the connection opened at startup; the rest dial on demand.
+func (r *run) pool(ctx context.Context, first target, dial func() (target, error), jobs <-chan job) {
+	workers := max(1, r.conf.Transfer.Workers)
+	if r.conf.Ordered { workers = 1 } // files finish in the order they were queued
+	ctl := newAIMD(workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		conn := first
+		if i > 0 { conn = nil }
+		wg.Add(1)
+		go func() { defer wg.Done(); r.work(ctx, conn, dial, ctl, jobs) }()
+	}
+	wg.Wait()
+}
+
+func (r *run) work(ctx context.Context, conn target, dial func() (target, error), ctl *aimd, jobs <-chan job) {
+	defer func() { if conn != nil { conn.close() } }()
+	for j := range jobs {
+		if ctx.Err() != nil { continue } // drain while the scanner stops
+		var err error
+		var rec recorder // the last attempt's session, which outlives its connection
+		for try := 1; try <= attempts; try++ {
+			ctl.acquire()
+			var rtt time.Duration
+			if conn == nil { conn, err = dial() }
+			if err == nil && j.pull {
+				rtt, err = r.pullFile(ctx, conn, j)
+			} else if err == nil {
+				rtt, err = r.syncFile(ctx, conn, j)
+			}
+			ctl.release(err, rtt)
+			if rc, ok := conn.(recorder); ok { rec = rc }
+			if err == nil || !retryable(err) { break }
+			if try < attempts { debugf(catTransfer, "%s: attempt %d failed, retrying: %v", j.rel, try, err) }
+			// the connection may be what broke; start the retry on a fresh one
+			if conn != nil { conn.close(); conn = nil }
+		}
+		r.resume.handled(j.dir, err == nil)
+		switch {
+		case err == nil, ctx.Err() != nil:
+			continue
+		case errors.Is(err, ErrConflict):
+			r.conflicts.Add(1)
+		case errors.Is(err, ErrWithheld):
+			r.withheld.Add(1)
+		case errors.Is(err, ErrHeld):
+			r.held.Add(1)
+		case errors.Is(err, ErrDeferred):
+			r.deferred.Add(1)
+		default:
+			r.fail(err)
+			if rec != nil { rec.save(err) }
+		}
+		r.prog.OnError(j.rel, err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ────────── write-once target ──────────────────────────────
+// With write_once the engine never replaces or deletes anything on the
+// target, so a backup keeps every version a ransomware run could
+// overwrite. A file's first upload takes its own name; when it changes,
+// the new content goes next to it under a name stamped with its mtime,
+// report.pdf → report.20261014T072815Z.pdf. The stamp comes from the file,
+// not the clock, so a later run stats that name and finds the version it
+// already wrote; the cost is a second lookup for files that have changed
+// since their first upload.
+const versionStamp = "20060102T150405Z"
+
+// versionName is rel with mtime stamped in before the extension.
+func versionName(rel string, mtime time.Time) string {
+	dir, base := path.Split(rel)
+	ext := path.Ext(base)
+	if ext == base { ext = "" } // .profile has no extension
+	return dir + strings.TrimSuffix(base, ext) + "." + mtime.UTC().Format(versionStamp) + ext
+}
+
+// version returns where a changed file's content goes on a write-once
+// target, and whether it still has to be uploaded there.
+func (r *run) version(ctx context.Context, t target, local *FileInfo) (string, bool, error) {
+	v := versionName(local.Rel, local.MTime)
+	remote, err := t.stat(ctx, v)
+	if errors.Is(err, ErrNotFound) {
+		debugf(catCompare, "%s: changed, new version %s", local.Rel, v)
+		return v, true, nil
+	}
+	if err != nil { return "", false, err }
+	if remote.Size != local.Size {
+		return "", false, withClass(ErrPermission, fmt.Errorf("write_once: %s already holds other content", v))
+	}
+	debugf(catCompare, "%s: up to date as %s", local.Rel, v)
+	return v, false, nil
+}
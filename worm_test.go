@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersionName(t *testing.T) {
+	at := time.Date(2026, 10, 14, 9, 28, 15, 0, time.FixedZone("CEST", 2*3600))
+	for rel, want := range map[string]string{
+		"report.pdf":        "report.20261014T072815Z.pdf",
+		"a/b/report.tar.gz": "a/b/report.tar.20261014T072815Z.gz",
+		"Makefile":          "Makefile.20261014T072815Z",
+		"home/.profile":     "home/.profile.20261014T072815Z",
+	} {
+		if got := versionName(rel, at); got != want { t.Errorf("versionName(%q) = %q, want %q", rel, got, want) }
+	}
+}
+
+// names lists the files in dir.
+func names(t *testing.T, dir string) []string {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil { t.Fatal(err) }
+	var ns []string
+	for _, e := range ents { ns = append(ns, e.Name()) }
+	sort.Strings(ns)
+	return ns
+}
+
+func TestWriteOnce(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction, w.conf.WriteOnce = "", true
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "report.pdf", "v1", then)
+	if s := w.sync(t); s.Uploaded != 1 { t.Fatalf("first run uploaded %d, want 1", s.Uploaded) }
+
+	// the upload's mtime is the clock's; the edits come after it
+	v2 := time.Now().Add(time.Minute).Truncate(time.Second)
+	put(t, w.here, "report.pdf", "v2", v2)
+	if s := w.sync(t); s.Uploaded != 1 || s.Failed != 0 { t.Errorf("after an edit: %d up, %d failed; want 1, 0", s.Uploaded, s.Failed) }
+	if s := w.sync(t); s.Uploaded != 0 { t.Errorf("nothing changed: %d uploaded, want 0", s.Uploaded) }
+	v3 := v2.Add(time.Minute)
+	put(t, w.here, "report.pdf", "v3", v3)
+	w.sync(t)
+
+	first, second := versionName("report.pdf", v2), versionName("report.pdf", v3)
+	want := []string{"report.pdf", first, second}
+	sort.Strings(want)
+	if got := names(t, w.there); strings.Join(got, " ") != strings.Join(want, " ") { t.Errorf("target holds %v, want %v", got, want) }
+	for rel, want := range map[string]string{"report.pdf": "v1", first: "v2", second: "v3"} {
+		if got := body(w.there, rel); got != want { t.Errorf("%s = %q, want %q", rel, got, want) }
+	}
+
+	// a local deletion changes nothing on the target
+	os.Remove(filepath.Join(w.here, "report.pdf"))
+	put(t, w.here, "other.txt", "o", then)
+	w.sync(t)
+	if got := body(w.there, "report.pdf"); got != "v1" { t.Errorf("report.pdf on the target = %q after the local deletion, want v1", got) }
+}
+
+func TestWriteOnceTaken(t *testing.T) {
+	w := newTwoWay(t)
+	w.conf.Direction, w.conf.WriteOnce = "", true
+	then := time.Now().Add(-time.Hour).Truncate(time.Second)
+	put(t, w.here, "a.txt", "v1", then)
+	w.sync(t)
+	at := time.Now().Add(time.Minute).Truncate(time.Second)
+	put(t, w.here, "a.txt", "v2", at)
+	// something else already wrote the version's name
+	put(t, w.there, versionName("a.txt", at), "not v2", then)
+	if s := w.sync(t); s.Failed != 1 || s.Errors["permission"] != 1 { t.Errorf("%d failed, errors %v; want one permission failure", s.Failed, s.Errors) }
+	if got := body(w.there, versionName("a.txt", at)); got != "not v2" { t.Errorf("existing version replaced with %q", got) }
+	if got := body(w.there, "a.txt"); got != "v1" { t.Errorf("a.txt = %q, want v1", got) }
+}
+
+func TestWriteOnceRefused(t *testing.T) {
+	for name, check := range map[string]func(*Conf) error{
+		"mirror":         func(c *Conf) error { c.Mirror = true; return checkMirror(c) },
+		"canary":         func(c *Conf) error { c.Canary = "canary.txt"; return checkCanary(c) },
+		"detect_renames": func(c *Conf) error { c.DetectRenames = true; return checkRenames(c) },
+		"backup_dir":     func(c *Conf) error { c.BackupDir = "old"; _, err := newBackups(c); return err },
+		"direction pull": func(c *Conf) error { c.Direction = "pull"; return checkDirection(c) },
+	} {
+		c := &Conf{WriteOnce: true, LocalDir: t.TempDir(), StateFile: "s.json"}
+		if err := check(c); err == nil { t.Errorf("write_once with %s accepted", name) }
+	}
+}